@@ -1,15 +1,26 @@
 package main
 
 import (
+	"context"
 	"doc-management/internal/app"
 	"doc-management/internal/config"
+	"doc-management/internal/encryption"
 	"doc-management/internal/hashing"
+	"doc-management/internal/keystore"
 	"doc-management/internal/ports/http"
+	"doc-management/internal/rbac"
+	"doc-management/internal/repository"
+	"doc-management/internal/repository/filesystem"
 	"doc-management/internal/repository/mongodb"
+	"doc-management/internal/repository/s3"
+	"doc-management/internal/usermanager"
+	"errors"
+	"fmt"
 	"log"
 	"time"
 
 	"github.com/spf13/viper"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -37,11 +48,64 @@ func main() {
 	}
 	defer db.Disconnect()
 
-	app := app.NewApp(logger, db)
+	keyring, err := newKeyring()
+	if err != nil {
+		logger.Fatal("failed to read the master keys: " + err.Error())
+	}
+
+	store, err := newContentStore(logger, db, keyring)
+	if err != nil {
+		logger.Fatal("failed to set up the content store: " + err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.GetRequestTimeout())
+	err = multierr.Combine(db.EnsureSearchIndex(ctx), db.EnsureCatalogIndex(ctx), db.EnsureProjectionIndex(ctx), db.EnsureKeyHistoryIndex(ctx), db.EnsureBatchesIndex(ctx), db.EnsureOutboxIndex(ctx), db.EnsureInboxIndex(ctx))
+	cancel()
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+
+	policy, err := newPolicy(logger)
+	if err != nil {
+		logger.Fatal("failed to load the RBAC policy: " + err.Error())
+	}
+
+	provider, err := newIdentityProvider(logger)
+	if err != nil {
+		logger.Fatal("failed to initialize the identity provider: " + err.Error())
+	}
+
+	signers, err := newSigners(logger, db, keyring, provider)
+	if err != nil {
+		logger.Fatal("failed to set up the signing keys: " + err.Error())
+	}
+
+	app := app.NewApp(logger, app.Deps{
+		Content:    store,
+		Index:      db,
+		Catalog:    db,
+		Projected:  db,
+		Audit:      db,
+		Batches:    db,
+		Outbox:     db,
+		Issues:     db,
+		Events:     db,
+		Checkpoint: db,
+		Policy:     policy,
+		Signers:    signers,
+	})
 	if err := app.Start(); err != nil {
 		logger.Fatal("failed to start the app: " + err.Error())
 	}
-	ser := http.NewServer(logger, &app, config.GetPort())
+
+	tokenParams := provider.TokenParams()
+	if claim := config.GetAuthUserIDClaim(); claim != "" {
+		tokenParams.UserIDClaim = claim
+	}
+	if claim := config.GetAuthScopeClaim(); claim != "" {
+		tokenParams.ScopeClaim = claim
+	}
+	ser := http.NewServer(logger, &app, config.GetPort(), tokenParams)
 	if err := ser.Run(); err != nil {
 		logger.Fatal("failed to run the server: " + err.Error())
 	}
@@ -49,6 +113,148 @@ func main() {
 	logger.Info("application finished")
 }
 
+func newIdentityProvider(logger *zap.Logger) (usermanager.IdentityProvider, error) {
+	logger.Info("using identity provider: " + config.GetIdentityProvider())
+
+	// the tokens issued by Azure AD B2C or, for the file provider, by any configured issuer
+	tokenParams := usermanager.TokenParams{
+		Issuer:       config.GetTokenIssuer(),
+		Audience:     config.GetClientID(),
+		DiscoveryURL: config.GetOpenIDConfigURL(),
+		PolicyName:   config.GetAuthPolicyName(),
+		UserIDClaim:  "oid",
+		ScopeClaim:   "scp",
+	}
+
+	switch config.GetIdentityProvider() {
+	case config.IdentityProviderAzure:
+		return usermanager.NewGraphProvider(config.GetTenantID(), config.GetClientID(), config.GetMsExtensionID(), config.GetAppSecret(), tokenParams)
+
+	case config.IdentityProviderOIDC:
+		ctx, cancel := context.WithTimeout(context.Background(), config.GetRequestTimeout())
+		defer cancel()
+		return usermanager.NewSCIMProvider(ctx, config.GetOIDCIssuer(), config.GetSCIMURL(), config.GetOIDCClientID(), config.GetOIDCClientSecret())
+
+	case config.IdentityProviderFile:
+		return usermanager.NewFileProvider(config.GetUsersFile(), tokenParams)
+
+	default:
+		return nil, errors.New("unknown identity provider: " + config.GetIdentityProvider())
+	}
+}
+
+func newSigners(logger *zap.Logger, db mongodb.Repository, keyring *encryption.Keyring, provider usermanager.IdentityProvider) (keystore.Signers, error) {
+	logger.Info("using key storage: " + config.GetKeyStorage())
+
+	switch config.GetKeyStorage() {
+	case config.KeyStorageKeystore:
+		// the keys kept in the directory so far are moved to the keystore on their first use and cleared there,
+		// no private key is written to the directory
+		return keystore.New(logger, db, keyring, db, usermanager.NewKeystoreUserManager(provider))
+
+	case config.KeyStorageDirectory:
+		logger.Warn("the private keys are stored in the identity provider, migrate them to the keystore")
+		return keystore.NewDirectorySigners(usermanager.NewUserManager(provider), db), nil
+	}
+
+	return nil, errors.New("unknown key storage: " + config.GetKeyStorage())
+}
+
+func newPolicy(logger *zap.Logger) (rbac.Policy, error) {
+	path := config.GetRBACPolicyFile()
+	if path == "" {
+		logger.Warn("no RBAC policy file given, all users can propose and sign in all the categories")
+		return rbac.Policy{DefaultRole: rbac.RoleSigner}, nil
+	}
+
+	return rbac.ReadPolicyFile(path)
+}
+
+// newKeyring reads the master keys encrypting the content and the signing keys
+func newKeyring() (*encryption.Keyring, error) {
+	keyring := encryption.NewKeyring()
+	if err := keyring.ParseKeys(config.GetEncryptionMasterKey()); err != nil {
+		return nil, errors.New("invalid master key: " + err.Error())
+	}
+	if path := config.GetEncryptionKeyFile(); path != "" {
+		if err := keyring.ReadKeyFile(path); err != nil {
+			return nil, errors.New("failed to read the key file: " + err.Error())
+		}
+	}
+
+	return keyring, nil
+}
+
+func newContentStore(logger *zap.Logger, db mongodb.Repository, keyring *encryption.Keyring) (repository.ContentStore, error) {
+	store, err := newBackendStore(logger, db)
+	if err != nil {
+		return nil, err
+	}
+
+	if keyring.Empty() {
+		logger.Warn("no master key given, the content is stored unencrypted")
+		return store, nil
+	}
+
+	encrypted, err := repository.NewEncryptedStore(store, keyring)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("content encrypted with the master key: " + keyring.ActiveKeyID())
+
+	if config.GetEncryptionRotateKeys() {
+		go rotateKeys(logger, encrypted)
+	}
+
+	return encrypted, nil
+}
+
+func rotateKeys(logger *zap.Logger, store repository.EncryptedStore) {
+	rewritten, err := store.RotateKeys(context.Background())
+	if err != nil {
+		logger.Error(fmt.Sprint("key rotation failed after re-encrypting ", rewritten, " entries: ", err.Error()))
+		return
+	}
+	logger.Info(fmt.Sprint("key rotation finished, re-encrypted ", rewritten, " entries"))
+}
+
+func newBackendStore(logger *zap.Logger, db mongodb.Repository) (repository.ContentStore, error) {
+	logger.Info("using content store: " + config.GetContentStore())
+
+	switch config.GetContentStore() {
+	case config.ContentStoreMongoDB:
+		// the migration isn't bound by the request timeout; the content not migrated
+		// couldn't be read, so the app doesn't start until it's complete
+		if err := db.MigrateLegacyContent(context.Background(), config.GetContentMigrationBatch()); err != nil {
+			return nil, errors.New("failed to migrate the legacy content, restart to continue: " + err.Error())
+		}
+
+		return db, nil
+
+	case config.ContentStoreFilesystem:
+		blobs, err := filesystem.NewStore(config.GetContentDir())
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewRefCountedStore(blobs), nil
+
+	case config.ContentStoreS3:
+		blobs, err := s3.NewStore(s3.Config{
+			Endpoint:  config.GetS3Endpoint(),
+			Region:    config.GetS3Region(),
+			Bucket:    config.GetS3Bucket(),
+			AccessKey: config.GetS3AccessKey(),
+			SecretKey: config.GetS3SecretKey(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewRefCountedStore(blobs), nil
+	}
+
+	return nil, errors.New("unknown content store: " + config.GetContentStore())
+}
+
 func getLogger() (*zap.Logger, error) {
 	options := []zap.Option{
 		zap.AddCaller(),
@@ -2,84 +2,247 @@ package app
 
 import (
 	"context"
+	"doc-management/internal/audit"
+	"doc-management/internal/batches"
 	"doc-management/internal/blockchain"
 	"doc-management/internal/blockchain/events"
+	"doc-management/internal/catalog"
 	"doc-management/internal/config"
+	"doc-management/internal/inbox"
+	"doc-management/internal/keystore"
 	"doc-management/internal/model"
-	"doc-management/internal/repository/mongodb"
-	"doc-management/internal/signkeys"
-	"doc-management/internal/usermanager"
+	"doc-management/internal/outbox"
+	"doc-management/internal/projection"
+	"doc-management/internal/rbac"
+	"doc-management/internal/repository"
+	"doc-management/internal/retry"
+	"doc-management/internal/search"
 	"errors"
+	"fmt"
 	"time"
 
 	"go.uber.org/zap"
 )
 
+var errAlreadyAccepted = errors.New("the proposal is already accepted as a doc version")
+
+const proposalAcceptedEvent = "proposal_accepted"
+
 const (
 	acceptingProcessTimeout = 20 * time.Second
+	projectionInitTimeout   = 5 * time.Minute
 )
 
 type App struct {
 	blkchnClient *blockchain.Client
-	userManager  usermanager.UserManager
-	logger       *zap.Logger
-	db           mongodb.Repository
-	listener     *events.EventListener
+	// serves the reads of the blockchain state from the local projection
+	reader    projection.Reader
+	projector *projection.Projector
+	// sign the transactions of the users and of the application itself
+	signers keystore.Signers
+	// tracks the submitted batches until they are committed or rejected
+	batches *batches.Tracker
+	// submits the batches the stored content depends on, retried until they are committed
+	dispatcher *outbox.Dispatcher
+	// repairs the mismatches between the stored content and the blockchain, nil if the store can't list the references
+	reconciler *outbox.Reconciler
+	// records the received events and processes them until they succeed
+	inbox *inbox.Inbox
+	// the last block whose events were handled
+	checkpoint events.Checkpoint
+	logger     *zap.Logger
+	store      repository.ContentStore
+	index      search.Index
+	catalog    catalog.Catalog
+	listener   *events.EventListener
+	policy     rbac.Policy
+	audit      audit.Log
+	// the users sign their transactions, the app only relays them
+	clientSigning bool
+}
 
-	appKeys signkeys.UserKeys
+// Deps are the stores and the services the app is built with, e.g. all the stores may be the same MongoDB repository
+type Deps struct {
+	// the off-chain content of the proposals and the doc versions
+	Content   repository.ContentStore
+	Index     search.Index
+	Catalog   catalog.Catalog
+	Projected projection.Repository
+	Audit     audit.Log
+	Batches   batches.Store
+	Outbox    outbox.Store
+	Issues    outbox.IssueStore
+	Events    inbox.Store
+	// the last block whose events were handled
+	Checkpoint events.Checkpoint
+	Policy     rbac.Policy
+	Signers    keystore.Signers
 }
 
-func NewApp(logger *zap.Logger, db mongodb.Repository) App {
+func NewApp(logger *zap.Logger, deps Deps) App {
+	client := blockchain.NewClient(logger, config.GetValidatorRestAPIAddr())
+	submitRetry := retry.Policy{
+		InitialBackoff: config.GetOutboxRetryInitial(),
+		MaxBackoff:     config.GetOutboxRetryMax(),
+		MaxAttempts:    config.GetOutboxMaxAttempts(),
+	}
+	eventRetry := retry.Policy{
+		InitialBackoff: config.GetInboxRetryInitial(),
+		MaxBackoff:     config.GetInboxRetryMax(),
+		MaxAttempts:    config.GetInboxMaxAttempts(),
+	}
+	// reconnected until it succeeds
+	reconnect := retry.Policy{
+		InitialBackoff: config.GetEventsReconnectInitial(),
+		MaxBackoff:     config.GetEventsReconnectMax(),
+	}
+
+	a := App{
+		blkchnClient:  client,
+		reader:        deps.Projected,
+		projector:     projection.NewProjector(logger, client, deps.Projected),
+		listener:      events.NewEventListener(logger, config.GetValidatorAddr(), reconnect, config.GetEventsIdleTimeout()),
+		logger:        logger,
+		store:         deps.Content,
+		index:         deps.Index,
+		catalog:       deps.Catalog,
+		policy:        deps.Policy,
+		audit:         deps.Audit,
+		signers:       deps.Signers,
+		batches:       batches.NewTracker(logger, deps.Batches, client, config.GetBatchPollInterval(), config.GetBatchExpiry()),
+		dispatcher:    outbox.NewDispatcher(logger, deps.Outbox, client, deps.Content, submitRetry, config.GetOutboxPollInterval()),
+		inbox:         inbox.NewInbox(logger, deps.Events, eventRetry, config.GetInboxPollInterval()),
+		checkpoint:    deps.Checkpoint,
+		clientSigning: config.GetClientSigning(),
+	}
 
-	return App{
-		blkchnClient: blockchain.NewClient(logger, config.GetValidatorRestAPIAddr()),
-		listener:     events.NewEventListener(logger, config.GetValidatorAddr()),
-		logger:       logger,
-		db:           db,
-		// initialize when starting the app
-		userManager: usermanager.UserManager{},
-		appKeys:     signkeys.UserKeys{},
+	if refs, ok := deps.Content.(repository.RefListingStore); ok {
+		a.reconciler = outbox.NewReconciler(logger, deps.Issues, deps.Outbox, client, refs, a.removeProposal, config.GetReconcileGrace(), config.GetReconcileInterval())
+	} else {
+		logger.Warn("the content store can't list its references, the content is not reconciled")
 	}
+
+	return a
 }
 
 func (a *App) Start() error {
-	userManag, err := usermanager.NewUserManager(config.GetTenantID(), config.GetClientID(), config.GetMsExtensionID(), config.GetAppSecret())
-	if err != nil {
-		return errors.New("failed to initialize user manager: " + err.Error())
-	}
-	a.userManager = userManag
 	ctx, cancel := context.WithTimeout(context.Background(), config.GetRequestTimeout())
 	defer cancel()
-	appKeys, err := userManag.InitAndReadAppKeys(ctx, config.GetAppUserID())
-	a.appKeys = appKeys
-	a.logger.Info("app keys initialized", zap.String("publicKeyShort", appKeys.PublicKey.AsHex()[:20]))
+	appPublicKey, err := a.signers.PublicKey(ctx, config.GetAppUserID())
+	if err != nil {
+		return errors.New("failed to read the app keys: " + err.Error())
+	}
+	if len(appPublicKey) < 20 {
+		return errors.New("invalid app public key")
+	}
+	a.logger.Info("app keys initialized", zap.String("publicKeyShort", appPublicKey[:20]))
+
+	// loading the state snapshot may take longer than a request
+	projectionCtx, cancelProjection := context.WithTimeout(context.Background(), projectionInitTimeout)
+	defer cancelProjection()
+	projectionBlockID, projectionBlockNum, err := a.projector.Init(projectionCtx)
+	if err != nil {
+		return errors.New("failed to initialize the projection: " + err.Error())
+	}
+	eventBlockID, eventBlockNum, err := a.checkpoint.LastEventBlock(ctx)
+	if err != nil {
+		return errors.New("failed to read the last handled block: " + err.Error())
+	}
+	// the state changes and the events are replayed from the older of their last blocks,
+	// the blocks the other one has handled already are handled again
+	a.listener.SetLastKnownBlock(olderBlock(projectionBlockID, projectionBlockNum, eventBlockID, eventBlockNum))
+	a.listener.SetCheckpoint(a.checkpoint)
+	a.listener.SetUnknownBlockHandler(a.catchUp)
+	a.listener.SetStateDeltaHandler(a.projector.Prefixes(), a.projector.HandleStateDelta)
 
-	if err := a.listener.SetHandler("proposal_accepted", a.handleProposalAccepted); err != nil {
+	// the events are recorded first, so they are processed even if the processing fails at first
+	a.inbox.SetHandler(proposalAcceptedEvent, a.handleProposalAccepted)
+	if err := a.listener.SetHandler(proposalAcceptedEvent, a.recordEvent); err != nil {
 		return errors.New("failed to set the handler for 'proposal_accepted' event: " + err.Error())
 	}
+	// the events failed before a restart are processed as well
+	a.inbox.Start()
 
-	if err := a.listener.Start(); err != nil {
-		return errors.New("failed to start the listener: " + err.Error())
+	// connected in the background, the app starts while the validator is unavailable too,
+	// and reconnected if the validator is restarted, until the app is stopped
+	a.listener.Start(context.Background())
+
+	// the batches submitted before a restart are tracked as well
+	a.batches.Start()
+	// the catalog and the search index list the accepted doc versions once they are committed
+	a.dispatcher.SetCommitHandler(audit.ActionAcceptDocument, a.docVersionCommitted)
+	// the batches not submitted before a restart are submitted now
+	a.dispatcher.Start()
+	if a.reconciler != nil {
+		a.reconciler.Start()
 	}
 
+	// the documents accepted before the catalog was introduced or while the app was down
+	go a.syncCatalog()
+
 	return nil
 }
 
+// olderBlock returns the older of the last blocks of the projection and of the events. The projection loaded
+// from the state snapshot has no block number yet, it's newer than the last block of the events then,
+// as the events of a block are handled only after its state changes.
+func olderBlock(projectionID string, projectionNum uint64, eventsID string, eventsNum uint64) string {
+	switch {
+	case eventsID == "" || eventsID == projectionID:
+		return projectionID
+	case projectionID == "" || projectionNum == 0 || eventsNum < projectionNum:
+		return eventsID
+	}
+	return projectionID
+}
+
 func (a App) Stop() {
+	if a.reconciler != nil {
+		a.reconciler.Stop()
+	}
+	a.dispatcher.Stop()
+	a.batches.Stop()
 	if err := a.listener.Stop(); err != nil {
 		a.logger.Warn("error when stopping the listener: " + err.Error())
 	}
+	a.inbox.Stop()
 }
 
-func (a App) handleProposalAccepted(data []byte) error {
-	proposalID := string(data)
-	if proposalID == "" {
-		return errors.New("can't process accepted proposal, proposal ID is missing")
-	}
+// EventsStatus returns the state of the connection to the validator's events, the accepted proposals are
+// submitted as doc versions only while it's connected
+func (a App) EventsStatus() events.Status {
+	return a.listener.Status()
+}
 
+// recordEvent records the event received from the blockchain in the inbox and processes it;
+// the event is keyed by its data, the proposal ID, so each proposal is accepted once
+func (a App) recordEvent(event events.Event) error {
 	ctx, cancel := context.WithTimeout(context.Background(), acceptingProcessTimeout)
 	defer cancel()
 
+	recorded, err := a.inbox.Record(ctx, inbox.Event{
+		Type:     event.Type,
+		Key:      string(event.Data),
+		Data:     event.Data,
+		BlockID:  event.BlockID,
+		BlockNum: event.BlockNum,
+	})
+	if err != nil {
+		return err
+	}
+	if recorded.Status == inbox.StatusPending {
+		a.logger.Warn("event processing failed, retrying in the background: "+recorded.LastError, zap.String("eventID", recorded.ID))
+	}
+
+	return nil
+}
+
+func (a App) handleProposalAccepted(ctx context.Context, event inbox.Event) error {
+	proposalID := event.Key
+
+	ctx, cancel := context.WithTimeout(ctx, acceptingProcessTimeout)
+	defer cancel()
+
 	a.logger.Info("submitting accepted doc version", zap.String("proposalID", proposalID))
 
 	proposal, err := a.getProposalData(ctx, proposalID)
@@ -87,29 +250,105 @@ func (a App) handleProposalAccepted(data []byte) error {
 		return err
 	}
 
-	docs, err := a.blkchnClient.GetDocumentVersions(ctx, proposal.Category, proposal.DocumentName)
+	newDoc, err := a.nextDocVersion(ctx, proposal)
+	if err == errAlreadyAccepted {
+		// the events of the blocks committed while the app was down are replayed on start,
+		// the final vote may be submitted together with the doc version
+		return nil
+	}
 	if err != nil {
-		return errors.New("failed to get the doc versions: " + err.Error())
+		return err
 	}
 
-	newVersion := model.GetNextDocVersion(docs)
+	inFlight, err := a.dispatcher.InFlight(ctx, audit.ActionAcceptDocument, proposalID)
+	if err != nil {
+		return err
+	}
+	if inFlight {
+		a.logger.Info("doc version of the proposal already submitted, skipping", zap.String("proposalID", proposalID))
+		return nil
+	}
 
-	newDoc := model.NewDocumentFromProposal(proposal, newVersion)
+	signer, err := a.appSigner(ctx, keystore.Operation{Action: audit.ActionAcceptDocument, Category: newDoc.Category, Target: proposalID})
+	if err != nil {
+		return err
+	}
 
-	if err := a.db.InsertDocumentVersion(ctx, newDoc); err != nil {
-		return errors.New("failed to insert the accepted doc into db: " + err.Error())
+	template, err := blockchain.DocumentVersionTemplate(newDoc)
+	if err != nil {
+		return err
+	}
+	builder := blockchain.NewBatchBuilder(signer)
+	if err := builder.Add("document", template, signer); err != nil {
+		return err
 	}
 
-	// submit to blockchain only if all the previous operations succeeded, as this action is irreversible
-	transactionID, err := a.blkchnClient.SubmitDocumentVersion(ctx, newDoc, a.appKeys.GetSigner())
+	// the content is already stored with the proposal, only the doc version reference is added;
+	// the proposal reference is released once the version is committed
+	batchID, err := a.submitThroughOutbox(ctx, builder, acceptEntry(config.GetAppUserID(), proposal, newDoc), nil, 0)
 	if err != nil {
-		// remove the doc from the database
-		a.logger.Debug("removing the doc content from the database on error", zap.String("proposalID", proposal.ProposalID), zap.Error(err))
-		_ = a.db.RemoveDocumentVersion(context.Background(), newDoc)
 		return err
 	}
 
-	a.logger.Info("new doc version saved, transaction ID: "+transactionID, zap.String("docName", newDoc.DocumentName), zap.String("author", newDoc.Author))
+	a.trackBatch(ctx, batchID, config.GetAppUserID(), audit.ActionAcceptDocument, proposalID)
+	a.logger.Info("new doc version submitted, batch ID: "+batchID, zap.String("docName", newDoc.DocumentName), zap.String("author", newDoc.Author))
+
+	return nil
+}
+
+// nextDocVersion returns the new version of the document accepted with the proposal,
+// errAlreadyAccepted if the proposal is already recorded as a version
+func (a App) nextDocVersion(ctx context.Context, proposal model.Proposal) (model.Document, error) {
+	docs, err := a.blkchnClient.GetDocumentVersions(ctx, proposal.Category, proposal.DocumentName)
+	if err != nil {
+		return model.Document{}, errors.New("failed to get the doc versions: " + err.Error())
+	}
+
+	for _, doc := range docs {
+		if doc.ProposalID == proposal.ProposalID {
+			a.logger.Info(fmt.Sprint("proposal already accepted as version ", doc.Version, ", skipping"), zap.String("proposalID", proposal.ProposalID))
+			return model.Document{}, errAlreadyAccepted
+		}
+	}
+
+	return model.NewDocumentFromProposal(proposal, model.GetNextDocVersion(docs)), nil
+}
+
+// acceptEntry returns the outbox entry of the batch recording the doc version accepted with the proposal,
+// the content reference moves from the proposal to the version once it's committed
+func acceptEntry(userID string, proposal model.Proposal, newDoc model.Document) outbox.Entry {
+	return outbox.Entry{
+		UserID:      userID,
+		Action:      audit.ActionAcceptDocument,
+		Target:      proposal.ProposalID,
+		ContentHash: newDoc.ContentHash,
+		ContentRef:  docVersionRef(newDoc),
+		ReplacedRef: repository.ProposalRef(proposal.ProposalID),
+	}
+}
+
+// docVersionCommitted updates the catalog and the search index with the doc version of the committed batch,
+// as it's recorded on the blockchain; the version submitted but never committed is never listed or found
+func (a App) docVersionCommitted(ctx context.Context, entry outbox.Entry) error {
+	category, docName, version, ok := repository.ParseDocVersionRef(entry.ContentRef)
+	if !ok {
+		return errors.New("invalid doc version reference: " + entry.ContentRef)
+	}
+
+	doc, err := a.blkchnClient.GetDocumentVersion(ctx, category, docName, version)
+	if err != nil {
+		return errors.New("failed to get the committed doc version: " + err.Error())
+	}
+	if doc.Content, err = a.getContent(ctx, doc.ContentHash); err != nil {
+		return errors.New("failed to get the content of the committed doc version: " + err.Error())
+	}
+
+	if err := a.catalog.UpdateDocument(ctx, doc); err != nil {
+		return errors.New("failed to update the catalog: " + err.Error())
+	}
+	if err := a.index.IndexDocument(ctx, search.NewEntry(doc)); err != nil {
+		return errors.New("failed to index the new doc version: " + err.Error())
+	}
 
 	return nil
 }
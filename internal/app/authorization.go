@@ -0,0 +1,85 @@
+package app
+
+import (
+	"context"
+	"doc-management/internal/audit"
+	"doc-management/internal/config"
+	"doc-management/internal/keystore"
+	"doc-management/internal/rbac"
+	"errors"
+	"time"
+
+	"github.com/hyperledger/sawtooth-sdk-go/signing"
+	"go.uber.org/zap"
+)
+
+func (a App) authorize(subject rbac.Subject, category string, role rbac.Role) error {
+	if err := a.policy.Authorize(subject, category, role); err != nil {
+		a.logger.Warn("permission denied", zap.String("userID", subject.UserID), zap.String("category", category), zap.String("requiredRole", string(role)))
+		return err
+	}
+
+	return nil
+}
+
+// allowedCategories returns the categories in which the subject has the role;
+// nil if the subject has the role in all the categories, empty if in none
+func (a App) allowedCategories(subject rbac.Subject, role rbac.Role) []string {
+	categories, all := a.policy.Categories(subject, role)
+	if all {
+		return nil
+	}
+	if categories == nil {
+		return []string{}
+	}
+
+	return categories
+}
+
+func isAllowed(allowed []string, category string) bool {
+	if allowed == nil {
+		return true
+	}
+	for _, c := range allowed {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// actingAs returns the user in whose name the subject acts: the subject itself if onBehalfOf is empty.
+// Acting on behalf of another user requires the admin role in the category and is recorded in the audit log.
+func (a App) actingAs(ctx context.Context, subject rbac.Subject, onBehalfOf, category string, action audit.Action, target string) (string, error) {
+	if subject.UserID == "" {
+		return "", rbac.ErrForbidden
+	}
+	if onBehalfOf == "" || onBehalfOf == subject.UserID {
+		return subject.UserID, nil
+	}
+
+	if err := a.authorize(subject, category, rbac.RoleAdmin); err != nil {
+		return "", err
+	}
+
+	// don't let the action pass unrecorded
+	entry := audit.Entry{
+		Time:       time.Now().UTC(),
+		Action:     action,
+		Actor:      subject.UserID,
+		OnBehalfOf: onBehalfOf,
+		Category:   category,
+		Target:     target,
+	}
+	if err := a.audit.Record(ctx, entry); err != nil {
+		return "", errors.New("failed to record the action in the audit log: " + err.Error())
+	}
+	a.logger.Info("acting on behalf of another user", zap.String("action", string(action)), zap.String("actor", subject.UserID), zap.String("onBehalfOf", onBehalfOf), zap.String("target", target))
+
+	return onBehalfOf, nil
+}
+
+// appSigner returns the signer of the application's own transactions
+func (a App) appSigner(ctx context.Context, op keystore.Operation) (*signing.Signer, error) {
+	return a.signers.Signer(ctx, rbac.Subject{UserID: config.GetAppUserID()}, "", op)
+}
@@ -0,0 +1,73 @@
+package app
+
+import (
+	"context"
+	"doc-management/internal/inbox"
+	"doc-management/internal/model"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// catchUp handles the blocks whose events can't be replayed: the projection is loaded from the current state
+// and the accepted proposals with no doc version, whose "proposal_accepted" event was missed, are accepted now
+func (a App) catchUp(unknownBlockID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), projectionInitTimeout)
+	defer cancel()
+
+	a.logger.Warn("the events since the last known block are lost, scanning the state for the accepted proposals", zap.String("blockID", unknownBlockID))
+	if _, err := a.projector.Resync(ctx); err != nil {
+		return err
+	}
+
+	missed, err := a.acceptedWithoutVersion(ctx)
+	if err != nil {
+		return errors.New("failed to scan the state for the accepted proposals: " + err.Error())
+	}
+
+	for _, proposal := range missed {
+		// recorded as the missed event, so it's retried and never accepted twice
+		event, err := a.inbox.Record(ctx, inbox.Event{Type: proposalAcceptedEvent, Key: proposal.ProposalID, Data: []byte(proposal.ProposalID)})
+		if err != nil {
+			return err
+		}
+		if event.Status != inbox.StatusProcessed {
+			a.logger.Warn("the missed accepted proposal is not processed yet: "+event.LastError, zap.String("proposalID", proposal.ProposalID))
+		}
+	}
+
+	a.logger.Info(fmt.Sprint("state scan finished, found ", len(missed), " accepted proposals with no doc version"))
+	return nil
+}
+
+// acceptedWithoutVersion returns the accepted proposals on the blockchain that have no doc version
+func (a App) acceptedWithoutVersion(ctx context.Context) ([]model.Proposal, error) {
+	docs, err := a.blkchnClient.GetAllDocumentVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	versioned := make(map[string]bool, len(docs))
+	for _, doc := range docs {
+		versioned[doc.ProposalID] = true
+	}
+
+	var missed []model.Proposal
+	start := ""
+	for {
+		page, next, err := a.blkchnClient.GetProposalsPage(ctx, start, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, proposal := range page {
+			if proposal.CurrentStatus == model.ProposalStatusAccepted && !versioned[proposal.ProposalID] {
+				missed = append(missed, proposal)
+			}
+		}
+
+		if next == "" {
+			return missed, nil
+		}
+		start = next
+	}
+}
@@ -0,0 +1,129 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"doc-management/internal/hashing"
+	"doc-management/internal/model"
+	"doc-management/internal/repository"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// the proposals removing a document have no content, it's not stored;
+// the outbox entries without content have no content hash
+func isEmptyContent(contentHash string) bool {
+	return contentHash == "" || contentHash == hashing.CalculateSHA512("")
+}
+
+func docVersionRef(doc model.Document) string {
+	return repository.DocVersionRef(doc.Category, doc.DocumentName, doc.Version)
+}
+
+// proposalContent returns the streamed content of the new proposal, or the one given in the proposal itself
+func proposalContent(proposal model.Proposal, content io.Reader) io.Reader {
+	if content != nil {
+		return content
+	}
+	return bytes.NewReader(proposal.Content)
+}
+
+func (a App) getContent(ctx context.Context, contentHash string) ([]byte, error) {
+	if isEmptyContent(contentHash) {
+		return []byte{}, nil
+	}
+
+	return a.store.GetContent(ctx, contentHash)
+}
+
+func (a App) putContent(ctx context.Context, contentHash string, content io.Reader, size int64, ref string) error {
+	if isEmptyContent(contentHash) {
+		return nil
+	}
+
+	return repository.PutContentFrom(ctx, a.store, contentHash, content, size, ref)
+}
+
+// openVerifiedContent returns the content checked against the content hash, ErrContentInvalid if it doesn't match;
+// the content is streamed from the store to a temporary file, so that it's not held in memory
+// and nothing of it is read before it's verified. The returned content must be closed.
+func (a App) openVerifiedContent(ctx context.Context, contentHash string) (io.ReadSeekCloser, error) {
+	if isEmptyContent(contentHash) {
+		return memoryContent{bytes.NewReader(nil)}, nil
+	}
+
+	stored, err := repository.OpenContent(ctx, a.store, contentHash)
+	if err != nil {
+		return nil, err
+	}
+	defer stored.Close()
+
+	file, err := ioutil.TempFile("", "content-*")
+	if err != nil {
+		return nil, errors.New("failed to create the content file: " + err.Error())
+	}
+	content := spooledContent{file}
+
+	if _, err := io.Copy(file, stored); err != nil {
+		content.Close()
+		return nil, errors.New("failed to read the content: " + err.Error())
+	}
+
+	if err := verifyContent(content, contentHash); err != nil {
+		content.Close()
+		return nil, err
+	}
+
+	return content, nil
+}
+
+// verifyContent hashes the content from its start, leaves it rewound
+func verifyContent(content io.ReadSeeker, contentHash string) error {
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return errors.New("failed to read the content: " + err.Error())
+	}
+
+	storedHash, _, err := hashing.CalculateSHA512From(content)
+	if err != nil {
+		return errors.New("failed to read the content: " + err.Error())
+	}
+	if storedHash != contentHash {
+		return ErrContentInvalid
+	}
+
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return errors.New("failed to read the content: " + err.Error())
+	}
+	return nil
+}
+
+type memoryContent struct {
+	*bytes.Reader
+}
+
+func (memoryContent) Close() error {
+	return nil
+}
+
+// spooledContent is the content kept in a temporary file, removed on close
+type spooledContent struct {
+	*os.File
+}
+
+func (c spooledContent) Close() error {
+	err := c.File.Close()
+	if removeErr := os.Remove(c.Name()); removeErr != nil {
+		return removeErr
+	}
+	return err
+}
+
+func (a App) releaseContent(ctx context.Context, contentHash string, ref string) error {
+	if isEmptyContent(contentHash) {
+		return nil
+	}
+
+	return a.store.ReleaseContent(ctx, contentHash, ref)
+}
@@ -0,0 +1,102 @@
+package app
+
+import (
+	"context"
+	"doc-management/internal/audit"
+	"doc-management/internal/batches"
+	"doc-management/internal/blockchain"
+	"doc-management/internal/rbac"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// GetBatch returns the submitted batch with its status, visible to the user who submitted it
+// and to the admins of all the categories
+func (a App) GetBatch(ctx context.Context, subject rbac.Subject, batchID string) (batches.Batch, error) {
+	batch, err := a.batches.Get(ctx, batchID)
+	if err != nil {
+		return batches.Batch{}, err
+	}
+
+	if err := a.authorizeBatch(subject, batch); err != nil {
+		return batches.Batch{}, err
+	}
+	return batch, nil
+}
+
+// WatchBatch returns the batch and the channel receiving its changes until it's final, the channel is nil
+// if the batch is final already; the returned function stops the watching
+func (a App) WatchBatch(ctx context.Context, subject rbac.Subject, batchID string) (batches.Batch, <-chan batches.Batch, func(), error) {
+	// subscribed before reading the batch, so no change is missed
+	changes, unsubscribe := a.batches.Subscribe(batchID)
+
+	batch, err := a.GetBatch(ctx, subject, batchID)
+	if err != nil {
+		unsubscribe()
+		return batches.Batch{}, nil, nil, err
+	}
+	if batch.Final() {
+		unsubscribe()
+		return batch, nil, func() {}, nil
+	}
+
+	return batch, changes, unsubscribe, nil
+}
+
+// AwaitBatch waits up to the given time for the submitted batch to be committed or rejected; returns the batch
+// as it's known after the wait and the InvalidTransactionError if the batch was rejected
+func (a App) AwaitBatch(ctx context.Context, batch batches.Batch, wait time.Duration) (batches.Batch, error) {
+	if wait <= 0 || batch.Final() {
+		return batch, batch.Err()
+	}
+
+	changes, unsubscribe := a.batches.Subscribe(batch.ID)
+	defer unsubscribe()
+
+	// it may have changed before the subscription
+	if current, err := a.batches.Get(ctx, batch.ID); err == nil {
+		batch = current
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for !batch.Final() {
+		select {
+		case <-ctx.Done():
+			return batch, nil
+		case <-timer.C:
+			return batch, nil
+		case change, ok := <-changes:
+			if !ok {
+				return batch, nil
+			}
+			batch = change
+		}
+	}
+
+	return batch, batch.Err()
+}
+
+func (a App) authorizeBatch(subject rbac.Subject, batch batches.Batch) error {
+	if subject.UserID == "" {
+		return rbac.ErrForbidden
+	}
+	if subject.UserID == batch.UserID {
+		return nil
+	}
+
+	return a.authorize(subject, rbac.AllCategories, rbac.RoleAdmin)
+}
+
+// trackBatch records the submitted batch to be tracked until it's committed or rejected; the batch is already
+// submitted, so a failure is only logged
+func (a App) trackBatch(ctx context.Context, batchID, userID string, action audit.Action, target string) batches.Batch {
+	batch, err := a.batches.Track(ctx, batches.Batch{ID: batchID, UserID: userID, Action: action, Target: target})
+	if err != nil {
+		a.logger.Error("the submitted batch is not tracked: "+err.Error(), zap.String("batchID", batchID), zap.String("userID", userID))
+		return batches.Batch{ID: batchID, UserID: userID, Action: action, Target: target, Status: blockchain.BatchPending}
+	}
+
+	return batch
+}
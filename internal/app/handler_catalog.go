@@ -0,0 +1,57 @@
+package app
+
+import (
+	"context"
+	"doc-management/internal/catalog"
+	"doc-management/internal/model"
+	"doc-management/internal/paging"
+	"doc-management/internal/rbac"
+	"fmt"
+	"time"
+)
+
+const catalogSyncTimeout = 5 * time.Minute
+
+// GetCategories returns the categories visible to the subject
+func (a App) GetCategories(ctx context.Context, subject rbac.Subject, page paging.Page) (categories []catalog.Category, next string, total int64, err error) {
+	page.Normalize()
+	return a.catalog.ListCategories(ctx, a.allowedCategories(subject, rbac.RoleViewer), page)
+}
+
+func (a App) GetCategoryDocuments(ctx context.Context, subject rbac.Subject, category string, page paging.Page) (docs []catalog.Document, next string, total int64, err error) {
+	if err := a.authorize(subject, category, rbac.RoleViewer); err != nil {
+		return nil, "", 0, err
+	}
+
+	page.Normalize()
+	return a.catalog.ListDocuments(ctx, category, page)
+}
+
+// syncCatalog adds the latest versions of all the documents existing on the blockchain to the catalog
+func (a App) syncCatalog() {
+	ctx, cancel := context.WithTimeout(context.Background(), catalogSyncTimeout)
+	defer cancel()
+
+	versions, err := a.blkchnClient.GetAllDocumentVersions(ctx)
+	if err != nil {
+		a.logger.Error("failed to get the documents to sync the catalog: " + err.Error())
+		return
+	}
+
+	latest := make(map[string]model.Document)
+	for _, doc := range versions {
+		key := doc.Category + ";" + doc.DocumentName
+		if doc.Version > latest[key].Version {
+			latest[key] = doc
+		}
+	}
+
+	for _, doc := range latest {
+		if err := a.catalog.UpdateDocument(ctx, doc); err != nil {
+			a.logger.Error("failed to sync the catalog: " + err.Error())
+			return
+		}
+	}
+
+	a.logger.Info(fmt.Sprint("catalog synced, ", len(latest), " documents"))
+}
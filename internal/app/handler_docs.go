@@ -2,20 +2,35 @@ package app
 
 import (
 	"context"
+	"doc-management/internal/audit"
+	"doc-management/internal/config"
 	"doc-management/internal/hashing"
+	"doc-management/internal/keystore"
 	"doc-management/internal/model"
+	"doc-management/internal/rbac"
+	"doc-management/internal/repository"
+	"doc-management/internal/search"
 	"errors"
 	"fmt"
+	"io"
+	"strings"
 
 	"go.uber.org/zap"
 )
 
 var (
 	invalidContent = []byte("INVALID")
+
+	ErrNoContent      = errors.New("document version has no content")
+	ErrContentInvalid = errors.New("stored content doesn't match the content hash")
 )
 
-func (a App) GetDocumentVersions(ctx context.Context, docName, category string) ([]model.Document, error) {
-	docs, err := a.blkchnClient.GetDocumentVersions(ctx, category, docName)
+func (a App) GetDocumentVersions(ctx context.Context, subject rbac.Subject, docName, category string) ([]model.Document, error) {
+	if err := a.authorize(subject, category, rbac.RoleViewer); err != nil {
+		return []model.Document{}, err
+	}
+
+	docs, err := a.reader.GetDocumentVersions(ctx, category, docName)
 	if err != nil {
 		return []model.Document{}, err
 	}
@@ -23,24 +38,91 @@ func (a App) GetDocumentVersions(ctx context.Context, docName, category string)
 	return a.fillAndVerifyDocContent(ctx, docs)
 }
 
-func (a App) GetDocuments(ctx context.Context, author, signer string) (docs []model.Document, err error) {
+// OpenDocumentVersion returns a single document version with its full content, the content is verified
+// against the hash stored on the blockchain before it's returned; the returned content must be closed
+func (a App) OpenDocumentVersion(ctx context.Context, subject rbac.Subject, docName, category string, version int) (model.Document, io.ReadSeekCloser, error) {
+	if err := a.authorize(subject, category, rbac.RoleViewer); err != nil {
+		return model.Document{}, nil, err
+	}
+
+	doc, err := a.reader.GetDocumentVersion(ctx, category, docName, version)
+	if err != nil {
+		return model.Document{}, nil, err
+	}
+
+	switch doc.Status {
+	case model.DocStatusActive:
+	case model.DocStatusInvalid:
+		return model.Document{}, nil, ErrContentInvalid
+	default:
+		return model.Document{}, nil, ErrNoContent
+	}
+
+	content, err := a.openVerifiedContent(ctx, doc.ContentHash)
+	if err == ErrContentInvalid {
+		a.logger.Error("document content hash not matched!", zap.String("docName", doc.DocumentName), zap.String("category", doc.Category), zap.Int("version", doc.Version))
+		a.invalidateDoc(doc)
+		return model.Document{}, nil, err
+	}
+	if err == repository.ErrContentNotFound {
+		return model.Document{}, nil, err
+	}
+	if err != nil {
+		return model.Document{}, nil, errors.New("failed to get the document content: " + err.Error())
+	}
+
+	return doc, content, nil
+}
+
+// GetDocuments returns the documents of the author or signed by the signer, only of the categories visible to the subject
+func (a App) GetDocuments(ctx context.Context, subject rbac.Subject, author, signer string) (docs []model.Document, err error) {
 	if author == "" && signer == "" {
 		err = errors.New("at least one of params author and signer needs to be given")
 		return
 	}
 
 	if author != "" {
-		docs, err = a.blkchnClient.GetDocumentsOfAuthor(ctx, author)
+		docs, err = a.reader.GetDocumentsOfAuthor(ctx, author)
 
 	} else {
-		docs, err = a.blkchnClient.GetDocumentsSignedBy(ctx, signer)
+		docs, err = a.reader.GetDocumentsSignedBy(ctx, signer)
 	}
+	if err != nil {
+		return
+	}
+
+	allowed := a.allowedCategories(subject, rbac.RoleViewer)
+	var visible []model.Document
+	for _, doc := range docs {
+		if isAllowed(allowed, doc.Category) {
+			visible = append(visible, doc)
+		}
+	}
+	docs = visible
 
 	return a.fillAndVerifyDocContent(ctx, docs)
 }
 
+// SearchDocuments returns the document versions matching the query, the most relevant first
+func (a App) SearchDocuments(ctx context.Context, subject rbac.Subject, query search.Query) ([]search.Hit, error) {
+	if err := query.Normalize(); err != nil {
+		return nil, err
+	}
+
+	if query.Category != "" {
+		if err := a.authorize(subject, query.Category, rbac.RoleViewer); err != nil {
+			return nil, err
+		}
+	}
+	query.Categories = a.allowedCategories(subject, rbac.RoleViewer)
+
+	return a.index.Search(ctx, query)
+}
+
 func (a App) fillAndVerifyDocContent(ctx context.Context, docs []model.Document) (verified []model.Document, err error) {
 
+	var invalid []model.Document
+
 	// TODO: parallelize
 	for i, doc := range docs {
 
@@ -50,19 +132,19 @@ func (a App) fillAndVerifyDocContent(ctx context.Context, docs []model.Document)
 		}
 
 		if doc.Status == model.DocStatusActive {
-			docWithContent, err := a.db.FillDocumentContent(ctx, doc)
+			content, err := a.getContent(ctx, doc.ContentHash)
 			if err != nil {
 				a.logger.Error("error when getting the document content: "+err.Error(), zap.String("docName", doc.DocumentName), zap.String("category", doc.Category), zap.Int("version", doc.Version))
 				docs[i].Content = []byte("ERROR")
 				continue
 			}
 
-			dbContentHash := hashing.CalculateSHA512(string(docWithContent.Content))
+			dbContentHash := hashing.CalculateSHA512(string(content))
 			if dbContentHash == doc.ContentHash {
-				docs[i] = docWithContent
+				docs[i].Content = content
 				continue
 			} else {
-				a.invalidateDoc(doc)
+				invalid = append(invalid, doc)
 			}
 		}
 
@@ -70,14 +152,61 @@ func (a App) fillAndVerifyDocContent(ctx context.Context, docs []model.Document)
 		docs[i].Status = model.DocStatusInvalid
 	}
 
+	// the mismatched versions are invalidated in a single batch
+	if len(invalid) > 0 {
+		a.invalidateDocs(invalid)
+	}
+
 	a.logger.Info(fmt.Sprint("content hash checked, returning ", len(verified), "/", len(docs), " documents"))
 
 	return docs, nil
 }
 
 func (a App) invalidateDoc(doc model.Document) {
+	a.invalidateDocs([]model.Document{doc})
+}
+
+// invalidateDocs invalidates the doc versions on the blockchain, in a single batch per category as the app's key use
+// is authorized and audited per category; the catalog and the index are updated
+func (a App) invalidateDocs(docs []model.Document) {
+	var categories []string
+	byCategory := make(map[string][]model.Document)
+	for _, doc := range docs {
+		if _, ok := byCategory[doc.Category]; !ok {
+			categories = append(categories, doc.Category)
+		}
+		byCategory[doc.Category] = append(byCategory[doc.Category], doc)
+	}
+
+	for _, category := range categories {
+		a.invalidateCategoryDocs(category, byCategory[category])
+	}
+}
+
+func (a App) invalidateCategoryDocs(category string, docs []model.Document) {
+	names := make([]string, len(docs))
+	for i, doc := range docs {
+		names[i] = doc.DocumentName
+	}
+	target := strings.Join(names, ",")
+
 	// keep the invalid content in the db
-	if _, err := a.blkchnClient.InvalidateDocumentVersion(context.Background(), doc, a.appKeys.GetSigner()); err != nil {
-		a.logger.Error("can't invalidate the doc: " + err.Error())
+	signer, err := a.appSigner(context.Background(), keystore.Operation{Action: audit.ActionInvalidateDocument, Category: category, Target: target})
+	if err != nil {
+		a.logger.Error("can't invalidate the docs: "+err.Error(), zap.String("category", category))
+	} else if batchID, err := a.blkchnClient.InvalidateDocumentVersions(context.Background(), docs, signer); err != nil {
+		a.logger.Error("can't invalidate the docs: "+err.Error(), zap.String("category", category))
+	} else {
+		a.trackBatch(context.Background(), batchID, config.GetAppUserID(), audit.ActionInvalidateDocument, target)
+	}
+
+	for _, doc := range docs {
+		if err := a.catalog.UpdateDocumentStatus(context.Background(), doc.Category, doc.DocumentName, doc.Version, model.DocStatusInvalid); err != nil {
+			a.logger.Error("can't update the doc status in the catalog: " + err.Error())
+		}
+
+		if err := a.index.SetStatus(context.Background(), doc.Category, doc.DocumentName, doc.Version, model.DocStatusInvalid); err != nil {
+			a.logger.Error("can't update the indexed doc status: " + err.Error())
+		}
 	}
 }
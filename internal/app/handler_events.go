@@ -0,0 +1,34 @@
+package app
+
+import (
+	"context"
+	"doc-management/internal/inbox"
+	"doc-management/internal/rbac"
+	"errors"
+
+	"go.uber.org/zap"
+)
+
+// ListEvents returns the received events in the given status, the newest first;
+// allowed to the admins of all the categories
+func (a App) ListEvents(ctx context.Context, subject rbac.Subject, status inbox.Status, limit int) ([]inbox.Event, error) {
+	if err := a.authorize(subject, rbac.AllCategories, rbac.RoleAdmin); err != nil {
+		return nil, err
+	}
+	if !status.IsValid() {
+		return nil, errors.New("invalid event status: " + string(status))
+	}
+
+	return a.inbox.List(ctx, status, limit)
+}
+
+// ReplayEvent processes the dead-lettered event again, e.g. once the cause of the failures is fixed;
+// allowed to the admins of all the categories
+func (a App) ReplayEvent(ctx context.Context, subject rbac.Subject, eventID string) (inbox.Event, error) {
+	if err := a.authorize(subject, rbac.AllCategories, rbac.RoleAdmin); err != nil {
+		return inbox.Event{}, err
+	}
+
+	a.logger.Info("event replay requested", zap.String("userID", subject.UserID), zap.String("eventID", eventID))
+	return a.inbox.Replay(ctx, eventID)
+}
@@ -0,0 +1,67 @@
+package app
+
+import (
+	"context"
+	"doc-management/internal/config"
+	"doc-management/internal/keystore"
+	"doc-management/internal/rbac"
+	"doc-management/internal/signkeys"
+
+	"go.uber.org/zap"
+)
+
+// RotateKey replaces the signing key of the user, the users can rotate their own keys,
+// the admins of all the categories also the keys of others and of the application
+func (a App) RotateKey(ctx context.Context, subject rbac.Subject, userID, reason string) (keystore.KeyInfo, error) {
+	if err := a.authorizeKeyChange(subject, userID); err != nil {
+		return keystore.KeyInfo{}, err
+	}
+
+	return a.signers.Rotate(ctx, subject, userID, reason)
+}
+
+// RevokeKey blocks the key of the user, the current one if publicKey is empty; the current key is replaced by a new one
+func (a App) RevokeKey(ctx context.Context, subject rbac.Subject, userID, publicKey, reason string) (keystore.KeyInfo, error) {
+	if err := a.authorizeKeyChange(subject, userID); err != nil {
+		return keystore.KeyInfo{}, err
+	}
+
+	return a.signers.Revoke(ctx, subject, userID, publicKey, reason)
+}
+
+// GetKeys returns the current and the past public keys of the user, to verify the signatures made by the user;
+// the public keys are available to all the authenticated users
+func (a App) GetKeys(ctx context.Context, subject rbac.Subject, userID string) ([]keystore.KeyInfo, error) {
+	if subject.UserID == "" {
+		return nil, rbac.ErrForbidden
+	}
+
+	return a.signers.Keys(ctx, userID)
+}
+
+// AuditKeys verifies the key records of all the users, the mismatched public keys are replaced by the ones
+// derived from the private keys if repair is set; allowed to the admins of all the categories
+func (a App) AuditKeys(ctx context.Context, subject rbac.Subject, repair bool) ([]signkeys.KeyCheck, error) {
+	if err := a.authorize(subject, rbac.AllCategories, rbac.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	checks, err := a.signers.AuditKeys(ctx, repair)
+	if err != nil {
+		return nil, err
+	}
+
+	a.logger.Info("audited the key records", zap.String("userID", subject.UserID), zap.Bool("repair", repair), zap.Int("records", len(checks)))
+	return checks, nil
+}
+
+func (a App) authorizeKeyChange(subject rbac.Subject, userID string) error {
+	if subject.UserID == "" {
+		return rbac.ErrForbidden
+	}
+	if subject.UserID == userID && userID != config.GetAppUserID() {
+		return nil
+	}
+
+	return a.authorize(subject, rbac.AllCategories, rbac.RoleAdmin)
+}
@@ -0,0 +1,135 @@
+package app
+
+import (
+	"context"
+	"doc-management/internal/audit"
+	"doc-management/internal/blockchain"
+	"doc-management/internal/config"
+	"doc-management/internal/keystore"
+	"doc-management/internal/model"
+	"doc-management/internal/outbox"
+	"doc-management/internal/rbac"
+	"errors"
+	"io"
+
+	"go.uber.org/zap"
+)
+
+// ErrReconcilerDisabled is returned if the content store can't list its references
+var ErrReconcilerDisabled = errors.New("the content reconciliation is not supported by the content store")
+
+// submitThroughOutbox records the batch in the outbox, stores its content and submits it; if the submission fails,
+// it's retried in the background. If the content is nil, it's already stored and only the reference is added to it,
+// e.g. the content of the accepted proposal; nothing is stored for the batches without content.
+// Returns the batch ID, an error only if the batch can't be recorded or is rejected.
+func (a App) submitThroughOutbox(ctx context.Context, builder *blockchain.BatchBuilder, entry outbox.Entry, content io.Reader, size int64) (string, error) {
+	batchID, batchList, err := builder.Build()
+	if err != nil {
+		return "", err
+	}
+	entry.ID = batchID
+	entry.BatchList = batchList
+	if isEmptyContent(entry.ContentHash) {
+		entry.ContentHash = ""
+	}
+
+	// recorded first, so the content is released if the batch is never committed
+	entry, err = a.dispatcher.Add(ctx, entry)
+	if err != nil {
+		return "", err
+	}
+
+	if entry.ContentHash != "" {
+		if err := a.storeContentRef(ctx, entry, content, size); err != nil {
+			if cancelErr := a.dispatcher.Cancel(context.Background(), entry, err); cancelErr != nil {
+				a.logger.Error("failed to cancel the outbox batch: "+cancelErr.Error(), zap.String("batchID", batchID))
+			}
+			return "", err
+		}
+	}
+
+	entry, err = a.dispatcher.Submit(ctx, entry)
+	if err != nil {
+		return "", err
+	}
+	if entry.State == outbox.StatePending {
+		a.logger.Warn("batch submission failed, retrying in the background: "+entry.LastError, zap.String("batchID", batchID))
+	}
+
+	return batchID, nil
+}
+
+func (a App) storeContentRef(ctx context.Context, entry outbox.Entry, content io.Reader, size int64) error {
+	if content == nil {
+		return a.store.AddRef(ctx, entry.ContentHash, entry.ContentRef)
+	}
+	return a.putContent(ctx, entry.ContentHash, content, size, entry.ContentRef)
+}
+
+// removeProposal removes the proposal whose content is invalid or missing from the blockchain
+func (a App) removeProposal(ctx context.Context, proposal model.Proposal) error {
+	signer, err := a.appSigner(ctx, keystore.Operation{Action: audit.ActionRemoveProposal, Category: proposal.Category, Target: proposal.ProposalID})
+	if err != nil {
+		return err
+	}
+
+	template, err := blockchain.RemoveProposalTemplate(proposal)
+	if err != nil {
+		return err
+	}
+	builder := blockchain.NewBatchBuilder(signer)
+	if err := builder.Add("remove", template, signer); err != nil {
+		return err
+	}
+
+	batchID, err := a.submitThroughOutbox(ctx, builder, outbox.Entry{UserID: config.GetAppUserID(), Action: audit.ActionRemoveProposal, Target: proposal.ProposalID}, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	a.trackBatch(ctx, batchID, config.GetAppUserID(), audit.ActionRemoveProposal, proposal.ProposalID)
+	return nil
+}
+
+// ListOutbox returns the batches in the outbox in the given state, the newest first;
+// allowed to the admins of all the categories
+func (a App) ListOutbox(ctx context.Context, subject rbac.Subject, state outbox.State, limit int) ([]outbox.Entry, error) {
+	if err := a.authorize(subject, rbac.AllCategories, rbac.RoleAdmin); err != nil {
+		return nil, err
+	}
+	if !state.IsValid() {
+		return nil, errors.New("invalid outbox state: " + string(state))
+	}
+
+	return a.dispatcher.List(ctx, state, limit)
+}
+
+// ListReconciliationIssues returns the content issues found by the reconciler in the given status, all if it's empty;
+// allowed to the admins of all the categories
+func (a App) ListReconciliationIssues(ctx context.Context, subject rbac.Subject, status outbox.IssueStatus, limit int) ([]outbox.Issue, error) {
+	if err := a.authorize(subject, rbac.AllCategories, rbac.RoleAdmin); err != nil {
+		return nil, err
+	}
+	if status != "" && !status.IsValid() {
+		return nil, errors.New("invalid issue status: " + string(status))
+	}
+	if a.reconciler == nil {
+		return nil, ErrReconcilerDisabled
+	}
+
+	return a.reconciler.Issues(ctx, status, limit)
+}
+
+// Reconcile runs the content reconciliation now and returns the issues found;
+// allowed to the admins of all the categories
+func (a App) Reconcile(ctx context.Context, subject rbac.Subject) ([]outbox.Issue, error) {
+	if err := a.authorize(subject, rbac.AllCategories, rbac.RoleAdmin); err != nil {
+		return nil, err
+	}
+	if a.reconciler == nil {
+		return nil, ErrReconcilerDisabled
+	}
+
+	a.logger.Info("reconciliation requested", zap.String("userID", subject.UserID))
+	return a.reconciler.Reconcile(ctx)
+}
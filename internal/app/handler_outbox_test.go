@@ -0,0 +1,240 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"doc-management/internal/batches"
+	"doc-management/internal/blockchain"
+	"doc-management/internal/encryption"
+	"doc-management/internal/hashing"
+	"doc-management/internal/keystore"
+	"doc-management/internal/model"
+	"doc-management/internal/outbox"
+	"doc-management/internal/projection"
+	"doc-management/internal/rbac"
+	"doc-management/internal/repository"
+	"doc-management/internal/repository/filesystem"
+	"doc-management/internal/retry"
+	"doc-management/internal/signkeys"
+	"encoding/base64"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/sawtooth-sdk-go/signing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type memoryOutbox struct {
+	outbox.Store
+	mu      sync.Mutex
+	entries map[string]outbox.Entry
+}
+
+func (s *memoryOutbox) InsertOutboxEntry(ctx context.Context, entry outbox.Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[entry.ID] = entry
+	return nil
+}
+
+func (s *memoryOutbox) UpdateOutboxEntry(ctx context.Context, entry outbox.Entry) error {
+	return s.InsertOutboxEntry(ctx, entry)
+}
+
+func (s *memoryOutbox) list() []outbox.Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var entries []outbox.Entry
+	for _, entry := range s.entries {
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// acceptingChain accepts all the batches
+type acceptingChain struct{}
+
+func (acceptingChain) RelayBatchList(ctx context.Context, data []byte) error {
+	return nil
+}
+
+func (acceptingChain) GetBatchStatuses(ctx context.Context, batchIDs []string, wait time.Duration) ([]blockchain.BatchStatus, error) {
+	return nil, nil
+}
+
+type memoryBatches struct {
+	batches.Store
+}
+
+func (memoryBatches) InsertBatch(ctx context.Context, batch batches.Batch) error {
+	return nil
+}
+
+// keySigners signs everything with the same key
+type keySigners struct {
+	keystore.Signers
+	signer *signing.Signer
+}
+
+func (s keySigners) Signer(ctx context.Context, subject rbac.Subject, onBehalfOf string, op keystore.Operation) (*signing.Signer, error) {
+	return s.signer, nil
+}
+
+type noProposals struct {
+	projection.Repository
+}
+
+func (noProposals) GetDocProposals(ctx context.Context, category, docName string) ([]model.Proposal, error) {
+	return nil, blockchain.ErrNotFound
+}
+
+func newOutboxApp(t *testing.T, store repository.ContentStore) (App, *memoryOutbox) {
+	hashing.Initialize(zap.NewNop())
+	keys, err := signkeys.GenerateKeys()
+	require.NoError(t, err)
+
+	entries := &memoryOutbox{entries: make(map[string]outbox.Entry)}
+	logger := zap.NewNop()
+	return App{
+		logger:     logger,
+		store:      store,
+		reader:     noProposals{},
+		signers:    keySigners{signer: keys.GetSigner()},
+		policy:     rbac.Policy{DefaultRole: rbac.RoleProposer},
+		batches:    batches.NewTracker(logger, memoryBatches{}, acceptingChain{}, time.Second, time.Minute),
+		dispatcher: outbox.NewDispatcher(logger, entries, acceptingChain{}, store, retry.Policy{MaxAttempts: 1}, time.Second),
+	}, entries
+}
+
+// testStore is the content store used by the app and the store listing what's stored underneath
+type testStore struct {
+	repository.ContentStore
+	stored repository.RewritableStore
+}
+
+// contentStores returns the plain and the encrypted store
+func contentStores(t *testing.T) map[string]testStore {
+	blobs, err := filesystem.NewStore(t.TempDir())
+	require.NoError(t, err)
+	plain := repository.NewRefCountedStore(blobs)
+
+	key := make([]byte, 32)
+	_, err = rand.Read(key)
+	require.NoError(t, err)
+	keyring := encryption.NewKeyring()
+	require.NoError(t, keyring.AddKey("first", base64.StdEncoding.EncodeToString(key)))
+	encryptedBlobs, err := filesystem.NewStore(t.TempDir())
+	require.NoError(t, err)
+	encryptedPlain := repository.NewRefCountedStore(encryptedBlobs)
+	encrypted, err := repository.NewEncryptedStore(encryptedPlain, keyring)
+	require.NoError(t, err)
+
+	return map[string]testStore{"plain": {plain, plain}, "encrypted": {encrypted, encryptedPlain}}
+}
+
+func TestRemoveProposal(t *testing.T) {
+	for name, store := range contentStores(t) {
+		t.Run(name, func(t *testing.T) {
+			a, entries := newOutboxApp(t, store.ContentStore)
+
+			proposal := model.Proposal{ProposalID: "some-proposal", DocumentName: "contract", Category: "legal", ContentHash: hashing.CalculateSHA512("mala agatka")}
+			require.NoError(t, a.removeProposal(context.TODO(), proposal))
+
+			submitted := entries.list()
+			require.Len(t, submitted, 1)
+			assert.Equal(t, outbox.StateSubmitted, submitted[0].State)
+			assert.Empty(t, submitted[0].ContentHash)
+
+			stored, err := store.stored.ListContent(context.TODO())
+			require.NoError(t, err)
+			assert.Empty(t, stored)
+		})
+	}
+}
+
+func TestAddRemovalProposal(t *testing.T) {
+	for name, store := range contentStores(t) {
+		t.Run(name, func(t *testing.T) {
+			a, entries := newOutboxApp(t, store.ContentStore)
+
+			removal := model.Proposal{DocumentName: "contract", Category: "legal", ProposedStatus: model.DocStatusRemoved}
+			proposal, _, err := a.AddProposal(context.TODO(), rbac.Subject{UserID: "some-user"}, removal, nil)
+			require.NoError(t, err)
+			assert.Equal(t, hashing.CalculateSHA512(""), proposal.ContentHash)
+
+			submitted := entries.list()
+			require.Len(t, submitted, 1)
+			assert.Equal(t, outbox.StateSubmitted, submitted[0].State)
+			assert.Empty(t, submitted[0].ContentHash)
+
+			stored, err := store.stored.ListContent(context.TODO())
+			require.NoError(t, err)
+			assert.Empty(t, stored)
+		})
+	}
+}
+
+// acceptBatch returns the batch recording the new doc version
+func acceptBatch(t *testing.T, a App, newDoc model.Document) *blockchain.BatchBuilder {
+	signer, err := a.signers.Signer(context.TODO(), rbac.Subject{}, "", keystore.Operation{})
+	require.NoError(t, err)
+	template, err := blockchain.DocumentVersionTemplate(newDoc)
+	require.NoError(t, err)
+	builder := blockchain.NewBatchBuilder(signer)
+	require.NoError(t, builder.Add("document", template, signer))
+	return builder
+}
+
+func TestSubmitAcceptedVersion(t *testing.T) {
+	for name, store := range contentStores(t) {
+		t.Run(name, func(t *testing.T) {
+			a, entries := newOutboxApp(t, store.ContentStore)
+
+			content := []byte("mala agatka")
+			proposal := model.Proposal{ProposalID: "some-proposal", DocumentName: "contract", Category: "legal", ModificationAuthor: "some-user", ContentHash: hashing.CalculateSHA512(string(content))}
+			require.NoError(t, store.PutContent(context.TODO(), proposal.ContentHash, content, repository.ProposalRef(proposal.ProposalID)))
+			newDoc := model.NewDocumentFromProposal(proposal, 1)
+
+			// the content isn't given again
+			_, err := a.submitThroughOutbox(context.TODO(), acceptBatch(t, a, newDoc), acceptEntry("some-user", proposal, newDoc), nil, 0)
+			require.NoError(t, err)
+
+			submitted := entries.list()
+			require.Len(t, submitted, 1)
+			assert.Equal(t, outbox.StateSubmitted, submitted[0].State)
+
+			refs, err := store.stored.(repository.RefListingStore).ListRefs(context.TODO())
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{repository.ProposalRef(proposal.ProposalID), docVersionRef(newDoc)}, refs[proposal.ContentHash])
+
+			stored, err := store.GetContent(context.TODO(), proposal.ContentHash)
+			require.NoError(t, err)
+			assert.Equal(t, content, stored)
+		})
+	}
+}
+
+func TestSubmitAcceptedVersionWithoutContent(t *testing.T) {
+	for name, store := range contentStores(t) {
+		t.Run(name, func(t *testing.T) {
+			a, entries := newOutboxApp(t, store.ContentStore)
+
+			proposal := model.Proposal{ProposalID: "some-proposal", DocumentName: "contract", Category: "legal", ModificationAuthor: "some-user", ContentHash: hashing.CalculateSHA512("mala agatka")}
+			newDoc := model.NewDocumentFromProposal(proposal, 1)
+
+			_, err := a.submitThroughOutbox(context.TODO(), acceptBatch(t, a, newDoc), acceptEntry("some-user", proposal, newDoc), nil, 0)
+			assert.ErrorIs(t, err, repository.ErrContentNotFound)
+
+			failed := entries.list()
+			require.Len(t, failed, 1)
+			assert.Equal(t, outbox.StateFailed, failed[0].State)
+
+			stored, err := store.stored.ListContent(context.TODO())
+			require.NoError(t, err)
+			assert.Empty(t, stored)
+		})
+	}
+}
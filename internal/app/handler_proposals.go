@@ -2,96 +2,293 @@ package app
 
 import (
 	"context"
+	"doc-management/internal/audit"
+	"doc-management/internal/batches"
 	"doc-management/internal/blockchain"
 	"doc-management/internal/hashing"
+	"doc-management/internal/keystore"
 	"doc-management/internal/model"
+	"doc-management/internal/outbox"
+	"doc-management/internal/paging"
+	"doc-management/internal/projection"
+	"doc-management/internal/rbac"
+	"doc-management/internal/repository"
 	"errors"
 	"fmt"
+	"io"
 
+	"github.com/hyperledger/sawtooth-sdk-go/signing"
 	"go.uber.org/zap"
 )
 
-var ErrProposalExists = errors.New("proposal already exists")
+var (
+	ErrProposalExists = errors.New("proposal already exists")
+	ErrAlreadySigned  = errors.New("the proposal is already signed by the user")
+	ErrOwnProposal    = errors.New("the author can't sign the own proposal")
+)
 
 func (a App) getProposalData(ctx context.Context, proposalID string) (model.Proposal, error) {
-	proposal, err := a.blkchnClient.GetProposal(ctx, proposalID)
+	proposal, err := a.getProposal(ctx, proposalID)
+	if err == ErrContentInvalid {
+		return model.Proposal{}, errors.New("can't accept the proposal, content verification failed, proposalID: " + proposalID)
+	}
+
+	return proposal, err
+}
+
+// GetProposal returns the proposal with its full content verified against the content hash
+func (a App) GetProposal(ctx context.Context, subject rbac.Subject, proposalID string) (model.Proposal, error) {
+	proposal, err := a.reader.GetProposal(ctx, proposalID)
 	if err != nil {
 		return model.Proposal{}, err
 	}
 
+	if err := a.authorize(subject, proposal.Category, rbac.RoleViewer); err != nil {
+		return model.Proposal{}, err
+	}
+
+	return a.verifyProposal(ctx, proposal)
+}
+
+// OpenProposal returns the proposal with its full content, the content is verified against the hash
+// stored on the blockchain before it's returned; the returned content must be closed
+func (a App) OpenProposal(ctx context.Context, subject rbac.Subject, proposalID string) (model.Proposal, io.ReadSeekCloser, error) {
+	proposal, err := a.reader.GetProposal(ctx, proposalID)
+	if err != nil {
+		return model.Proposal{}, nil, err
+	}
+
+	if err := a.authorize(subject, proposal.Category, rbac.RoleViewer); err != nil {
+		return model.Proposal{}, nil, err
+	}
+
+	content, err := a.openVerifiedContent(ctx, proposal.ContentHash)
+	if err == ErrContentInvalid {
+		a.discardInvalidProposal(proposal, "")
+		return model.Proposal{}, nil, err
+	}
+	if err == repository.ErrContentNotFound {
+		return model.Proposal{}, nil, err
+	}
+	if err != nil {
+		return model.Proposal{}, nil, errors.New("failed to get the proposal content: " + err.Error())
+	}
+
+	return proposal, content, nil
+}
+
+// getProposal returns the proposal without checking the permissions, used by the application itself
+func (a App) getProposal(ctx context.Context, proposalID string) (model.Proposal, error) {
+	proposal, err := a.reader.GetProposal(ctx, proposalID)
+	if err != nil {
+		return model.Proposal{}, err
+	}
+
+	return a.verifyProposal(ctx, proposal)
+}
+
+func (a App) verifyProposal(ctx context.Context, proposal model.Proposal) (model.Proposal, error) {
 	filled, err := a.fillAndVerifyProposalContent(ctx, []model.Proposal{proposal})
 	if err != nil {
 		return model.Proposal{}, err
 	}
 
 	if len(filled) < 1 {
-		return model.Proposal{}, errors.New("can't accept the proposal, content verification failed, proposalID: " + proposalID)
+		return model.Proposal{}, ErrContentInvalid
 	}
 
 	return filled[0], nil
+}
+
+// SignProposal signs the proposal by the subject, or by the user given in onBehalfOf if the subject is an admin;
+// returns the submitted batch, pending until the vote is committed
+func (a App) SignProposal(ctx context.Context, subject rbac.Subject, proposalID string, onBehalfOf string) (batches.Batch, error) {
+	proposal, err := a.reader.GetProposal(ctx, proposalID)
+	if err != nil {
+		return batches.Batch{}, err
+	}
+
+	if err := a.authorize(subject, proposal.Category, rbac.RoleSigner); err != nil {
+		return batches.Batch{}, err
+	}
+
+	userID, err := a.actingAs(ctx, subject, onBehalfOf, proposal.Category, audit.ActionSignOnBehalf, proposalID)
+	if err != nil {
+		return batches.Batch{}, err
+	}
+	if err := checkVoter(proposal, userID); err != nil {
+		return batches.Batch{}, err
+	}
+
+	signer, err := a.signers.Signer(ctx, subject, userID, keystore.Operation{Action: audit.ActionSignProposal, Category: proposal.Category, Target: proposalID})
+	if err != nil {
+		return batches.Batch{}, err
+	}
+
+	if a.isFinalVote(ctx, proposal) {
+		newDoc, accepted, err := a.prepareAcceptedVersion(ctx, proposalID, userID)
+		if err == nil {
+			return a.signAndAccept(ctx, subject, accepted, newDoc, userID, signer)
+		}
+		a.logger.Warn("submitting the final vote alone, the doc version is submitted when the proposal is accepted: "+err.Error(), zap.String("proposalID", proposalID))
+	}
+
+	batchID, err := a.blkchnClient.SignProposal(ctx, proposalID, userID, signer)
+	if err != nil {
+		return batches.Batch{}, err
+	}
 
+	a.logger.Debug("proposal vote submitted, batch ID: " + batchID)
+	return a.trackBatch(ctx, batchID, subject.UserID, audit.ActionSignProposal, proposalID), nil
 }
 
-// SignProposal user ID refers to a user who signs the proposal
-func (a App) SignProposal(ctx context.Context, proposalID string, userID string) error {
+// isFinalVote tells if the next vote accepts the proposal; false if the vote threshold can't be read
+func (a App) isFinalVote(ctx context.Context, proposal model.Proposal) bool {
+	if proposal.CurrentStatus != model.ProposalStatusActive {
+		return false
+	}
 
-	user, err := a.userManager.GetUserByID(ctx, userID)
+	threshold, err := a.blkchnClient.GetVoteThreshold(ctx)
 	if err != nil {
-		return err
+		a.logger.Debug("can't read the vote threshold: " + err.Error())
+		return false
 	}
 
-	transactionID, err := a.blkchnClient.SignProposal(ctx, proposalID, userID, user.Keys.GetSigner())
+	return len(proposal.Signers)+1 >= threshold
+}
+
+// prepareAcceptedVersion returns the proposal with its verified content and the doc version it becomes
+// with the user's final vote
+func (a App) prepareAcceptedVersion(ctx context.Context, proposalID, userID string) (model.Document, model.Proposal, error) {
+	proposal, err := a.getProposalData(ctx, proposalID)
 	if err != nil {
-		return err
+		return model.Document{}, model.Proposal{}, err
+	}
+	proposal.Signers = append(proposal.Signers, userID)
+
+	newDoc, err := a.nextDocVersion(ctx, proposal)
+	if err != nil {
+		return model.Document{}, model.Proposal{}, err
+	}
+
+	return newDoc, proposal, nil
+}
+
+// signAndAccept submits the final vote together with the new doc version in one batch, so the version
+// is recorded atomically with the acceptance; the proposal_accepted event finds the version already recorded
+func (a App) signAndAccept(ctx context.Context, subject rbac.Subject, proposal model.Proposal, newDoc model.Document, userID string, voter *signing.Signer) (batches.Batch, error) {
+	appSigner, err := a.appSigner(ctx, keystore.Operation{Action: audit.ActionAcceptDocument, Category: newDoc.Category, Target: proposal.ProposalID})
+	if err != nil {
+		return batches.Batch{}, err
+	}
+
+	builder, err := a.blkchnClient.SignAndAcceptBatch(ctx, proposal.ProposalID, userID, voter, newDoc, appSigner)
+	if err != nil {
+		return batches.Batch{}, err
+	}
+
+	// the content is already stored with the proposal, only the doc version reference is added
+	batchID, err := a.submitThroughOutbox(ctx, builder, acceptEntry(subject.UserID, proposal, newDoc), nil, 0)
+	if err != nil {
+		return batches.Batch{}, err
+	}
+
+	a.logger.Info("final vote submitted with the new doc version, batch ID: "+batchID, zap.String("docName", newDoc.DocumentName), zap.Int("version", newDoc.Version))
+	return a.trackBatch(ctx, batchID, subject.UserID, audit.ActionSignProposal, proposal.ProposalID), nil
+}
+
+// checkVoter rejects the votes the transaction processor would reject
+func checkVoter(proposal model.Proposal, userID string) error {
+	if proposal.ModificationAuthor == userID {
+		return ErrOwnProposal
+	}
+	for _, signer := range proposal.Signers {
+		if signer == userID {
+			return ErrAlreadySigned
+		}
 	}
 
-	a.logger.Debug("proposal signed, transaction ID: " + transactionID)
 	return nil
 }
 
-func (a App) GetToSignProposals(ctx context.Context, userID string) (propos []model.Proposal, err error) {
-	propos, err = a.blkchnClient.GetActiveProposals(ctx)
+// SignProposalTemplate returns the unsigned transaction of the subject's vote, to be signed by the client
+func (a App) SignProposalTemplate(ctx context.Context, subject rbac.Subject, proposalID string) (blockchain.TransactionTemplate, error) {
+	proposal, err := a.reader.GetProposal(ctx, proposalID)
 	if err != nil {
-		return
+		return blockchain.TransactionTemplate{}, err
 	}
 
-	var proposByOthers []model.Proposal
-	for _, p := range propos {
-		if p.ModificationAuthor != userID {
-			proposByOthers = append(proposByOthers, p)
+	if err := a.authorize(subject, proposal.Category, rbac.RoleSigner); err != nil {
+		return blockchain.TransactionTemplate{}, err
+	}
+	if err := checkVoter(proposal, subject.UserID); err != nil {
+		return blockchain.TransactionTemplate{}, err
+	}
+
+	return a.blkchnClient.SignProposalTemplate(ctx, proposalID, subject.UserID)
+}
+
+// ListProposals returns a page of the proposals matching the filter, with their content.
+// Only the categories visible to the subject are listed; the proposals to be signed,
+// selected by NotAuthor, only from the categories where the subject can sign.
+func (a App) ListProposals(ctx context.Context, subject rbac.Subject, filter projection.ProposalFilter, page paging.Page) (propos []model.Proposal, next string, total int64, err error) {
+	if filter.Status != "" && !filter.Status.IsValid() {
+		return nil, "", 0, errors.New("invalid proposal status: " + string(filter.Status))
+	}
+
+	role := rbac.RoleViewer
+	if filter.NotAuthor != "" {
+		role = rbac.RoleSigner
+	}
+	if filter.Category != "" {
+		if err := a.authorize(subject, filter.Category, role); err != nil {
+			return nil, "", 0, err
 		}
 	}
-	a.logger.Info(fmt.Sprint("to sign: ", len(proposByOthers), "/", len(propos)), zap.String("userID", userID))
+	filter.Categories = a.allowedCategories(subject, role)
+
+	page.Normalize()
+	propos, next, total, err = a.reader.ListProposals(ctx, filter, page)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	a.logger.Info(fmt.Sprint("listed ", len(propos), "/", total, " proposals"), zap.String("category", filter.Category), zap.String("author", filter.Author), zap.String("notAuthor", filter.NotAuthor))
 
-	return a.fillAndVerifyProposalContent(ctx, proposByOthers)
+	propos, err = a.fillAndVerifyProposalContent(ctx, propos)
+	return propos, next, total, err
+}
+
+// discardInvalidProposal releases the content not matching the proposal's content hash and removes the proposal
+func (a App) discardInvalidProposal(p model.Proposal, dbContentHash string) {
+	a.logger.Error("proposal content hash not matched! removing...", zap.String("proposalID", p.ProposalID), zap.String("dbHash", dbContentHash), zap.String("expectedHash", p.ContentHash))
+
+	if err := a.releaseContent(context.Background(), p.ContentHash, repository.ProposalRef(p.ProposalID)); err != nil {
+		a.logger.Error("failed to remove the proposal content: " + err.Error())
+	}
+
+	if err := a.removeProposal(context.Background(), p); err != nil {
+		a.logger.Error("can't remove the proposal from blockchain: " + err.Error())
+	}
 }
 
 func (a App) fillAndVerifyProposalContent(ctx context.Context, propos []model.Proposal) ([]model.Proposal, error) {
 	var verified []model.Proposal
 	// TODO: parallelize
 	for _, p := range propos {
-		pWithContent, err := a.db.FillProposalContent(ctx, p)
+		content, err := a.getContent(ctx, p.ContentHash)
 		if err != nil {
 			a.logger.Error("error when getting the proposal content: "+err.Error(), zap.String("proposalID", p.ProposalID))
 			continue
 		}
 
-		dbContentHash := hashing.CalculateSHA512(string(pWithContent.Content))
+		dbContentHash := hashing.CalculateSHA512(string(content))
 		if dbContentHash != p.ContentHash {
-			a.logger.Error("proposal content hash not matched! removing...", zap.String("proposalID", p.ProposalID), zap.String("dbHash", dbContentHash), zap.String("expectedHash", p.ContentHash))
-
-			if err := a.db.RemoveProposal(context.Background(), p); err != nil {
-				a.logger.Error("failed to remove the proposal from db: " + err.Error())
-			}
-
-			if _, err := a.blkchnClient.RemoveProposal(context.Background(), p.ProposalID, a.appKeys.GetSigner()); err != nil {
-				a.logger.Error("can't remove the proposal from blockchain: " + err.Error())
-			}
-
+			a.discardInvalidProposal(p, dbContentHash)
 			continue
 		}
 
-		verified = append(verified, pWithContent)
+		p.Content = content
+		verified = append(verified, p)
 	}
 
 	a.logger.Info(fmt.Sprint("content hash checked, returning ", len(verified), "/", len(propos), " proposals"))
@@ -99,31 +296,97 @@ func (a App) fillAndVerifyProposalContent(ctx context.Context, propos []model.Pr
 	return verified, nil
 }
 
-func (a App) GetUserProposals(ctx context.Context, userID string) (propos []model.Proposal, err error) {
-	propos, err = a.blkchnClient.GetUserProposals(ctx, userID)
+// AddProposal submits the proposal authored by the subject, or by the user given in proposal.ModificationAuthor
+// if the subject is an admin; returns the submitted proposal and its batch, pending until it's committed.
+// The content is read from the reader, its hash and size filled in the proposal by the caller, or taken from proposal.Content if the reader is nil.
+func (a App) AddProposal(ctx context.Context, subject rbac.Subject, proposal model.Proposal, content io.Reader) (model.Proposal, batches.Batch, error) {
+	proposal, err := a.prepareProposal(ctx, subject, proposal)
 	if err != nil {
-		// if this user doesn't yet exist on the blockchain
-		// he simply hasn't created anything = no error
-		if err == blockchain.ErrNotFound {
-			return propos, nil
-		}
+		return model.Proposal{}, batches.Batch{}, err
+	}
+
+	signer, err := a.signers.Signer(ctx, subject, proposal.ModificationAuthor, keystore.Operation{Action: audit.ActionSubmitProposal, Category: proposal.Category, Target: proposal.ProposalID})
+	if err != nil {
+		return model.Proposal{}, batches.Batch{}, err
+	}
+
+	a.logger.Info("submitting proposal", zap.String("docName", proposal.DocumentName), zap.String("author", proposal.ModificationAuthor), zap.String("proposalID", proposal.ProposalID))
+
+	template, err := blockchain.ProposalTemplate(proposal)
+	if err != nil {
+		return model.Proposal{}, batches.Batch{}, err
+	}
+	builder := blockchain.NewBatchBuilder(signer)
+	if err := builder.Add("proposal", template, signer); err != nil {
+		return model.Proposal{}, batches.Batch{}, err
+	}
+
+	// the content is stored only after the batch is recorded, and released if the batch is never committed
+	entry := outbox.Entry{
+		UserID:      subject.UserID,
+		Action:      audit.ActionSubmitProposal,
+		Target:      proposal.ProposalID,
+		ContentHash: proposal.ContentHash,
+		ContentRef:  repository.ProposalRef(proposal.ProposalID),
+	}
+	batchID, err := a.submitThroughOutbox(ctx, builder, entry, proposalContent(proposal, content), proposal.ContentSize)
+	if err != nil {
+		return model.Proposal{}, batches.Batch{}, err
+	}
+
+	a.logger.Info("proposal submitted, batch ID: "+batchID, zap.String("docName", proposal.DocumentName), zap.String("author", proposal.ModificationAuthor))
+
+	return proposal, a.trackBatch(ctx, batchID, subject.UserID, audit.ActionSubmitProposal, proposal.ProposalID), nil
+}
+
+// ProposalTemplate stores the content of the subject's proposal and returns the unsigned transaction submitting it,
+// to be signed by the client; the content is kept until the proposal is removed. The content is read as in AddProposal.
+func (a App) ProposalTemplate(ctx context.Context, subject rbac.Subject, proposal model.Proposal, content io.Reader) (model.Proposal, blockchain.TransactionTemplate, error) {
+	// the client signs with its own key, so it can't act on behalf of others
+	if proposal.ModificationAuthor != "" && proposal.ModificationAuthor != subject.UserID {
+		return model.Proposal{}, blockchain.TransactionTemplate{}, rbac.ErrForbidden
+	}
+
+	proposal, err := a.prepareProposal(ctx, subject, proposal)
+	if err != nil {
+		return model.Proposal{}, blockchain.TransactionTemplate{}, err
+	}
+
+	template, err := blockchain.ProposalTemplate(proposal)
+	if err != nil {
+		return model.Proposal{}, blockchain.TransactionTemplate{}, err
+	}
 
-		return propos, err
+	if err := a.putContent(ctx, proposal.ContentHash, proposalContent(proposal, content), proposal.ContentSize, repository.ProposalRef(proposal.ProposalID)); err != nil {
+		return model.Proposal{}, blockchain.TransactionTemplate{}, err
 	}
 
-	return a.fillAndVerifyProposalContent(ctx, propos)
+	a.logger.Info("proposal prepared for the client signing", zap.String("docName", proposal.DocumentName), zap.String("author", proposal.ModificationAuthor), zap.String("proposalID", proposal.ProposalID))
+
+	return proposal, template, nil
 }
 
-func (a App) AddProposal(ctx context.Context, proposal model.Proposal) error {
+// prepareProposal completes and validates the proposal, checks the subject's permissions and that it's not a duplicate
+func (a App) prepareProposal(ctx context.Context, subject rbac.Subject, proposal model.Proposal) (model.Proposal, error) {
 
 	// fill in the missing fields with defaults and validate
 	proposal.Complete()
 	if err := proposal.Validate(); err != nil {
-		return err
+		return model.Proposal{}, err
 	}
 
+	if err := a.authorize(subject, proposal.Category, rbac.RoleProposer); err != nil {
+		return model.Proposal{}, err
+	}
+
+	author, err := a.actingAs(ctx, subject, proposal.ModificationAuthor, proposal.Category, audit.ActionProposeOnBehalf, proposal.ProposalID)
+	if err != nil {
+		return model.Proposal{}, err
+	}
+	proposal.ModificationAuthor = author
+
 	// check if this proposal already exists
-	existingPropos, err := a.blkchnClient.GetDocProposals(ctx, proposal.Category, proposal.DocumentName)
+	existingPropos, err := a.reader.GetDocProposals(ctx, proposal.Category, proposal.DocumentName)
 	if err != nil && err != blockchain.ErrNotFound {
 		a.logger.Error(fmt.Sprint("failed to get the existing proposals for the document ", proposal.DocumentName,
 			", category ", proposal.Category, "; proceeding with submitting the new proposal"))
@@ -132,32 +395,9 @@ func (a App) AddProposal(ctx context.Context, proposal model.Proposal) error {
 	for _, existing := range existingPropos {
 		if existing.ContentHash == proposal.ContentHash {
 			a.logger.Debug("proposal already exists", zap.String("category", proposal.Category), zap.String("docName", proposal.DocumentName), zap.String("existingProposalID", existing.ProposalID))
-			return ErrProposalExists
+			return model.Proposal{}, ErrProposalExists
 		}
 	}
 
-	user, err := a.userManager.GetUserByID(ctx, proposal.ModificationAuthor)
-	if err != nil {
-		return err
-	}
-
-	a.logger.Info("submitting proposal", zap.String("docName", proposal.DocumentName), zap.String("author", proposal.ModificationAuthor), zap.String("proposalID", proposal.ProposalID))
-
-	// first insert the transaction to the DB
-	if err := a.db.InsertProposal(ctx, proposal); err != nil {
-		return err
-	}
-
-	// submit to blockchain only if all the previous operations succeeded, as this action is irreversible
-	transactionID, err := a.blkchnClient.SubmitProposal(ctx, proposal, user.Keys.GetSigner())
-	if err != nil {
-		// remove the doc from the database
-		a.logger.Debug("removing the proposal content from the database on error", zap.String("proposalID", proposal.ProposalID))
-		_ = a.db.RemoveProposal(context.Background(), proposal)
-		return err
-	}
-
-	a.logger.Info("proposal submitted, transaction ID: "+transactionID, zap.String("docName", proposal.DocumentName), zap.String("author", proposal.ModificationAuthor))
-
-	return nil
+	return proposal, nil
 }
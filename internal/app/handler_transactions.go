@@ -0,0 +1,184 @@
+package app
+
+import (
+	"context"
+	"doc-management/internal/audit"
+	"doc-management/internal/batches"
+	"doc-management/internal/blockchain"
+	"doc-management/internal/blockchain/proposalfamily"
+	"doc-management/internal/keystore"
+	"doc-management/internal/rbac"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var ErrClientSigningDisabled = errors.New("client signing is disabled")
+
+// ClientSigning tells if the users sign their transactions themselves, the app only relays them
+func (a App) ClientSigning() bool {
+	return a.clientSigning
+}
+
+// RelayTransactions submits the batch list signed by the client after checking that each transaction is made
+// by the subject and allowed to it; returns the relayed batches, pending until they are committed
+func (a App) RelayTransactions(ctx context.Context, subject rbac.Subject, data []byte) ([]batches.Batch, error) {
+	if !a.clientSigning {
+		return nil, ErrClientSigningDisabled
+	}
+	if subject.UserID == "" {
+		return nil, rbac.ErrForbidden
+	}
+
+	signed, err := blockchain.ParseBatchList(data)
+	if err != nil {
+		return nil, err
+	}
+
+	activeKey, err := a.activeKey(ctx, subject.UserID)
+	if err != nil {
+		return nil, err
+	}
+	// the revocation failed to replace the key
+	if err := a.checkKeyNotRevoked(ctx, activeKey); err != nil {
+		return nil, err
+	}
+
+	for _, batch := range signed {
+		if err := a.checkSignerKey(subject, activeKey, batch.SignerPublicKey); err != nil {
+			return nil, err
+		}
+
+		for _, transaction := range batch.Transactions {
+			if err := a.checkSignerKey(subject, activeKey, transaction.SignerPublicKey); err != nil {
+				return nil, err
+			}
+			if err := a.checkClientTransaction(ctx, subject, transaction); err != nil {
+				a.logger.Warn("refused to relay a client signed transaction: "+err.Error(), zap.String("userID", subject.UserID), zap.String("batchID", batch.ID))
+				return nil, err
+			}
+		}
+	}
+
+	// don't let the transactions pass unrecorded
+	for _, batch := range signed {
+		entry := audit.Entry{
+			Time:      time.Now().UTC(),
+			Action:    audit.ActionRelayBatch,
+			Actor:     subject.UserID,
+			Target:    batch.ID,
+			PublicKey: batch.SignerPublicKey,
+		}
+		if err := a.audit.Record(ctx, entry); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := a.blkchnClient.RelayBatchList(ctx, data); err != nil {
+		return nil, err
+	}
+
+	relayed := make([]batches.Batch, len(signed))
+	for i, batch := range signed {
+		relayed[i] = a.trackBatch(ctx, batch.ID, subject.UserID, audit.ActionRelayBatch, "")
+	}
+
+	a.logger.Info(fmt.Sprint("relayed ", len(relayed), " client signed batches"), zap.String("userID", subject.UserID))
+	return relayed, nil
+}
+
+// checkClientTransaction checks that the transaction is a proposal or a vote of the subject, allowed by its role,
+// and that it uses exactly the addresses of its payload
+func (a App) checkClientTransaction(ctx context.Context, subject rbac.Subject, transaction blockchain.SignedTransaction) error {
+	payload, err := blockchain.DecodeProposalTransaction(transaction)
+	if err != nil {
+		return err
+	}
+
+	switch payload.Action {
+	case proposalfamily.ActionInsert:
+		if payload.Author != subject.UserID {
+			return rbac.ErrForbidden
+		}
+		if err := a.authorize(subject, payload.Category, rbac.RoleProposer); err != nil {
+			return err
+		}
+
+		// the content is uploaded when the proposal template is requested
+		proposal := payload.Proposal()
+		if !isEmptyContent(proposal.ContentHash) {
+			stored, err := a.store.HasContent(ctx, proposal.ContentHash)
+			if err != nil {
+				return err
+			}
+			if !stored {
+				return fmt.Errorf("%w: the content of proposal %s is not uploaded", blockchain.ErrInvalidBatch, proposal.ProposalID)
+			}
+		}
+
+		return blockchain.CheckAddresses(transaction, blockchain.ProposalAddresses(proposal))
+
+	case proposalfamily.ActionVote:
+		if payload.Voter != subject.UserID {
+			return rbac.ErrForbidden
+		}
+
+		proposal, err := a.reader.GetProposal(ctx, payload.ProposalID)
+		if err != nil {
+			return err
+		}
+		if err := a.authorize(subject, proposal.Category, rbac.RoleSigner); err != nil {
+			return err
+		}
+		if err := checkVoter(proposal, payload.Voter); err != nil {
+			return err
+		}
+
+		return blockchain.CheckAddresses(transaction, blockchain.VoteAddresses(proposal, payload.Voter))
+	}
+
+	// the proposals are removed only by the application
+	return rbac.ErrForbidden
+}
+
+// activeKey returns the user's active public key, the only key the user's batches are relayed with
+func (a App) activeKey(ctx context.Context, userID string) (string, error) {
+	keys, err := a.signers.Keys(ctx, userID)
+	if err != nil {
+		return "", errors.New("failed to get the user's keys: " + err.Error())
+	}
+
+	for _, key := range keys {
+		if key.Status == keystore.KeyStatusActive {
+			return key.PublicKey, nil
+		}
+	}
+	return "", fmt.Errorf("%w: the user has no active key", rbac.ErrForbidden)
+}
+
+// checkSignerKey rejects the batches and transactions signed by any other key than the subject's active one,
+// e.g. by another user's key or by a retired or revoked key
+func (a App) checkSignerKey(subject rbac.Subject, activeKey, publicKey string) error {
+	if !strings.EqualFold(publicKey, activeKey) {
+		a.logger.Warn("refused to relay a batch not signed by the user's active key", zap.String("userID", subject.UserID), zap.String("publicKey", publicKey))
+		return fmt.Errorf("%w: signed by the key %s, not the active key of the user", rbac.ErrForbidden, publicKey)
+	}
+
+	return nil
+}
+
+func (a App) checkKeyNotRevoked(ctx context.Context, publicKey string) error {
+	revoked, err := a.signers.Revoked(ctx, publicKey)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		a.logger.Warn("refused to relay a batch signed by a revoked key", zap.String("publicKey", publicKey))
+		return fmt.Errorf("%w: signed by the revoked key %s", blockchain.ErrInvalidBatch, publicKey)
+	}
+
+	return nil
+}
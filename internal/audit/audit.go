@@ -0,0 +1,49 @@
+package audit
+
+import (
+	"context"
+	"time"
+)
+
+// Action is an operation recorded in the audit log
+type Action string
+
+const (
+	// a proposal submitted by an admin in the name of another user
+	ActionProposeOnBehalf Action = "propose_on_behalf"
+	// a proposal signed by an admin in the name of another user
+	ActionSignOnBehalf Action = "sign_on_behalf"
+
+	// the signing operations, recorded whenever a key is used
+	ActionSubmitProposal     Action = "submit_proposal"
+	ActionSignProposal       Action = "sign_proposal"
+	ActionRemoveProposal     Action = "remove_proposal"
+	ActionAcceptDocument     Action = "accept_document"
+	ActionInvalidateDocument Action = "invalidate_document"
+	// a batch signed by the user outside the application, only relayed
+	ActionRelayBatch Action = "relay_batch"
+
+	// the key management, the public key is the affected one
+	ActionRotateKey Action = "rotate_key"
+	ActionRevokeKey Action = "revoke_key"
+)
+
+// Entry records an action of the authenticated user
+type Entry struct {
+	Time   time.Time
+	Action Action
+	// authenticated user who performed the action
+	Actor string
+	// user in whose name the action was performed
+	OnBehalfOf string
+	Category   string
+	// ID of the affected object, e.g. proposal ID or document name
+	Target string
+	// public key of the signing key used, if any
+	PublicKey string
+}
+
+// Log keeps the audit entries, they are never modified nor removed
+type Log interface {
+	Record(ctx context.Context, entry Entry) error
+}
@@ -0,0 +1,53 @@
+package batches
+
+import (
+	"context"
+	"doc-management/internal/audit"
+	"doc-management/internal/blockchain"
+	"errors"
+	"time"
+)
+
+var ErrNotFound = errors.New("batch not found")
+
+// Batch is a batch submitted to the validator, tracked until it's committed or rejected
+type Batch struct {
+	ID string
+	// the user who submitted the batch, the app user for the transactions of the application
+	UserID string
+	Action audit.Action
+	// ID of the affected object, e.g. proposal ID
+	Target string
+	// PENDING until the batch is COMMITTED or INVALID, UNKNOWN if the validator lost it
+	Status string
+	// the messages of the transaction processors, if the batch is invalid
+	InvalidTransactions []blockchain.InvalidTransaction
+	SubmittedAt         time.Time
+	UpdatedAt           time.Time
+}
+
+// Final tells if the batch won't change its status anymore
+func (b Batch) Final() bool {
+	return b.Status != blockchain.BatchPending
+}
+
+// Err returns the InvalidTransactionError of the first rejected transaction if the batch is invalid
+func (b Batch) Err() error {
+	status := blockchain.BatchStatus{BatchID: b.ID, Status: b.Status, InvalidTransactions: b.InvalidTransactions}
+	return status.InvalidBatchError()
+}
+
+// Store keeps the submitted batches
+type Store interface {
+	InsertBatch(ctx context.Context, batch Batch) error
+	// GetBatch returns ErrNotFound if the batch is not tracked
+	GetBatch(ctx context.Context, batchID string) (Batch, error)
+	UpdateBatch(ctx context.Context, batch Batch) error
+	// PendingBatches returns the batches not final yet, the oldest first
+	PendingBatches(ctx context.Context, limit int) ([]Batch, error)
+}
+
+// StatusSource reports the statuses of the submitted batches, e.g. the validator REST API
+type StatusSource interface {
+	GetBatchStatuses(ctx context.Context, batchIDs []string, wait time.Duration) ([]blockchain.BatchStatus, error)
+}
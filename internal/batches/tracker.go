@@ -0,0 +1,215 @@
+package batches
+
+import (
+	"context"
+	"doc-management/internal/blockchain"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// the max number of batches queried at once
+	pollLimit   = 100
+	pollTimeout = 30 * time.Second
+)
+
+// Tracker persists the submitted batches and polls the validator until they are committed or rejected;
+// the changes are sent to the subscribers
+type Tracker struct {
+	logger       *zap.Logger
+	store        Store
+	source       StatusSource
+	pollInterval time.Duration
+	// a batch still unknown to the validator after this time is given up
+	expiry time.Duration
+
+	mu          sync.Mutex
+	subscribers map[string][]chan Batch
+	// wakes up the poll loop when a batch is submitted
+	wake   chan struct{}
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewTracker(logger *zap.Logger, store Store, source StatusSource, pollInterval, expiry time.Duration) *Tracker {
+	return &Tracker{
+		logger:       logger,
+		store:        store,
+		source:       source,
+		pollInterval: pollInterval,
+		expiry:       expiry,
+		subscribers:  make(map[string][]chan Batch),
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Track records the submitted batch as pending, returns the recorded batch
+func (t *Tracker) Track(ctx context.Context, batch Batch) (Batch, error) {
+	now := time.Now().UTC()
+	batch.Status = blockchain.BatchPending
+	batch.SubmittedAt = now
+	batch.UpdatedAt = now
+
+	if err := t.store.InsertBatch(ctx, batch); err != nil {
+		return Batch{}, errors.New("failed to record the submitted batch: " + err.Error())
+	}
+
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+	return batch, nil
+}
+
+func (t *Tracker) Get(ctx context.Context, batchID string) (Batch, error) {
+	return t.store.GetBatch(ctx, batchID)
+}
+
+// Subscribe returns the channel receiving the changes of the batch, it's closed once the batch is final;
+// if the receiver is slow, only the latest change is kept. The returned function cancels the subscription.
+func (t *Tracker) Subscribe(batchID string) (<-chan Batch, func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ch := make(chan Batch, 1)
+	t.subscribers[batchID] = append(t.subscribers[batchID], ch)
+
+	return ch, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		subscribers := t.subscribers[batchID]
+		for i, subscriber := range subscribers {
+			if subscriber == ch {
+				t.subscribers[batchID] = append(subscribers[:i:i], subscribers[i+1:]...)
+				if len(t.subscribers[batchID]) == 0 {
+					delete(t.subscribers, batchID)
+				}
+				close(ch)
+				return
+			}
+		}
+	}
+}
+
+// Start runs the poll loop until Stop is called
+func (t *Tracker) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+	t.done = make(chan struct{})
+
+	go func() {
+		defer close(t.done)
+
+		ticker := time.NewTicker(t.pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-t.wake:
+			}
+
+			pollCtx, cancelPoll := context.WithTimeout(ctx, pollTimeout)
+			if err := t.Poll(pollCtx); err != nil && ctx.Err() == nil {
+				t.logger.Warn("failed to poll the batch statuses: " + err.Error())
+			}
+			cancelPoll()
+		}
+	}()
+}
+
+func (t *Tracker) Stop() {
+	if t.cancel == nil {
+		return
+	}
+	t.cancel()
+	<-t.done
+}
+
+// Poll queries the statuses of the pending batches once and records the final ones
+func (t *Tracker) Poll(ctx context.Context) error {
+	pending, err := t.store.PendingBatches(ctx, pollLimit)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	batchIDs := make([]string, len(pending))
+	for i, batch := range pending {
+		batchIDs[i] = batch.ID
+	}
+	statuses, err := t.source.GetBatchStatuses(ctx, batchIDs, 0)
+	if err != nil {
+		return err
+	}
+
+	reported := make(map[string]blockchain.BatchStatus, len(statuses))
+	for _, status := range statuses {
+		reported[status.BatchID] = status
+	}
+
+	now := time.Now().UTC()
+	for _, batch := range pending {
+		status, ok := reported[batch.ID]
+		switch {
+		case ok && status.Final():
+			batch.Status = status.Status
+			batch.InvalidTransactions = status.InvalidTransactions
+		case now.Sub(batch.SubmittedAt) > t.expiry:
+			// pending for too long or lost by the validator, e.g. on a restart
+			batch.Status = blockchain.BatchUnknown
+		default:
+			continue
+		}
+		batch.UpdatedAt = now
+
+		if err := t.store.UpdateBatch(ctx, batch); err != nil {
+			return err
+		}
+		t.logChange(batch)
+		t.notify(batch)
+	}
+
+	return nil
+}
+
+func (t *Tracker) logChange(batch Batch) {
+	fields := []zap.Field{zap.String("batchID", batch.ID), zap.String("userID", batch.UserID), zap.String("action", string(batch.Action)), zap.String("target", batch.Target)}
+
+	switch batch.Status {
+	case blockchain.BatchCommitted:
+		t.logger.Info("batch committed", fields...)
+	case blockchain.BatchInvalid:
+		t.logger.Warn("batch rejected by the validator", append(fields, zap.Any("invalidTransactions", batch.InvalidTransactions))...)
+	default:
+		t.logger.Warn("batch not committed in time, giving up", fields...)
+	}
+}
+
+func (t *Tracker) notify(batch Batch) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, ch := range t.subscribers[batch.ID] {
+		// keep only the latest change for a slow receiver
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- batch
+
+		if batch.Final() {
+			close(ch)
+		}
+	}
+	if batch.Final() {
+		delete(t.subscribers, batch.ID)
+	}
+}
@@ -0,0 +1,159 @@
+package batches
+
+import (
+	"context"
+	"doc-management/internal/audit"
+	"doc-management/internal/blockchain"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type memoryStore struct {
+	mu      sync.Mutex
+	batches map[string]Batch
+}
+
+func (s *memoryStore) InsertBatch(ctx context.Context, batch Batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches[batch.ID] = batch
+	return nil
+}
+
+func (s *memoryStore) GetBatch(ctx context.Context, batchID string) (Batch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	batch, ok := s.batches[batchID]
+	if !ok {
+		return Batch{}, ErrNotFound
+	}
+	return batch, nil
+}
+
+func (s *memoryStore) UpdateBatch(ctx context.Context, batch Batch) error {
+	return s.InsertBatch(ctx, batch)
+}
+
+func (s *memoryStore) PendingBatches(ctx context.Context, limit int) ([]Batch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pending []Batch
+	for _, batch := range s.batches {
+		if !batch.Final() {
+			pending = append(pending, batch)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].SubmittedAt.Before(pending[j].SubmittedAt) })
+	return pending, nil
+}
+
+// fakeSource reports the given statuses, the other batches are pending
+type fakeSource map[string]blockchain.BatchStatus
+
+func (f fakeSource) GetBatchStatuses(ctx context.Context, batchIDs []string, wait time.Duration) ([]blockchain.BatchStatus, error) {
+	var statuses []blockchain.BatchStatus
+	for _, batchID := range batchIDs {
+		status, ok := f[batchID]
+		if !ok {
+			status = blockchain.BatchStatus{BatchID: batchID, Status: blockchain.BatchPending}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func newTestTracker(source fakeSource) (*Tracker, *memoryStore) {
+	store := &memoryStore{batches: map[string]Batch{}}
+	return NewTracker(zap.NewNop(), store, source, time.Second, time.Minute), store
+}
+
+func TestTrackUntilCommitted(t *testing.T) {
+	source := fakeSource{}
+	tracker, _ := newTestTracker(source)
+	ctx := context.Background()
+
+	batch, err := tracker.Track(ctx, Batch{ID: "batch-1", UserID: "user-1", Action: audit.ActionSubmitProposal, Target: "proposal-1"})
+	require.NoError(t, err)
+	assert.Equal(t, blockchain.BatchPending, batch.Status)
+
+	changes, stop := tracker.Subscribe("batch-1")
+	defer stop()
+
+	require.NoError(t, tracker.Poll(ctx))
+	stored, err := tracker.Get(ctx, "batch-1")
+	require.NoError(t, err)
+	assert.Equal(t, blockchain.BatchPending, stored.Status)
+
+	source["batch-1"] = blockchain.BatchStatus{BatchID: "batch-1", Status: blockchain.BatchCommitted}
+	require.NoError(t, tracker.Poll(ctx))
+
+	change := <-changes
+	assert.Equal(t, blockchain.BatchCommitted, change.Status)
+	assert.Equal(t, "proposal-1", change.Target)
+	// the subscription ends with the final status
+	_, open := <-changes
+	assert.False(t, open)
+
+	stored, err = tracker.Get(ctx, "batch-1")
+	require.NoError(t, err)
+	assert.Equal(t, blockchain.BatchCommitted, stored.Status)
+}
+
+func TestTrackInvalidBatch(t *testing.T) {
+	invalid := []blockchain.InvalidTransaction{{ID: "transaction-1", Message: "the proposal already exists"}}
+	source := fakeSource{"batch-1": {BatchID: "batch-1", Status: blockchain.BatchInvalid, InvalidTransactions: invalid}}
+	tracker, _ := newTestTracker(source)
+	ctx := context.Background()
+
+	_, err := tracker.Track(ctx, Batch{ID: "batch-1", UserID: "user-1"})
+	require.NoError(t, err)
+	require.NoError(t, tracker.Poll(ctx))
+
+	stored, err := tracker.Get(ctx, "batch-1")
+	require.NoError(t, err)
+	assert.Equal(t, blockchain.BatchInvalid, stored.Status)
+	assert.Equal(t, invalid, stored.InvalidTransactions)
+}
+
+func TestTrackGivesUpLostBatch(t *testing.T) {
+	source := fakeSource{"batch-1": {BatchID: "batch-1", Status: blockchain.BatchUnknown}}
+	tracker, store := newTestTracker(source)
+	ctx := context.Background()
+
+	_, err := tracker.Track(ctx, Batch{ID: "batch-1", UserID: "user-1"})
+	require.NoError(t, err)
+
+	// unknown to the validator, still tracked for a while
+	require.NoError(t, tracker.Poll(ctx))
+	stored, err := tracker.Get(ctx, "batch-1")
+	require.NoError(t, err)
+	assert.Equal(t, blockchain.BatchPending, stored.Status)
+
+	stored.SubmittedAt = stored.SubmittedAt.Add(-2 * time.Minute)
+	require.NoError(t, store.UpdateBatch(ctx, stored))
+	require.NoError(t, tracker.Poll(ctx))
+
+	stored, err = tracker.Get(ctx, "batch-1")
+	require.NoError(t, err)
+	assert.Equal(t, blockchain.BatchUnknown, stored.Status)
+	assert.True(t, stored.Final())
+}
+
+func TestUnsubscribe(t *testing.T) {
+	tracker, _ := newTestTracker(fakeSource{})
+
+	changes, stop := tracker.Subscribe("batch-1")
+	stop()
+	_, open := <-changes
+	assert.False(t, open)
+	assert.Empty(t, tracker.subscribers)
+
+	// stopping twice is harmless
+	stop()
+}
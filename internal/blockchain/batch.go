@@ -0,0 +1,89 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/signing"
+	"go.uber.org/zap"
+)
+
+// BatchBuilder combines several transactions, possibly of different families and signers, into one batch;
+// the validator commits either all of them or none
+type BatchBuilder struct {
+	batcher      *signing.Signer
+	transactions []*transaction_pb2.Transaction
+	// transaction IDs by the step names
+	steps map[string]string
+}
+
+// NewBatchBuilder returns an empty batch to be signed by the batcher
+func NewBatchBuilder(batcher *signing.Signer) *BatchBuilder {
+	return &BatchBuilder{batcher: batcher, steps: make(map[string]string)}
+}
+
+// Add signs the template by the signer and appends it to the batch as the named step;
+// the transaction is processed only after the steps it depends on, which have to be added before
+func (b *BatchBuilder) Add(step string, template TransactionTemplate, signer *signing.Signer, dependsOn ...string) error {
+	if step == "" {
+		return errors.New("the batch step name is missing")
+	}
+	if _, exists := b.steps[step]; exists {
+		return errors.New("the batch step " + step + " is added twice")
+	}
+
+	var dependencies []string
+	for _, dependency := range dependsOn {
+		id, ok := b.steps[dependency]
+		if !ok {
+			return errors.New("the batch step " + step + " depends on the unknown step " + dependency)
+		}
+		dependencies = append(dependencies, id)
+	}
+
+	transaction, err := template.sign(signer, b.batcher.GetPublicKey().AsHex(), dependencies)
+	if err != nil {
+		return errors.New("failed to sign the batch step " + step + ": " + err.Error())
+	}
+
+	b.steps[step] = transaction.HeaderSignature
+	b.transactions = append(b.transactions, &transaction)
+	return nil
+}
+
+// TransactionID returns the ID of the transaction added as the step
+func (b *BatchBuilder) TransactionID(step string) (string, bool) {
+	id, ok := b.steps[step]
+	return id, ok
+}
+
+// Len returns the number of the transactions in the batch
+func (b *BatchBuilder) Len() int {
+	return len(b.transactions)
+}
+
+// Build returns the ID of the batch and the serialized batch list holding it
+func (b *BatchBuilder) Build() (batchID string, batchList []byte, err error) {
+	if len(b.transactions) == 0 {
+		return "", nil, errors.New("the batch has no transactions")
+	}
+
+	return createBatchList(b.transactions, b.batcher)
+}
+
+// SubmitBatch submits all the transactions of the builder in a single batch, returns the batch ID
+// without waiting for the batch to be committed, see GetBatchStatuses
+func (c Client) SubmitBatch(ctx context.Context, builder *BatchBuilder) (string, error) {
+	batchID, batchList, err := builder.Build()
+	if err != nil {
+		return "", err
+	}
+
+	if err := c.submitBatchList(ctx, batchList); err != nil {
+		return "", err
+	}
+
+	c.logger.Debug("batch submitted", zap.String("batchID", batchID), zap.Int("transactions", builder.Len()))
+	return batchID, nil
+}
@@ -0,0 +1,68 @@
+package blockchain
+
+import (
+	"doc-management/internal/hashing"
+	"doc-management/internal/model"
+	"testing"
+
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/batch_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_pb2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestBatchBuilder(t *testing.T) {
+	hashing.Initialize(zap.NewNop())
+	app := newSigner(t)
+	voter := newSigner(t)
+
+	proposal := model.Proposal{ProposalID: "proposal-1", Category: "policies", DocumentName: "doc", ModificationAuthor: "user-1", ContentHash: "hash"}
+	vote, err := newTemplate(map[interface{}]interface{}{"action": "vote", "proposalID": proposal.ProposalID}, VoteAddresses(proposal, "user-2"), "proposals", "1.0")
+	require.NoError(t, err)
+	version, err := DocumentVersionTemplate(model.NewDocumentFromProposal(proposal, 1))
+	require.NoError(t, err)
+
+	builder := NewBatchBuilder(app)
+	require.NoError(t, builder.Add("vote", vote, voter))
+	require.NoError(t, builder.Add("document", version, app, "vote"))
+	assert.Equal(t, 2, builder.Len())
+
+	batchID, batchList, err := builder.Build()
+	require.NoError(t, err)
+
+	// the signatures and the batcher key of both transactions are verified
+	batches, err := ParseBatchList(batchList)
+	require.NoError(t, err)
+	require.Len(t, batches, 1)
+	assert.Equal(t, batchID, batches[0].ID)
+	assert.Equal(t, app.GetPublicKey().AsHex(), batches[0].SignerPublicKey)
+	require.Len(t, batches[0].Transactions, 2)
+	assert.Equal(t, voter.GetPublicKey().AsHex(), batches[0].Transactions[0].SignerPublicKey)
+	assert.Equal(t, "doctracker", batches[0].Transactions[1].FamilyName)
+
+	var list batch_pb2.BatchList
+	require.NoError(t, proto.Unmarshal(batchList, &list))
+	var header transaction_pb2.TransactionHeader
+	require.NoError(t, proto.Unmarshal(list.Batches[0].Transactions[1].Header, &header))
+	voteID, _ := builder.TransactionID("vote")
+	assert.Equal(t, []string{voteID}, header.Dependencies)
+}
+
+func TestBatchBuilderInvalidSteps(t *testing.T) {
+	hashing.Initialize(zap.NewNop())
+	signer := newSigner(t)
+	template, err := InvalidateDocumentTemplate(model.Document{Category: "policies", DocumentName: "doc", Version: 1})
+	require.NoError(t, err)
+
+	builder := NewBatchBuilder(signer)
+	_, _, err = builder.Build()
+	assert.Error(t, err, "empty batch")
+
+	assert.Error(t, builder.Add("invalidate", template, signer, "missing"))
+	require.NoError(t, builder.Add("invalidate", template, signer))
+	assert.Error(t, builder.Add("invalidate", template, signer))
+	assert.Error(t, builder.Add("", template, signer))
+	assert.Equal(t, 1, builder.Len())
+}
@@ -11,7 +11,6 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/fxamacker/cbor"
 	"github.com/hyperledger/sawtooth-sdk-go/protobuf/batch_pb2"
@@ -19,11 +18,6 @@ import (
 	"github.com/hyperledger/sawtooth-sdk-go/signing"
 	"go.uber.org/zap"
 	"google.golang.org/protobuf/proto"
-	"gopkg.in/yaml.v3"
-)
-
-var (
-	ErrNotFound = errors.New("responded with status 404")
 )
 
 const (
@@ -31,8 +25,7 @@ const (
 	batchStatusAPI         string = "batch_statuses"
 	stateAPI               string = "state"
 	contentTypeOctetStream string = "application/octet-stream"
-
-	wait uint = 10
+	contentTypeJSON        string = "application/json"
 )
 
 type Client struct {
@@ -49,24 +42,34 @@ func NewClient(logger *zap.Logger, validatorRestAPIAddr string) *Client {
 	return &Client{logger: logger, url: url}
 }
 
+// submitTransaction submits the transaction in a new batch signed by the signer, returns the batch ID
+// without waiting for the batch to be committed, see GetBatchStatuses
 func (c Client) submitTransaction(ctx context.Context, transaction transaction_pb2.Transaction, signer *signing.Signer) (string, error) {
 
 	batchId, batchList, err := createBatchList(
 		[]*transaction_pb2.Transaction{&transaction}, signer)
-
-	startTime := time.Now()
-	response, err := c.sendRequest(
-		ctx, batchAPI, batchList, contentTypeOctetStream)
 	if err != nil {
 		return "", err
 	}
-	status, err := c.getStatus(batchId, startTime)
-	if err != nil {
+
+	if err := c.submitBatchList(ctx, batchList); err != nil {
 		return "", err
 	}
 
-	c.logger.Info("request response: " + response + ", status: " + status)
-	return transaction.HeaderSignature, nil
+	c.logger.Debug("batch submitted", zap.String("batchID", batchId), zap.String("transactionID", transaction.HeaderSignature))
+	return batchId, nil
+}
+
+// submitBatchList sends the serialized batch list to the validator, the batches are processed asynchronously
+func (c Client) submitBatchList(ctx context.Context, batchList []byte) error {
+	response, err := c.sendRequest(
+		ctx, batchAPI, batchList, contentTypeOctetStream)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Debug("request response: " + response)
+	return nil
 }
 
 func (c Client) sendRequest(
@@ -94,23 +97,19 @@ func (c Client) sendRequest(
 
 	c.logger.Debug("sending " + req.Method + " request to " + url)
 	response, err := http.DefaultClient.Do(req)
-
 	if err != nil {
-		return "", errors.New(
-			fmt.Sprintf("Failed to connect to REST API: %v", err))
-	}
-	if response.StatusCode == 404 {
-		c.logger.Debug(fmt.Sprintf("%v", response))
-		return "", ErrNotFound
-	} else if response.StatusCode >= 400 {
-		return "", errors.New(
-			fmt.Sprintf("Error %d: %s", response.StatusCode, response.Status))
+		return "", connectionError(err)
 	}
 	defer response.Body.Close()
 
 	reponseBody, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return "", errors.New(fmt.Sprintf("Error reading response: %v", err))
+		return "", connectionError(err)
+	}
+
+	if response.StatusCode >= 400 {
+		c.logger.Debug(fmt.Sprint("request to ", url, " failed with status ", response.StatusCode, ": ", string(reponseBody)))
+		return "", responseError(response.StatusCode, reponseBody)
 	}
 	return string(reponseBody), nil
 }
@@ -164,46 +163,6 @@ func createBatchList(
 	return batchId, batchList, nil
 }
 
-func (c Client) getStatus(batchId string, startTime time.Time) (string, error) {
-
-	waitTime := uint(0)
-	statusPending := "PENDING"
-	for waitTime < wait {
-		status, err := c.getStatusRequest(context.Background(), batchId, wait-waitTime)
-		if err != nil {
-			return "", err
-		}
-		waitTime = uint(time.Now().Sub(startTime))
-		if status != statusPending {
-			return status, nil
-		}
-	}
-
-	return statusPending, nil
-
-}
-
-func (c Client) getStatusRequest(ctx context.Context,
-	batchId string, wait uint) (string, error) {
-
-	// API to call
-	apiSuffix := fmt.Sprintf("%s?id=%s&wait=%d",
-		batchStatusAPI, batchId, wait)
-	response, err := c.sendRequest(ctx, apiSuffix, []byte{}, "")
-	if err != nil {
-		return "", err
-	}
-
-	responseMap := make(map[string]interface{})
-	err = yaml.Unmarshal([]byte(response), &responseMap)
-	if err != nil {
-		return "", errors.New(fmt.Sprintf("Error reading response: %v", err))
-	}
-	entry :=
-		responseMap["data"].([]interface{})[0].(map[string]interface{})
-	return fmt.Sprint(entry["status"]), nil
-}
-
 func unmarshalDataList(response string) ([]json.RawMessage, error) {
 
 	var unmarshalled struct {
@@ -42,6 +42,42 @@ func (c Client) GetDocumentVersions(ctx context.Context, category string, docNam
 	return data, nil
 }
 
+// GetAllDocumentVersions returns the versions of all the documents existing on the blockchain
+func (c Client) GetAllDocumentVersions(ctx context.Context) (data []model.Document, err error) {
+	entries, _, err := c.ListState(ctx, doctrackerfamily.GetDocsPrefix(), "")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		doc, err := DecodeDocument(entry.Data)
+		if err != nil {
+			c.logger.Error("get all docs state: "+err.Error(), zap.String("address", entry.Address))
+			continue
+		}
+		data = append(data, doc)
+	}
+
+	return data, nil
+}
+
+func (c Client) GetDocumentVersion(ctx context.Context, category string, docName string, version int) (model.Document, error) {
+	addr := doctrackerfamily.GetDocVersionAddress(model.Document{Category: category, DocumentName: docName, Version: version})
+
+	url := fmt.Sprintf("%s/%s", stateAPI, addr)
+	response, err := c.sendRequest(ctx, url, nil, "")
+	if err != nil {
+		return model.Document{}, err
+	}
+
+	var doc model.Document
+	if err := unmarshalStatePayload(&doc, response); err != nil {
+		return model.Document{}, errors.New("get doc version state: " + err.Error())
+	}
+
+	return doc, nil
+}
+
 func (c Client) GetDocumentsOfAuthor(ctx context.Context, author string) (docs []model.Document, err error) {
 	user, err := c.getUserData(ctx, author)
 	if err != nil {
@@ -124,23 +160,73 @@ func (c Client) GetDocumentsSignedBy(ctx context.Context, signer string) (docs [
 	return docs, nil
 }
 
-func (c Client) InvalidateDocumentVersion(ctx context.Context, doc model.Document, signer *signing.Signer) (transactionID string, err error) {
+func (c Client) InvalidateDocumentVersion(ctx context.Context, doc model.Document, signer *signing.Signer) (batchID string, err error) {
+	template, err := InvalidateDocumentTemplate(doc)
+	if err != nil {
+		return "", err
+	}
+
+	transaction, err := template.Sign(signer)
+	if err != nil {
+		return "", errors.New("failed to invalidate a document version: " + err.Error())
+	}
+
+	return c.submitTransaction(ctx, transaction, signer)
+}
+
+// InvalidateDocumentVersions invalidates all the document versions in a single batch
+func (c Client) InvalidateDocumentVersions(ctx context.Context, docs []model.Document, signer *signing.Signer) (batchID string, err error) {
+	builder := NewBatchBuilder(signer)
+	for _, doc := range docs {
+		template, err := InvalidateDocumentTemplate(doc)
+		if err != nil {
+			return "", err
+		}
+
+		step := fmt.Sprint(doc.Category, "/", doc.DocumentName, "/", doc.Version)
+		if _, added := builder.TransactionID(step); added {
+			continue
+		}
+		if err := builder.Add(step, template, signer); err != nil {
+			return "", errors.New("failed to invalidate the document versions: " + err.Error())
+		}
+	}
+
+	return c.SubmitBatch(ctx, builder)
+}
+
+// InvalidateDocumentTemplate returns the unsigned transaction invalidating the document version
+func InvalidateDocumentTemplate(doc model.Document) (TransactionTemplate, error) {
 	docDataAddress := doctrackerfamily.GetDocVersionAddress(doc)
 
 	payload := make(map[interface{}]interface{})
 	payload["action"] = doctrackerfamily.ActionInvalidate
 	payload["address"] = docDataAddress
 
-	transaction, err := NewTransaction(payload, signer, []string{docDataAddress}, doctrackerfamily.FamilyName, doctrackerfamily.FamilyVersion)
+	template, err := newTemplate(payload, []string{docDataAddress}, doctrackerfamily.FamilyName, doctrackerfamily.FamilyVersion)
 	if err != nil {
-		return "", errors.New("failed to invalidate a document version: " + err.Error())
+		return TransactionTemplate{}, errors.New("failed to invalidate a document version: " + err.Error())
 	}
 
-	return c.submitTransaction(ctx, transaction, signer)
+	return template, nil
+}
 
+func (c Client) SubmitDocumentVersion(ctx context.Context, doc model.Document, signer *signing.Signer) (batchID string, err error) {
+	template, err := DocumentVersionTemplate(doc)
+	if err != nil {
+		return "", err
+	}
+
+	transaction, err := template.Sign(signer)
+	if err != nil {
+		return "", errors.New("failed to create a new document version transaction: " + err.Error())
+	}
+
+	return c.submitTransaction(ctx, transaction, signer)
 }
 
-func (c Client) SubmitDocumentVersion(ctx context.Context, doc model.Document, signer *signing.Signer) (transactionID string, err error) {
+// DocumentVersionTemplate returns the unsigned transaction recording the accepted document version
+func DocumentVersionTemplate(doc model.Document) (TransactionTemplate, error) {
 	docDataAddress := doctrackerfamily.GetDocVersionAddress(doc)
 	authorAddress := doctrackerfamily.GetUserAddress(doc.Author)
 	signerAddresses := make([]string, len(doc.Signers))
@@ -158,11 +244,14 @@ func (c Client) SubmitDocumentVersion(ctx context.Context, doc model.Document, s
 	payload["author"] = doc.Author
 	payload["version"] = doc.Version
 	payload["signers"] = doc.Signers
+	payload["fileName"] = doc.FileName
+	payload["contentType"] = doc.ContentType
+	payload["contentSize"] = doc.ContentSize
 
-	transaction, err := NewTransaction(payload, signer, append(signerAddresses, []string{authorAddress, docDataAddress}...), doctrackerfamily.FamilyName, doctrackerfamily.FamilyVersion)
+	template, err := newTemplate(payload, append(signerAddresses, []string{authorAddress, docDataAddress}...), doctrackerfamily.FamilyName, doctrackerfamily.FamilyVersion)
 	if err != nil {
-		return "", errors.New("failed to create a new document version transaction: " + err.Error())
+		return TransactionTemplate{}, errors.New("failed to create a new document version transaction: " + err.Error())
 	}
 
-	return c.submitTransaction(ctx, transaction, signer)
+	return template, nil
 }
@@ -33,6 +33,13 @@ func GetDocAddress(category string, docName string) (address string) {
 	return familyHash[0:6] + docPrefixHash[0:6] + categoryHash[0:6] + docNameHash[0:48]
 }
 
+// GetDocsPrefix returns the address prefix of all the doc versions
+func GetDocsPrefix() (prefix string) {
+	initHashVars()
+
+	return familyHash[0:6] + docPrefixHash[0:6]
+}
+
 func GetDocVersionAddress(doc model.Document) (address string) {
 	initHashVars()
 
@@ -0,0 +1,91 @@
+package blockchain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+var (
+	ErrNotFound = errors.New("responded with status 404")
+	// the validator can't be reached or isn't ready, e.g. still syncing or its queue is full
+	ErrValidatorUnavailable = errors.New("the validator is unavailable")
+	// the validator didn't respond in time
+	ErrValidatorTimeout = errors.New("the validator timed out")
+)
+
+// InvalidTransactionError is a transaction rejected by the validator or by the transaction processor
+type InvalidTransactionError struct {
+	BatchID       string
+	TransactionID string
+	// the reason given by the transaction processor
+	Message string
+}
+
+func (e *InvalidTransactionError) Error() string {
+	if e.TransactionID == "" {
+		return "invalid transaction: " + e.Message
+	}
+	return "invalid transaction " + e.TransactionID + ": " + e.Message
+}
+
+// InvalidBatchError returns the error of the first rejected transaction of the INVALID batch
+func (s BatchStatus) InvalidBatchError() error {
+	if s.Status != BatchInvalid {
+		return nil
+	}
+
+	err := &InvalidTransactionError{BatchID: s.BatchID, Message: "the batch was rejected"}
+	if len(s.InvalidTransactions) > 0 {
+		err.TransactionID = s.InvalidTransactions[0].ID
+		err.Message = s.InvalidTransactions[0].Message
+	}
+	return err
+}
+
+// the REST API error code of a batch rejected on submission
+const submittedBatchesInvalid = 30
+
+// connectionError classifies the failure of the request to the REST API
+func connectionError(err error) error {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return fmt.Errorf("%w: %v", ErrValidatorTimeout, err)
+	}
+
+	return fmt.Errorf("%w: failed to connect to REST API: %v", ErrValidatorUnavailable, err)
+}
+
+// responseError converts the error response of the REST API to the typed error
+func responseError(statusCode int, body []byte) error {
+	// e.g. {"error": {"code": 30, "title": "Submitted Batches Invalid", "message": "..."}}
+	var unmarshalled struct {
+		Error struct {
+			Code    int    `json:"code"`
+			Title   string `json:"title"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	message := http.StatusText(statusCode)
+	if json.Unmarshal(body, &unmarshalled) == nil && unmarshalled.Error.Message != "" {
+		message = unmarshalled.Error.Title + ": " + unmarshalled.Error.Message
+	}
+
+	switch statusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusBadRequest:
+		if unmarshalled.Error.Code == submittedBatchesInvalid {
+			return &InvalidTransactionError{Message: message}
+		}
+	case http.StatusServiceUnavailable, http.StatusTooManyRequests:
+		return fmt.Errorf("%w: %s", ErrValidatorUnavailable, message)
+	case http.StatusGatewayTimeout, http.StatusRequestTimeout:
+		return fmt.Errorf("%w: %s", ErrValidatorTimeout, message)
+	}
+
+	return fmt.Errorf("error %d: %s", statusCode, message)
+}
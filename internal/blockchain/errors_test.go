@@ -0,0 +1,82 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestResponseErrors(t *testing.T) {
+	var status int
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+	client := NewClient(zap.NewNop(), server.URL)
+	ctx := context.Background()
+
+	status, body = http.StatusNotFound, `{"error": {"code": 75, "title": "State Not Found", "message": "..."}}`
+	_, err := client.sendRequest(ctx, stateAPI+"/addr", nil, "")
+	assert.Equal(t, ErrNotFound, err)
+
+	status, body = http.StatusBadRequest, `{"error": {"code": 30, "title": "Submitted Batches Invalid", "message": "The submitted BatchList was rejected by the validator."}}`
+	_, err = client.sendRequest(ctx, batchAPI, []byte("batches"), contentTypeOctetStream)
+	var invalid *InvalidTransactionError
+	require.True(t, errors.As(err, &invalid))
+	assert.Contains(t, invalid.Message, "rejected by the validator")
+
+	// other bad requests are not blamed on the transactions
+	status, body = http.StatusBadRequest, `{"error": {"code": 62, "title": "Invalid State Address", "message": "..."}}`
+	_, err = client.sendRequest(ctx, stateAPI+"/addr", nil, "")
+	assert.False(t, errors.As(err, &invalid))
+
+	status, body = http.StatusServiceUnavailable, `{"error": {"code": 15, "title": "Validator Not Ready", "message": "..."}}`
+	_, err = client.sendRequest(ctx, batchAPI, []byte("batches"), contentTypeOctetStream)
+	assert.True(t, errors.Is(err, ErrValidatorUnavailable))
+
+	status, body = http.StatusGatewayTimeout, `{"error": {"code": 17, "title": "Validator Timed Out", "message": "..."}}`
+	_, err = client.sendRequest(ctx, batchAPI, []byte("batches"), contentTypeOctetStream)
+	assert.True(t, errors.Is(err, ErrValidatorTimeout))
+
+	status, body = http.StatusOK, `{}`
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = client.sendRequest(timeoutCtx, "slow", nil, "")
+	assert.True(t, errors.Is(err, ErrValidatorTimeout))
+}
+
+func TestValidatorUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	_, err := NewClient(zap.NewNop(), server.URL).sendRequest(context.Background(), stateAPI, nil, "")
+	assert.True(t, errors.Is(err, ErrValidatorUnavailable))
+}
+
+func TestInvalidBatchError(t *testing.T) {
+	assert.NoError(t, BatchStatus{BatchID: "batch-1", Status: BatchCommitted}.InvalidBatchError())
+
+	err := BatchStatus{
+		BatchID:             "batch-1",
+		Status:              BatchInvalid,
+		InvalidTransactions: []InvalidTransaction{{ID: "transaction-1", Message: "the voter already signed the proposal"}},
+	}.InvalidBatchError()
+
+	var invalid *InvalidTransactionError
+	require.True(t, errors.As(err, &invalid))
+	assert.Equal(t, "batch-1", invalid.BatchID)
+	assert.Equal(t, "transaction-1", invalid.TransactionID)
+	assert.Equal(t, "the voter already signed the proposal", invalid.Message)
+}
@@ -2,149 +2,498 @@
 package events
 
 import (
+	"context"
+	"doc-management/internal/blockchain"
+	"doc-management/internal/retry"
 	"errors"
 	"fmt"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/hyperledger/sawtooth-sdk-go/messaging"
 	"github.com/hyperledger/sawtooth-sdk-go/protobuf/client_event_pb2"
 	"github.com/hyperledger/sawtooth-sdk-go/protobuf/events_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/network_pb2"
 	"github.com/hyperledger/sawtooth-sdk-go/protobuf/validator_pb2"
 	"github.com/pebbe/zmq4"
-	"go.uber.org/multierr"
 	"go.uber.org/zap"
 	"google.golang.org/protobuf/proto"
 )
 
+const (
+	checkpointTimeout = 10 * time.Second
+	// the receiving returns at least this often, to check whether the listener is stopped or the connection idle
+	receiveTimeout = time.Second
+	// how long the responses to the subscription requests are waited for
+	subscribeTimeout = 10 * time.Second
+)
+
+var errUnknownBlock = errors.New("the last known block is unknown to the validator")
+
+// Checkpoint keeps the last block whose events were handled, the events are replayed from it after a restart
+type Checkpoint interface {
+	// LastEventBlock returns the ID and the number of the last handled block, empty if no block was handled yet
+	LastEventBlock(ctx context.Context) (blockID string, blockNum uint64, err error)
+	SaveEventBlock(ctx context.Context, blockID string, blockNum uint64) error
+}
+
+// Event is an event emitted by a transaction processor, with the block it was emitted in
+type Event struct {
+	Type     string
+	Data     []byte
+	BlockID  string
+	BlockNum uint64
+}
+
+type State string
+
+const (
+	StateDisconnected State = "disconnected"
+	// started, connecting for the first time with a backoff
+	StateConnecting State = "connecting"
+	StateConnected  State = "connected"
+	// the connection was lost, connecting again with a backoff
+	StateReconnecting State = "reconnecting"
+)
+
+// Status is the state of the connection to the validator, for the health checks
+type Status struct {
+	State State
+	// when the listener got to the state
+	Since time.Time
+	// the last block whose events were handled
+	LastBlockID string
+	// why the connection was lost, or why the last reconnection attempt failed
+	LastError string
+}
+
 type EventListener struct {
-	log           *zap.Logger
-	connection    messaging.Connection
-	validatorUrl  string
-	closerFunc    []func() error
-	handlers      map[string](func(data []byte) error)
-	stopListening chan bool
-	wg            *sync.WaitGroup
-}
-
-func NewEventListener(logger *zap.Logger, validatorAddr string) *EventListener {
-	validatorUrl := fmt.Sprint("tcp://", validatorAddr)
-	return &EventListener{
+	log          *zap.Logger
+	validatorUrl string
+	zmqContext   *zmq4.Context
+	connection   messaging.Connection
+	// opens a new connection to the validator
+	dial        func() (messaging.Connection, error)
+	unsubscribe func() error
+	handlers    map[string](func(event Event) error)
+
+	// state delta handler is called for each block in the commit order
+	deltaHandler      func(delta blockchain.StateDelta) error
+	deltaPrefixes     []string
+	lastKnownBlockIDs []string
+	checkpoint        Checkpoint
+	// called if the events since the last known block can't be replayed
+	unknownBlockHandler func(blockID string) error
+
+	reconnect retry.Policy
+	// the validator pings the idle connections, the connection is lost if nothing is received for this long
+	idleTimeout   time.Duration
+	lastMessageAt time.Time
+
+	mu     sync.Mutex
+	status Status
+	cancel context.CancelFunc
+	done   chan struct{}
+	// the error of the unsubscription when stopped
+	stopErr error
+}
+
+// NewEventListener creates the listener of the validator's events; the lost connection is reconnected
+// with the backoff of the reconnect policy, which has no max number of attempts
+func NewEventListener(logger *zap.Logger, validatorAddr string, reconnect retry.Policy, idleTimeout time.Duration) *EventListener {
+	e := &EventListener{
 		log:          logger,
-		validatorUrl: validatorUrl,
-		handlers:     make(map[string]func(data []byte) error),
-		wg:           &sync.WaitGroup{},
+		validatorUrl: fmt.Sprint("tcp://", validatorAddr),
+		handlers:     make(map[string]func(event Event) error),
+		reconnect:    reconnect,
+		idleTimeout:  idleTimeout,
+		status:       Status{State: StateDisconnected, Since: time.Now().UTC()},
+	}
+	e.dial = e.dialValidator
+	return e
+}
+
+// Start connects to the validator in the background, with the backoff of the reconnect policy until it succeeds,
+// and handles the events until the context is done or Stop is called; the events of the blocks committed
+// while the connection was lost are replayed once it's reconnected
+func (e *EventListener) Start(ctx context.Context) {
+	e.setState(StateConnecting, nil)
+
+	ctx, e.cancel = context.WithCancel(ctx)
+	e.done = make(chan struct{})
+	go func() {
+		defer close(e.done)
+		e.run(ctx)
+	}()
+}
+
+// Stop stops the listener and unsubscribes from the events, once the event being handled is handled
+func (e *EventListener) Stop() error {
+	if e.cancel == nil {
+		return nil
 	}
+	e.cancel()
+	<-e.done
+
+	return e.stopErr
+}
+
+// Status returns the current state of the connection to the validator
+func (e *EventListener) Status() Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.status
 }
 
-func (e *EventListener) Start() error {
-	zmqContext, err := zmq4.NewContext()
+func (e *EventListener) dialValidator() (messaging.Connection, error) {
+	if e.zmqContext == nil {
+		zmqContext, err := zmq4.NewContext()
+		if err != nil {
+			return nil, err
+		}
+		e.zmqContext = zmqContext
+	}
+
+	connection, err := messaging.NewConnection(e.zmqContext, zmq4.DEALER, e.validatorUrl, false)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if err := connection.Socket().SetRcvtimeo(receiveTimeout); err != nil {
+		connection.Close()
+		return nil, err
 	}
 
-	zmqConnection, err := messaging.NewConnection(
-		zmqContext,
-		zmq4.DEALER,
-		e.validatorUrl,
-		false,
-	)
+	return connection, nil
+}
+
+// connect opens a new connection and subscribes to the events since the last known block
+func (e *EventListener) connect() error {
+	connection, err := e.dial()
 	if err != nil {
 		return err
 	}
-	e.connection = zmqConnection
+	e.connection = connection
 
-	for eventType, _ := range e.handlers {
-		if err := e.subscribeToEvent(eventType); err != nil {
-			e.log.Error("error when subscribing to event " + eventType + ": " + err.Error())
+	err = e.subscribe()
+	if err == errUnknownBlock {
+		// e.g. the blockchain was reset, or the block was abandoned on a fork
+		unknownBlockID := e.lastKnownBlockIDs[0]
+		e.log.Warn("the last known block is unknown to the validator, subscribing from the current block", zap.String("blockID", unknownBlockID))
+		e.lastKnownBlockIDs = nil
+		if err = e.subscribe(); err == nil {
+			e.handleUnknownBlock(unknownBlockID)
 		}
 	}
+	if err != nil {
+		e.connection.Close()
+		return errors.New("error when subscribing to the events: " + err.Error())
+	}
 
-	e.stopListening = make(chan bool)
-	go e.listenLoop(e.stopListening)
-
+	e.lastMessageAt = time.Now()
+	e.setState(StateConnected, nil)
 	return nil
 }
 
-func (e EventListener) Stop() error {
-	e.stopListening <- true
-	var allErr error
-	for _, close := range e.closerFunc {
-		if err := close(); err != nil {
-			allErr = multierr.Append(allErr, err)
+// run connects, handles the events and reconnects if the connection is lost, until the context is done
+func (e *EventListener) run(ctx context.Context) {
+	if err := e.connect(); err != nil {
+		e.log.Warn("connecting to the validator failed: " + err.Error())
+		e.setState(StateConnecting, err)
+		if !e.connectAgain(ctx, StateConnecting) {
+			e.setState(StateDisconnected, nil)
+			return
 		}
 	}
-	e.connection.Close()
-	e.log.Info("waiting for all the event handlers to finish...")
-	e.wg.Wait()
-	e.log.Info("event listener handlers finished")
-
-	return allErr
-}
-
-func (e *EventListener) listenLoop(stop chan bool) error {
 	e.log.Info("start listening on blockchain events")
 
 	for {
+		err := e.listen(ctx)
+		if ctx.Err() != nil {
+			e.close()
+			return
+		}
+
+		e.log.Warn("the connection to the validator dropped: " + err.Error())
+		e.connection.Close()
+		e.setState(StateReconnecting, err)
+		if !e.connectAgain(ctx, StateReconnecting) {
+			e.setState(StateDisconnected, nil)
+			return
+		}
+	}
+}
+
+// connectAgain connects with a backoff until it succeeds, reporting the failed attempts in the given state;
+// returns false if the context is done first
+func (e *EventListener) connectAgain(ctx context.Context, state State) bool {
+	for attempt := 1; ; attempt++ {
+		timer := time.NewTimer(e.reconnect.Backoff(attempt))
 		select {
-		case <-stop:
-			return nil
-		default:
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
 
-			// Wait for a message on connection
-			_, message, err := e.connection.RecvMsg()
-			if err != nil {
-				return err
-			}
+		err := e.connect()
+		if err == nil {
+			e.log.Info(fmt.Sprint("connected to the validator, attempt ", attempt))
+			return true
+		}
+		e.log.Warn(fmt.Sprint("connecting to the validator failed, attempt ", attempt, ": ", err.Error()))
+		e.setState(state, err)
+	}
+}
 
-			// Check if received is a client event message
-			if message.MessageType !=
-				validator_pb2.Message_CLIENT_EVENTS {
-				continue
+// close unsubscribes from the events and closes the connection
+func (e *EventListener) close() {
+	if e.unsubscribe != nil {
+		e.stopErr = e.unsubscribe()
+	}
+	e.connection.Close()
+	e.setState(StateDisconnected, nil)
+}
+
+// listen handles the received messages until the context is done, returns the error if the connection is lost
+func (e *EventListener) listen(ctx context.Context) error {
+	for ctx.Err() == nil {
+		// Wait for a message on connection
+		_, message, err := e.connection.RecvMsg()
+		if isTimeout(err) {
+			if idle := time.Since(e.lastMessageAt); idle > e.idleTimeout {
+				return errors.New(fmt.Sprint("nothing received from the validator for ", idle.Round(time.Second)))
 			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		e.lastMessageAt = time.Now()
 
-			event_list := events_pb2.EventList{}
-			if err := proto.Unmarshal(message.Content, &event_list); err != nil {
-				e.log.Error("failed to unmarshal proto message: " + err.Error())
-				continue
+		switch message.MessageType {
+		case validator_pb2.Message_PING_REQUEST:
+			// the validator drops the connections which don't respond
+			if err := e.pong(message.CorrelationId); err != nil {
+				return err
 			}
-			// Received following events from validator
-			for _, event := range event_list.Events {
-				// handle event here
-				e.log.Info("event received: " + event.EventType)
-
-				handler, ok := e.handlers[event.EventType]
-				if !ok {
-					e.log.Warn("handler missing for the event: " + event.EventType)
-					continue
-				}
-
-				e.wg.Add(1)
-				go func(event *events_pb2.Event) {
-					defer e.wg.Done()
-
-					if err := handler(event.GetData()); err != nil {
-						e.log.Error("error when handling the event: " + err.Error())
-					}
-				}(event)
+
+		case validator_pb2.Message_CLIENT_EVENTS:
+			// the connection is dropped and the events are subscribed again from the last handled block
+			if err := e.handleEvents(message.Content); err != nil {
+				return err
 			}
 		}
 	}
+
+	return nil
+}
+
+func (e *EventListener) pong(corrId string) error {
+	response, err := proto.Marshal(&network_pb2.PingResponse{})
+	if err != nil {
+		return err
+	}
+	return e.connection.SendMsg(validator_pb2.Message_PING_RESPONSE, response, corrId)
+}
+
+// handleEvents handles the events of a block, the block is saved as the last handled one only if all
+// the handlers succeed; otherwise the error is returned and the events are to be replayed
+func (e *EventListener) handleEvents(content []byte) error {
+	event_list := events_pb2.EventList{}
+	if err := proto.Unmarshal(content, &event_list); err != nil {
+		// the same message would be received again
+		e.log.Error("failed to unmarshal proto message: " + err.Error())
+		return nil
+	}
+
+	// the state changes are handled first, all the events synchronously to keep the order of the blocks
+	if err := e.handleStateDelta(event_list.Events); err != nil {
+		return err
+	}
+	blockID, blockNum := committedBlock(event_list.Events)
+
+	// Received following events from validator
+	for _, event := range event_list.Events {
+		if event.EventType == blockCommitEvent || event.EventType == stateDeltaEvent {
+			continue
+		}
+
+		// handle event here
+		e.log.Info("event received: " + event.EventType)
+
+		handler, ok := e.handlers[event.EventType]
+		if !ok {
+			e.log.Warn("handler missing for the event: " + event.EventType)
+			continue
+		}
+
+		if err := handler(Event{Type: event.EventType, Data: event.GetData(), BlockID: blockID, BlockNum: blockNum}); err != nil {
+			e.log.Error("error when handling the event: "+err.Error(), zap.String("blockID", blockID))
+			return errors.New("error when handling the event " + event.EventType + " of block " + blockID + ": " + err.Error())
+		}
+	}
+
+	if blockID == "" {
+		return nil
+	}
+	e.saveCheckpoint(blockID, blockNum)
+	// replayed from this block if the connection is lost
+	e.SetLastKnownBlock(blockID)
+	e.mu.Lock()
+	e.status.LastBlockID = blockID
+	e.mu.Unlock()
+	return nil
+}
+
+func (e *EventListener) setState(state State, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.status.State != state {
+		e.status.Since = time.Now().UTC()
+	}
+	e.status.State = state
+	e.status.LastError = ""
+	if err != nil {
+		e.status.LastError = err.Error()
+	}
+}
+
+// isTimeout tells whether the receiving returned as nothing was received within receiveTimeout
+func isTimeout(err error) bool {
+	return err != nil && zmq4.AsErrno(err) == zmq4.Errno(syscall.EAGAIN)
+}
+
+// recvWithId waits up to subscribeTimeout for the response with the correlation ID,
+// the other messages are kept for the next receiving
+func (e *EventListener) recvWithId(corrId string) (*validator_pb2.Message, error) {
+	deadline := time.Now().Add(subscribeTimeout)
+	for {
+		_, response, err := e.connection.RecvMsgWithId(corrId)
+		if isTimeout(err) && time.Now().Before(deadline) {
+			continue
+		}
+		return response, err
+	}
 }
 
-func (e *EventListener) SetHandler(eventType string, handler func(data []byte) error) error {
+// SetHandler sets the handler of the events of the type; if it fails, the connection is dropped and the events
+// since the last handled block are replayed, so the handler has to be idempotent
+func (e *EventListener) SetHandler(eventType string, handler func(event Event) error) error {
 	e.handlers[eventType] = handler
 	return nil
 }
 
-func (e *EventListener) subscribeToEvent(eventType string) (err error) {
+// handleStateDelta returns the error if the state changes of the block aren't handled, the block is replayed then
+func (e *EventListener) handleStateDelta(eventList []*events_pb2.Event) error {
+	if e.deltaHandler == nil {
+		return nil
+	}
 
-	subs := events_pb2.EventSubscription{
-		EventType: eventType,
+	delta, ok, err := parseStateDelta(eventList)
+	if err != nil {
+		e.log.Error("failed to parse the state delta: " + err.Error())
+		return errors.New("failed to parse the state delta: " + err.Error())
 	}
+	if !ok {
+		return nil
+	}
+
+	e.log.Debug(fmt.Sprint("block committed: ", delta.BlockNum, ", state changes: ", len(delta.Changes)), zap.String("blockID", delta.BlockID))
+	if err := e.deltaHandler(delta); err != nil {
+		e.log.Error("error when handling the state delta: "+err.Error(), zap.String("blockID", delta.BlockID))
+		return errors.New("error when handling the state delta of block " + delta.BlockID + ": " + err.Error())
+	}
+
+	return nil
+}
+
+// SetStateDeltaHandler sets the handler of the state changes of the addresses starting with the given prefixes;
+// if it fails, the block is replayed the same way as when an event handler fails
+func (e *EventListener) SetStateDeltaHandler(addressPrefixes []string, handler func(delta blockchain.StateDelta) error) {
+	e.deltaPrefixes = addressPrefixes
+	e.deltaHandler = handler
+}
+
+// SetLastKnownBlock makes the validator send the events of all the blocks committed after the given one,
+// before the new events; needs to be set before starting the listener
+func (e *EventListener) SetLastKnownBlock(blockID string) {
+	e.lastKnownBlockIDs = nil
+	if blockID != "" {
+		e.lastKnownBlockIDs = []string{blockID}
+	}
+}
+
+// SetCheckpoint makes the listener save the last block whose events were handled, the events are replayed
+// from the block given in SetLastKnownBlock on start; needs to be set before starting the listener
+func (e *EventListener) SetCheckpoint(checkpoint Checkpoint) {
+	e.checkpoint = checkpoint
+}
+
+// SetUnknownBlockHandler sets the handler called if the last known block is unknown to the validator on start
+// or on a reconnection, so the events since then can't be replayed; the listener is subscribed from the current
+// block then and the handler is called before any new event is handled
+func (e *EventListener) SetUnknownBlockHandler(handler func(blockID string) error) {
+	e.unknownBlockHandler = handler
+}
+
+func (e *EventListener) saveCheckpoint(blockID string, blockNum uint64) {
+	if e.checkpoint == nil || blockID == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), checkpointTimeout)
+	defer cancel()
+	if err := e.checkpoint.SaveEventBlock(ctx, blockID, blockNum); err != nil {
+		// the events of the block are replayed again after a restart
+		e.log.Error("failed to save the last handled block: "+err.Error(), zap.String("blockID", blockID))
+	}
+}
+
+func (e *EventListener) handleUnknownBlock(blockID string) {
+	if e.unknownBlockHandler == nil {
+		return
+	}
+
+	if err := e.unknownBlockHandler(blockID); err != nil {
+		e.log.Error("error when handling the unknown block: "+err.Error(), zap.String("blockID", blockID))
+	}
+}
+
+func (e *EventListener) subscriptions() []*events_pb2.EventSubscription {
+	var subs []*events_pb2.EventSubscription
+	for eventType := range e.handlers {
+		subs = append(subs, &events_pb2.EventSubscription{EventType: eventType})
+	}
+
+	// tells the block of the other events
+	if len(subs) > 0 || e.deltaHandler != nil {
+		subs = append(subs, &events_pb2.EventSubscription{EventType: blockCommitEvent})
+	}
+
+	if e.deltaHandler != nil {
+		filters := make([]*events_pb2.EventFilter, len(e.deltaPrefixes))
+		for i, prefix := range e.deltaPrefixes {
+			filters[i] = &events_pb2.EventFilter{
+				Key:         "address",
+				MatchString: "^" + prefix + ".*",
+				FilterType:  events_pb2.EventFilter_REGEX_ANY,
+			}
+		}
+
+		subs = append(subs, &events_pb2.EventSubscription{EventType: stateDeltaEvent, Filters: filters})
+	}
+
+	return subs
+}
+
+// subscribe sends a single request for all the events, as the validator keeps one subscription per connection
+func (e *EventListener) subscribe() (err error) {
+
 	request := client_event_pb2.ClientEventsSubscribeRequest{
-		Subscriptions: []*events_pb2.EventSubscription{
-			&subs,
-		},
+		Subscriptions:     e.subscriptions(),
+		LastKnownBlockIds: e.lastKnownBlockIDs,
 	}
 
 	serializedReq, err := proto.Marshal(&request)
@@ -165,7 +514,7 @@ func (e *EventListener) subscribeToEvent(eventType string) (err error) {
 	e.log.Debug("waiting for receiving the subscription confirmation...")
 	// Wait for subscription status, wait for response of
 	// message with specific correlation id
-	_, response, err := e.connection.RecvMsgWithId(corrId)
+	response, err := e.recvWithId(corrId)
 	if err != nil {
 		return
 	}
@@ -182,6 +531,9 @@ func (e *EventListener) subscribeToEvent(eventType string) (err error) {
 	// Client subscription is not successful, optional
 	// retries can be done later for subscription based on
 	// response cause
+	if subsResponse.Status == client_event_pb2.ClientEventsSubscribeResponse_UNKNOWN_BLOCK {
+		return errUnknownBlock
+	}
 	if subsResponse.Status !=
 		client_event_pb2.ClientEventsSubscribeResponse_OK {
 		return errors.New("client subscription failed, subscription status: " + subsResponse.String())
@@ -209,8 +561,7 @@ func (e *EventListener) subscribeToEvent(eventType string) (err error) {
 			return err
 		}
 		// Wait for status
-		_, unsubscribe_response, err :=
-			e.connection.RecvMsgWithId(corrId)
+		unsubscribe_response, err := e.recvWithId(corrId)
 		// Optional retries can be done depending on error status
 		if err != nil {
 			return err
@@ -231,8 +582,9 @@ func (e *EventListener) subscribeToEvent(eventType string) (err error) {
 		return nil
 	}
 
-	e.closerFunc = append(e.closerFunc, unsubscribe)
-	e.log.Info("successfully subscribed to event '" + eventType + "'")
+	// the subscription of the previous connection is gone with it
+	e.unsubscribe = unsubscribe
+	e.log.Info(fmt.Sprint("successfully subscribed to ", len(request.Subscriptions), " events"))
 
 	return nil
 }
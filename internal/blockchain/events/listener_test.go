@@ -0,0 +1,373 @@
+package events
+
+import (
+	"context"
+	"doc-management/internal/blockchain"
+	"doc-management/internal/retry"
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/sawtooth-sdk-go/messaging"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/client_event_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/events_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/validator_pb2"
+	"github.com/pebbe/zmq4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// fakeConnection delivers the queued messages, then times out as an idle connection does
+type fakeConnection struct {
+	mu       sync.Mutex
+	incoming []*validator_pb2.Message
+	sent     []*validator_pb2.Message
+	// the responses to the subscription requests in turn, OK once they run out
+	subscribeStatuses []client_event_pb2.ClientEventsSubscribeResponse_Status
+	subscriptions     []*client_event_pb2.ClientEventsSubscribeRequest
+	closed            bool
+}
+
+func (c *fakeConnection) SendData(id string, data []byte) error { return nil }
+
+func (c *fakeConnection) SendNewMsg(t validator_pb2.Message_MessageType, content []byte) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	corrId := fmt.Sprint("corr-", len(c.sent))
+	c.sent = append(c.sent, &validator_pb2.Message{MessageType: t, Content: content, CorrelationId: corrId})
+	if t == validator_pb2.Message_CLIENT_EVENTS_SUBSCRIBE_REQUEST {
+		request := &client_event_pb2.ClientEventsSubscribeRequest{}
+		if err := proto.Unmarshal(content, request); err != nil {
+			return "", err
+		}
+		c.subscriptions = append(c.subscriptions, request)
+	}
+	return corrId, nil
+}
+
+func (c *fakeConnection) SendNewMsgTo(id string, t validator_pb2.Message_MessageType, content []byte) (string, error) {
+	return c.SendNewMsg(t, content)
+}
+
+func (c *fakeConnection) SendMsg(t validator_pb2.Message_MessageType, content []byte, corrId string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent = append(c.sent, &validator_pb2.Message{MessageType: t, Content: content, CorrelationId: corrId})
+	return nil
+}
+
+func (c *fakeConnection) SendMsgTo(id string, t validator_pb2.Message_MessageType, content []byte, corrId string) error {
+	return c.SendMsg(t, content, corrId)
+}
+
+func (c *fakeConnection) RecvData() (string, []byte, error) {
+	return "", nil, errors.New("not supported")
+}
+
+func (c *fakeConnection) RecvMsg() (string, *validator_pb2.Message, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.incoming) == 0 {
+		time.Sleep(time.Millisecond)
+		return "", nil, zmq4.Errno(syscall.EAGAIN)
+	}
+	message := c.incoming[0]
+	c.incoming = c.incoming[1:]
+	return "", message, nil
+}
+
+// RecvMsgWithId responds to the last sent request
+func (c *fakeConnection) RecvMsgWithId(corrId string) (string, *validator_pb2.Message, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var response proto.Message
+	switch c.sent[len(c.sent)-1].MessageType {
+	case validator_pb2.Message_CLIENT_EVENTS_SUBSCRIBE_REQUEST:
+		status := client_event_pb2.ClientEventsSubscribeResponse_OK
+		if len(c.subscribeStatuses) > 0 {
+			status = c.subscribeStatuses[0]
+			c.subscribeStatuses = c.subscribeStatuses[1:]
+		}
+		response = &client_event_pb2.ClientEventsSubscribeResponse{Status: status}
+	case validator_pb2.Message_CLIENT_EVENTS_UNSUBSCRIBE_REQUEST:
+		response = &client_event_pb2.ClientEventsUnsubscribeResponse{Status: client_event_pb2.ClientEventsUnsubscribeResponse_OK}
+	default:
+		return "", nil, errors.New("unexpected request")
+	}
+
+	content, err := proto.Marshal(response)
+	return "", &validator_pb2.Message{CorrelationId: corrId, Content: content}, err
+}
+
+func (c *fakeConnection) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+}
+
+func (c *fakeConnection) Socket() *zmq4.Socket                            { return nil }
+func (c *fakeConnection) Monitor(events zmq4.Event) (*zmq4.Socket, error) { return nil, nil }
+func (c *fakeConnection) Identity() string                                { return "fake" }
+
+func (c *fakeConnection) sentOfType(t validator_pb2.Message_MessageType) []*validator_pb2.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var sent []*validator_pb2.Message
+	for _, message := range c.sent {
+		if message.MessageType == t {
+			sent = append(sent, message)
+		}
+	}
+	return sent
+}
+
+func (c *fakeConnection) lastKnownBlocks() [][]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var blocks [][]string
+	for _, request := range c.subscriptions {
+		blocks = append(blocks, request.LastKnownBlockIds)
+	}
+	return blocks
+}
+
+type memoryCheckpoint struct {
+	mu      sync.Mutex
+	blockID string
+}
+
+func (c *memoryCheckpoint) LastEventBlock(ctx context.Context) (string, uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.blockID, 0, nil
+}
+
+func (c *memoryCheckpoint) SaveEventBlock(ctx context.Context, blockID string, blockNum uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.blockID = blockID
+	return nil
+}
+
+func committedEvents(t *testing.T, blockID string, proposalID string) *validator_pb2.Message {
+	content, err := proto.Marshal(&events_pb2.EventList{Events: []*events_pb2.Event{
+		{EventType: blockCommitEvent, Attributes: []*events_pb2.Event_Attribute{
+			{Key: "block_id", Value: blockID},
+			{Key: "block_num", Value: "12"},
+		}},
+		{EventType: "proposal_accepted", Data: []byte(proposalID)},
+	}})
+	require.NoError(t, err)
+	return &validator_pb2.Message{MessageType: validator_pb2.Message_CLIENT_EVENTS, Content: content}
+}
+
+// newTestListener dials the given connections in turn, a nil connection fails to connect
+func newTestListener(idleTimeout time.Duration, connections ...*fakeConnection) (*EventListener, chan Event) {
+	policy := retry.Policy{InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+	listener := NewEventListener(zap.NewNop(), "validator:4004", policy, idleTimeout)
+
+	var dialed int
+	listener.dial = func() (messaging.Connection, error) {
+		if dialed >= len(connections) {
+			return nil, errors.New("validator unavailable")
+		}
+		connection := connections[dialed]
+		dialed++
+		if connection == nil {
+			return nil, errors.New("validator unavailable")
+		}
+		return connection, nil
+	}
+
+	received := make(chan Event, 10)
+	_ = listener.SetHandler("proposal_accepted", func(event Event) error {
+		received <- event
+		return nil
+	})
+	return listener, received
+}
+
+func receive(t *testing.T, received chan Event) Event {
+	select {
+	case event := <-received:
+		return event
+	case <-time.After(time.Second):
+		require.FailNow(t, "no event received")
+		return Event{}
+	}
+}
+
+func TestListenerUntilContextDone(t *testing.T) {
+	connection := &fakeConnection{incoming: []*validator_pb2.Message{
+		{MessageType: validator_pb2.Message_PING_REQUEST, CorrelationId: "ping-1"},
+		committedEvents(t, "block-1", "proposal-1"),
+	}}
+	listener, received := newTestListener(time.Hour, connection)
+	checkpoint := &memoryCheckpoint{}
+	listener.SetCheckpoint(checkpoint)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	listener.Start(ctx)
+
+	event := receive(t, received)
+	assert.Equal(t, StateConnected, listener.Status().State)
+	assert.Equal(t, Event{Type: "proposal_accepted", Data: []byte("proposal-1"), BlockID: "block-1", BlockNum: 12}, event)
+	require.Eventually(t, func() bool { return listener.Status().LastBlockID == "block-1" }, time.Second, time.Millisecond)
+	blockID, _, _ := checkpoint.LastEventBlock(ctx)
+	assert.Equal(t, "block-1", blockID)
+
+	pongs := connection.sentOfType(validator_pb2.Message_PING_RESPONSE)
+	require.Len(t, pongs, 1)
+	assert.Equal(t, "ping-1", pongs[0].CorrelationId)
+
+	cancel()
+	require.Eventually(t, func() bool { return listener.Status().State == StateDisconnected }, time.Second, time.Millisecond)
+	// already stopped, doesn't block
+	require.NoError(t, listener.Stop())
+	assert.Len(t, connection.sentOfType(validator_pb2.Message_CLIENT_EVENTS_UNSUBSCRIBE_REQUEST), 1)
+	assert.True(t, connection.closed)
+}
+
+func TestListenerReconnects(t *testing.T) {
+	first := &fakeConnection{incoming: []*validator_pb2.Message{committedEvents(t, "block-1", "proposal-1")}}
+	second := &fakeConnection{incoming: []*validator_pb2.Message{committedEvents(t, "block-2", "proposal-2")}}
+	// the first reconnection attempt fails, e.g. while the validator restarts
+	listener, received := newTestListener(50*time.Millisecond, first, nil, second)
+
+	listener.Start(context.Background())
+	assert.Equal(t, "block-1", receive(t, received).BlockID)
+
+	// nothing received, the connection is considered lost
+	assert.Equal(t, "block-2", receive(t, received).BlockID)
+	assert.True(t, first.closed)
+	assert.Equal(t, StateConnected, listener.Status().State)
+	// the events missed meanwhile are replayed
+	assert.Equal(t, [][]string{{"block-1"}}, second.lastKnownBlocks())
+
+	require.NoError(t, listener.Stop())
+	assert.Equal(t, StateDisconnected, listener.Status().State)
+}
+
+func TestListenerUnknownBlock(t *testing.T) {
+	connection := &fakeConnection{subscribeStatuses: []client_event_pb2.ClientEventsSubscribeResponse_Status{client_event_pb2.ClientEventsSubscribeResponse_UNKNOWN_BLOCK}}
+	listener, _ := newTestListener(time.Hour, connection)
+	listener.SetLastKnownBlock("abandoned")
+	unknown := make(chan string, 1)
+	listener.SetUnknownBlockHandler(func(blockID string) error {
+		unknown <- blockID
+		return nil
+	})
+
+	listener.Start(context.Background())
+	defer listener.Stop()
+
+	// subscribed from the current block, handled before any new event
+	select {
+	case blockID := <-unknown:
+		assert.Equal(t, "abandoned", blockID)
+	case <-time.After(time.Second):
+		require.FailNow(t, "the unknown block not handled")
+	}
+	assert.Equal(t, [][]string{{"abandoned"}, nil}, connection.lastKnownBlocks())
+}
+
+func TestListenerStartsWithoutValidator(t *testing.T) {
+	connection := &fakeConnection{incoming: []*validator_pb2.Message{committedEvents(t, "block-1", "proposal-1")}}
+	// the validator isn't up yet when the app starts
+	listener, received := newTestListener(time.Hour, nil, nil, connection)
+	listener.reconnect = retry.Policy{InitialBackoff: 50 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+
+	listener.Start(context.Background())
+	require.Eventually(t, func() bool { return listener.Status().LastError != "" }, time.Second, time.Millisecond)
+	assert.Equal(t, StateConnecting, listener.Status().State)
+	assert.Equal(t, "validator unavailable", listener.Status().LastError)
+
+	assert.Equal(t, "block-1", receive(t, received).BlockID)
+	assert.Equal(t, StateConnected, listener.Status().State)
+
+	require.NoError(t, listener.Stop())
+	assert.Equal(t, StateDisconnected, listener.Status().State)
+}
+
+func TestListenerStoppedWhileConnecting(t *testing.T) {
+	listener, _ := newTestListener(time.Hour)
+
+	listener.Start(context.Background())
+	require.Eventually(t, func() bool { return listener.Status().LastError != "" }, time.Second, time.Millisecond)
+
+	require.NoError(t, listener.Stop())
+	assert.Equal(t, StateDisconnected, listener.Status().State)
+}
+
+func TestListenerReplaysFailedBlock(t *testing.T) {
+	first := &fakeConnection{incoming: []*validator_pb2.Message{
+		committedEvents(t, "block-1", "proposal-1"),
+		committedEvents(t, "block-2", "proposal-2"),
+		committedEvents(t, "block-3", "proposal-3"),
+	}}
+	second := &fakeConnection{incoming: []*validator_pb2.Message{
+		committedEvents(t, "block-2", "proposal-2"),
+		committedEvents(t, "block-3", "proposal-3"),
+	}}
+	listener, _ := newTestListener(time.Hour, first, second)
+	checkpoint := &memoryCheckpoint{}
+	listener.SetCheckpoint(checkpoint)
+	received := make(chan Event, 10)
+	var failed bool
+	_ = listener.SetHandler("proposal_accepted", func(event Event) error {
+		if event.BlockID == "block-2" && !failed {
+			failed = true
+			return errors.New("the inbox is unavailable")
+		}
+		received <- event
+		return nil
+	})
+
+	listener.Start(context.Background())
+	defer listener.Stop()
+
+	assert.Equal(t, "block-1", receive(t, received).BlockID)
+	// the failed block isn't skipped, the events are replayed from the last handled block
+	assert.Equal(t, "block-2", receive(t, received).BlockID)
+	assert.Equal(t, "block-3", receive(t, received).BlockID)
+	assert.True(t, first.closed)
+	assert.Equal(t, [][]string{{"block-1"}}, second.lastKnownBlocks())
+	require.Eventually(t, func() bool {
+		blockID, _, _ := checkpoint.LastEventBlock(context.Background())
+		return blockID == "block-3"
+	}, time.Second, time.Millisecond)
+}
+
+func TestListenerReplaysFailedStateDelta(t *testing.T) {
+	first := &fakeConnection{incoming: []*validator_pb2.Message{
+		committedEvents(t, "block-1", "proposal-1"),
+		committedEvents(t, "block-2", "proposal-2"),
+	}}
+	second := &fakeConnection{incoming: []*validator_pb2.Message{committedEvents(t, "block-2", "proposal-2")}}
+	listener, received := newTestListener(time.Hour, first, second)
+	var applied []string
+	listener.SetStateDeltaHandler([]string{"prefix"}, func(delta blockchain.StateDelta) error {
+		if delta.BlockID == "block-2" && len(applied) == 1 {
+			applied = append(applied, "failed")
+			return errors.New("the projection is unavailable")
+		}
+		applied = append(applied, delta.BlockID)
+		return nil
+	})
+
+	listener.Start(context.Background())
+	defer listener.Stop()
+
+	assert.Equal(t, "block-1", receive(t, received).BlockID)
+	// the events of the block are handled only after its state changes
+	assert.Equal(t, "block-2", receive(t, received).BlockID)
+	assert.Equal(t, []string{"block-1", "failed", "block-2"}, applied)
+	assert.Equal(t, [][]string{{"block-1"}}, second.lastKnownBlocks())
+}
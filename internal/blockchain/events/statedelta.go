@@ -0,0 +1,77 @@
+package events
+
+import (
+	"doc-management/internal/blockchain"
+	"errors"
+	"strconv"
+
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/events_pb2"
+	transaction_receipt_pb2 "github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_receipt_pb2"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	blockCommitEvent = "sawtooth/block-commit"
+	stateDeltaEvent  = "sawtooth/state-delta"
+)
+
+// parseStateDelta reads the block committed in the event list;
+// returns false if there is no block commit event in the list
+func parseStateDelta(eventList []*events_pb2.Event) (delta blockchain.StateDelta, ok bool, err error) {
+	for _, event := range eventList {
+		switch event.EventType {
+		case blockCommitEvent:
+			ok = true
+			if delta.BlockID, delta.BlockNum, err = parseBlockCommit(event); err != nil {
+				return delta, ok, err
+			}
+
+		case stateDeltaEvent:
+			var changes transaction_receipt_pb2.StateChangeList
+			if err := proto.Unmarshal(event.Data, &changes); err != nil {
+				return delta, ok, errors.New("failed to unmarshal the state changes: " + err.Error())
+			}
+			for _, change := range changes.StateChanges {
+				delta.Changes = append(delta.Changes, blockchain.StateChange{
+					Address: change.Address,
+					Value:   change.Value,
+					Deleted: change.Type == transaction_receipt_pb2.StateChange_DELETE,
+				})
+			}
+		}
+	}
+
+	if ok && delta.BlockID == "" {
+		return delta, ok, errors.New("block ID missing in the block commit event")
+	}
+
+	return delta, ok, nil
+}
+
+func parseBlockCommit(event *events_pb2.Event) (blockID string, blockNum uint64, err error) {
+	for _, attr := range event.Attributes {
+		switch attr.Key {
+		case "block_id":
+			blockID = attr.Value
+		case "block_num":
+			if blockNum, err = strconv.ParseUint(attr.Value, 10, 64); err != nil {
+				return "", 0, errors.New("invalid block number: " + attr.Value)
+			}
+		}
+	}
+
+	return blockID, blockNum, nil
+}
+
+// committedBlock returns the block committed in the event list, empty if there is no block commit event
+func committedBlock(eventList []*events_pb2.Event) (blockID string, blockNum uint64) {
+	for _, event := range eventList {
+		if event.EventType == blockCommitEvent {
+			// the invalid number is reported with the state delta
+			blockID, blockNum, _ = parseBlockCommit(event)
+			return blockID, blockNum
+		}
+	}
+
+	return "", 0
+}
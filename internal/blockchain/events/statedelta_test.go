@@ -0,0 +1,60 @@
+package events
+
+import (
+	"doc-management/internal/blockchain"
+	"testing"
+
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/events_pb2"
+	transaction_receipt_pb2 "github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_receipt_pb2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestParseStateDelta(t *testing.T) {
+	changes, err := proto.Marshal(&transaction_receipt_pb2.StateChangeList{
+		StateChanges: []*transaction_receipt_pb2.StateChange{
+			{Address: "8ed94c01", Value: []byte("value"), Type: transaction_receipt_pb2.StateChange_SET},
+			{Address: "8ed94c02", Type: transaction_receipt_pb2.StateChange_DELETE},
+		},
+	})
+	require.NoError(t, err)
+
+	delta, ok, err := parseStateDelta([]*events_pb2.Event{
+		{EventType: "proposal_accepted", Data: []byte("some-id")},
+		{EventType: stateDeltaEvent, Data: changes},
+		{EventType: blockCommitEvent, Attributes: []*events_pb2.Event_Attribute{
+			{Key: "block_id", Value: "abc"},
+			{Key: "block_num", Value: "12"},
+		}},
+	})
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, blockchain.StateDelta{
+		BlockID:  "abc",
+		BlockNum: 12,
+		Changes: []blockchain.StateChange{
+			{Address: "8ed94c01", Value: []byte("value")},
+			{Address: "8ed94c02", Deleted: true},
+		},
+	}, delta)
+
+	_, ok, err = parseStateDelta([]*events_pb2.Event{{EventType: "proposal_accepted"}})
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestCommittedBlock(t *testing.T) {
+	blockID, blockNum := committedBlock([]*events_pb2.Event{
+		{EventType: "proposal_accepted", Data: []byte("some-id")},
+		{EventType: blockCommitEvent, Attributes: []*events_pb2.Event_Attribute{
+			{Key: "block_id", Value: "abc"},
+			{Key: "block_num", Value: "12"},
+		}},
+	})
+	assert.Equal(t, "abc", blockID)
+	assert.Equal(t, uint64(12), blockNum)
+
+	blockID, _ = committedBlock([]*events_pb2.Event{{EventType: "proposal_accepted"}})
+	assert.Empty(t, blockID)
+}
@@ -26,6 +26,13 @@ func initHashVars() {
 	})
 }
 
+// GetFamilyPrefix returns the prefix of all the addresses of the family
+func GetFamilyPrefix() string {
+	initHashVars()
+
+	return familyHash[0:6]
+}
+
 func GetDocAddress(category string, docName string) (address string) {
 	initHashVars()
 
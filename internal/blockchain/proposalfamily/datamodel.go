@@ -17,6 +17,9 @@ type ProposalData struct {
 	ProposedDocStatus string   `cbor:"proposedDocStatus"`
 	CurrentStatus     string   `cbor:"currentStatus"`
 	ContentHash       string   `cbor:"contentHash"`
+	FileName          string   `cbor:"fileName"`
+	ContentType       string   `cbor:"contentType"`
+	ContentSize       int64    `cbor:"contentSize"`
 }
 
 type DocData struct {
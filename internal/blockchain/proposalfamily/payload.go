@@ -0,0 +1,114 @@
+package proposalfamily
+
+import (
+	"doc-management/internal/model"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/fxamacker/cbor"
+)
+
+// Payload is the CBOR encoded payload of the proposals family transactions
+type Payload struct {
+	Action     Action `cbor:"action"`
+	ProposalID string `cbor:"proposalID"`
+
+	// insert
+	Category       string          `cbor:"category"`
+	DocName        string          `cbor:"docName"`
+	ContentHash    string          `cbor:"contentHash"`
+	ProposedStatus model.DocStatus `cbor:"proposedStatus"`
+	Author         string          `cbor:"author"`
+	FileName       string          `cbor:"fileName"`
+	ContentType    string          `cbor:"contentType"`
+	ContentSize    int64           `cbor:"contentSize"`
+
+	// vote
+	Voter string `cbor:"voter"`
+}
+
+// the fields allowed in the payload of each action
+var payloadFields = map[Action][]string{
+	ActionInsert: {"action", "proposalID", "category", "docName", "contentHash", "proposedStatus", "author", "fileName", "contentType", "contentSize"},
+	ActionVote:   {"action", "proposalID", "voter"},
+	ActionDelete: {"action", "proposalID"},
+}
+
+// DecodePayload decodes the payload and checks it against the schema of its action
+func DecodePayload(data []byte) (Payload, error) {
+	var fields map[string]interface{}
+	if err := cbor.Unmarshal(data, &fields); err != nil {
+		return Payload{}, errors.New("failed to decode the payload: " + err.Error())
+	}
+
+	var payload Payload
+	if err := cbor.Unmarshal(data, &payload); err != nil {
+		return Payload{}, errors.New("invalid payload: " + err.Error())
+	}
+
+	allowed, ok := payloadFields[payload.Action]
+	if !ok {
+		return Payload{}, errors.New("unknown action: " + string(payload.Action))
+	}
+	for field := range fields {
+		if !contains(allowed, field) {
+			return Payload{}, fmt.Errorf("unexpected field %s in the %s payload", field, payload.Action)
+		}
+	}
+
+	return payload, payload.validate()
+}
+
+// Proposal returns the proposal inserted by the payload
+func (p Payload) Proposal() model.Proposal {
+	return model.Proposal{
+		ProposalID:         p.ProposalID,
+		DocumentName:       p.DocName,
+		Category:           p.Category,
+		ModificationAuthor: p.Author,
+		ContentHash:        p.ContentHash,
+		ProposedStatus:     p.ProposedStatus,
+		FileName:           p.FileName,
+		ContentType:        p.ContentType,
+		ContentSize:        p.ContentSize,
+	}
+}
+
+func (p Payload) validate() error {
+	if p.ProposalID == "" {
+		return errors.New("proposalID is missing")
+	}
+
+	switch p.Action {
+	case ActionInsert:
+		if p.Category == "" || p.DocName == "" || p.Author == "" {
+			return errors.New("category, docName and author are required")
+		}
+		if !p.ProposedStatus.IsValid() {
+			return errors.New("invalid proposed status: " + string(p.ProposedStatus))
+		}
+		if hash, err := hex.DecodeString(p.ContentHash); err != nil || len(hash) != 64 {
+			return errors.New("contentHash is not a SHA-512 hash")
+		}
+		if p.ContentSize < 0 {
+			return errors.New("invalid content size")
+		}
+
+	case ActionVote:
+		if p.Voter == "" {
+			return errors.New("voter is missing")
+		}
+	}
+
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -2,7 +2,6 @@ package blockchain
 
 import (
 	"context"
-	"net/url"
 
 	"doc-management/internal/blockchain/proposalfamily"
 	propfamily "doc-management/internal/blockchain/proposalfamily"
@@ -15,7 +14,7 @@ import (
 	"go.uber.org/zap"
 )
 
-func (c Client) RemoveProposal(ctx context.Context, proposalID string, signer *signing.Signer) (transactionID string, err error) {
+func (c Client) RemoveProposal(ctx context.Context, proposalID string, signer *signing.Signer) (batchID string, err error) {
 	proposalAddr := propfamily.GetProposalAddressFromID(proposalID)
 
 	docAddr, authorAddr, err := c.getAddrByProposalID(ctx, proposalID)
@@ -35,6 +34,20 @@ func (c Client) RemoveProposal(ctx context.Context, proposalID string, signer *s
 	return c.submitTransaction(ctx, transaction, signer)
 }
 
+// RemoveProposalTemplate returns the unsigned transaction removing the proposal
+func RemoveProposalTemplate(proposal model.Proposal) (TransactionTemplate, error) {
+	payload := make(map[interface{}]interface{})
+	payload["action"] = proposalfamily.ActionDelete
+	payload["proposalID"] = proposal.ProposalID
+
+	template, err := newTemplate(payload, ProposalAddresses(proposal), propfamily.FamilyName, propfamily.FamilyVersion)
+	if err != nil {
+		return TransactionTemplate{}, errors.New("failed to create a proposal remove transaction: " + err.Error())
+	}
+
+	return template, nil
+}
+
 func (c Client) getAddrByProposalID(ctx context.Context, proposalID string) (docAddr string, authorAddr string, err error) {
 	prop, err := c.getProposalState(ctx, proposalID)
 	if err != nil {
@@ -48,10 +61,25 @@ func (c Client) getAddrByProposalID(ctx context.Context, proposalID string) (doc
 	return
 }
 
-func (c Client) SignProposal(ctx context.Context, proposalID string, userID string, signer *signing.Signer) (transactionID string, err error) {
+func (c Client) SignProposal(ctx context.Context, proposalID string, userID string, signer *signing.Signer) (batchID string, err error) {
+	template, err := c.SignProposalTemplate(ctx, proposalID, userID)
+	if err != nil {
+		return "", err
+	}
+
+	transaction, err := template.Sign(signer)
+	if err != nil {
+		return "", errors.New("failed to create a proposal sign transaction: " + err.Error())
+	}
+
+	return c.submitTransaction(ctx, transaction, signer)
+}
+
+// SignProposalTemplate returns the unsigned transaction of the user's vote for the proposal
+func (c Client) SignProposalTemplate(ctx context.Context, proposalID string, userID string) (TransactionTemplate, error) {
 	proposalAddr := propfamily.GetProposalAddressFromID(proposalID)
 	voterAddr := propfamily.GetUserAddress(userID)
-	settingAddr := settingsfamily.GetAddress("proposal.vote.threshold")
+	settingAddr := settingsfamily.GetAddress(voteThresholdSetting)
 
 	docAddr, authorAddr, err := c.getAddrByProposalID(ctx, proposalID)
 	if err != nil {
@@ -63,53 +91,28 @@ func (c Client) SignProposal(ctx context.Context, proposalID string, userID stri
 	payload["proposalID"] = proposalID
 	payload["voter"] = userID
 
-	transaction, err := NewTransaction(payload, signer, []string{proposalAddr, voterAddr, authorAddr, docAddr, settingAddr}, propfamily.FamilyName, propfamily.FamilyVersion)
-	if err != nil {
-		return "", errors.New("failed to create a proposal sign transaction: " + err.Error())
-	}
-
-	return c.submitTransaction(ctx, transaction, signer)
+	return newTemplate(payload, []string{proposalAddr, voterAddr, authorAddr, docAddr, settingAddr}, propfamily.FamilyName, propfamily.FamilyVersion)
 }
 
-// GetActiveProposals returns all active proposals
-func (c Client) GetActiveProposals(ctx context.Context) (proposals []model.Proposal, err error) {
+// GetProposalsPage returns a page of all the proposals using the REST API paging,
+// starting at the given position; returns the position of the next page, empty if it's the last one
+func (c Client) GetProposalsPage(ctx context.Context, start string, limit int) (proposals []model.Proposal, next string, err error) {
 	// fetch all the proposals = pass only the part of address corresponding to proposals
-	addr := propfamily.GetProposalAddressFromID("")
-	filter := url.Values{}
-	filter.Set("address", addr)
-
-	url := fmt.Sprintf("%s?%s", stateAPI, filter.Encode())
-	// TODO: paging, limits
-	response, err := c.sendRequest(ctx, url, nil, "")
+	entries, _, next, err := c.listStatePage(ctx, propfamily.GetProposalAddressFromID(""), "", start, limit)
 	if err != nil {
-		return
+		return nil, "", err
 	}
 
-	unmarshalled, err := unmarshalDataList(response)
-	if err != nil {
-		return
-	}
-	// c.logger.Info(fmt.Sprint("fetched ", len(unmarshalled), " proposals from the current state"))
-
-	for _, payload := range unmarshalled {
-		var proposal propfamily.ProposalData
-		if err := unmarshalStatePayload(&proposal, string(payload)); err != nil {
-			c.logger.Error("get active proposals: failed to unmarshal the proposal payload: " + err.Error())
-			continue
-		}
-
-		if proposal.CurrentStatus != string(model.DocStatusActive) {
-			// return only active proposals
-			// c.logger.Debug("get active proposals: found inactive proposal: " + proposal.ProposalID)
+	for _, entry := range entries {
+		proposal, err := DecodeProposal(entry.Data)
+		if err != nil {
+			c.logger.Error("get proposals: "+err.Error(), zap.String("address", entry.Address))
 			continue
 		}
-
-		m := convertToModelProposal(proposal)
-		proposals = append(proposals, m)
-
+		proposals = append(proposals, proposal)
 	}
 
-	return proposals, nil
+	return proposals, next, nil
 }
 
 func convertToModelProposal(propData propfamily.ProposalData) model.Proposal {
@@ -120,6 +123,9 @@ func convertToModelProposal(propData propfamily.ProposalData) model.Proposal {
 		ModificationAuthor: propData.Author,
 		Content:            []byte{},
 		ContentHash:        propData.ContentHash,
+		FileName:           propData.FileName,
+		ContentType:        propData.ContentType,
+		ContentSize:        propData.ContentSize,
 		ProposedStatus:     model.DocStatus(propData.ProposedDocStatus),
 		CurrentStatus:      model.ProposalStatus(propData.CurrentStatus),
 		Signers:            propData.Signers,
@@ -146,36 +152,14 @@ func (c Client) GetDocProposals(ctx context.Context, category string, documentNa
 }
 func (c Client) GetProposal(ctx context.Context, proposalID string) (model.Proposal, error) {
 	propData, err := c.getProposalState(ctx, proposalID)
-	if err != nil {
-		return model.Proposal{}, errors.New("failed to get the proposal from blockchain: " + err.Error())
+	if err == ErrNotFound {
+		return model.Proposal{}, err
 	}
-
-	return convertToModelProposal(propData), nil
-}
-
-// GetUserProposals returns only active proposals created by the user
-func (c Client) GetUserProposals(ctx context.Context, user string) (proposals []model.Proposal, err error) {
-	userPropos, err := c.getUserState(ctx, user)
 	if err != nil {
-		return
+		return model.Proposal{}, fmt.Errorf("failed to get the proposal from blockchain: %w", err)
 	}
 
-	// TODO: parallelize
-	for _, id := range userPropos.Active {
-		propData, err := c.getProposalState(ctx, id)
-		if err != nil {
-			c.logger.Error("getting user proposal error, skipping... error: "+err.Error(), zap.String("proposalID", id))
-			continue
-		}
-
-		proposals = append(proposals, convertToModelProposal(propData))
-	}
-
-	if len(proposals) != len(userPropos.Active) {
-		c.logger.Warn(fmt.Sprint("returning ", len(proposals), "/", len(userPropos.Active), " proposals due to get proposal state errors"))
-	}
-
-	return proposals, nil
+	return convertToModelProposal(propData), nil
 }
 
 func (c Client) getDocProposalState(ctx context.Context, category string, docName string) (data propfamily.DocData, err error) {
@@ -210,28 +194,22 @@ func (c Client) getProposalState(ctx context.Context, proposalID string) (data p
 	return payload, nil
 }
 
-func (c Client) getUserState(ctx context.Context, user string) (data propfamily.UserData, err error) {
-	addr := propfamily.GetUserAddress(user)
-	url := fmt.Sprintf("%s/%s", stateAPI, addr)
-	response, err := c.sendRequest(ctx, url, nil, "")
+func (c Client) SubmitProposal(ctx context.Context, proposal model.Proposal, signer *signing.Signer) (batchID string, err error) {
+	template, err := ProposalTemplate(proposal)
 	if err != nil {
-		return data, err
+		return "", err
 	}
 
-	var payload propfamily.UserData
-	if err := unmarshalStatePayload(&payload, response); err != nil {
-		return data, errors.New("get user state error: " + err.Error())
+	transaction, err := template.Sign(signer)
+	if err != nil {
+		return "", errors.New("failed to create a new proposal transaction: " + err.Error())
 	}
 
-	return payload, nil
+	return c.submitTransaction(ctx, transaction, signer)
 }
 
-func (c Client) SubmitProposal(ctx context.Context, proposal model.Proposal, signer *signing.Signer) (transactionID string, err error) {
-
-	proposalDataAddress := proposalfamily.GetProposalAddress(proposal)
-	authorAddress := proposalfamily.GetUserAddress(proposal.ModificationAuthor)
-	docAddress := proposalfamily.GetDocAddress(proposal.Category, proposal.DocumentName)
-
+// ProposalTemplate returns the unsigned transaction submitting the proposal
+func ProposalTemplate(proposal model.Proposal) (TransactionTemplate, error) {
 	payload := make(map[interface{}]interface{})
 	payload["action"] = proposalfamily.ActionInsert
 	payload["proposalID"] = proposal.ProposalID
@@ -240,12 +218,38 @@ func (c Client) SubmitProposal(ctx context.Context, proposal model.Proposal, sig
 	payload["contentHash"] = proposal.ContentHash
 	payload["proposedStatus"] = proposal.ProposedStatus
 	payload["author"] = proposal.ModificationAuthor
+	payload["fileName"] = proposal.FileName
+	payload["contentType"] = proposal.ContentType
+	payload["contentSize"] = proposal.ContentSize
 
-	transaction, err := NewTransaction(payload, signer, []string{proposalDataAddress, authorAddress, docAddress}, propfamily.FamilyName, propfamily.FamilyVersion)
+	template, err := newTemplate(payload, ProposalAddresses(proposal), propfamily.FamilyName, propfamily.FamilyVersion)
 	if err != nil {
-		return "", errors.New("failed to create a new proposal transaction: " + err.Error())
+		return TransactionTemplate{}, errors.New("failed to create a new proposal transaction: " + err.Error())
 	}
 
-	return c.submitTransaction(ctx, transaction, signer)
+	return template, nil
+}
+
+// SignAndAcceptBatch returns the final vote for the proposal together with the accepted document version
+// in a single batch batched by the app; the document version is processed after the vote,
+// so it's recorded only if the vote is committed
+func (c Client) SignAndAcceptBatch(ctx context.Context, proposalID string, userID string, voter *signing.Signer, doc model.Document, app *signing.Signer) (*BatchBuilder, error) {
+	vote, err := c.SignProposalTemplate(ctx, proposalID, userID)
+	if err != nil {
+		return nil, err
+	}
+	version, err := DocumentVersionTemplate(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	builder := NewBatchBuilder(app)
+	if err := builder.Add("vote", vote, voter); err != nil {
+		return nil, err
+	}
+	if err := builder.Add("document", version, app, "vote"); err != nil {
+		return nil, err
+	}
 
+	return builder, nil
 }
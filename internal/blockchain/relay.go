@@ -0,0 +1,140 @@
+package blockchain
+
+import (
+	"context"
+	"doc-management/internal/hashing"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/batch_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/signing"
+	"google.golang.org/protobuf/proto"
+)
+
+var ErrInvalidBatch = errors.New("invalid batch")
+
+// SignedBatch is a batch signed outside the application, with its signatures verified
+type SignedBatch struct {
+	ID              string
+	SignerPublicKey string
+	Transactions    []SignedTransaction
+}
+
+type SignedTransaction struct {
+	ID              string
+	SignerPublicKey string
+	FamilyName      string
+	FamilyVersion   string
+	Inputs          []string
+	Outputs         []string
+	// CBOR encoded payload
+	Payload []byte
+}
+
+// ParseBatchList decodes the serialized BatchList and verifies the signatures of all the batches and transactions
+func ParseBatchList(data []byte) ([]SignedBatch, error) {
+	var batchList batch_pb2.BatchList
+	if err := proto.Unmarshal(data, &batchList); err != nil {
+		return nil, invalidBatch("failed to decode the batch list: " + err.Error())
+	}
+	if len(batchList.Batches) == 0 {
+		return nil, invalidBatch("the batch list is empty")
+	}
+
+	context := signing.NewSecp256k1Context()
+	var batches []SignedBatch
+	for _, batch := range batchList.Batches {
+		signed, err := verifyBatch(context, batch)
+		if err != nil {
+			return nil, err
+		}
+		batches = append(batches, signed)
+	}
+
+	return batches, nil
+}
+
+// RelayBatchList submits the batch list signed outside the application as it is,
+// the batches are processed asynchronously
+func (c Client) RelayBatchList(ctx context.Context, data []byte) error {
+	return c.submitBatchList(ctx, data)
+}
+
+func verifyBatch(context signing.Context, batch *batch_pb2.Batch) (SignedBatch, error) {
+	var header batch_pb2.BatchHeader
+	if err := proto.Unmarshal(batch.Header, &header); err != nil {
+		return SignedBatch{}, invalidBatch("failed to decode the batch header: " + err.Error())
+	}
+	if !verifySignature(context, batch.HeaderSignature, batch.Header, header.SignerPublicKey) {
+		return SignedBatch{}, invalidBatch("invalid signature of batch " + batch.HeaderSignature)
+	}
+
+	if len(header.TransactionIds) != len(batch.Transactions) {
+		return SignedBatch{}, invalidBatch("the transactions of batch " + batch.HeaderSignature + " don't match its header")
+	}
+
+	signed := SignedBatch{ID: batch.HeaderSignature, SignerPublicKey: header.SignerPublicKey}
+	for i, transaction := range batch.Transactions {
+		if transaction.HeaderSignature != header.TransactionIds[i] {
+			return SignedBatch{}, invalidBatch("the transactions of batch " + batch.HeaderSignature + " don't match its header")
+		}
+
+		signedTransaction, err := verifyTransaction(context, transaction, header.SignerPublicKey)
+		if err != nil {
+			return SignedBatch{}, err
+		}
+		signed.Transactions = append(signed.Transactions, signedTransaction)
+	}
+
+	return signed, nil
+}
+
+func verifyTransaction(context signing.Context, transaction *transaction_pb2.Transaction, batcherPublicKey string) (SignedTransaction, error) {
+	var header transaction_pb2.TransactionHeader
+	if err := proto.Unmarshal(transaction.Header, &header); err != nil {
+		return SignedTransaction{}, invalidBatch("failed to decode the transaction header: " + err.Error())
+	}
+	if !verifySignature(context, transaction.HeaderSignature, transaction.Header, header.SignerPublicKey) {
+		return SignedTransaction{}, invalidBatch("invalid signature of transaction " + transaction.HeaderSignature)
+	}
+	if header.BatcherPublicKey != batcherPublicKey {
+		return SignedTransaction{}, invalidBatch("transaction " + transaction.HeaderSignature + " is batched by another key")
+	}
+	if header.PayloadSha512 != hashing.CalculateSHA512(string(transaction.Payload)) {
+		return SignedTransaction{}, invalidBatch("the payload of transaction " + transaction.HeaderSignature + " doesn't match its hash")
+	}
+
+	return SignedTransaction{
+		ID:              transaction.HeaderSignature,
+		SignerPublicKey: header.SignerPublicKey,
+		FamilyName:      header.FamilyName,
+		FamilyVersion:   header.FamilyVersion,
+		Inputs:          header.Inputs,
+		Outputs:         header.Outputs,
+		Payload:         transaction.Payload,
+	}, nil
+}
+
+func verifySignature(context signing.Context, signatureHex string, message []byte, publicKeyHex string) bool {
+	// the SDK panics on malformed signatures and keys
+	signature, err := hex.DecodeString(signatureHex)
+	if err != nil || len(signature) != 64 {
+		return false
+	}
+	publicKey, err := hex.DecodeString(publicKeyHex)
+	if err != nil {
+		return false
+	}
+	if _, err := btcec.ParsePubKey(publicKey, btcec.S256()); err != nil {
+		return false
+	}
+
+	return context.Verify(signature, message, signing.NewSecp256k1PublicKey(publicKey))
+}
+
+func invalidBatch(message string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidBatch, message)
+}
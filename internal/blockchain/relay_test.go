@@ -0,0 +1,94 @@
+package blockchain
+
+import (
+	"doc-management/internal/hashing"
+	"doc-management/internal/model"
+	"doc-management/internal/signkeys"
+	"errors"
+	"testing"
+
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/batch_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/signing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+func newSigner(t *testing.T) *signing.Signer {
+	keys, err := signkeys.GenerateKeys()
+	require.NoError(t, err)
+	return keys.GetSigner()
+}
+
+func signedBatchList(t *testing.T, signer *signing.Signer) []byte {
+	hashing.Initialize(zap.NewNop())
+	template, err := ProposalTemplate(model.Proposal{
+		ProposalID:         "proposal-1",
+		Category:           "policies",
+		DocumentName:       "doc",
+		ModificationAuthor: "user-1",
+		ContentHash:        "hash",
+	})
+	require.NoError(t, err)
+
+	transaction, err := template.Sign(signer)
+	require.NoError(t, err)
+
+	_, batchList, err := createBatchList([]*transaction_pb2.Transaction{&transaction}, signer)
+	require.NoError(t, err)
+	return batchList
+}
+
+func TestParseBatchList(t *testing.T) {
+	signer := newSigner(t)
+
+	batches, err := ParseBatchList(signedBatchList(t, signer))
+	require.NoError(t, err)
+	require.Len(t, batches, 1)
+	assert.Equal(t, signer.GetPublicKey().AsHex(), batches[0].SignerPublicKey)
+	require.Len(t, batches[0].Transactions, 1)
+	assert.Equal(t, "proposals", batches[0].Transactions[0].FamilyName)
+}
+
+// modifyBatchList decodes the batch list, applies the change and encodes it again
+func modifyBatchList(t *testing.T, data []byte, modify func(list *batch_pb2.BatchList)) []byte {
+	var list batch_pb2.BatchList
+	require.NoError(t, proto.Unmarshal(data, &list))
+	modify(&list)
+	modified, err := proto.Marshal(&list)
+	require.NoError(t, err)
+	return modified
+}
+
+func TestParseBatchListRejectsTampered(t *testing.T) {
+	valid := signedBatchList(t, newSigner(t))
+	other := signedBatchList(t, newSigner(t))
+
+	cases := map[string][]byte{
+		"garbage": []byte("not a batch list"),
+		"empty":   {},
+		"payload changed": modifyBatchList(t, valid, func(list *batch_pb2.BatchList) {
+			list.Batches[0].Transactions[0].Payload = append(list.Batches[0].Transactions[0].Payload, 0)
+		}),
+		"batch signature changed": modifyBatchList(t, valid, func(list *batch_pb2.BatchList) {
+			list.Batches[0].HeaderSignature = list.Batches[0].HeaderSignature[:126] + "00"
+		}),
+		"short signature": modifyBatchList(t, valid, func(list *batch_pb2.BatchList) {
+			list.Batches[0].HeaderSignature = "00"
+		}),
+		"transaction of another batch": modifyBatchList(t, valid, func(list *batch_pb2.BatchList) {
+			var otherList batch_pb2.BatchList
+			require.NoError(t, proto.Unmarshal(other, &otherList))
+			list.Batches[0].Transactions = otherList.Batches[0].Transactions
+		}),
+	}
+
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseBatchList(data)
+			assert.True(t, errors.Is(err, ErrInvalidBatch), "unexpected error: %v", err)
+		})
+	}
+}
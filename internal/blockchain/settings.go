@@ -0,0 +1,60 @@
+package blockchain
+
+import (
+	"context"
+	"doc-management/internal/blockchain/settingsfamily"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/setting_pb2"
+	"google.golang.org/protobuf/proto"
+)
+
+// GetVoteThreshold returns the number of the votes accepting a proposal, ErrNotFound if the setting is not set
+func (c Client) GetVoteThreshold(ctx context.Context) (int, error) {
+	value, err := c.getSetting(ctx, voteThresholdSetting)
+	if err != nil {
+		return 0, err
+	}
+
+	threshold, err := strconv.Atoi(value)
+	if err != nil || threshold < 1 {
+		return 0, errors.New("invalid value of the setting " + voteThresholdSetting + ": " + value)
+	}
+	return threshold, nil
+}
+
+func (c Client) getSetting(ctx context.Context, name string) (string, error) {
+	url := fmt.Sprintf("%s/%s", stateAPI, settingsfamily.GetAddress(name))
+	response, err := c.sendRequest(ctx, url, nil, "")
+	if err != nil {
+		return "", err
+	}
+
+	var unmarshalled struct {
+		Data string
+	}
+	if err := json.Unmarshal([]byte(response), &unmarshalled); err != nil {
+		return "", errors.New("failed to unmarshal the setting: " + err.Error())
+	}
+	decoded, err := base64.StdEncoding.DecodeString(unmarshalled.Data)
+	if err != nil {
+		return "", errors.New("failed to decode the setting: " + err.Error())
+	}
+
+	var setting setting_pb2.Setting
+	if err := proto.Unmarshal(decoded, &setting); err != nil {
+		return "", errors.New("failed to unmarshal the setting: " + err.Error())
+	}
+
+	// more entries are stored under the address on a collision
+	for _, entry := range setting.Entries {
+		if entry.Key == name {
+			return entry.Value, nil
+		}
+	}
+	return "", ErrNotFound
+}
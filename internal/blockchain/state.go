@@ -0,0 +1,120 @@
+package blockchain
+
+import (
+	"context"
+	"doc-management/internal/blockchain/proposalfamily"
+	"doc-management/internal/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/fxamacker/cbor"
+)
+
+// StateEntry is raw data stored under an address on the blockchain
+type StateEntry struct {
+	Address string
+	Data    []byte
+}
+
+type StateChange struct {
+	Address string
+	// empty if deleted
+	Value   []byte
+	Deleted bool
+}
+
+// StateDelta holds the changes of the watched addresses made in a single block;
+// it's delivered for each committed block, even if there are no changes
+type StateDelta struct {
+	BlockID  string
+	BlockNum uint64
+	Changes  []StateChange
+}
+
+// ListState returns all the entries with addresses starting with the prefix, following the REST API paging.
+// If the head is given, the state is read at this block, otherwise at the current chain head.
+// Returns the ID of the block the state was read at.
+func (c Client) ListState(ctx context.Context, addressPrefix string, head string) (entries []StateEntry, stateHead string, err error) {
+	start := ""
+	for {
+		pageEntries, pageHead, next, err := c.listStatePage(ctx, addressPrefix, head, start, 0)
+		if err != nil {
+			return nil, "", err
+		}
+		entries = append(entries, pageEntries...)
+
+		// the next pages need to be read at the same block
+		head = pageHead
+		if next == "" {
+			return entries, head, nil
+		}
+		start = next
+	}
+}
+
+// listStatePage returns a single page of the state entries starting at the given position;
+// the validator's default page size is used if the limit is 0. Returns the position of the next page,
+// empty if it's the last one.
+func (c Client) listStatePage(ctx context.Context, addressPrefix, head, start string, limit int) (entries []StateEntry, stateHead string, next string, err error) {
+	filter := url.Values{}
+	filter.Set("address", addressPrefix)
+	if head != "" {
+		filter.Set("head", head)
+	}
+	if start != "" {
+		filter.Set("start", start)
+	}
+	if limit > 0 {
+		filter.Set("limit", fmt.Sprint(limit))
+	}
+
+	url := fmt.Sprintf("%s?%s", stateAPI, filter.Encode())
+	response, err := c.sendRequest(ctx, url, nil, "")
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	var unmarshalled struct {
+		Data []struct {
+			Address string
+			// base64 encoded by the JSON decoder
+			Data []byte
+		}
+		Head   string
+		Paging struct {
+			NextPosition string `json:"next_position"`
+		}
+	}
+	if err := json.Unmarshal([]byte(response), &unmarshalled); err != nil {
+		return nil, "", "", errors.New("failed to unmarshal the state list: " + err.Error())
+	}
+
+	entries = make([]StateEntry, len(unmarshalled.Data))
+	for i, entry := range unmarshalled.Data {
+		entries[i] = StateEntry{Address: entry.Address, Data: entry.Data}
+	}
+
+	return entries, unmarshalled.Head, unmarshalled.Paging.NextPosition, nil
+}
+
+// DecodeProposal decodes the proposal data stored on the blockchain
+func DecodeProposal(data []byte) (model.Proposal, error) {
+	var proposal proposalfamily.ProposalData
+	if err := cbor.Unmarshal(data, &proposal); err != nil {
+		return model.Proposal{}, errors.New("failed to unmarshal the proposal: " + err.Error())
+	}
+
+	return convertToModelProposal(proposal), nil
+}
+
+// DecodeDocument decodes the doc version data stored on the blockchain
+func DecodeDocument(data []byte) (model.Document, error) {
+	var doc model.Document
+	if err := cbor.Unmarshal(data, &doc); err != nil {
+		return model.Document{}, errors.New("failed to unmarshal the doc version: " + err.Error())
+	}
+
+	return doc, nil
+}
@@ -0,0 +1,76 @@
+package blockchain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// the statuses of a submitted batch reported by the validator
+const (
+	BatchCommitted = "COMMITTED"
+	BatchInvalid   = "INVALID"
+	BatchPending   = "PENDING"
+	// the validator doesn't know the batch, e.g. it was dropped on a restart
+	BatchUnknown = "UNKNOWN"
+)
+
+// InvalidTransaction is a transaction of an invalid batch with the message of the transaction processor
+type InvalidTransaction struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+}
+
+type BatchStatus struct {
+	BatchID string `json:"batchID"`
+	Status  string `json:"status"`
+	// the transactions rejected by the transaction processors, if the batch is invalid
+	InvalidTransactions []InvalidTransaction `json:"invalidTransactions,omitempty"`
+}
+
+// Final tells if the batch won't change its status anymore
+func (s BatchStatus) Final() bool {
+	return s.Status == BatchCommitted || s.Status == BatchInvalid
+}
+
+// GetBatchStatuses returns the statuses of the batches; the validator waits up to the given time
+// for the pending batches to be committed
+func (c Client) GetBatchStatuses(ctx context.Context, batchIDs []string, wait time.Duration) ([]BatchStatus, error) {
+	if len(batchIDs) == 0 {
+		return nil, nil
+	}
+
+	// the IDs are sent in the body, there may be too many for the URL
+	ids, err := json.Marshal(batchIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	apiSuffix := batchStatusAPI
+	if seconds := int(wait / time.Second); seconds > 0 {
+		apiSuffix = fmt.Sprintf("%s?wait=%d", batchStatusAPI, seconds)
+	}
+	response, err := c.sendRequest(ctx, apiSuffix, ids, contentTypeJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the batch statuses: %w", err)
+	}
+
+	var unmarshalled struct {
+		Data []struct {
+			ID                  string               `json:"id"`
+			Status              string               `json:"status"`
+			InvalidTransactions []InvalidTransaction `json:"invalid_transactions"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(response), &unmarshalled); err != nil {
+		return nil, errors.New("failed to unmarshal the batch statuses: " + err.Error())
+	}
+
+	statuses := make([]BatchStatus, len(unmarshalled.Data))
+	for i, entry := range unmarshalled.Data {
+		statuses[i] = BatchStatus{BatchID: entry.ID, Status: entry.Status, InvalidTransactions: entry.InvalidTransactions}
+	}
+	return statuses, nil
+}
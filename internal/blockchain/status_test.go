@@ -0,0 +1,44 @@
+package blockchain
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestGetBatchStatuses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/batch_statuses", r.URL.Path)
+		assert.Equal(t, "2", r.URL.Query().Get("wait"))
+
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		var ids []string
+		require.NoError(t, json.Unmarshal(body, &ids))
+		assert.Equal(t, []string{"batch-1", "batch-2"}, ids)
+
+		_, _ = w.Write([]byte(`{"data": [
+			{"id": "batch-1", "status": "COMMITTED", "invalid_transactions": []},
+			{"id": "batch-2", "status": "INVALID", "invalid_transactions": [{"id": "transaction-1", "message": "proposal exists", "extended_data": ""}]}
+		]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(zap.NewNop(), server.URL)
+	statuses, err := client.GetBatchStatuses(context.Background(), []string{"batch-1", "batch-2"}, 2*time.Second)
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+
+	assert.Equal(t, BatchStatus{BatchID: "batch-1", Status: BatchCommitted, InvalidTransactions: []InvalidTransaction{}}, statuses[0])
+	assert.True(t, statuses[0].Final())
+	assert.Equal(t, BatchInvalid, statuses[1].Status)
+	assert.Equal(t, []InvalidTransaction{{ID: "transaction-1", Message: "proposal exists"}}, statuses[1].InvalidTransactions)
+}
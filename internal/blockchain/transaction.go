@@ -33,23 +33,59 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
+// TransactionTemplate is an unsigned transaction, it can be signed by the application or outside of it
+type TransactionTemplate struct {
+	FamilyName    string `json:"familyName"`
+	FamilyVersion string `json:"familyVersion"`
+	// the input and the output addresses
+	Addresses []string `json:"addresses"`
+	// CBOR encoded payload
+	Payload []byte `json:"payload"`
+}
+
 func NewTransaction(payload map[interface{}]interface{}, signer *signing.Signer, addresses []string, familyName, familyVersion string) (transaction_pb2.Transaction, error) {
+	template, err := newTemplate(payload, addresses, familyName, familyVersion)
+	if err != nil {
+		return transaction_pb2.Transaction{}, err
+	}
+
+	return template.Sign(signer)
+}
 
+func newTemplate(payload map[interface{}]interface{}, addresses []string, familyName, familyVersion string) (TransactionTemplate, error) {
 	payloadDump, err := cbor.Marshal(payload, cbor.CanonicalEncOptions())
 	if err != nil {
-		return transaction_pb2.Transaction{}, errors.New("failed to dump the payload: " + err.Error())
+		return TransactionTemplate{}, errors.New("failed to dump the payload: " + err.Error())
 	}
 
+	return TransactionTemplate{
+		FamilyName:    familyName,
+		FamilyVersion: familyVersion,
+		Addresses:     addresses,
+		Payload:       payloadDump,
+	}, nil
+}
+
+// Sign builds the transaction signed and batched by the signer
+func (t TransactionTemplate) Sign(signer *signing.Signer) (transaction_pb2.Transaction, error) {
+	return t.sign(signer, signer.GetPublicKey().AsHex(), nil)
+}
+
+// sign builds the transaction signed by the signer, to be batched by the batcher's key;
+// the transaction is processed only after the transactions with the given IDs
+func (t TransactionTemplate) sign(signer *signing.Signer, batcherPublicKey string, dependencies []string) (transaction_pb2.Transaction, error) {
+
 	// Construct TransactionHeader
 	rawTransactionHeader := transaction_pb2.TransactionHeader{
 		SignerPublicKey:  signer.GetPublicKey().AsHex(),
-		FamilyName:       familyName,
-		FamilyVersion:    familyVersion,
+		FamilyName:       t.FamilyName,
+		FamilyVersion:    t.FamilyVersion,
 		Nonce:            strconv.Itoa(rand.Int()),
-		BatcherPublicKey: signer.GetPublicKey().AsHex(),
-		Inputs:           addresses,
-		Outputs:          addresses,
-		PayloadSha512:    hashing.CalculateSHA512(string(payloadDump)),
+		BatcherPublicKey: batcherPublicKey,
+		Dependencies:     dependencies,
+		Inputs:           t.Addresses,
+		Outputs:          t.Addresses,
+		PayloadSha512:    hashing.CalculateSHA512(string(t.Payload)),
 	}
 
 	transactionHeader, err := proto.Marshal(&rawTransactionHeader)
@@ -66,7 +102,7 @@ func NewTransaction(payload map[interface{}]interface{}, signer *signing.Signer,
 	transaction := transaction_pb2.Transaction{
 		Header:          transactionHeader,
 		HeaderSignature: transactionHeaderSignature,
-		Payload:         payloadDump,
+		Payload:         t.Payload,
 	}
 
 	return transaction, nil
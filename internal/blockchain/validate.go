@@ -0,0 +1,103 @@
+package blockchain
+
+import (
+	"doc-management/internal/blockchain/doctrackerfamily"
+	"doc-management/internal/blockchain/proposalfamily"
+	"doc-management/internal/blockchain/settingsfamily"
+	"doc-management/internal/model"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	addressLength        = 70
+	voteThresholdSetting = "proposal.vote.threshold"
+)
+
+// ProposalAddresses returns the addresses of the transaction inserting the proposal
+func ProposalAddresses(proposal model.Proposal) []string {
+	return []string{
+		proposalfamily.GetProposalAddress(proposal),
+		proposalfamily.GetUserAddress(proposal.ModificationAuthor),
+		proposalfamily.GetDocAddress(proposal.Category, proposal.DocumentName),
+	}
+}
+
+// VoteAddresses returns the addresses of the voter's transaction signing the proposal
+func VoteAddresses(proposal model.Proposal, voter string) []string {
+	return []string{
+		proposalfamily.GetProposalAddress(proposal),
+		proposalfamily.GetUserAddress(voter),
+		proposalfamily.GetUserAddress(proposal.ModificationAuthor),
+		proposalfamily.GetDocAddress(proposal.Category, proposal.DocumentName),
+		settingsfamily.GetAddress(voteThresholdSetting),
+	}
+}
+
+// DecodeProposalTransaction checks that the client signed transaction belongs to the proposals family,
+// uses only its addresses and returns its payload checked against the schema
+func DecodeProposalTransaction(transaction SignedTransaction) (proposalfamily.Payload, error) {
+	if transaction.FamilyName == doctrackerfamily.FamilyName {
+		return proposalfamily.Payload{}, invalidBatch("the " + doctrackerfamily.FamilyName + " transactions are submitted only by the application")
+	}
+	if transaction.FamilyName != proposalfamily.FamilyName || transaction.FamilyVersion != proposalfamily.FamilyVersion {
+		return proposalfamily.Payload{}, invalidBatch("unsupported transaction family " + transaction.FamilyName + " " + transaction.FamilyVersion)
+	}
+
+	settingAddr := settingsfamily.GetAddress(voteThresholdSetting)
+	for _, address := range append(append([]string{}, transaction.Inputs...), transaction.Outputs...) {
+		if !isAddress(address) {
+			return proposalfamily.Payload{}, invalidBatch("invalid address " + address)
+		}
+		if !strings.HasPrefix(address, proposalfamily.GetFamilyPrefix()) && address != settingAddr {
+			return proposalfamily.Payload{}, invalidBatch("address " + address + " is outside of the " + proposalfamily.FamilyName + " family")
+		}
+	}
+
+	payload, err := proposalfamily.DecodePayload(transaction.Payload)
+	if err != nil {
+		return proposalfamily.Payload{}, invalidBatch("transaction " + transaction.ID + ": " + err.Error())
+	}
+
+	return payload, nil
+}
+
+// CheckAddresses checks that the transaction reads and writes exactly the expected addresses
+func CheckAddresses(transaction SignedTransaction, expected []string) error {
+	if !sameAddresses(transaction.Inputs, expected) || !sameAddresses(transaction.Outputs, expected) {
+		return invalidBatch("the addresses of transaction " + transaction.ID + " don't match its payload")
+	}
+
+	return nil
+}
+
+func isAddress(address string) bool {
+	if len(address) != addressLength || strings.ToLower(address) != address {
+		return false
+	}
+	_, err := hex.DecodeString(address)
+	return err == nil
+}
+
+func sameAddresses(addresses []string, expected []string) bool {
+	set := addressSet(addresses)
+	expectedSet := addressSet(expected)
+
+	if len(set) != len(expectedSet) {
+		return false
+	}
+	for address := range expectedSet {
+		if !set[address] {
+			return false
+		}
+	}
+	return true
+}
+
+func addressSet(addresses []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, address := range addresses {
+		set[address] = true
+	}
+	return set
+}
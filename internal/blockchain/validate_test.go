@@ -0,0 +1,124 @@
+package blockchain
+
+import (
+	"doc-management/internal/blockchain/doctrackerfamily"
+	"doc-management/internal/blockchain/proposalfamily"
+	"doc-management/internal/hashing"
+	"doc-management/internal/model"
+	"errors"
+	"testing"
+
+	"github.com/fxamacker/cbor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func testProposal() model.Proposal {
+	return model.Proposal{
+		ProposalID:         "proposal-1",
+		Category:           "policies",
+		DocumentName:       "doc",
+		ModificationAuthor: "user-1",
+		ContentHash:        hashing.CalculateSHA512("content"),
+		ProposedStatus:     model.DocStatusActive,
+	}
+}
+
+// unsignedTransaction returns the transaction of the template, the signatures are checked by ParseBatchList
+func unsignedTransaction(template TransactionTemplate) SignedTransaction {
+	return SignedTransaction{
+		ID:            "transaction-1",
+		FamilyName:    template.FamilyName,
+		FamilyVersion: template.FamilyVersion,
+		Inputs:        template.Addresses,
+		Outputs:       template.Addresses,
+		Payload:       template.Payload,
+	}
+}
+
+func encode(t *testing.T, payload map[string]interface{}) []byte {
+	data, err := cbor.Marshal(payload, cbor.CanonicalEncOptions())
+	require.NoError(t, err)
+	return data
+}
+
+func TestDecodeProposalTransaction(t *testing.T) {
+	hashing.Initialize(zap.NewNop())
+	proposal := testProposal()
+
+	template, err := ProposalTemplate(proposal)
+	require.NoError(t, err)
+	transaction := unsignedTransaction(template)
+
+	payload, err := DecodeProposalTransaction(transaction)
+	require.NoError(t, err)
+	assert.Equal(t, proposalfamily.ActionInsert, payload.Action)
+	assert.Equal(t, proposal, payload.Proposal())
+	assert.NoError(t, CheckAddresses(transaction, ProposalAddresses(payload.Proposal())))
+
+	// the payload written for another author
+	other := proposal
+	other.ModificationAuthor = "user-2"
+	err = CheckAddresses(transaction, ProposalAddresses(other))
+	assert.True(t, errors.Is(err, ErrInvalidBatch))
+}
+
+func TestDecodeVoteTransaction(t *testing.T) {
+	hashing.Initialize(zap.NewNop())
+	proposal := testProposal()
+
+	payload := encode(t, map[string]interface{}{"action": "vote", "proposalID": proposal.ProposalID, "voter": "user-2"})
+	transaction := unsignedTransaction(TransactionTemplate{
+		FamilyName:    proposalfamily.FamilyName,
+		FamilyVersion: proposalfamily.FamilyVersion,
+		Addresses:     VoteAddresses(proposal, "user-2"),
+		Payload:       payload,
+	})
+
+	decoded, err := DecodeProposalTransaction(transaction)
+	require.NoError(t, err)
+	assert.Equal(t, "user-2", decoded.Voter)
+	assert.NoError(t, CheckAddresses(transaction, VoteAddresses(proposal, decoded.Voter)))
+}
+
+func TestDecodeProposalTransactionRejected(t *testing.T) {
+	hashing.Initialize(zap.NewNop())
+	proposal := testProposal()
+
+	template, err := ProposalTemplate(proposal)
+	require.NoError(t, err)
+
+	cases := map[string]func(transaction *SignedTransaction){
+		"doctracker family": func(tx *SignedTransaction) { tx.FamilyName = doctrackerfamily.FamilyName },
+		"unknown family":    func(tx *SignedTransaction) { tx.FamilyName = "intkey" },
+		"other version":     func(tx *SignedTransaction) { tx.FamilyVersion = "2.0" },
+		"doctracker address": func(tx *SignedTransaction) {
+			tx.Outputs = append([]string{doctrackerfamily.GetDocAddress(proposal.Category, proposal.DocumentName) + "0001"}, tx.Outputs...)
+		},
+		"malformed address": func(tx *SignedTransaction) { tx.Inputs = append([]string{"8ed94c"}, tx.Inputs...) },
+		"unknown field": func(tx *SignedTransaction) {
+			tx.Payload = encode(t, map[string]interface{}{"action": "vote", "proposalID": "proposal-1", "voter": "user-2", "weight": 10})
+		},
+		"unknown action": func(tx *SignedTransaction) {
+			tx.Payload = encode(t, map[string]interface{}{"action": "accept", "proposalID": "proposal-1"})
+		},
+		"missing voter": func(tx *SignedTransaction) {
+			tx.Payload = encode(t, map[string]interface{}{"action": "vote", "proposalID": "proposal-1"})
+		},
+		"invalid content hash": func(tx *SignedTransaction) {
+			tx.Payload = encode(t, map[string]interface{}{"action": "insert", "proposalID": "proposal-1", "category": "policies",
+				"docName": "doc", "author": "user-1", "proposedStatus": "active", "contentHash": "abc"})
+		},
+		"not cbor": func(tx *SignedTransaction) { tx.Payload = []byte("{}") },
+	}
+
+	for name, modify := range cases {
+		t.Run(name, func(t *testing.T) {
+			transaction := unsignedTransaction(template)
+			modify(&transaction)
+			_, err := DecodeProposalTransaction(transaction)
+			assert.True(t, errors.Is(err, ErrInvalidBatch), "unexpected error: %v", err)
+		})
+	}
+}
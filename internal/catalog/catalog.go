@@ -0,0 +1,53 @@
+package catalog
+
+import (
+	"context"
+	"doc-management/internal/model"
+	"doc-management/internal/paging"
+	"time"
+)
+
+type Category struct {
+	Name          string
+	DocumentCount int
+	UpdatedAt     time.Time
+}
+
+// Document is the catalog entry of a document, describing its latest version
+type Document struct {
+	Category      string
+	DocumentName  string
+	LatestVersion int
+	Status        model.DocStatus
+	// author of the latest version
+	Author      string
+	FileName    string
+	ContentType string
+	UpdatedAt   time.Time
+}
+
+// Catalog lists the categories and the documents existing on the blockchain;
+// the blockchain addresses are hashes of the category and the document name, so they can't be browsed
+type Catalog interface {
+	// UpdateDocument updates the document entry if the given version is newer than the one in the catalog
+	UpdateDocument(ctx context.Context, doc model.Document) error
+	// UpdateDocumentStatus updates the document status if the given version is the latest one
+	UpdateDocumentStatus(ctx context.Context, category, docName string, version int, status model.DocStatus) error
+	// ListCategories returns the categories ordered by name, only the given ones if not nil
+	ListCategories(ctx context.Context, only []string, page paging.Page) (categories []Category, next string, total int64, err error)
+	// ListDocuments returns the documents of the category ordered by name
+	ListDocuments(ctx context.Context, category string, page paging.Page) (docs []Document, next string, total int64, err error)
+}
+
+func NewDocument(doc model.Document) Document {
+	return Document{
+		Category:      doc.Category,
+		DocumentName:  doc.DocumentName,
+		LatestVersion: doc.Version,
+		Status:        doc.Status,
+		Author:        doc.Author,
+		FileName:      doc.FileName,
+		ContentType:   doc.ContentType,
+		UpdatedAt:     time.Now().UTC(),
+	}
+}
@@ -17,3 +17,25 @@ func TestTimeout(t *testing.T) {
 	timeout = GetRequestTimeout()
 	assert.Equal(t, timeout, 14*time.Second)
 }
+
+func TestContentStore(t *testing.T) {
+	viper.Set("CONTENT_STORE", "")
+	assert.Equal(t, ContentStoreMongoDB, GetContentStore())
+
+	viper.Set("CONTENT_STORE", ContentStoreFilesystem)
+	assert.Equal(t, ContentStoreFilesystem, GetContentStore())
+}
+
+func TestOpenIDConfigURL(t *testing.T) {
+	viper.Set("AUTH_OPENID_CONFIG_URL", "")
+	viper.Set("MS_TOKEN_ISSUER", "https://tenant.b2clogin.com/1234/v2.0/")
+	viper.Set("AUTH_POLICY_NAME", "")
+	assert.Equal(t, "https://tenant.b2clogin.com/1234/v2.0/.well-known/openid-configuration?p=B2C_1_singin", GetOpenIDConfigURL())
+
+	viper.Set("AUTH_POLICY_NAME", "none")
+	assert.Equal(t, "", GetAuthPolicyName())
+	assert.Equal(t, "https://tenant.b2clogin.com/1234/v2.0/.well-known/openid-configuration", GetOpenIDConfigURL())
+
+	viper.Set("AUTH_OPENID_CONFIG_URL", "http://localhost/config")
+	assert.Equal(t, "http://localhost/config", GetOpenIDConfigURL())
+}
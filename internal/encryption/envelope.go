@@ -0,0 +1,226 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// Envelope encryption: the content is encrypted with AES-GCM using a random data key,
+// the data key is encrypted (wrapped) with the master key and stored together with the content.
+//
+// Envelope layout:
+//   magic | key ID length (1B) | key ID | wrapped key length (2B) | wrapped key | ciphertext
+// The wrapped key and the ciphertext are prefixed with their nonces.
+// The streamed envelopes have their own magic and the ciphertext split into chunks, see stream.go.
+
+var magic = []byte("DMENC1")
+
+// MagicSize is the length of the prefix telling the envelopes apart from the plain data
+const MagicSize = 6
+
+const dataKeySize = 32
+
+type envelope struct {
+	magic      []byte
+	keyID      string
+	wrappedKey []byte
+	ciphertext []byte
+}
+
+// IsEnvelope tells if the data was sealed, as a whole or streamed
+func IsEnvelope(data []byte) bool {
+	return bytes.HasPrefix(data, magic) || bytes.HasPrefix(data, streamMagic)
+}
+
+// Seal encrypts the plaintext with a new data key wrapped by the active master key;
+// additional data is authenticated, but not stored - it needs to be given again to open the envelope
+func Seal(keyring *Keyring, plaintext []byte, additionalData []byte) ([]byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, errors.New("failed to generate the data key: " + err.Error())
+	}
+
+	ciphertext, err := encrypt(dataKey, plaintext, additionalData)
+	if err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err := wrapKey(keyring, keyring.ActiveKeyID(), dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return envelope{
+		magic:      magic,
+		keyID:      keyring.ActiveKeyID(),
+		wrappedKey: wrappedKey,
+		ciphertext: ciphertext,
+	}.marshal(), nil
+}
+
+func Open(keyring *Keyring, sealed []byte, additionalData []byte) ([]byte, error) {
+	env, err := unmarshalEnvelope(sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(env.magic, streamMagic) {
+		return ioutil.ReadAll(OpenStream(keyring, bytes.NewReader(sealed), additionalData))
+	}
+
+	dataKey, err := unwrapKey(keyring, env.keyID, env.wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return decrypt(dataKey, env.ciphertext, additionalData)
+}
+
+// Rewrap wraps the data key of the envelope with the active master key, the encrypted content stays untouched.
+// Returns false if the envelope already uses the active key.
+func Rewrap(keyring *Keyring, sealed []byte) ([]byte, bool, error) {
+	env, err := unmarshalEnvelope(sealed)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if env.keyID == keyring.ActiveKeyID() {
+		return sealed, false, nil
+	}
+
+	dataKey, err := unwrapKey(keyring, env.keyID, env.wrappedKey)
+	if err != nil {
+		return nil, false, err
+	}
+
+	env.wrappedKey, err = wrapKey(keyring, keyring.ActiveKeyID(), dataKey)
+	if err != nil {
+		return nil, false, err
+	}
+	env.keyID = keyring.ActiveKeyID()
+
+	return env.marshal(), true, nil
+}
+
+func wrapKey(keyring *Keyring, keyID string, dataKey []byte) ([]byte, error) {
+	masterKey, err := keyring.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the key ID is authenticated, so that the wrapped key can't be assigned to another master key
+	return encrypt(masterKey, dataKey, []byte(keyID))
+}
+
+func unwrapKey(keyring *Keyring, keyID string, wrappedKey []byte) ([]byte, error) {
+	masterKey, err := keyring.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := decrypt(masterKey, wrappedKey, []byte(keyID))
+	if err != nil {
+		return nil, errors.New("failed to unwrap the data key: " + err.Error())
+	}
+	return dataKey, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt returns the ciphertext prefixed with the nonce
+func encrypt(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, errors.New("failed to initialize the cipher: " + err.Error())
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.New("failed to generate the nonce: " + err.Error())
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func decrypt(key []byte, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, errors.New("failed to initialize the cipher: " + err.Error())
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, errors.New("failed to decrypt: " + err.Error())
+	}
+	return plaintext, nil
+}
+
+func (e envelope) marshal() []byte {
+	var buf bytes.Buffer
+	buf.Write(e.magic)
+	buf.WriteByte(byte(len(e.keyID)))
+	buf.WriteString(e.keyID)
+	_ = binary.Write(&buf, binary.BigEndian, uint16(len(e.wrappedKey)))
+	buf.Write(e.wrappedKey)
+	buf.Write(e.ciphertext)
+	return buf.Bytes()
+}
+
+func unmarshalEnvelope(data []byte) (envelope, error) {
+	r := bytes.NewReader(data)
+	env, err := readEnvelopeHeader(r)
+	if err != nil {
+		return envelope{}, err
+	}
+
+	env.ciphertext = data[len(data)-r.Len():]
+	return env, nil
+}
+
+// readEnvelopeHeader reads the envelope up to the ciphertext
+func readEnvelopeHeader(r io.Reader) (envelope, error) {
+	errInvalid := errors.New("invalid envelope")
+
+	header := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return envelope{}, errInvalid
+	}
+	if !IsEnvelope(header) {
+		return envelope{}, errInvalid
+	}
+	env := envelope{magic: header[:len(magic)]}
+
+	keyID := make([]byte, int(header[len(magic)]))
+	var wrappedKeyLen uint16
+	if _, err := io.ReadFull(r, keyID); err != nil {
+		return envelope{}, errInvalid
+	}
+	if err := binary.Read(r, binary.BigEndian, &wrappedKeyLen); err != nil {
+		return envelope{}, errInvalid
+	}
+	env.keyID = string(keyID)
+
+	env.wrappedKey = make([]byte, wrappedKeyLen)
+	if _, err := io.ReadFull(r, env.wrappedKey); err != nil {
+		return envelope{}, errInvalid
+	}
+
+	return env, nil
+}
@@ -0,0 +1,164 @@
+package encryption_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"doc-management/internal/encryption"
+	"encoding/base64"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newKey(t *testing.T) string {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func TestSealOpen(t *testing.T) {
+	keyring := encryption.NewKeyring()
+	require.NoError(t, keyring.AddKey("first", newKey(t)))
+
+	plaintext := []byte("mala agatka")
+	sealed, err := encryption.Seal(keyring, plaintext, []byte("hash"))
+	require.NoError(t, err)
+	assert.True(t, encryption.IsEnvelope(sealed))
+	assert.NotContains(t, string(sealed), string(plaintext))
+
+	opened, err := encryption.Open(keyring, sealed, []byte("hash"))
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, opened)
+
+	// sealed for another content hash
+	_, err = encryption.Open(keyring, sealed, []byte("other hash"))
+	assert.Error(t, err)
+
+	sealed[len(sealed)-1] ^= 0xff
+	_, err = encryption.Open(keyring, sealed, []byte("hash"))
+	assert.Error(t, err)
+}
+
+func sealStream(t *testing.T, keyring *encryption.Keyring, plaintext []byte, additionalData []byte) []byte {
+	reader, size, err := encryption.SealStream(keyring, bytes.NewReader(plaintext), int64(len(plaintext)), additionalData)
+	require.NoError(t, err)
+	sealed, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, size, int64(len(sealed)))
+	return sealed
+}
+
+func TestSealOpenStream(t *testing.T) {
+	keyring := encryption.NewKeyring()
+	require.NoError(t, keyring.AddKey("first", newKey(t)))
+
+	const chunkSize = 64 << 10
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, 3*chunkSize + 100} {
+		plaintext := make([]byte, size)
+		_, err := rand.Read(plaintext)
+		require.NoError(t, err)
+
+		sealed := sealStream(t, keyring, plaintext, []byte("hash"))
+		assert.True(t, encryption.IsEnvelope(sealed))
+
+		opened, err := ioutil.ReadAll(encryption.OpenStream(keyring, bytes.NewReader(sealed), []byte("hash")))
+		require.NoError(t, err)
+		assert.Equal(t, plaintext, opened)
+
+		opened, err = encryption.Open(keyring, sealed, []byte("hash"))
+		require.NoError(t, err)
+		assert.Equal(t, plaintext, opened)
+
+		_, err = ioutil.ReadAll(encryption.OpenStream(keyring, bytes.NewReader(sealed), []byte("other hash")))
+		assert.Error(t, err)
+	}
+}
+
+func TestOpenStreamTampered(t *testing.T) {
+	keyring := encryption.NewKeyring()
+	require.NoError(t, keyring.AddKey("first", newKey(t)))
+
+	const chunkSize = 64 << 10
+	plaintext := make([]byte, 2*chunkSize)
+	sealed := sealStream(t, keyring, plaintext, nil)
+	// the last, empty chunk
+	tagSize := 16
+
+	for name, tampered := range map[string][]byte{
+		"last chunk dropped":  sealed[:len(sealed)-tagSize],
+		"chunk cut":           sealed[:len(sealed)-tagSize-100],
+		"chunk appended":      append(append([]byte{}, sealed...), sealed[len(sealed)-tagSize:]...),
+		"ciphertext modified": append(append([]byte{}, sealed[:len(sealed)-1]...), sealed[len(sealed)-1]^0xff),
+	} {
+		_, err := ioutil.ReadAll(encryption.OpenStream(keyring, bytes.NewReader(tampered), nil))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestOpenStreamSealed(t *testing.T) {
+	keyring := encryption.NewKeyring()
+	require.NoError(t, keyring.AddKey("first", newKey(t)))
+
+	plaintext := []byte("mala agatka")
+	sealed, err := encryption.Seal(keyring, plaintext, []byte("hash"))
+	require.NoError(t, err)
+
+	opened, err := ioutil.ReadAll(encryption.OpenStream(keyring, bytes.NewReader(sealed), []byte("hash")))
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, opened)
+}
+
+func TestRewrap(t *testing.T) {
+	firstKey, secondKey := newKey(t), newKey(t)
+	keyring := encryption.NewKeyring()
+	require.NoError(t, keyring.AddKey("first", firstKey))
+
+	plaintext := []byte("mala agatka")
+	sealed, err := encryption.Seal(keyring, plaintext, nil)
+	require.NoError(t, err)
+
+	_, rewrapped, err := encryption.Rewrap(keyring, sealed)
+	require.NoError(t, err)
+	assert.False(t, rewrapped)
+
+	require.NoError(t, keyring.AddKey("second", secondKey))
+	assert.Equal(t, "second", keyring.ActiveKeyID())
+
+	resealed, rewrapped, err := encryption.Rewrap(keyring, sealed)
+	require.NoError(t, err)
+	assert.True(t, rewrapped)
+
+	// after rewrapping the old master key is no longer needed
+	onlySecond := encryption.NewKeyring()
+	require.NoError(t, onlySecond.AddKey("second", secondKey))
+
+	opened, err := encryption.Open(onlySecond, resealed, nil)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, opened)
+
+	_, err = encryption.Open(onlySecond, sealed, nil)
+	assert.Error(t, err)
+
+	// the header of the streamed envelope is rewrapped the same way
+	streamed := sealStream(t, keyring, plaintext, nil)
+	require.NoError(t, keyring.AddKey("third", newKey(t)))
+	resealed, rewrapped, err = encryption.Rewrap(keyring, streamed)
+	require.NoError(t, err)
+	assert.True(t, rewrapped)
+
+	opened, err = encryption.Open(keyring, resealed, nil)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, opened)
+}
+
+func TestParseKeys(t *testing.T) {
+	keyring := encryption.NewKeyring()
+	require.NoError(t, keyring.ParseKeys("\n# comment\nold:"+newKey(t)+"\nnew: "+newKey(t)+"\n"))
+	assert.Equal(t, "new", keyring.ActiveKeyID())
+
+	assert.Error(t, keyring.ParseKeys("missing-separator"))
+	assert.Error(t, keyring.ParseKeys("short:"+base64.StdEncoding.EncodeToString([]byte("abc"))))
+}
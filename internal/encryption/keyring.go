@@ -0,0 +1,89 @@
+package encryption
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const masterKeySize = 32
+
+// Keyring holds the master keys used to wrap the data keys. New data keys are wrapped
+// with the active key, the other keys are kept to unwrap the data keys wrapped before the rotation.
+type Keyring struct {
+	activeKeyID string
+	keys        map[string][]byte
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string][]byte)}
+}
+
+// AddKey adds a base64 encoded AES-256 master key, the last added key becomes the active one
+func (k *Keyring) AddKey(keyID string, base64Key string) error {
+	if keyID == "" || len(keyID) > 255 {
+		return errors.New("invalid master key ID: " + keyID)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(base64Key)
+	if err != nil {
+		return errors.New("failed to decode the master key " + keyID + ": " + err.Error())
+	}
+	if len(key) != masterKeySize {
+		return errors.New(fmt.Sprint("master key ", keyID, " has invalid length: ", len(key), ", expected: ", masterKeySize))
+	}
+
+	k.keys[keyID] = key
+	k.activeKeyID = keyID
+	return nil
+}
+
+// ParseKeys reads the keys in the format `keyID:base64Key`, separated by new lines;
+// empty lines and lines starting with # are skipped
+func (k *Keyring) ParseKeys(keys string) error {
+	scanner := bufio.NewScanner(strings.NewReader(keys))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return errors.New("invalid master key entry, expected keyID:base64Key")
+		}
+		if err := k.AddKey(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (k *Keyring) ReadKeyFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return errors.New("failed to read the key file: " + err.Error())
+	}
+
+	return k.ParseKeys(string(content))
+}
+
+func (k *Keyring) Empty() bool {
+	return len(k.keys) == 0
+}
+
+func (k *Keyring) ActiveKeyID() string {
+	return k.activeKeyID
+}
+
+func (k *Keyring) key(keyID string) ([]byte, error) {
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, errors.New("master key not found: " + keyID)
+	}
+	return key, nil
+}
@@ -0,0 +1,214 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// Streamed envelopes keep the content of any size without holding it in memory.
+// The header is the same as of the sealed envelope, the ciphertext is a sequence of chunks:
+//   sealed chunk | sealed chunk | ... | sealed last chunk
+// Each chunk holds streamChunkSize bytes of the plaintext, only the last one is shorter (possibly empty).
+// The nonce of a chunk is its sequence number with the last chunk flag, the data key is used
+// for a single envelope only. The chunks can't be reordered, dropped or appended.
+
+var streamMagic = []byte("DMENC2")
+
+const streamChunkSize = 64 << 10
+
+// SealStream encrypts the plaintext of the given size read from the reader with a new data key
+// wrapped by the active master key; the plaintext is read and encrypted while the returned reader is read.
+// Returns the size of the sealed content, additional data is authenticated with each chunk.
+func SealStream(keyring *Keyring, plaintext io.Reader, size int64, additionalData []byte) (io.Reader, int64, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, 0, errors.New("failed to generate the data key: " + err.Error())
+	}
+
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, 0, errors.New("failed to initialize the cipher: " + err.Error())
+	}
+
+	wrappedKey, err := wrapKey(keyring, keyring.ActiveKeyID(), dataKey)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	header := envelope{
+		magic:      streamMagic,
+		keyID:      keyring.ActiveKeyID(),
+		wrappedKey: wrappedKey,
+	}.marshal()
+
+	chunks := size/streamChunkSize + 1
+	sealedSize := int64(len(header)) + size + chunks*int64(gcm.Overhead())
+
+	return &sealReader{
+		chunkStream: newChunkStream(gcm, additionalData),
+		plaintext:   plaintext,
+		chunk:       make([]byte, streamChunkSize),
+		pending:     header,
+	}, sealedSize, nil
+}
+
+// OpenStream decrypts the envelope read from the reader, sealed as a whole or streamed;
+// the streamed envelope is decrypted chunk by chunk while the returned reader is read
+func OpenStream(keyring *Keyring, sealed io.Reader, additionalData []byte) io.Reader {
+	return &openReader{keyring: keyring, sealed: sealed, additionalData: additionalData}
+}
+
+type chunkStream struct {
+	gcm            cipher.AEAD
+	additionalData []byte
+	nonce          []byte
+	counter        uint64
+}
+
+func newChunkStream(gcm cipher.AEAD, additionalData []byte) chunkStream {
+	return chunkStream{gcm: gcm, additionalData: additionalData, nonce: make([]byte, gcm.NonceSize())}
+}
+
+// nextNonce returns the nonce of the next chunk: zeros | sequence number (8B) | last chunk flag (1B)
+func (s *chunkStream) nextNonce(last bool) []byte {
+	binary.BigEndian.PutUint64(s.nonce[len(s.nonce)-9:], s.counter)
+	s.nonce[len(s.nonce)-1] = 0
+	if last {
+		s.nonce[len(s.nonce)-1] = 1
+	}
+	s.counter++
+	return s.nonce
+}
+
+type sealReader struct {
+	chunkStream
+	plaintext io.Reader
+	chunk     []byte
+	// sealed, not read yet
+	pending []byte
+	sealed  []byte
+	done    bool
+}
+
+func (r *sealReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.sealChunk(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *sealReader) sealChunk() error {
+	n, err := io.ReadFull(r.plaintext, r.chunk)
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		r.done = true
+	default:
+		return err
+	}
+
+	r.sealed = r.gcm.Seal(r.sealed[:0], r.nextNonce(r.done), r.chunk[:n], r.additionalData)
+	r.pending = r.sealed
+	return nil
+}
+
+type openReader struct {
+	keyring        *Keyring
+	sealed         io.Reader
+	additionalData []byte
+	// nil until the header is read
+	stream *chunkStream
+	chunk  []byte
+	// decrypted, not read yet
+	pending   []byte
+	plaintext []byte
+	done      bool
+	err       error
+}
+
+func (r *openReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		if r.err = r.openNext(); r.err != nil {
+			return 0, r.err
+		}
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *openReader) openNext() error {
+	if r.stream == nil {
+		return r.openHeader()
+	}
+
+	n, err := io.ReadFull(r.sealed, r.chunk)
+	switch err {
+	case nil:
+	case io.ErrUnexpectedEOF:
+		r.done = true
+	case io.EOF:
+		return errors.New("failed to decrypt: the content is truncated")
+	default:
+		return err
+	}
+
+	r.plaintext, err = r.stream.gcm.Open(r.plaintext[:0], r.stream.nextNonce(r.done), r.chunk[:n], r.stream.additionalData)
+	if err != nil {
+		return errors.New("failed to decrypt: " + err.Error())
+	}
+	r.pending = r.plaintext
+	return nil
+}
+
+func (r *openReader) openHeader() error {
+	env, err := readEnvelopeHeader(r.sealed)
+	if err != nil {
+		return err
+	}
+
+	// sealed as a whole
+	if !bytes.Equal(env.magic, streamMagic) {
+		env.ciphertext, err = ioutil.ReadAll(r.sealed)
+		if err != nil {
+			return err
+		}
+		r.pending, err = Open(r.keyring, env.marshal(), r.additionalData)
+		r.done = true
+		return err
+	}
+
+	dataKey, err := unwrapKey(r.keyring, env.keyID, env.wrappedKey)
+	if err != nil {
+		return err
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return errors.New("failed to initialize the cipher: " + err.Error())
+	}
+
+	stream := newChunkStream(gcm, r.additionalData)
+	r.stream = &stream
+	r.chunk = make([]byte, streamChunkSize+gcm.Overhead())
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/hex"
+	"io"
 
 	"hash"
 
@@ -37,6 +38,17 @@ func CalculateSHA512(data string) string {
 	return hex.EncodeToString(h)
 }
 
+// CalculateSHA512From hashes the data read from the reader, returns also the number of bytes read
+func CalculateSHA512From(data io.Reader) (string, int64, error) {
+	h := sha512.New()
+	size, err := io.Copy(h, data)
+	if err != nil {
+		return "", size, err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
 func CalculateSHA256(data string) string {
 	hashInstance.sha256.Reset()
 	_, _ = hashInstance.sha256.Write([]byte(data))
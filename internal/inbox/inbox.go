@@ -0,0 +1,258 @@
+package inbox
+
+import (
+	"context"
+	"doc-management/internal/retry"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// the max number of events processed at once
+	processLimit   = 100
+	processTimeout = 30 * time.Second
+)
+
+var (
+	ErrNotFound = errors.New("event not found")
+	ErrNotDead  = errors.New("only the dead-lettered events can be replayed")
+)
+
+type Status string
+
+const (
+	// to be processed, retried with a backoff
+	StatusPending   Status = "pending"
+	StatusProcessed Status = "processed"
+	// given up after the max number of attempts, processed again only when replayed
+	StatusDead Status = "dead"
+)
+
+func (s Status) IsValid() bool {
+	return s == StatusPending || s == StatusProcessed || s == StatusDead
+}
+
+// Event is a blockchain event recorded before it's processed, so it's not lost if the processing fails
+type Event struct {
+	// derived from the type and the key, the same event is recorded once
+	ID   string
+	Type string
+	// identifies the subject of the event, e.g. the proposal ID of "proposal_accepted"
+	Key  string
+	Data []byte
+	// the block the event was emitted in
+	BlockID  string
+	BlockNum uint64
+
+	Status   Status
+	Attempts int
+	// when a pending event is processed next time
+	NextAttemptAt time.Time
+	LastError     string
+	ReceivedAt    time.Time
+	UpdatedAt     time.Time
+}
+
+func EventID(eventType, key string) string {
+	return eventType + ":" + key
+}
+
+// Store keeps the recorded events
+type Store interface {
+	// InsertEvent records the event, returns false if an event with the same ID is already recorded
+	InsertEvent(ctx context.Context, event Event) (bool, error)
+	// GetEvent returns ErrNotFound if there is no such event
+	GetEvent(ctx context.Context, id string) (Event, error)
+	UpdateEvent(ctx context.Context, event Event) error
+	// DueEvents returns the pending events to be processed until the given time, the oldest first
+	DueEvents(ctx context.Context, until time.Time, limit int) ([]Event, error)
+	// ListEvents returns the events in the given status, the newest first
+	ListEvents(ctx context.Context, status Status, limit int) ([]Event, error)
+}
+
+// Handler processes the event, it's called again if it fails
+type Handler func(ctx context.Context, event Event) error
+
+// Inbox records the received events and processes each of them once, the failed ones are retried with a backoff
+// and dead-lettered after the max number of attempts
+type Inbox struct {
+	logger       *zap.Logger
+	store        Store
+	handlers     map[string]Handler
+	retry        retry.Policy
+	pollInterval time.Duration
+
+	// a single event processed at a time
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewInbox(logger *zap.Logger, store Store, policy retry.Policy, pollInterval time.Duration) *Inbox {
+	return &Inbox{
+		logger:       logger,
+		store:        store,
+		handlers:     make(map[string]Handler),
+		retry:        policy,
+		pollInterval: pollInterval,
+	}
+}
+
+// SetHandler sets the handler of the events of the type; needs to be set before the events are recorded
+func (i *Inbox) SetHandler(eventType string, handler Handler) {
+	i.handlers[eventType] = handler
+}
+
+// Record records the event and processes it right away; the event already recorded is neither recorded
+// nor processed again, it's returned as it is
+func (i *Inbox) Record(ctx context.Context, event Event) (Event, error) {
+	if event.Type == "" || event.Key == "" {
+		return Event{}, errors.New("the event type or key is missing")
+	}
+
+	now := time.Now().UTC()
+	event.ID = EventID(event.Type, event.Key)
+	event.Status = StatusPending
+	event.Attempts = 0
+	event.NextAttemptAt = now
+	event.ReceivedAt = now
+	event.UpdatedAt = now
+
+	inserted, err := i.store.InsertEvent(ctx, event)
+	if err != nil {
+		return Event{}, errors.New("failed to record the event: " + err.Error())
+	}
+	if !inserted {
+		i.logger.Debug("event already recorded, skipping", zap.String("eventID", event.ID), zap.String("blockID", event.BlockID))
+		return i.store.GetEvent(ctx, event.ID)
+	}
+
+	return i.process(ctx, event.ID)
+}
+
+// Replay processes the dead-lettered event again, with the attempts reset
+func (i *Inbox) Replay(ctx context.Context, id string) (Event, error) {
+	event, err := i.store.GetEvent(ctx, id)
+	if err != nil {
+		return Event{}, err
+	}
+	if event.Status != StatusDead {
+		return Event{}, ErrNotDead
+	}
+
+	event.Status = StatusPending
+	event.Attempts = 0
+	event.NextAttemptAt = time.Now().UTC()
+	event.UpdatedAt = event.NextAttemptAt
+	if err := i.store.UpdateEvent(ctx, event); err != nil {
+		return Event{}, err
+	}
+	i.logger.Info("replaying the dead-lettered event", zap.String("eventID", event.ID))
+
+	return i.process(ctx, event.ID)
+}
+
+// List returns the events in the given status, the newest first
+func (i *Inbox) List(ctx context.Context, status Status, limit int) ([]Event, error) {
+	return i.store.ListEvents(ctx, status, limit)
+}
+
+// Start runs the processing of the due events until Stop is called
+func (i *Inbox) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	i.cancel = cancel
+	i.done = make(chan struct{})
+
+	go func() {
+		defer close(i.done)
+
+		ticker := time.NewTicker(i.pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			processCtx, cancelProcess := context.WithTimeout(ctx, processTimeout)
+			if err := i.Process(processCtx); err != nil && ctx.Err() == nil {
+				i.logger.Warn("failed to process the inbox: " + err.Error())
+			}
+			cancelProcess()
+		}
+	}()
+}
+
+func (i *Inbox) Stop() {
+	if i.cancel == nil {
+		return
+	}
+	i.cancel()
+	<-i.done
+}
+
+// Process processes the due pending events, once
+func (i *Inbox) Process(ctx context.Context) error {
+	due, err := i.store.DueEvents(ctx, time.Now().UTC(), processLimit)
+	if err != nil {
+		return err
+	}
+
+	for _, event := range due {
+		if _, err := i.process(ctx, event.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// process calls the handler of the pending event and records the result; the error is returned only
+// if the event can't be read or updated
+func (i *Inbox) process(ctx context.Context, id string) (Event, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	// read again, it may have been processed meanwhile
+	event, err := i.store.GetEvent(ctx, id)
+	if err != nil {
+		return Event{}, err
+	}
+	if event.Status != StatusPending {
+		return event, nil
+	}
+
+	event.Attempts++
+	err = errors.New("handler missing for the event: " + event.Type)
+	if handler, ok := i.handlers[event.Type]; ok {
+		err = handler(ctx, event)
+	}
+	event.UpdatedAt = time.Now().UTC()
+
+	fields := []zap.Field{zap.String("eventID", event.ID), zap.String("blockID", event.BlockID)}
+	switch {
+	case err == nil:
+		event.Status = StatusProcessed
+		event.LastError = ""
+
+	case event.Attempts >= i.retry.MaxAttempts:
+		event.Status = StatusDead
+		event.LastError = err.Error()
+		i.logger.Error(fmt.Sprint("event dead-lettered after ", event.Attempts, " attempts: ", err.Error()), fields...)
+
+	default:
+		event.LastError = err.Error()
+		event.NextAttemptAt = event.UpdatedAt.Add(i.retry.Backoff(event.Attempts))
+		i.logger.Warn(fmt.Sprint("event processing failed, attempt ", event.Attempts, ", retrying at ", event.NextAttemptAt.Format(time.RFC3339), ": ", err.Error()), fields...)
+	}
+
+	if err := i.store.UpdateEvent(ctx, event); err != nil {
+		return event, err
+	}
+	return event, nil
+}
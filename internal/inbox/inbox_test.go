@@ -0,0 +1,179 @@
+package inbox
+
+import (
+	"context"
+	"doc-management/internal/retry"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type memoryStore struct {
+	mu     sync.Mutex
+	events map[string]Event
+}
+
+func (s *memoryStore) InsertEvent(ctx context.Context, event Event) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.events[event.ID]; exists {
+		return false, nil
+	}
+	s.events[event.ID] = event
+	return true, nil
+}
+
+func (s *memoryStore) GetEvent(ctx context.Context, id string) (Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	event, ok := s.events[id]
+	if !ok {
+		return Event{}, ErrNotFound
+	}
+	return event, nil
+}
+
+func (s *memoryStore) UpdateEvent(ctx context.Context, event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events[event.ID] = event
+	return nil
+}
+
+func (s *memoryStore) DueEvents(ctx context.Context, until time.Time, limit int) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []Event
+	for _, event := range s.events {
+		if event.Status == StatusPending && !event.NextAttemptAt.After(until) {
+			due = append(due, event)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].ReceivedAt.Before(due[j].ReceivedAt) })
+	return due, nil
+}
+
+func (s *memoryStore) ListEvents(ctx context.Context, status Status, limit int) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []Event
+	for _, event := range s.events {
+		if event.Status == status {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// due makes the pending event due now
+func (s *memoryStore) due(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	event := s.events[id]
+	event.NextAttemptAt = time.Now().Add(-time.Second)
+	s.events[id] = event
+}
+
+// failingHandler fails the given number of times, then succeeds
+type failingHandler struct {
+	failures int
+	calls    int
+}
+
+func (h *failingHandler) handle(ctx context.Context, event Event) error {
+	h.calls++
+	if h.calls <= h.failures {
+		return errors.New("db unavailable")
+	}
+	return nil
+}
+
+func newTestInbox(handler *failingHandler) (*Inbox, *memoryStore) {
+	store := &memoryStore{events: map[string]Event{}}
+	policy := retry.Policy{InitialBackoff: time.Minute, MaxBackoff: time.Hour, MaxAttempts: 3}
+	inbox := NewInbox(zap.NewNop(), store, policy, time.Second)
+	inbox.SetHandler("proposal_accepted", handler.handle)
+	return inbox, store
+}
+
+func TestRecordOnce(t *testing.T) {
+	handler := &failingHandler{}
+	inbox, _ := newTestInbox(handler)
+	ctx := context.Background()
+
+	event, err := inbox.Record(ctx, Event{Type: "proposal_accepted", Key: "proposal-1", BlockID: "block-1"})
+	require.NoError(t, err)
+	assert.Equal(t, StatusProcessed, event.Status)
+	assert.Equal(t, "proposal_accepted:proposal-1", event.ID)
+	assert.Equal(t, 1, event.Attempts)
+
+	// e.g. the events replayed after a restart
+	event, err = inbox.Record(ctx, Event{Type: "proposal_accepted", Key: "proposal-1", BlockID: "block-1"})
+	require.NoError(t, err)
+	assert.Equal(t, StatusProcessed, event.Status)
+	assert.Equal(t, 1, handler.calls)
+}
+
+func TestRecordRetried(t *testing.T) {
+	handler := &failingHandler{failures: 1}
+	inbox, store := newTestInbox(handler)
+	ctx := context.Background()
+
+	event, err := inbox.Record(ctx, Event{Type: "proposal_accepted", Key: "proposal-1"})
+	require.NoError(t, err)
+	assert.Equal(t, StatusPending, event.Status)
+	assert.Equal(t, "db unavailable", event.LastError)
+	assert.True(t, event.NextAttemptAt.After(time.Now()))
+
+	// not due yet
+	require.NoError(t, inbox.Process(ctx))
+	assert.Equal(t, 1, handler.calls)
+
+	store.due(event.ID)
+	require.NoError(t, inbox.Process(ctx))
+
+	event, err = store.GetEvent(ctx, event.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusProcessed, event.Status)
+	assert.Equal(t, 2, event.Attempts)
+	assert.Empty(t, event.LastError)
+}
+
+func TestDeadLetterReplayed(t *testing.T) {
+	handler := &failingHandler{failures: 3}
+	inbox, store := newTestInbox(handler)
+	ctx := context.Background()
+
+	event, err := inbox.Record(ctx, Event{Type: "proposal_accepted", Key: "proposal-1"})
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		store.due(event.ID)
+		require.NoError(t, inbox.Process(ctx))
+	}
+
+	dead, err := inbox.List(ctx, StatusDead, 0)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, 3, dead[0].Attempts)
+
+	// dead-lettered, not processed anymore
+	store.due(event.ID)
+	require.NoError(t, inbox.Process(ctx))
+	assert.Equal(t, 3, handler.calls)
+
+	event, err = inbox.Replay(ctx, event.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusProcessed, event.Status)
+	assert.Equal(t, 1, event.Attempts)
+
+	_, err = inbox.Replay(ctx, event.ID)
+	assert.Equal(t, ErrNotDead, err)
+	_, err = inbox.Replay(ctx, "proposal_accepted:unknown")
+	assert.Equal(t, ErrNotFound, err)
+}
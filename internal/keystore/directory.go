@@ -0,0 +1,78 @@
+package keystore
+
+import (
+	"context"
+	"doc-management/internal/audit"
+	"doc-management/internal/rbac"
+	"doc-management/internal/signkeys"
+	"doc-management/internal/usermanager"
+
+	"github.com/hyperledger/sawtooth-sdk-go/signing"
+)
+
+// DirectorySigners use the keys stored by the identity provider; kept for the deployments
+// not migrated to the keystore yet. A transaction made on behalf of another user is signed with that user's key.
+type DirectorySigners struct {
+	users usermanager.UserManager
+	audit audit.Log
+}
+
+func NewDirectorySigners(users usermanager.UserManager, auditLog audit.Log) DirectorySigners {
+	return DirectorySigners{users: users, audit: auditLog}
+}
+
+func (d DirectorySigners) Signer(ctx context.Context, subject rbac.Subject, onBehalfOf string, op Operation) (*signing.Signer, error) {
+	owner := subject.UserID
+	if onBehalfOf != "" {
+		owner = onBehalfOf
+	}
+	if owner == "" {
+		return nil, rbac.ErrForbidden
+	}
+
+	user, err := d.users.GetUserByID(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	signer := user.Keys.GetSigner()
+	if err := recordOperation(ctx, d.audit, subject, onBehalfOf, op, signer.GetPublicKey().AsHex()); err != nil {
+		return nil, err
+	}
+
+	return signer, nil
+}
+
+func (d DirectorySigners) PublicKey(ctx context.Context, userID string) (string, error) {
+	user, err := d.users.GetUserByID(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+
+	return user.Keys.GetSigner().GetPublicKey().AsHex(), nil
+}
+
+func (d DirectorySigners) Rotate(ctx context.Context, subject rbac.Subject, userID, reason string) (KeyInfo, error) {
+	return KeyInfo{}, ErrNotSupported
+}
+
+func (d DirectorySigners) Revoke(ctx context.Context, subject rbac.Subject, userID, publicKey, reason string) (KeyInfo, error) {
+	return KeyInfo{}, ErrNotSupported
+}
+
+func (d DirectorySigners) Keys(ctx context.Context, userID string) ([]KeyInfo, error) {
+	publicKey, err := d.PublicKey(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return []KeyInfo{{UserID: userID, PublicKey: publicKey, Status: KeyStatusActive}}, nil
+}
+
+func (d DirectorySigners) Revoked(ctx context.Context, publicKey string) (bool, error) {
+	return false, nil
+}
+
+func (d DirectorySigners) AuditKeys(ctx context.Context, repair bool) ([]signkeys.KeyCheck, error) {
+	return d.users.AuditKeys(ctx, repair)
+}
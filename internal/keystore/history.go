@@ -0,0 +1,40 @@
+package keystore
+
+import (
+	"context"
+	"time"
+)
+
+type KeyStatus string
+
+const (
+	KeyStatusActive KeyStatus = "active"
+	// replaced by a newer key, the signatures made before remain valid
+	KeyStatusRetired KeyStatus = "retired"
+	// compromised, the key can't be used anymore
+	KeyStatusRevoked KeyStatus = "revoked"
+)
+
+// KeyInfo describes a public key of a user and its validity, so the signatures can be verified later
+type KeyInfo struct {
+	UserID    string
+	PublicKey string
+	Status    KeyStatus
+	CreatedAt time.Time
+	// set once the key is retired or revoked
+	RetiredAt time.Time
+	RetiredBy string
+	// the key which replaced this one
+	ReplacedBy string
+	Reason     string
+}
+
+// History keeps the keys no longer in use, they are never removed
+type History interface {
+	// PutRetiredKey stores the key info, replacing the previous info of the same public key
+	PutRetiredKey(ctx context.Context, info KeyInfo) error
+	// GetRetiredKey returns ErrNotFound if the key is not retired
+	GetRetiredKey(ctx context.Context, publicKey string) (KeyInfo, error)
+	// GetRetiredKeys returns the retired keys of the user, the oldest first
+	GetRetiredKeys(ctx context.Context, userID string) ([]KeyInfo, error)
+}
@@ -0,0 +1,112 @@
+package keystore
+
+import (
+	"context"
+	"doc-management/internal/encryption"
+	"doc-management/internal/signkeys"
+	"encoding/hex"
+	"errors"
+
+	"go.uber.org/zap"
+)
+
+// KeySource is the source of the key checks of the keystore records
+const KeySource = "keystore"
+
+// AuditKeys verifies that the stored public keys match the sealed private keys, the mismatched ones are replaced
+// by the derived keys if repair is set; the legacy key records are verified as well, the private keys left
+// in the directory after they were moved to the keystore are cleared if repair is set
+func (k Keystore) AuditKeys(ctx context.Context, repair bool) ([]signkeys.KeyCheck, error) {
+	records, err := k.store.ListKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	checks := make([]signkeys.KeyCheck, 0, len(records))
+	for _, record := range records {
+		checks = append(checks, k.checkRecord(ctx, record, repair))
+	}
+
+	if k.legacy != nil {
+		legacy, err := k.legacy.AuditKeys(ctx, repair)
+		if err != nil {
+			return nil, errors.New("failed to audit the legacy keys: " + err.Error())
+		}
+		checks = append(checks, k.checkLegacy(ctx, legacy, records, repair)...)
+	}
+
+	return checks, nil
+}
+
+// checkLegacy reports the legacy records of the users having a key in the keystore which still hold
+// a private key, and clears them if repair is set; the other records of these users are left out
+func (k Keystore) checkLegacy(ctx context.Context, legacy []signkeys.KeyCheck, records []Record, repair bool) []signkeys.KeyCheck {
+	inKeystore := make(map[string]bool, len(records))
+	for _, record := range records {
+		inKeystore[record.UserID] = true
+	}
+
+	checks := make([]signkeys.KeyCheck, 0, len(legacy))
+	for _, check := range legacy {
+		if !inKeystore[check.UserID] {
+			checks = append(checks, check)
+			continue
+		}
+		if check.Status == signkeys.CheckMissing {
+			continue
+		}
+
+		check.Status = signkeys.CheckImported
+		if repair {
+			if err := k.legacy.ClearStoredKeys(ctx, check.UserID); err != nil {
+				check.Error = err.Error()
+			} else {
+				k.logger.Info("cleared the legacy private key", zap.String("userID", check.UserID))
+				check.Status = signkeys.CheckRepaired
+			}
+		}
+		checks = append(checks, check)
+	}
+
+	return checks
+}
+
+func (k Keystore) checkRecord(ctx context.Context, record Record, repair bool) signkeys.KeyCheck {
+	privateKey, err := encryption.Open(k.keyring, record.PrivateKey, []byte(record.UserID))
+	if err != nil {
+		return signkeys.KeyCheck{
+			UserID:          record.UserID,
+			Source:          KeySource,
+			Status:          signkeys.CheckInvalid,
+			StoredPublicKey: record.PublicKey,
+			Error:           "failed to decrypt the signing key: " + err.Error(),
+		}
+	}
+
+	keys, check := signkeys.Check(record.UserID, KeySource, hex.EncodeToString(privateKey), record.PublicKey)
+	if !keys.Valid() {
+		return check
+	}
+
+	// the key as it appears in the transaction headers
+	check.PublicKey = keys.GetSigner().GetPublicKey().AsHex()
+	if check.Status != signkeys.CheckMismatch {
+		return check
+	}
+
+	k.logger.Warn("the stored public key doesn't match the signing key", zap.String("userID", record.UserID))
+	if !repair {
+		return check
+	}
+
+	repaired := record
+	repaired.PublicKey = check.PublicKey
+	if err := k.store.ReplaceKey(ctx, record.PublicKey, repaired); err != nil {
+		check.Error = err.Error()
+		return check
+	}
+
+	k.logger.Info("repaired the stored public key", zap.String("userID", record.UserID))
+	check.Status = signkeys.CheckRepaired
+	return check
+}
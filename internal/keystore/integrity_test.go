@@ -0,0 +1,66 @@
+package keystore_test
+
+import (
+	"context"
+	"doc-management/internal/rbac"
+	"doc-management/internal/signkeys"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditRepairsMismatchedKey(t *testing.T) {
+	keys, store, _ := newKeystore(t, nil)
+	ctx := context.Background()
+
+	publicKey, err := keys.PublicKey(ctx, "user-1")
+	require.NoError(t, err)
+
+	other, err := signkeys.GenerateKeys()
+	require.NoError(t, err)
+	record := store.records["user-1"]
+	record.PublicKey = other.GetSigner().GetPublicKey().AsHex()
+	store.records["user-1"] = record
+
+	// the signer still uses the key derived from the private key
+	signer, err := keys.Signer(ctx, rbac.Subject{UserID: "user-1"}, "", signOp)
+	require.NoError(t, err)
+	assert.Equal(t, publicKey, signer.GetPublicKey().AsHex())
+
+	checks, err := keys.AuditKeys(ctx, false)
+	require.NoError(t, err)
+	require.Len(t, checks, 1)
+	assert.Equal(t, signkeys.CheckMismatch, checks[0].Status)
+	assert.Equal(t, publicKey, checks[0].PublicKey)
+	assert.Equal(t, record.PublicKey, checks[0].StoredPublicKey)
+	assert.Equal(t, record.PublicKey, store.records["user-1"].PublicKey)
+
+	checks, err = keys.AuditKeys(ctx, true)
+	require.NoError(t, err)
+	require.Len(t, checks, 1)
+	assert.Equal(t, signkeys.CheckRepaired, checks[0].Status)
+	assert.Equal(t, publicKey, store.records["user-1"].PublicKey)
+
+	checks, err = keys.AuditKeys(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, signkeys.CheckOK, checks[0].Status)
+}
+
+func TestAuditReportsUnreadableKey(t *testing.T) {
+	keys, store, _ := newKeystore(t, nil)
+	ctx := context.Background()
+
+	_, err := keys.PublicKey(ctx, "user-1")
+	require.NoError(t, err)
+
+	record := store.records["user-1"]
+	record.PrivateKey = []byte("garbage")
+	store.records["user-1"] = record
+
+	checks, err := keys.AuditKeys(ctx, true)
+	require.NoError(t, err)
+	require.Len(t, checks, 1)
+	assert.Equal(t, signkeys.CheckInvalid, checks[0].Status)
+	assert.NotEmpty(t, checks[0].Error)
+}
@@ -0,0 +1,227 @@
+package keystore
+
+import (
+	"context"
+	"doc-management/internal/audit"
+	"doc-management/internal/encryption"
+	"doc-management/internal/rbac"
+	"doc-management/internal/signkeys"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/hyperledger/sawtooth-sdk-go/signing"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrNotFound = errors.New("signing key not found")
+	// the key was rotated or revoked meanwhile
+	ErrKeyChanged = errors.New("signing key changed meanwhile")
+	ErrKeyRevoked = errors.New("signing key revoked")
+)
+
+// Record is the signing key of a user, the private key is sealed with the master key
+type Record struct {
+	UserID     string
+	PublicKey  string
+	PrivateKey []byte
+	CreatedAt  time.Time
+}
+
+// Store keeps the key records and the history of the keys no longer in use
+type Store interface {
+	History
+	// GetKey returns ErrNotFound if the user has no key
+	GetKey(ctx context.Context, userID string) (Record, error)
+	// InsertKey stores the record unless the user already has a key; returns the record stored for the user
+	InsertKey(ctx context.Context, record Record) (Record, error)
+	// ReplaceKey replaces the user's key if it's still the one with the given public key, returns ErrKeyChanged otherwise
+	ReplaceKey(ctx context.Context, publicKey string, record Record) error
+	// ListKeys returns the keys of all the users
+	ListKeys(ctx context.Context) ([]Record, error)
+}
+
+// Legacy is the storage of the keys used before the keystore, e.g. the directory
+type Legacy interface {
+	// GetStoredKeys returns the keys stored for the user, empty if there are none
+	GetStoredKeys(ctx context.Context, userID string) (signkeys.UserKeys, error)
+	// AuditKeys verifies the stored key records, repairs the mismatched ones if repair is set
+	AuditKeys(ctx context.Context, repair bool) ([]signkeys.KeyCheck, error)
+	// ClearStoredKeys removes the stored private key of the user, once the user has a key in the keystore
+	ClearStoredKeys(ctx context.Context, userID string) error
+}
+
+// LegacyKeys is the Legacy storage with the keys read by the function, there is nothing to audit
+type LegacyKeys func(ctx context.Context, userID string) (signkeys.UserKeys, error)
+
+func (f LegacyKeys) GetStoredKeys(ctx context.Context, userID string) (signkeys.UserKeys, error) {
+	if f == nil {
+		return signkeys.UserKeys{}, nil
+	}
+	return f(ctx, userID)
+}
+
+func (f LegacyKeys) AuditKeys(ctx context.Context, repair bool) ([]signkeys.KeyCheck, error) {
+	return nil, nil
+}
+
+func (f LegacyKeys) ClearStoredKeys(ctx context.Context, userID string) error {
+	return nil
+}
+
+// Keystore keeps the users' signing keys encrypted at rest. A key can be used only by its owner,
+// a transaction made on behalf of another user is signed with the key of the acting user.
+type Keystore struct {
+	store   Store
+	keyring *encryption.Keyring
+	audit   audit.Log
+	legacy  Legacy
+	logger  *zap.Logger
+}
+
+// New creates the keystore, the legacy keys are imported on the first use of the user's key if given
+func New(logger *zap.Logger, store Store, keyring *encryption.Keyring, auditLog audit.Log, legacy Legacy) (Keystore, error) {
+	if keyring == nil || keyring.Empty() {
+		return Keystore{}, errors.New("the keystore needs a master key to encrypt the keys")
+	}
+
+	return Keystore{store: store, keyring: keyring, audit: auditLog, legacy: legacy, logger: logger}, nil
+}
+
+func (k Keystore) Signer(ctx context.Context, subject rbac.Subject, onBehalfOf string, op Operation) (*signing.Signer, error) {
+	if subject.UserID == "" {
+		return nil, rbac.ErrForbidden
+	}
+
+	keys, err := k.keys(ctx, subject.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	signer := keys.GetSigner()
+
+	// the revocation failed to replace the key
+	revoked, err := k.Revoked(ctx, signer.GetPublicKey().AsHex())
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrKeyRevoked
+	}
+
+	if err := recordOperation(ctx, k.audit, subject, onBehalfOf, op, signer.GetPublicKey().AsHex()); err != nil {
+		return nil, err
+	}
+
+	return signer, nil
+}
+
+func (k Keystore) PublicKey(ctx context.Context, userID string) (string, error) {
+	keys, err := k.keys(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+
+	return keys.GetSigner().GetPublicKey().AsHex(), nil
+}
+
+// keys returns the user's keys, importing the legacy ones or generating new ones if the user has none
+func (k Keystore) keys(ctx context.Context, userID string) (signkeys.UserKeys, error) {
+	record, err := k.store.GetKey(ctx, userID)
+	if err == ErrNotFound {
+		record, err = k.createKey(ctx, userID)
+	}
+	if err != nil {
+		return signkeys.UserKeys{}, err
+	}
+
+	privateKey, err := encryption.Open(k.keyring, record.PrivateKey, []byte(userID))
+	if err != nil {
+		return signkeys.UserKeys{}, errors.New("failed to decrypt the signing key: " + err.Error())
+	}
+
+	keys, err := signkeys.NewUserKeys(hex.EncodeToString(privateKey), record.PublicKey)
+	if err == signkeys.ErrKeyMismatch {
+		// the public key is derived from the private key, the record is fixed by the key audit
+		k.logger.Warn("the stored public key doesn't match the signing key", zap.String("userID", userID))
+		return keys, nil
+	}
+	return keys, err
+}
+
+func (k Keystore) createKey(ctx context.Context, userID string) (Record, error) {
+	keys, err := k.legacyKeys(ctx, userID)
+	if err != nil {
+		return Record{}, err
+	}
+
+	// the legacy key is cleared from the directory once the user has a key in the keystore
+	legacy := keys.Valid()
+	if legacy {
+		// don't bring the legacy key back once rotated or revoked
+		_, err := k.store.GetRetiredKey(ctx, keys.GetSigner().GetPublicKey().AsHex())
+		switch {
+		case err == ErrNotFound:
+			k.logger.Info("importing the legacy signing key to the keystore", zap.String("userID", userID))
+		case err != nil:
+			return Record{}, err
+		default:
+			keys = signkeys.UserKeys{}
+		}
+	}
+	if !keys.Valid() {
+		if keys, err = signkeys.GenerateKeys(); err != nil {
+			return Record{}, err
+		}
+	}
+
+	record, err := k.newRecord(userID, keys)
+	if err != nil {
+		return Record{}, err
+	}
+
+	stored, err := k.store.InsertKey(ctx, record)
+	if err != nil {
+		return Record{}, err
+	}
+	if legacy {
+		k.clearLegacyKey(ctx, userID)
+	}
+	return stored, nil
+}
+
+// clearLegacyKey removes the private key from the directory, the key audit reports it if that fails
+func (k Keystore) clearLegacyKey(ctx context.Context, userID string) {
+	if err := k.legacy.ClearStoredKeys(ctx, userID); err != nil {
+		k.logger.Error("the legacy private key is still stored in the directory, repair the keys to clear it: "+err.Error(), zap.String("userID", userID))
+	}
+}
+
+func (k Keystore) newRecord(userID string, keys signkeys.UserKeys) (Record, error) {
+	// the user ID is authenticated, so a sealed key can't be swapped between the users
+	sealed, err := encryption.Seal(k.keyring, keys.PrivateKey.AsBytes(), []byte(userID))
+	if err != nil {
+		return Record{}, errors.New("failed to encrypt the signing key: " + err.Error())
+	}
+
+	return Record{
+		UserID: userID,
+		// the key as it appears in the transaction headers
+		PublicKey:  keys.GetSigner().GetPublicKey().AsHex(),
+		PrivateKey: sealed,
+		CreatedAt:  time.Now().UTC(),
+	}, nil
+}
+
+func (k Keystore) legacyKeys(ctx context.Context, userID string) (signkeys.UserKeys, error) {
+	if k.legacy == nil {
+		return signkeys.UserKeys{}, nil
+	}
+
+	keys, err := k.legacy.GetStoredKeys(ctx, userID)
+	if err != nil {
+		return signkeys.UserKeys{}, errors.New("failed to get the legacy keys: " + err.Error())
+	}
+	return keys, nil
+}
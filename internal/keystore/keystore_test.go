@@ -0,0 +1,313 @@
+package keystore_test
+
+import (
+	"context"
+	"crypto/rand"
+	"doc-management/internal/audit"
+	"doc-management/internal/encryption"
+	"doc-management/internal/keystore"
+	"doc-management/internal/rbac"
+	"doc-management/internal/signkeys"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type memoryStore struct {
+	mu      sync.Mutex
+	records map[string]keystore.Record
+	retired []keystore.KeyInfo
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{records: map[string]keystore.Record{}}
+}
+
+func (s *memoryStore) GetKey(ctx context.Context, userID string) (keystore.Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[userID]
+	if !ok {
+		return keystore.Record{}, keystore.ErrNotFound
+	}
+	return record, nil
+}
+
+func (s *memoryStore) InsertKey(ctx context.Context, record keystore.Record) (keystore.Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.records[record.UserID]; ok {
+		return existing, nil
+	}
+	s.records[record.UserID] = record
+	return record, nil
+}
+
+func (s *memoryStore) ReplaceKey(ctx context.Context, publicKey string, record keystore.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.records[record.UserID].PublicKey != publicKey {
+		return keystore.ErrKeyChanged
+	}
+	s.records[record.UserID] = record
+	return nil
+}
+
+func (s *memoryStore) ListKeys(ctx context.Context) ([]keystore.Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var records []keystore.Record
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (s *memoryStore) PutRetiredKey(ctx context.Context, info keystore.KeyInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, retired := range s.retired {
+		if retired.PublicKey == info.PublicKey {
+			s.retired[i] = info
+			return nil
+		}
+	}
+	s.retired = append(s.retired, info)
+	return nil
+}
+
+func (s *memoryStore) GetRetiredKey(ctx context.Context, publicKey string) (keystore.KeyInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, retired := range s.retired {
+		if retired.PublicKey == publicKey {
+			return retired, nil
+		}
+	}
+	return keystore.KeyInfo{}, keystore.ErrNotFound
+}
+
+func (s *memoryStore) GetRetiredKeys(ctx context.Context, userID string) ([]keystore.KeyInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []keystore.KeyInfo
+	for _, retired := range s.retired {
+		if retired.UserID == userID {
+			keys = append(keys, retired)
+		}
+	}
+	return keys, nil
+}
+
+type memoryAudit struct {
+	entries []audit.Entry
+	err     error
+}
+
+func (a *memoryAudit) Record(ctx context.Context, entry audit.Entry) error {
+	if a.err != nil {
+		return a.err
+	}
+	a.entries = append(a.entries, entry)
+	return nil
+}
+
+func newKeyring(t *testing.T) *encryption.Keyring {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+
+	keyring := encryption.NewKeyring()
+	require.NoError(t, keyring.AddKey("master", base64.StdEncoding.EncodeToString(key)))
+	return keyring
+}
+
+func newKeystore(t *testing.T, legacy keystore.LegacyKeys) (keystore.Keystore, *memoryStore, *memoryAudit) {
+	store := newMemoryStore()
+	auditLog := &memoryAudit{}
+	keys, err := keystore.New(zap.NewNop(), store, newKeyring(t), auditLog, legacy)
+	require.NoError(t, err)
+	return keys, store, auditLog
+}
+
+var signOp = keystore.Operation{Action: audit.ActionSignProposal, Category: "policies", Target: "proposal-1"}
+
+func TestKeyEncryptedAtRest(t *testing.T) {
+	keys, store, _ := newKeystore(t, nil)
+	ctx := context.Background()
+
+	publicKey, err := keys.PublicKey(ctx, "user-1")
+	require.NoError(t, err)
+
+	record := store.records["user-1"]
+	assert.Equal(t, publicKey, record.PublicKey)
+	assert.True(t, encryption.IsEnvelope(record.PrivateKey))
+
+	// the same key is returned later
+	again, err := keys.PublicKey(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, publicKey, again)
+}
+
+func TestSealedKeyBoundToUser(t *testing.T) {
+	keys, store, _ := newKeystore(t, nil)
+	ctx := context.Background()
+
+	_, err := keys.PublicKey(ctx, "user-1")
+	require.NoError(t, err)
+
+	// a key record copied to another user can't be opened
+	record := store.records["user-1"]
+	record.UserID = "user-2"
+	store.records["user-2"] = record
+
+	_, err = keys.PublicKey(ctx, "user-2")
+	assert.Error(t, err)
+}
+
+func TestSignerUsesOwnKeyAndAudits(t *testing.T) {
+	keys, _, auditLog := newKeystore(t, nil)
+	ctx := context.Background()
+
+	adminKey, err := keys.PublicKey(ctx, "admin")
+	require.NoError(t, err)
+
+	// acting on behalf of another user, the admin still signs with the own key
+	signer, err := keys.Signer(ctx, rbac.Subject{UserID: "admin"}, "user-1", signOp)
+	require.NoError(t, err)
+	assert.Equal(t, adminKey, signer.GetPublicKey().AsHex())
+
+	require.Len(t, auditLog.entries, 1)
+	entry := auditLog.entries[0]
+	assert.Equal(t, audit.ActionSignProposal, entry.Action)
+	assert.Equal(t, "admin", entry.Actor)
+	assert.Equal(t, "user-1", entry.OnBehalfOf)
+	assert.Equal(t, "proposal-1", entry.Target)
+	assert.Equal(t, adminKey, entry.PublicKey)
+}
+
+func TestSignerFailsClosed(t *testing.T) {
+	keys, _, auditLog := newKeystore(t, nil)
+	ctx := context.Background()
+
+	_, err := keys.Signer(ctx, rbac.Subject{}, "", signOp)
+	assert.Equal(t, rbac.ErrForbidden, err)
+
+	auditLog.err = errors.New("audit log unavailable")
+	_, err = keys.Signer(ctx, rbac.Subject{UserID: "user-1"}, "", signOp)
+	assert.Error(t, err)
+}
+
+func TestLegacyKeysImported(t *testing.T) {
+	legacyKeys, err := signkeys.GenerateKeys()
+	require.NoError(t, err)
+
+	calls := 0
+	keys, _, _ := newKeystore(t, func(ctx context.Context, userID string) (signkeys.UserKeys, error) {
+		calls++
+		if userID == "user-1" {
+			return legacyKeys, nil
+		}
+		return signkeys.UserKeys{}, nil
+	})
+	ctx := context.Background()
+
+	publicKey, err := keys.PublicKey(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, legacyKeys.GetSigner().GetPublicKey().AsHex(), publicKey)
+
+	// without legacy keys a new one is generated
+	other, err := keys.PublicKey(ctx, "user-2")
+	require.NoError(t, err)
+	assert.NotEqual(t, publicKey, other)
+
+	// imported only once
+	_, err = keys.PublicKey(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+// memoryLegacy keeps the legacy keys of the directory
+type memoryLegacy struct {
+	keys     map[string]signkeys.UserKeys
+	clearErr error
+}
+
+func (l *memoryLegacy) GetStoredKeys(ctx context.Context, userID string) (signkeys.UserKeys, error) {
+	return l.keys[userID], nil
+}
+
+func (l *memoryLegacy) AuditKeys(ctx context.Context, repair bool) ([]signkeys.KeyCheck, error) {
+	var checks []signkeys.KeyCheck
+	for _, userID := range []string{"user-1", "user-2"} {
+		status := signkeys.CheckOK
+		if !l.keys[userID].Valid() {
+			status = signkeys.CheckMissing
+		}
+		checks = append(checks, signkeys.KeyCheck{UserID: userID, Source: "directory", Status: status})
+	}
+	return checks, nil
+}
+
+func (l *memoryLegacy) ClearStoredKeys(ctx context.Context, userID string) error {
+	if l.clearErr != nil {
+		return l.clearErr
+	}
+	delete(l.keys, userID)
+	return nil
+}
+
+func TestLegacyKeyClearedOnceImported(t *testing.T) {
+	legacyKeys, err := signkeys.GenerateKeys()
+	require.NoError(t, err)
+	otherKeys, err := signkeys.GenerateKeys()
+	require.NoError(t, err)
+
+	legacy := &memoryLegacy{
+		keys:     map[string]signkeys.UserKeys{"user-1": legacyKeys, "user-2": otherKeys},
+		clearErr: errors.New("directory unavailable"),
+	}
+	keys, err := keystore.New(zap.NewNop(), newMemoryStore(), newKeyring(t), &memoryAudit{}, legacy)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// imported even if the directory can't be updated
+	publicKey, err := keys.PublicKey(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, legacyKeys.GetSigner().GetPublicKey().AsHex(), publicKey)
+	assert.True(t, legacy.keys["user-1"].Valid())
+
+	legacy.clearErr = nil
+	_, err = keys.PublicKey(ctx, "user-2")
+	require.NoError(t, err)
+	assert.False(t, legacy.keys["user-2"].Valid())
+
+	// the key left in the directory is reported and cleared by the repair
+	checks, err := keys.AuditKeys(ctx, false)
+	require.NoError(t, err)
+	require.Len(t, checks, 3)
+	assert.Equal(t, keystore.KeySource, checks[0].Source)
+	assert.Equal(t, keystore.KeySource, checks[1].Source)
+	assert.Equal(t, "user-1", checks[2].UserID)
+	assert.Equal(t, signkeys.CheckImported, checks[2].Status)
+
+	checks, err = keys.AuditKeys(ctx, true)
+	require.NoError(t, err)
+	require.Len(t, checks, 3)
+	assert.Equal(t, signkeys.CheckRepaired, checks[2].Status)
+	assert.False(t, legacy.keys["user-1"].Valid())
+
+	checks, err = keys.AuditKeys(ctx, false)
+	require.NoError(t, err)
+	assert.Len(t, checks, 2)
+}
+
+func TestKeystoreNeedsMasterKey(t *testing.T) {
+	_, err := keystore.New(zap.NewNop(), newMemoryStore(), encryption.NewKeyring(), &memoryAudit{}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,165 @@
+package keystore
+
+import (
+	"context"
+	"doc-management/internal/audit"
+	"doc-management/internal/rbac"
+	"doc-management/internal/signkeys"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func (k Keystore) Rotate(ctx context.Context, subject rbac.Subject, userID, reason string) (KeyInfo, error) {
+	return k.replace(ctx, subject, userID, KeyStatusRetired, reason)
+}
+
+func (k Keystore) Revoke(ctx context.Context, subject rbac.Subject, userID, publicKey, reason string) (KeyInfo, error) {
+	if publicKey == "" {
+		return k.replace(ctx, subject, userID, KeyStatusRevoked, reason)
+	}
+
+	current, err := k.store.GetKey(ctx, userID)
+	if err != nil && err != ErrNotFound {
+		return KeyInfo{}, err
+	}
+	if err == nil && publicKey == current.PublicKey {
+		return k.replace(ctx, subject, userID, KeyStatusRevoked, reason)
+	}
+
+	// a retired key of the user, or a key the user signs with outside the application
+	info, err := k.store.GetRetiredKey(ctx, publicKey)
+	if err == ErrNotFound {
+		info = KeyInfo{UserID: userID, PublicKey: publicKey}
+	} else if err != nil {
+		return KeyInfo{}, err
+	}
+	if info.UserID != userID {
+		return KeyInfo{}, rbac.ErrForbidden
+	}
+	if info.Status == KeyStatusRevoked {
+		return info, nil
+	}
+
+	if err := k.recordKeyChange(ctx, subject, audit.ActionRevokeKey, userID, publicKey); err != nil {
+		return KeyInfo{}, err
+	}
+
+	info.Status = KeyStatusRevoked
+	info.RetiredAt = time.Now().UTC()
+	info.RetiredBy = subject.UserID
+	info.Reason = reason
+	if err := k.store.PutRetiredKey(ctx, info); err != nil {
+		return KeyInfo{}, err
+	}
+
+	k.logger.Warn("signing key revoked", zap.String("userID", userID), zap.String("publicKey", publicKey), zap.String("by", subject.UserID))
+	return info, nil
+}
+
+func (k Keystore) Keys(ctx context.Context, userID string) ([]KeyInfo, error) {
+	keys, err := k.store.GetRetiredKeys(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := k.store.GetKey(ctx, userID)
+	if err == ErrNotFound {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return append(keys, KeyInfo{UserID: userID, PublicKey: current.PublicKey, Status: KeyStatusActive, CreatedAt: current.CreatedAt}), nil
+}
+
+func (k Keystore) Revoked(ctx context.Context, publicKey string) (bool, error) {
+	info, err := k.store.GetRetiredKey(ctx, publicKey)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return info.Status == KeyStatusRevoked, nil
+}
+
+// replace generates a new key for the user and moves the current one to the history with the given status
+func (k Keystore) replace(ctx context.Context, subject rbac.Subject, userID string, status KeyStatus, reason string) (KeyInfo, error) {
+	current, err := k.store.GetKey(ctx, userID)
+	if err == ErrNotFound {
+		// the legacy key is imported, to be retired as well
+		current, err = k.createKey(ctx, userID)
+	}
+	if err != nil {
+		return KeyInfo{}, err
+	}
+
+	action := audit.ActionRotateKey
+	if status == KeyStatusRevoked {
+		action = audit.ActionRevokeKey
+	}
+	if err := k.recordKeyChange(ctx, subject, action, userID, current.PublicKey); err != nil {
+		return KeyInfo{}, err
+	}
+
+	keys, err := signkeys.GenerateKeys()
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	next, err := k.newRecord(userID, keys)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+
+	// the old key is in the history before it's replaced, so it's never lost
+	retired := KeyInfo{
+		UserID:     userID,
+		PublicKey:  current.PublicKey,
+		Status:     status,
+		CreatedAt:  current.CreatedAt,
+		RetiredAt:  next.CreatedAt,
+		RetiredBy:  subject.UserID,
+		ReplacedBy: next.PublicKey,
+		Reason:     reason,
+	}
+	if err := k.store.PutRetiredKey(ctx, retired); err != nil {
+		return KeyInfo{}, err
+	}
+
+	if err := k.store.ReplaceKey(ctx, current.PublicKey, next); err != nil {
+		if status == KeyStatusRetired {
+			// the key is still in use, the history entry is fixed by the next rotation
+			k.logger.Error("the key replaced meanwhile, the rotation failed", zap.String("userID", userID))
+		}
+		return KeyInfo{}, err
+	}
+
+	k.logger.Info("signing key replaced", zap.String("userID", userID), zap.String("status", string(status)), zap.String("oldPublicKey", current.PublicKey), zap.String("newPublicKey", next.PublicKey))
+	return KeyInfo{UserID: userID, PublicKey: next.PublicKey, Status: KeyStatusActive, CreatedAt: next.CreatedAt}, nil
+}
+
+func (k Keystore) recordKeyChange(ctx context.Context, subject rbac.Subject, action audit.Action, userID, publicKey string) error {
+	if subject.UserID == "" {
+		return rbac.ErrForbidden
+	}
+
+	entry := audit.Entry{
+		Time:      time.Now().UTC(),
+		Action:    action,
+		Actor:     subject.UserID,
+		Target:    userID,
+		PublicKey: publicKey,
+	}
+	if subject.UserID != userID {
+		entry.OnBehalfOf = userID
+	}
+	if err := k.audit.Record(ctx, entry); err != nil {
+		return errors.New("failed to record the key change in the audit log: " + err.Error())
+	}
+
+	return nil
+}
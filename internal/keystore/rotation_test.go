@@ -0,0 +1,135 @@
+package keystore_test
+
+import (
+	"context"
+	"doc-management/internal/audit"
+	"doc-management/internal/keystore"
+	"doc-management/internal/rbac"
+	"doc-management/internal/signkeys"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var admin = rbac.Subject{UserID: "admin"}
+
+func TestRotate(t *testing.T) {
+	keys, _, auditLog := newKeystore(t, nil)
+	ctx := context.Background()
+
+	oldKey, err := keys.PublicKey(ctx, "user-1")
+	require.NoError(t, err)
+
+	rotated, err := keys.Rotate(ctx, admin, "user-1", "scheduled")
+	require.NoError(t, err)
+	assert.NotEqual(t, oldKey, rotated.PublicKey)
+
+	// the new key signs from now on
+	signer, err := keys.Signer(ctx, rbac.Subject{UserID: "user-1"}, "", signOp)
+	require.NoError(t, err)
+	assert.Equal(t, rotated.PublicKey, signer.GetPublicKey().AsHex())
+
+	// the old key stays in the history, linked to the new one and still trusted
+	history, err := keys.Keys(ctx, "user-1")
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, oldKey, history[0].PublicKey)
+	assert.Equal(t, keystore.KeyStatusRetired, history[0].Status)
+	assert.Equal(t, rotated.PublicKey, history[0].ReplacedBy)
+	assert.Equal(t, "admin", history[0].RetiredBy)
+	assert.Equal(t, keystore.KeyStatusActive, history[1].Status)
+
+	revoked, err := keys.Revoked(ctx, oldKey)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	assert.Equal(t, audit.ActionRotateKey, auditLog.entries[0].Action)
+	assert.Equal(t, oldKey, auditLog.entries[0].PublicKey)
+	assert.Equal(t, "user-1", auditLog.entries[0].OnBehalfOf)
+}
+
+func TestRevokeCurrentKey(t *testing.T) {
+	keys, _, _ := newKeystore(t, nil)
+	ctx := context.Background()
+
+	oldKey, err := keys.PublicKey(ctx, "user-1")
+	require.NoError(t, err)
+
+	replacement, err := keys.Revoke(ctx, admin, "user-1", "", "leaked")
+	require.NoError(t, err)
+	assert.NotEqual(t, oldKey, replacement.PublicKey)
+
+	revoked, err := keys.Revoked(ctx, oldKey)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	signer, err := keys.Signer(ctx, rbac.Subject{UserID: "user-1"}, "", signOp)
+	require.NoError(t, err)
+	assert.Equal(t, replacement.PublicKey, signer.GetPublicKey().AsHex())
+}
+
+func TestRevokeRetiredAndExternalKeys(t *testing.T) {
+	keys, _, _ := newKeystore(t, nil)
+	ctx := context.Background()
+
+	oldKey, err := keys.PublicKey(ctx, "user-1")
+	require.NoError(t, err)
+	_, err = keys.Rotate(ctx, admin, "user-1", "")
+	require.NoError(t, err)
+
+	// a retired key found compromised later
+	info, err := keys.Revoke(ctx, admin, "user-1", oldKey, "leaked backup")
+	require.NoError(t, err)
+	assert.Equal(t, keystore.KeyStatusRevoked, info.Status)
+	assert.NotEmpty(t, info.ReplacedBy)
+
+	// a key the user signs with outside the application
+	external := "02" + oldKey[2:]
+	_, err = keys.Revoke(ctx, admin, "user-1", external, "lost wallet")
+	require.NoError(t, err)
+	revoked, err := keys.Revoked(ctx, external)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	// another user's key can't be revoked in the name of this user
+	_, err = keys.Revoke(ctx, admin, "user-2", external, "")
+	assert.Equal(t, rbac.ErrForbidden, err)
+}
+
+func TestRevokedLegacyKeyNotImported(t *testing.T) {
+	legacyKeys, err := signkeys.GenerateKeys()
+	require.NoError(t, err)
+	legacyKey := legacyKeys.GetSigner().GetPublicKey().AsHex()
+
+	keys, store, _ := newKeystore(t, func(ctx context.Context, userID string) (signkeys.UserKeys, error) {
+		return legacyKeys, nil
+	})
+	ctx := context.Background()
+
+	_, err = keys.Revoke(ctx, admin, "user-1", "", "leaked")
+	require.NoError(t, err)
+	revoked, err := keys.Revoked(ctx, legacyKey)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	// the legacy key in the directory is not brought back
+	delete(store.records, "user-1")
+	publicKey, err := keys.PublicKey(ctx, "user-1")
+	require.NoError(t, err)
+	assert.NotEqual(t, legacyKey, publicKey)
+}
+
+func TestSignerRefusesRevokedKey(t *testing.T) {
+	keys, store, _ := newKeystore(t, nil)
+	ctx := context.Background()
+
+	publicKey, err := keys.PublicKey(ctx, "user-1")
+	require.NoError(t, err)
+
+	// the revocation was recorded, but the key was not replaced
+	require.NoError(t, store.PutRetiredKey(ctx, keystore.KeyInfo{UserID: "user-1", PublicKey: publicKey, Status: keystore.KeyStatusRevoked}))
+
+	_, err = keys.Signer(ctx, rbac.Subject{UserID: "user-1"}, "", signOp)
+	assert.Equal(t, keystore.ErrKeyRevoked, err)
+}
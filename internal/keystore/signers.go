@@ -0,0 +1,62 @@
+package keystore
+
+import (
+	"context"
+	"doc-management/internal/audit"
+	"doc-management/internal/rbac"
+	"doc-management/internal/signkeys"
+	"errors"
+	"time"
+
+	"github.com/hyperledger/sawtooth-sdk-go/signing"
+)
+
+var ErrNotSupported = errors.New("the key management is not supported by the directory key storage, use the keystore")
+
+// Operation describes what the signer is requested for, it's recorded in the audit log
+type Operation struct {
+	Action   audit.Action
+	Category string
+	// ID of the affected object, e.g. proposal ID
+	Target string
+}
+
+// Signers hand out the signers of the blockchain transactions
+type Signers interface {
+	// Signer returns the signer for the operation performed by the subject, possibly on behalf of another user;
+	// the operation is recorded in the audit log before the signer is returned
+	Signer(ctx context.Context, subject rbac.Subject, onBehalfOf string, op Operation) (*signing.Signer, error)
+	// PublicKey returns the public key of the user, the keys are generated if missing
+	PublicKey(ctx context.Context, userID string) (string, error)
+
+	// Rotate replaces the user's key with a new one, the old key is kept in the history; returns the new key
+	Rotate(ctx context.Context, subject rbac.Subject, userID, reason string) (KeyInfo, error)
+	// Revoke blocks the key of the user, a new key is generated if it's the user's current key
+	Revoke(ctx context.Context, subject rbac.Subject, userID, publicKey, reason string) (KeyInfo, error)
+	// Keys returns the current and the past keys of the user
+	Keys(ctx context.Context, userID string) ([]KeyInfo, error)
+	// Revoked tells if the public key was revoked
+	Revoked(ctx context.Context, publicKey string) (bool, error)
+	// AuditKeys verifies the key records of all the users, the mismatched records are repaired if repair is set
+	AuditKeys(ctx context.Context, repair bool) ([]signkeys.KeyCheck, error)
+}
+
+func recordOperation(ctx context.Context, auditLog audit.Log, subject rbac.Subject, onBehalfOf string, op Operation, publicKey string) error {
+	if onBehalfOf == subject.UserID {
+		onBehalfOf = ""
+	}
+	entry := audit.Entry{
+		Time:       time.Now().UTC(),
+		Action:     op.Action,
+		Actor:      subject.UserID,
+		OnBehalfOf: onBehalfOf,
+		Category:   op.Category,
+		Target:     op.Target,
+		PublicKey:  publicKey,
+	}
+	if err := auditLog.Record(ctx, entry); err != nil {
+		return errors.New("failed to record the signing operation in the audit log: " + err.Error())
+	}
+
+	return nil
+}
@@ -18,6 +18,10 @@ type Document struct {
 	Author      string
 	Content     []byte
 	ContentHash string
+	// metadata of the file uploaded with the proposal
+	FileName    string
+	ContentType string
+	ContentSize int64
 
 	Version int
 	Status  DocStatus
@@ -42,6 +46,9 @@ func NewDocumentFromProposal(proposal Proposal, version int) Document {
 		Author:       proposal.ModificationAuthor,
 		Content:      proposal.Content,
 		ContentHash:  proposal.ContentHash,
+		FileName:     proposal.FileName,
+		ContentType:  proposal.ContentType,
+		ContentSize:  proposal.ContentSize,
 		Version:      version,
 		Status:       proposal.ProposedStatus,
 		ProposalID:   proposal.ProposalID,
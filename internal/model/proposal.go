@@ -23,12 +23,20 @@ type Proposal struct {
 	ModificationAuthor string
 	Content            []byte
 	ContentHash        string
-	ProposedStatus     DocStatus
-	CurrentStatus      ProposalStatus
+	// metadata of the uploaded file
+	FileName       string
+	ContentType    string
+	ContentSize    int64
+	ProposedStatus DocStatus
+	CurrentStatus  ProposalStatus
 
 	Signers []string
 }
 
+func (status ProposalStatus) IsValid() bool {
+	return status == ProposalStatusActive || status == ProposalStatusAccepted || status == ProposalStatusRemoved
+}
+
 func (proposal Proposal) Validate() error {
 	if !proposal.ProposedStatus.IsValid() {
 		return errors.New("invalid document status: " + proposal.ProposedStatus.String())
@@ -47,5 +55,9 @@ func (proposal *Proposal) Complete() {
 	if proposal.ProposedStatus == "" {
 		proposal.ProposedStatus = DocStatusActive
 	}
-	proposal.ContentHash = hashing.CalculateSHA512(string(proposal.Content))
+	// the hash and the size of the content streamed by the caller are filled in by the caller
+	if proposal.ContentHash == "" {
+		proposal.ContentHash = hashing.CalculateSHA512(string(proposal.Content))
+		proposal.ContentSize = int64(len(proposal.Content))
+	}
 }
@@ -5,7 +5,10 @@ import "doc-management/internal/signkeys"
 type User struct {
 	ID   string
 	Name string
+	// the public key is always derived from the private key
 	Keys signkeys.UserKeys
+	// the stored public key doesn't match the private key, the record needs a repair
+	KeysMismatch bool
 }
 
 func (u User) HasValidKeys() bool {
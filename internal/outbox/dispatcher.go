@@ -0,0 +1,345 @@
+package outbox
+
+import (
+	"context"
+	"doc-management/internal/audit"
+	"doc-management/internal/blockchain"
+	"doc-management/internal/repository"
+	"doc-management/internal/retry"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// the max number of entries processed at once
+	dispatchLimit   = 100
+	dispatchTimeout = 30 * time.Second
+)
+
+var errContentMissing = errors.New("the content of the batch was never stored")
+
+// CommitHandler applies the effects of the committed batch of the entry, e.g. updates the catalog;
+// it's called again on the next dispatch if it fails
+type CommitHandler func(ctx context.Context, entry Entry) error
+
+// Dispatcher submits the batches recorded in the outbox, retries the failed submissions with a backoff
+// and follows the submitted batches until they are committed; the content of a failed batch is released
+type Dispatcher struct {
+	logger       *zap.Logger
+	store        Store
+	chain        Chain
+	content      repository.ContentStore
+	retry        retry.Policy
+	pollInterval time.Duration
+	handlers     map[audit.Action]CommitHandler
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewDispatcher(logger *zap.Logger, store Store, chain Chain, content repository.ContentStore, policy retry.Policy, pollInterval time.Duration) *Dispatcher {
+	return &Dispatcher{
+		logger:       logger,
+		store:        store,
+		chain:        chain,
+		content:      content,
+		retry:        policy,
+		pollInterval: pollInterval,
+		handlers:     make(map[audit.Action]CommitHandler),
+	}
+}
+
+// SetCommitHandler sets the handler of the committed batches of the action, the entry is recorded as committed
+// only once the handler succeeds; needs to be set before the dispatcher is started
+func (d *Dispatcher) SetCommitHandler(action audit.Action, handler CommitHandler) {
+	d.handlers[action] = handler
+}
+
+// Add records the signed batch as pending, it has to be added before the content of the batch is stored
+func (d *Dispatcher) Add(ctx context.Context, entry Entry) (Entry, error) {
+	now := time.Now().UTC()
+	entry.State = StatePending
+	entry.Attempts = 0
+	entry.NextAttemptAt = now
+	entry.CreatedAt = now
+	entry.UpdatedAt = now
+
+	if err := d.store.InsertOutboxEntry(ctx, entry); err != nil {
+		return Entry{}, errors.New("failed to record the batch in the outbox: " + err.Error())
+	}
+
+	return entry, nil
+}
+
+// Submit submits the pending entry once its content is stored. If the submission fails, it's retried
+// in the background and the entry is returned pending; an error is returned only if the batch is rejected
+// or given up, the entry is failed then.
+func (d *Dispatcher) Submit(ctx context.Context, entry Entry) (Entry, error) {
+	return d.submit(ctx, entry)
+}
+
+// Cancel fails the pending entry whose content couldn't be stored, so it's never submitted
+func (d *Dispatcher) Cancel(ctx context.Context, entry Entry, reason error) error {
+	_, err := d.fail(ctx, entry, reason)
+	return err
+}
+
+// InFlight tells if a batch of the action on the target may still be committed,
+// so it's not submitted twice, e.g. when the events are replayed
+func (d *Dispatcher) InFlight(ctx context.Context, action audit.Action, target string) (bool, error) {
+	return d.store.OpenOutboxEntryExists(ctx, action, target)
+}
+
+// List returns the entries in the given state, the newest first
+func (d *Dispatcher) List(ctx context.Context, state State, limit int) ([]Entry, error) {
+	return d.store.ListOutboxEntries(ctx, state, limit)
+}
+
+// Start runs the dispatch loop until Stop is called
+func (d *Dispatcher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+	d.done = make(chan struct{})
+
+	go func() {
+		defer close(d.done)
+
+		ticker := time.NewTicker(d.pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			dispatchCtx, cancelDispatch := context.WithTimeout(ctx, dispatchTimeout)
+			if err := d.Dispatch(dispatchCtx); err != nil && ctx.Err() == nil {
+				d.logger.Warn("failed to dispatch the outbox: " + err.Error())
+			}
+			cancelDispatch()
+		}
+	}()
+}
+
+func (d *Dispatcher) Stop() {
+	if d.cancel == nil {
+		return
+	}
+	d.cancel()
+	<-d.done
+}
+
+// Dispatch submits the due pending entries and records the final statuses of the submitted ones, once
+func (d *Dispatcher) Dispatch(ctx context.Context) error {
+	due, err := d.store.DueOutboxEntries(ctx, time.Now().UTC(), dispatchLimit)
+	if err != nil {
+		return err
+	}
+
+	submitted := make(map[string]Entry)
+	var batchIDs []string
+	for _, entry := range due {
+		if entry.State == StatePending {
+			if _, err := d.submit(ctx, entry); err != nil {
+				d.logger.Warn("outbox batch failed: "+err.Error(), zap.String("batchID", entry.ID), zap.String("action", string(entry.Action)), zap.String("target", entry.Target))
+			}
+			continue
+		}
+		submitted[entry.ID] = entry
+		batchIDs = append(batchIDs, entry.ID)
+	}
+	if len(batchIDs) == 0 {
+		return nil
+	}
+
+	statuses, err := d.chain.GetBatchStatuses(ctx, batchIDs, 0)
+	if err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		entry, ok := submitted[status.BatchID]
+		if !ok {
+			continue
+		}
+
+		switch status.Status {
+		case blockchain.BatchCommitted:
+			if _, err := d.commit(ctx, entry); err != nil {
+				return err
+			}
+
+		case blockchain.BatchInvalid:
+			if _, err := d.fail(ctx, entry, status.InvalidBatchError()); err != nil {
+				return err
+			}
+
+		case blockchain.BatchUnknown:
+			// lost by the validator, e.g. on a restart
+			d.logger.Info("outbox batch unknown to the validator, submitting again", zap.String("batchID", entry.ID))
+			if _, err := d.submit(ctx, entry); err != nil {
+				d.logger.Warn("outbox batch failed: "+err.Error(), zap.String("batchID", entry.ID), zap.String("action", string(entry.Action)), zap.String("target", entry.Target))
+			}
+		}
+	}
+
+	return nil
+}
+
+func (d *Dispatcher) submit(ctx context.Context, entry Entry) (Entry, error) {
+	entry.Attempts++
+	entry.UpdatedAt = time.Now().UTC()
+
+	err := d.checkContent(ctx, entry)
+	if err == nil {
+		err = d.chain.RelayBatchList(ctx, entry.BatchList)
+	}
+
+	var invalidTransaction *blockchain.InvalidTransactionError
+	switch {
+	case err == nil:
+		entry.State = StateSubmitted
+		entry.LastError = ""
+
+	case errors.As(err, &invalidTransaction), errors.Is(err, errContentMissing):
+		failed, failErr := d.fail(ctx, entry, err)
+		if failErr != nil {
+			return failed, failErr
+		}
+		return failed, err
+
+	case entry.Attempts >= d.retry.MaxAttempts:
+		return d.giveUp(ctx, entry, err)
+
+	default:
+		entry.State = StatePending
+		entry.LastError = err.Error()
+		entry.NextAttemptAt = entry.UpdatedAt.Add(d.retry.Backoff(entry.Attempts))
+		d.logger.Warn(fmt.Sprint("outbox batch submission failed, attempt ", entry.Attempts, ", retrying at ", entry.NextAttemptAt.Format(time.RFC3339), ": ", err.Error()), zap.String("batchID", entry.ID))
+	}
+
+	if err := d.store.UpdateOutboxEntry(ctx, entry); err != nil {
+		return entry, err
+	}
+	return entry, nil
+}
+
+// checkContent makes sure the batch is never submitted without its content, e.g. after a crash
+// between recording the entry and storing the content
+func (d *Dispatcher) checkContent(ctx context.Context, entry Entry) error {
+	if entry.ContentHash == "" {
+		return nil
+	}
+
+	stored, err := d.content.HasContent(ctx, entry.ContentHash)
+	if err != nil {
+		return err
+	}
+	if !stored {
+		return errContentMissing
+	}
+	return nil
+}
+
+// giveUp stops submitting the entry after the last failed attempt. The attempt may have reached the validator
+// anyway, e.g. on a timeout, so the content is released only if the validator doesn't have the batch.
+func (d *Dispatcher) giveUp(ctx context.Context, entry Entry, reason error) (Entry, error) {
+	statuses, err := d.chain.GetBatchStatuses(ctx, []string{entry.ID}, 0)
+	if err != nil || len(statuses) != 1 {
+		// the content is left to the reconciler, which releases it unless the batch is committed after all
+		failed, failErr := d.markFailed(ctx, entry, reason)
+		if failErr != nil {
+			return failed, failErr
+		}
+		return failed, reason
+	}
+
+	switch statuses[0].Status {
+	case blockchain.BatchCommitted:
+		return d.commit(ctx, entry)
+
+	case blockchain.BatchPending:
+		entry.State = StateSubmitted
+		entry.LastError = ""
+		if err := d.store.UpdateOutboxEntry(ctx, entry); err != nil {
+			return entry, err
+		}
+		return entry, nil
+
+	case blockchain.BatchInvalid:
+		reason = statuses[0].InvalidBatchError()
+	}
+
+	failed, err := d.fail(ctx, entry, reason)
+	if err != nil {
+		return failed, err
+	}
+	return failed, reason
+}
+
+// commit records the entry as committed once the commit handler succeeds, the content is referenced
+// by the committed record from now on; if the handler fails, the entry is followed as submitted
+func (d *Dispatcher) commit(ctx context.Context, entry Entry) (Entry, error) {
+	if handler, ok := d.handlers[entry.Action]; ok {
+		if err := handler(ctx, entry); err != nil {
+			d.logger.Warn("failed to handle the committed outbox batch, retrying: "+err.Error(), zap.String("batchID", entry.ID), zap.String("action", string(entry.Action)), zap.String("target", entry.Target))
+			entry.State = StateSubmitted
+			entry.LastError = err.Error()
+			entry.UpdatedAt = time.Now().UTC()
+			if err := d.store.UpdateOutboxEntry(ctx, entry); err != nil {
+				return entry, err
+			}
+			return entry, nil
+		}
+	}
+
+	entry.State = StateCommitted
+	entry.LastError = ""
+	entry.UpdatedAt = time.Now().UTC()
+	if err := d.store.UpdateOutboxEntry(ctx, entry); err != nil {
+		return entry, err
+	}
+
+	d.release(ctx, entry, entry.ReplacedRef)
+	return entry, nil
+}
+
+// fail gives up the entry for the reason and releases its content
+func (d *Dispatcher) fail(ctx context.Context, entry Entry, reason error) (Entry, error) {
+	entry, err := d.markFailed(ctx, entry, reason)
+	if err != nil {
+		return entry, err
+	}
+
+	d.release(ctx, entry, entry.ContentRef)
+	return entry, nil
+}
+
+// markFailed gives up the entry for the reason, keeping its content
+func (d *Dispatcher) markFailed(ctx context.Context, entry Entry, reason error) (Entry, error) {
+	entry.State = StateFailed
+	entry.LastError = reason.Error()
+	entry.UpdatedAt = time.Now().UTC()
+
+	if err := d.store.UpdateOutboxEntry(ctx, entry); err != nil {
+		return entry, err
+	}
+	d.logger.Warn(fmt.Sprint("outbox batch given up after ", entry.Attempts, " attempts: ", reason.Error()), zap.String("batchID", entry.ID), zap.String("action", string(entry.Action)), zap.String("target", entry.Target))
+
+	return entry, nil
+}
+
+func (d *Dispatcher) release(ctx context.Context, entry Entry, ref string) {
+	if entry.ContentHash == "" || ref == "" {
+		return
+	}
+
+	if err := d.content.ReleaseContent(ctx, entry.ContentHash, ref); err != nil {
+		// left to the reconciler
+		d.logger.Warn("failed to release the outbox batch content: "+err.Error(), zap.String("batchID", entry.ID), zap.String("ref", ref))
+	}
+}
@@ -0,0 +1,448 @@
+package outbox
+
+import (
+	"context"
+	"doc-management/internal/audit"
+	"doc-management/internal/blockchain"
+	"doc-management/internal/repository"
+	"doc-management/internal/retry"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type memoryStore struct {
+	mu      sync.Mutex
+	entries map[string]Entry
+}
+
+func (s *memoryStore) InsertOutboxEntry(ctx context.Context, entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[entry.ID] = entry
+	return nil
+}
+
+func (s *memoryStore) GetOutboxEntry(ctx context.Context, id string) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[id]
+	if !ok {
+		return Entry{}, ErrNotFound
+	}
+	return entry, nil
+}
+
+func (s *memoryStore) UpdateOutboxEntry(ctx context.Context, entry Entry) error {
+	return s.InsertOutboxEntry(ctx, entry)
+}
+
+func (s *memoryStore) DueOutboxEntries(ctx context.Context, until time.Time, limit int) ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pending, submitted []Entry
+	for _, entry := range s.entries {
+		switch {
+		case entry.State == StatePending && !entry.NextAttemptAt.After(until):
+			pending = append(pending, entry)
+		case entry.State == StateSubmitted:
+			submitted = append(submitted, entry)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].NextAttemptAt.Before(pending[j].NextAttemptAt) })
+	sort.Slice(submitted, func(i, j int) bool { return submitted[i].CreatedAt.Before(submitted[j].CreatedAt) })
+	if limit > 0 && len(pending) > limit {
+		pending = pending[:limit]
+	}
+	if limit > 0 && len(submitted) > limit {
+		submitted = submitted[:limit]
+	}
+	return append(pending, submitted...), nil
+}
+
+func (s *memoryStore) ListOutboxEntries(ctx context.Context, state State, limit int) ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var entries []Entry
+	for _, entry := range s.entries {
+		if entry.State == state {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (s *memoryStore) OpenOutboxEntryExists(ctx context.Context, action audit.Action, target string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range s.entries {
+		if entry.Open() && entry.Action == action && entry.Target == target {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// memoryContent keeps the content with the set of its references
+type memoryContent map[string]map[string]bool
+
+func (c memoryContent) PutContent(ctx context.Context, contentHash string, content []byte, ref string) error {
+	if c[contentHash] == nil {
+		c[contentHash] = map[string]bool{}
+	}
+	c[contentHash][ref] = true
+	return nil
+}
+
+func (c memoryContent) GetContent(ctx context.Context, contentHash string) ([]byte, error) {
+	if _, ok := c[contentHash]; !ok {
+		return nil, repository.ErrContentNotFound
+	}
+	return []byte(contentHash), nil
+}
+
+func (c memoryContent) HasContent(ctx context.Context, contentHash string) (bool, error) {
+	_, ok := c[contentHash]
+	return ok, nil
+}
+
+func (c memoryContent) AddRef(ctx context.Context, contentHash string, ref string) error {
+	if _, ok := c[contentHash]; !ok {
+		return repository.ErrContentNotFound
+	}
+	c[contentHash][ref] = true
+	return nil
+}
+
+func (c memoryContent) ReleaseContent(ctx context.Context, contentHash string, ref string) error {
+	delete(c[contentHash], ref)
+	if len(c[contentHash]) == 0 {
+		delete(c, contentHash)
+	}
+	return nil
+}
+
+func (c memoryContent) ListRefs(ctx context.Context) (map[string][]string, error) {
+	refs := make(map[string][]string)
+	for contentHash, contentRefs := range c {
+		for ref := range contentRefs {
+			refs[contentHash] = append(refs[contentHash], ref)
+		}
+	}
+	return refs, nil
+}
+
+// fakeChain accepts the batches unless relayErr is set and reports the given statuses, the other batches are pending
+type fakeChain struct {
+	relayErr  error
+	relayed   int
+	statuses  map[string]blockchain.BatchStatus
+	statusErr error
+}
+
+func (f *fakeChain) RelayBatchList(ctx context.Context, data []byte) error {
+	f.relayed++
+	return f.relayErr
+}
+
+func (f *fakeChain) GetBatchStatuses(ctx context.Context, batchIDs []string, wait time.Duration) ([]blockchain.BatchStatus, error) {
+	if f.statusErr != nil {
+		return nil, f.statusErr
+	}
+	var statuses []blockchain.BatchStatus
+	for _, batchID := range batchIDs {
+		status, ok := f.statuses[batchID]
+		if !ok {
+			status = blockchain.BatchStatus{BatchID: batchID, Status: blockchain.BatchPending}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func newTestDispatcher(chain *fakeChain, content memoryContent) (*Dispatcher, *memoryStore) {
+	store := &memoryStore{entries: map[string]Entry{}}
+	policy := retry.Policy{InitialBackoff: time.Minute, MaxBackoff: time.Hour, MaxAttempts: 3}
+	return NewDispatcher(zap.NewNop(), store, chain, content, policy, time.Second), store
+}
+
+func acceptedEntry() Entry {
+	return Entry{
+		ID:          "batch-1",
+		UserID:      "user-1",
+		Action:      audit.ActionAcceptDocument,
+		Target:      "proposal-1",
+		BatchList:   []byte("batch list"),
+		ContentHash: "hash-1",
+		ContentRef:  repository.DocVersionRef("policies", "security", 2),
+		ReplacedRef: repository.ProposalRef("proposal-1"),
+	}
+}
+
+func TestDispatchUntilCommitted(t *testing.T) {
+	chain := &fakeChain{statuses: map[string]blockchain.BatchStatus{}}
+	content := memoryContent{}
+	dispatcher, store := newTestDispatcher(chain, content)
+	ctx := context.Background()
+
+	entry, err := dispatcher.Add(ctx, acceptedEntry())
+	require.NoError(t, err)
+	assert.Equal(t, StatePending, entry.State)
+
+	require.NoError(t, content.PutContent(ctx, entry.ContentHash, nil, entry.ReplacedRef))
+	require.NoError(t, content.PutContent(ctx, entry.ContentHash, nil, entry.ContentRef))
+
+	entry, err = dispatcher.Submit(ctx, entry)
+	require.NoError(t, err)
+	assert.Equal(t, StateSubmitted, entry.State)
+	assert.Equal(t, 1, entry.Attempts)
+
+	inFlight, err := dispatcher.InFlight(ctx, audit.ActionAcceptDocument, "proposal-1")
+	require.NoError(t, err)
+	assert.True(t, inFlight)
+
+	require.NoError(t, dispatcher.Dispatch(ctx))
+	stored, err := store.GetOutboxEntry(ctx, "batch-1")
+	require.NoError(t, err)
+	assert.Equal(t, StateSubmitted, stored.State)
+
+	chain.statuses["batch-1"] = blockchain.BatchStatus{BatchID: "batch-1", Status: blockchain.BatchCommitted}
+	require.NoError(t, dispatcher.Dispatch(ctx))
+
+	stored, err = store.GetOutboxEntry(ctx, "batch-1")
+	require.NoError(t, err)
+	assert.Equal(t, StateCommitted, stored.State)
+	// the content moved from the proposal to the doc version
+	assert.Equal(t, map[string]bool{entry.ContentRef: true}, content["hash-1"])
+	assert.Equal(t, 1, chain.relayed)
+}
+
+func TestCommitHandledBeforeCommitted(t *testing.T) {
+	chain := &fakeChain{statuses: map[string]blockchain.BatchStatus{
+		"batch-1": {BatchID: "batch-1", Status: blockchain.BatchCommitted},
+	}}
+	content := memoryContent{}
+	dispatcher, store := newTestDispatcher(chain, content)
+	ctx := context.Background()
+
+	var handled []string
+	handlerErr := errors.New("the catalog is unavailable")
+	dispatcher.SetCommitHandler(audit.ActionAcceptDocument, func(ctx context.Context, entry Entry) error {
+		handled = append(handled, entry.Target)
+		return handlerErr
+	})
+
+	entry, err := dispatcher.Add(ctx, acceptedEntry())
+	require.NoError(t, err)
+	require.NoError(t, content.PutContent(ctx, entry.ContentHash, nil, entry.ReplacedRef))
+	require.NoError(t, content.PutContent(ctx, entry.ContentHash, nil, entry.ContentRef))
+	_, err = dispatcher.Submit(ctx, entry)
+	require.NoError(t, err)
+
+	// the effects of the commit are applied again until they succeed
+	require.NoError(t, dispatcher.Dispatch(ctx))
+	stored, err := store.GetOutboxEntry(ctx, "batch-1")
+	require.NoError(t, err)
+	assert.Equal(t, StateSubmitted, stored.State)
+	assert.Equal(t, handlerErr.Error(), stored.LastError)
+	assert.Len(t, content["hash-1"], 2)
+
+	handlerErr = nil
+	require.NoError(t, dispatcher.Dispatch(ctx))
+	stored, err = store.GetOutboxEntry(ctx, "batch-1")
+	require.NoError(t, err)
+	assert.Equal(t, StateCommitted, stored.State)
+	assert.Empty(t, stored.LastError)
+	assert.Equal(t, []string{"proposal-1", "proposal-1"}, handled)
+	assert.Equal(t, map[string]bool{entry.ContentRef: true}, content["hash-1"])
+}
+
+func TestSubmitRetriedWithBackoff(t *testing.T) {
+	chain := &fakeChain{relayErr: blockchain.ErrValidatorUnavailable, statuses: map[string]blockchain.BatchStatus{}}
+	content := memoryContent{}
+	dispatcher, store := newTestDispatcher(chain, content)
+	ctx := context.Background()
+
+	entry, err := dispatcher.Add(ctx, acceptedEntry())
+	require.NoError(t, err)
+	require.NoError(t, content.PutContent(ctx, entry.ContentHash, nil, entry.ContentRef))
+
+	entry, err = dispatcher.Submit(ctx, entry)
+	require.NoError(t, err)
+	assert.Equal(t, StatePending, entry.State)
+	assert.Equal(t, 1, entry.Attempts)
+	assert.True(t, entry.NextAttemptAt.After(time.Now()))
+	assert.NotEmpty(t, entry.LastError)
+
+	// not due yet
+	require.NoError(t, dispatcher.Dispatch(ctx))
+	assert.Equal(t, 1, chain.relayed)
+
+	entry.NextAttemptAt = time.Now().Add(-time.Second)
+	require.NoError(t, store.UpdateOutboxEntry(ctx, entry))
+	chain.relayErr = nil
+	require.NoError(t, dispatcher.Dispatch(ctx))
+
+	stored, err := store.GetOutboxEntry(ctx, "batch-1")
+	require.NoError(t, err)
+	assert.Equal(t, StateSubmitted, stored.State)
+	assert.Equal(t, 2, stored.Attempts)
+	assert.Empty(t, stored.LastError)
+}
+
+func TestDispatchPendingBehindSubmitted(t *testing.T) {
+	chain := &fakeChain{statuses: map[string]blockchain.BatchStatus{}}
+	content := memoryContent{}
+	dispatcher, store := newTestDispatcher(chain, content)
+	ctx := context.Background()
+
+	// a full page of the older batches waiting on the validator
+	createdAt := time.Now().UTC().Add(-time.Hour)
+	for i := 0; i < dispatchLimit; i++ {
+		require.NoError(t, store.InsertOutboxEntry(ctx, Entry{ID: fmt.Sprint("submitted-", i), State: StateSubmitted, CreatedAt: createdAt}))
+	}
+
+	entry := acceptedEntry()
+	require.NoError(t, content.PutContent(ctx, entry.ContentHash, nil, entry.ContentRef))
+	entry, err := dispatcher.Add(ctx, entry)
+	require.NoError(t, err)
+
+	require.NoError(t, dispatcher.Dispatch(ctx))
+	stored, err := store.GetOutboxEntry(ctx, "batch-1")
+	require.NoError(t, err)
+	assert.Equal(t, StateSubmitted, stored.State)
+	assert.Equal(t, 1, chain.relayed)
+}
+
+// submitUntilGivenUp fails the submissions until the last attempt
+func submitUntilGivenUp(t *testing.T, dispatcher *Dispatcher, content memoryContent) (Entry, error) {
+	ctx := context.Background()
+	entry, err := dispatcher.Add(ctx, acceptedEntry())
+	require.NoError(t, err)
+	require.NoError(t, content.PutContent(ctx, entry.ContentHash, nil, entry.ReplacedRef))
+	require.NoError(t, content.PutContent(ctx, entry.ContentHash, nil, entry.ContentRef))
+
+	for i := 0; i < 2; i++ {
+		entry, err = dispatcher.Submit(ctx, entry)
+		require.NoError(t, err)
+		assert.Equal(t, StatePending, entry.State)
+	}
+
+	return dispatcher.Submit(ctx, entry)
+}
+
+func TestSubmitGivenUp(t *testing.T) {
+	chain := &fakeChain{relayErr: blockchain.ErrValidatorUnavailable, statuses: map[string]blockchain.BatchStatus{
+		"batch-1": {BatchID: "batch-1", Status: blockchain.BatchUnknown},
+	}}
+	content := memoryContent{}
+	dispatcher, store := newTestDispatcher(chain, content)
+	ctx := context.Background()
+	entry := acceptedEntry()
+
+	_, err := submitUntilGivenUp(t, dispatcher, content)
+	assert.True(t, errors.Is(err, blockchain.ErrValidatorUnavailable))
+
+	stored, err := store.GetOutboxEntry(ctx, "batch-1")
+	require.NoError(t, err)
+	assert.Equal(t, StateFailed, stored.State)
+	assert.Equal(t, 3, stored.Attempts)
+	// only the reference of the batch is released, the proposal keeps its content
+	assert.Equal(t, map[string]bool{entry.ReplacedRef: true}, content["hash-1"])
+}
+
+func TestSubmitGivenUpReachedValidator(t *testing.T) {
+	// e.g. the last submission timed out after the validator accepted the batch
+	chain := &fakeChain{relayErr: blockchain.ErrValidatorUnavailable, statuses: map[string]blockchain.BatchStatus{}}
+	content := memoryContent{}
+	dispatcher, store := newTestDispatcher(chain, content)
+	ctx := context.Background()
+	entry := acceptedEntry()
+
+	submitted, err := submitUntilGivenUp(t, dispatcher, content)
+	require.NoError(t, err)
+	assert.Equal(t, StateSubmitted, submitted.State)
+	assert.Equal(t, map[string]bool{entry.ReplacedRef: true, entry.ContentRef: true}, content["hash-1"])
+
+	inFlight, err := dispatcher.InFlight(ctx, audit.ActionAcceptDocument, "proposal-1")
+	require.NoError(t, err)
+	assert.True(t, inFlight)
+
+	chain.statuses["batch-1"] = blockchain.BatchStatus{BatchID: "batch-1", Status: blockchain.BatchCommitted}
+	require.NoError(t, dispatcher.Dispatch(ctx))
+	stored, err := store.GetOutboxEntry(ctx, "batch-1")
+	require.NoError(t, err)
+	assert.Equal(t, StateCommitted, stored.State)
+	assert.Equal(t, map[string]bool{entry.ContentRef: true}, content["hash-1"])
+}
+
+func TestSubmitGivenUpStatusUnavailable(t *testing.T) {
+	chain := &fakeChain{relayErr: blockchain.ErrValidatorUnavailable, statusErr: blockchain.ErrValidatorUnavailable}
+	content := memoryContent{}
+	dispatcher, store := newTestDispatcher(chain, content)
+	ctx := context.Background()
+	entry := acceptedEntry()
+
+	_, err := submitUntilGivenUp(t, dispatcher, content)
+	assert.True(t, errors.Is(err, blockchain.ErrValidatorUnavailable))
+
+	stored, err := store.GetOutboxEntry(ctx, "batch-1")
+	require.NoError(t, err)
+	assert.Equal(t, StateFailed, stored.State)
+	// the batch may still be committed, the content is left to the reconciler
+	assert.Equal(t, map[string]bool{entry.ReplacedRef: true, entry.ContentRef: true}, content["hash-1"])
+}
+
+func TestSubmitWithoutContent(t *testing.T) {
+	chain := &fakeChain{statuses: map[string]blockchain.BatchStatus{}}
+	dispatcher, store := newTestDispatcher(chain, memoryContent{})
+	ctx := context.Background()
+
+	// e.g. the app crashed before the content was stored
+	entry, err := dispatcher.Add(ctx, acceptedEntry())
+	require.NoError(t, err)
+
+	_, err = dispatcher.Submit(ctx, entry)
+	assert.Equal(t, errContentMissing, err)
+	assert.Equal(t, 0, chain.relayed)
+
+	stored, err := store.GetOutboxEntry(ctx, "batch-1")
+	require.NoError(t, err)
+	assert.Equal(t, StateFailed, stored.State)
+}
+
+func TestInvalidBatchReleasesContent(t *testing.T) {
+	chain := &fakeChain{statuses: map[string]blockchain.BatchStatus{}}
+	content := memoryContent{}
+	dispatcher, store := newTestDispatcher(chain, content)
+	ctx := context.Background()
+
+	entry, err := dispatcher.Add(ctx, Entry{
+		ID:          "batch-1",
+		Action:      audit.ActionSubmitProposal,
+		Target:      "proposal-1",
+		ContentHash: "hash-1",
+		ContentRef:  repository.ProposalRef("proposal-1"),
+	})
+	require.NoError(t, err)
+	require.NoError(t, content.PutContent(ctx, entry.ContentHash, nil, entry.ContentRef))
+	_, err = dispatcher.Submit(ctx, entry)
+	require.NoError(t, err)
+
+	invalid := []blockchain.InvalidTransaction{{ID: "transaction-1", Message: "the proposal already exists"}}
+	chain.statuses["batch-1"] = blockchain.BatchStatus{BatchID: "batch-1", Status: blockchain.BatchInvalid, InvalidTransactions: invalid}
+	require.NoError(t, dispatcher.Dispatch(ctx))
+
+	stored, err := store.GetOutboxEntry(ctx, "batch-1")
+	require.NoError(t, err)
+	assert.Equal(t, StateFailed, stored.State)
+	assert.Contains(t, stored.LastError, "the proposal already exists")
+	assert.Empty(t, content)
+}
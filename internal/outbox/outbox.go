@@ -0,0 +1,82 @@
+package outbox
+
+import (
+	"context"
+	"doc-management/internal/audit"
+	"doc-management/internal/blockchain"
+	"errors"
+	"time"
+)
+
+var ErrNotFound = errors.New("outbox entry not found")
+
+type State string
+
+const (
+	// not submitted yet or to be submitted again, retried with a backoff
+	StatePending State = "pending"
+	// accepted by the validator, waiting for the commit
+	StateSubmitted State = "submitted"
+	StateCommitted State = "committed"
+	// given up, the content stored for the batch is released
+	StateFailed State = "failed"
+)
+
+func (s State) IsValid() bool {
+	return s == StatePending || s == StateSubmitted || s == StateCommitted || s == StateFailed
+}
+
+// Entry is a signed batch intended to be submitted to the blockchain; it's persisted before the content
+// the batch refers to is stored, so neither the content nor the batch gets lost on a crash
+type Entry struct {
+	// ID of the signed batch
+	ID string
+	// the user who submitted the batch, the app user for the transactions of the application
+	UserID string
+	Action audit.Action
+	// ID of the affected object, e.g. proposal ID
+	Target string
+	// the serialized batch list, submitted as it is on each attempt
+	BatchList []byte
+
+	// the content stored for the batch under the reference, released if the batch fails
+	ContentHash string
+	ContentRef  string
+	// the reference of the same content released once the batch is committed, e.g. of the accepted proposal
+	ReplacedRef string
+
+	State    State
+	Attempts int
+	// when a pending entry is submitted next time
+	NextAttemptAt time.Time
+	LastError     string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// Open tells if the batch may still be committed
+func (e Entry) Open() bool {
+	return e.State == StatePending || e.State == StateSubmitted
+}
+
+// Store keeps the outbox entries
+type Store interface {
+	InsertOutboxEntry(ctx context.Context, entry Entry) error
+	// GetOutboxEntry returns ErrNotFound if there is no such entry
+	GetOutboxEntry(ctx context.Context, id string) (Entry, error)
+	UpdateOutboxEntry(ctx context.Context, entry Entry) error
+	// DueOutboxEntries returns up to the limit of the pending entries to be submitted until the given time,
+	// the earliest due first, and up to the limit of the submitted entries, the oldest first;
+	// the entries of one state don't hold back the other
+	DueOutboxEntries(ctx context.Context, until time.Time, limit int) ([]Entry, error)
+	// ListOutboxEntries returns the entries in the given state without their batch lists, the newest first
+	ListOutboxEntries(ctx context.Context, state State, limit int) ([]Entry, error)
+	// OpenOutboxEntryExists tells if there is a pending or submitted entry of the action on the target
+	OpenOutboxEntryExists(ctx context.Context, action audit.Action, target string) (bool, error)
+}
+
+// Chain accepts the batches and reports their statuses, e.g. the validator REST API
+type Chain interface {
+	RelayBatchList(ctx context.Context, data []byte) error
+	GetBatchStatuses(ctx context.Context, batchIDs []string, wait time.Duration) ([]blockchain.BatchStatus, error)
+}
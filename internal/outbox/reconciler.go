@@ -0,0 +1,432 @@
+package outbox
+
+import (
+	"context"
+	"doc-management/internal/hashing"
+	"doc-management/internal/model"
+	"doc-management/internal/repository"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const reconcileTimeout = 10 * time.Minute
+
+var ErrIssueNotFound = errors.New("reconciliation issue not found")
+
+type IssueKind string
+
+const (
+	// content referenced by a proposal or a doc version which is not on the blockchain or doesn't need it anymore
+	IssueOrphanedContent IssueKind = "orphaned_content"
+	// a proposal or a doc version on the blockchain whose content is not stored
+	IssueMissingContent IssueKind = "missing_content"
+)
+
+type IssueStatus string
+
+const (
+	// found recently, repaired once it's found after the grace period
+	IssueOpen     IssueStatus = "open"
+	IssueRepaired IssueStatus = "repaired"
+	// can't be repaired automatically
+	IssueFlagged IssueStatus = "flagged"
+	// not found anymore
+	IssueResolved IssueStatus = "resolved"
+)
+
+func (s IssueStatus) IsValid() bool {
+	return s == IssueOpen || s == IssueRepaired || s == IssueFlagged || s == IssueResolved
+}
+
+// Issue is a mismatch between the stored content and the records on the blockchain
+type Issue struct {
+	// derived from the kind, the content hash and the reference
+	ID          string
+	Kind        IssueKind
+	ContentHash string
+	// the content reference of the proposal or the doc version
+	Ref         string
+	Detail      string
+	Status      IssueStatus
+	FirstSeenAt time.Time
+	LastSeenAt  time.Time
+	UpdatedAt   time.Time
+}
+
+// IssueStore keeps the issues found by the reconciler
+type IssueStore interface {
+	// GetIssue returns ErrIssueNotFound if there is no such issue
+	GetIssue(ctx context.Context, id string) (Issue, error)
+	// SaveIssue inserts or replaces the issue
+	SaveIssue(ctx context.Context, issue Issue) error
+	// ListIssues returns the issues in the given status, all if it's empty, the recently seen first
+	ListIssues(ctx context.Context, status IssueStatus, limit int) ([]Issue, error)
+}
+
+// ChainState reads the proposals and the doc versions from the blockchain
+type ChainState interface {
+	GetProposalsPage(ctx context.Context, start string, limit int) (proposals []model.Proposal, next string, err error)
+	GetAllDocumentVersions(ctx context.Context) ([]model.Document, error)
+}
+
+// RemoveProposalFunc removes the proposal whose content is missing from the blockchain
+type RemoveProposalFunc func(ctx context.Context, proposal model.Proposal) error
+
+// finding is an issue found in a single run, with the action repairing it; nil if it can't be repaired
+type finding struct {
+	issue  Issue
+	repair func(ctx context.Context) error
+}
+
+// Reconciler periodically compares the stored content with the proposals and the doc versions on the blockchain.
+// The orphaned content references are released and the missing ones restored if the content is stored;
+// the active proposals with no content are removed. The doc versions with no content are only flagged.
+// An issue is repaired only if it's still found after the grace period, so the submissions in progress are not affected.
+type Reconciler struct {
+	logger         *zap.Logger
+	issues         IssueStore
+	outbox         Store
+	chain          ChainState
+	content        repository.RefListingStore
+	removeProposal RemoveProposalFunc
+	grace          time.Duration
+	interval       time.Duration
+
+	// a single run at a time
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewReconciler(logger *zap.Logger, issues IssueStore, outbox Store, chain ChainState, content repository.RefListingStore, removeProposal RemoveProposalFunc, grace, interval time.Duration) *Reconciler {
+	return &Reconciler{
+		logger:         logger,
+		issues:         issues,
+		outbox:         outbox,
+		chain:          chain,
+		content:        content,
+		removeProposal: removeProposal,
+		grace:          grace,
+		interval:       interval,
+	}
+}
+
+// Start runs the reconciliation periodically until Stop is called
+func (r *Reconciler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.done = make(chan struct{})
+
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			runCtx, cancelRun := context.WithTimeout(ctx, reconcileTimeout)
+			if _, err := r.Reconcile(runCtx); err != nil && ctx.Err() == nil {
+				r.logger.Warn("reconciliation failed: " + err.Error())
+			}
+			cancelRun()
+		}
+	}()
+}
+
+func (r *Reconciler) Stop() {
+	if r.cancel == nil {
+		return
+	}
+	r.cancel()
+	<-r.done
+}
+
+// Issues returns the recorded issues in the given status, all if it's empty
+func (r *Reconciler) Issues(ctx context.Context, status IssueStatus, limit int) ([]Issue, error) {
+	return r.issues.ListIssues(ctx, status, limit)
+}
+
+// Reconcile compares the content with the blockchain once, repairs the issues older than the grace period
+// and records them; returns the issues found in this run
+func (r *Reconciler) Reconcile(ctx context.Context) ([]Issue, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	findings, err := r.find(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	seen := make(map[string]bool, len(findings))
+	issues := make([]Issue, 0, len(findings))
+	for _, f := range findings {
+		issue, err := r.handle(ctx, f, now)
+		if err != nil {
+			return issues, err
+		}
+		seen[issue.ID] = true
+		issues = append(issues, issue)
+	}
+
+	// the issues gone since the previous run, e.g. the pending submission got committed
+	for _, status := range []IssueStatus{IssueOpen, IssueFlagged} {
+		previous, err := r.issues.ListIssues(ctx, status, 0)
+		if err != nil {
+			return issues, err
+		}
+		for _, issue := range previous {
+			if seen[issue.ID] {
+				continue
+			}
+			issue.Status = IssueResolved
+			issue.UpdatedAt = now
+			if err := r.issues.SaveIssue(ctx, issue); err != nil {
+				return issues, err
+			}
+		}
+	}
+
+	r.logger.Info(fmt.Sprint("reconciliation finished, found ", len(issues), " issues"))
+	return issues, nil
+}
+
+func (r *Reconciler) handle(ctx context.Context, f finding, now time.Time) (Issue, error) {
+	issue := f.issue
+	issue.ID = string(issue.Kind) + ":" + issue.ContentHash + ":" + issue.Ref
+	issue.FirstSeenAt = now
+	issue.LastSeenAt = now
+	issue.UpdatedAt = now
+
+	existing, err := r.issues.GetIssue(ctx, issue.ID)
+	if err != nil && err != ErrIssueNotFound {
+		return Issue{}, err
+	}
+	if err == nil && (existing.Status == IssueOpen || existing.Status == IssueFlagged) {
+		issue.FirstSeenAt = existing.FirstSeenAt
+	}
+
+	fields := []zap.Field{zap.String("kind", string(issue.Kind)), zap.String("ref", issue.Ref), zap.String("contentHash", issue.ContentHash)}
+	switch {
+	case f.repair == nil:
+		issue.Status = IssueFlagged
+		r.logger.Error("reconciliation: "+issue.Detail, fields...)
+
+	case now.Sub(issue.FirstSeenAt) < r.grace:
+		issue.Status = IssueOpen
+		r.logger.Info("reconciliation: "+issue.Detail+", to be repaired after the grace period", fields...)
+
+	default:
+		if err := f.repair(ctx); err != nil {
+			issue.Status = IssueOpen
+			issue.Detail += "; the repair failed: " + err.Error()
+			r.logger.Warn("reconciliation: "+issue.Detail, fields...)
+			break
+		}
+		issue.Status = IssueRepaired
+		r.logger.Info("reconciliation: "+issue.Detail+", repaired", fields...)
+	}
+
+	if err := r.issues.SaveIssue(ctx, issue); err != nil {
+		return Issue{}, err
+	}
+	return issue, nil
+}
+
+// find compares the content references with the proposals and the doc versions on the blockchain
+func (r *Reconciler) find(ctx context.Context) ([]finding, error) {
+	refs, err := r.content.ListRefs(ctx)
+	if err != nil {
+		return nil, errors.New("failed to list the content references: " + err.Error())
+	}
+
+	inFlight, err := r.inFlightRefs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	proposals, err := r.proposals(ctx)
+	if err != nil {
+		return nil, errors.New("failed to get the proposals: " + err.Error())
+	}
+
+	docList, err := r.chain.GetAllDocumentVersions(ctx)
+	if err != nil {
+		return nil, errors.New("failed to get the doc versions: " + err.Error())
+	}
+	docs := make(map[string]model.Document, len(docList))
+	for _, doc := range docList {
+		docs[repository.DocVersionRef(doc.Category, doc.DocumentName, doc.Version)] = doc
+	}
+
+	stored := make(map[string]bool)
+	for contentHash, contentRefs := range refs {
+		for _, ref := range contentRefs {
+			stored[contentHash+":"+ref] = true
+		}
+	}
+	// the proposals whose content is already referenced by the accepted doc version
+	accepted := make(map[string]bool, len(docList))
+	for ref, doc := range docs {
+		if stored[doc.ContentHash+":"+ref] {
+			accepted[doc.ProposalID] = true
+		}
+	}
+
+	var findings []finding
+	for contentHash, contentRefs := range refs {
+		for _, ref := range contentRefs {
+			if inFlight[ref] {
+				continue
+			}
+
+			detail := orphanDetail(ref, contentHash, proposals, docs, accepted)
+			if detail == "" {
+				continue
+			}
+			findings = append(findings, finding{
+				issue:  Issue{Kind: IssueOrphanedContent, ContentHash: contentHash, Ref: ref, Detail: detail},
+				repair: r.releaseRef(contentHash, ref),
+			})
+		}
+	}
+
+	for _, proposal := range proposals {
+		ref := repository.ProposalRef(proposal.ProposalID)
+		if proposal.CurrentStatus != model.ProposalStatusActive || isEmptyContent(proposal.ContentHash) || stored[proposal.ContentHash+":"+ref] || inFlight[ref] {
+			continue
+		}
+
+		issue := Issue{Kind: IssueMissingContent, ContentHash: proposal.ContentHash, Ref: ref}
+		if _, ok := refs[proposal.ContentHash]; ok {
+			issue.Detail = "the content of the proposal is stored without its reference"
+			findings = append(findings, finding{issue: issue, repair: r.restoreRef(proposal.ContentHash, ref)})
+			continue
+		}
+
+		issue.Detail = "the content of the proposal was never stored"
+		f := finding{issue: issue}
+		if r.removeProposal != nil {
+			proposal := proposal
+			f.repair = func(ctx context.Context) error { return r.removeProposal(ctx, proposal) }
+		}
+		findings = append(findings, f)
+	}
+
+	for ref, doc := range docs {
+		if doc.Status != model.DocStatusActive || isEmptyContent(doc.ContentHash) || stored[doc.ContentHash+":"+ref] || inFlight[ref] {
+			continue
+		}
+
+		issue := Issue{Kind: IssueMissingContent, ContentHash: doc.ContentHash, Ref: ref}
+		if _, ok := refs[doc.ContentHash]; ok {
+			issue.Detail = "the content of the doc version is stored without its reference"
+			findings = append(findings, finding{issue: issue, repair: r.restoreRef(doc.ContentHash, ref)})
+			continue
+		}
+
+		// the version can't be removed from the blockchain, the content may be restored from a backup
+		issue.Detail = "the content of the doc version is missing"
+		findings = append(findings, finding{issue: issue})
+	}
+
+	return findings, nil
+}
+
+// orphanDetail describes why the content reference is not needed anymore, empty if it is
+func orphanDetail(ref, contentHash string, proposals map[string]model.Proposal, docs map[string]model.Document, accepted map[string]bool) string {
+	if proposalID, ok := repository.ParseProposalRef(ref); ok {
+		proposal, exists := proposals[proposalID]
+		switch {
+		case !exists:
+			return "the proposal is not on the blockchain"
+		case proposal.ContentHash != contentHash:
+			return "the proposal has another content"
+		case proposal.CurrentStatus == model.ProposalStatusRemoved:
+			return "the proposal is removed"
+		case proposal.CurrentStatus == model.ProposalStatusAccepted && accepted[proposalID]:
+			return "the proposal is accepted, its content is referenced by the doc version"
+		}
+		return ""
+	}
+
+	if _, _, _, ok := repository.ParseDocVersionRef(ref); ok {
+		doc, exists := docs[ref]
+		switch {
+		case !exists:
+			return "the doc version is not on the blockchain"
+		case doc.ContentHash != contentHash:
+			return "the doc version has another content"
+		}
+	}
+
+	return ""
+}
+
+// inFlightRefs returns the content references of the batches in the outbox which may still be committed
+func (r *Reconciler) inFlightRefs(ctx context.Context) (map[string]bool, error) {
+	refs := make(map[string]bool)
+	for _, state := range []State{StatePending, StateSubmitted} {
+		entries, err := r.outbox.ListOutboxEntries(ctx, state, 0)
+		if err != nil {
+			return nil, errors.New("failed to get the outbox entries: " + err.Error())
+		}
+		for _, entry := range entries {
+			refs[entry.ContentRef] = true
+			refs[entry.ReplacedRef] = true
+		}
+	}
+	delete(refs, "")
+
+	return refs, nil
+}
+
+// proposals returns all the proposals on the blockchain by their IDs
+func (r *Reconciler) proposals(ctx context.Context) (map[string]model.Proposal, error) {
+	proposals := make(map[string]model.Proposal)
+	start := ""
+	for {
+		page, next, err := r.chain.GetProposalsPage(ctx, start, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, proposal := range page {
+			proposals[proposal.ProposalID] = proposal
+		}
+
+		if next == "" {
+			return proposals, nil
+		}
+		start = next
+	}
+}
+
+func (r *Reconciler) releaseRef(contentHash, ref string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		return r.content.ReleaseContent(ctx, contentHash, ref)
+	}
+}
+
+func (r *Reconciler) restoreRef(contentHash, ref string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		content, err := r.content.GetContent(ctx, contentHash)
+		if err != nil {
+			return err
+		}
+		return r.content.PutContent(ctx, contentHash, content, ref)
+	}
+}
+
+// the proposals removing a document have no content, it's not stored
+func isEmptyContent(contentHash string) bool {
+	return contentHash == "" || contentHash == hashing.CalculateSHA512("")
+}
@@ -0,0 +1,199 @@
+package outbox
+
+import (
+	"context"
+	"doc-management/internal/hashing"
+	"doc-management/internal/model"
+	"doc-management/internal/repository"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type memoryIssues struct {
+	mu     sync.Mutex
+	issues map[string]Issue
+}
+
+func (s *memoryIssues) GetIssue(ctx context.Context, id string) (Issue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	issue, ok := s.issues[id]
+	if !ok {
+		return Issue{}, ErrIssueNotFound
+	}
+	return issue, nil
+}
+
+func (s *memoryIssues) SaveIssue(ctx context.Context, issue Issue) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.issues[issue.ID] = issue
+	return nil
+}
+
+func (s *memoryIssues) ListIssues(ctx context.Context, status IssueStatus, limit int) ([]Issue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var issues []Issue
+	for _, issue := range s.issues {
+		if status == "" || issue.Status == status {
+			issues = append(issues, issue)
+		}
+	}
+	return issues, nil
+}
+
+type fakeChainState struct {
+	proposals []model.Proposal
+	docs      []model.Document
+}
+
+func (f fakeChainState) GetProposalsPage(ctx context.Context, start string, limit int) ([]model.Proposal, string, error) {
+	return f.proposals, "", nil
+}
+
+func (f fakeChainState) GetAllDocumentVersions(ctx context.Context) ([]model.Document, error) {
+	return f.docs, nil
+}
+
+type testReconciler struct {
+	*Reconciler
+	issues  *memoryIssues
+	outbox  *memoryStore
+	content memoryContent
+	removed []string
+}
+
+func newTestReconciler(chain fakeChainState, content memoryContent, grace time.Duration) *testReconciler {
+	hashing.Initialize(zap.NewNop())
+	r := &testReconciler{
+		issues:  &memoryIssues{issues: map[string]Issue{}},
+		outbox:  &memoryStore{entries: map[string]Entry{}},
+		content: content,
+	}
+	remove := func(ctx context.Context, proposal model.Proposal) error {
+		r.removed = append(r.removed, proposal.ProposalID)
+		return nil
+	}
+	r.Reconciler = NewReconciler(zap.NewNop(), r.issues, r.outbox, chain, content, remove, grace, time.Hour)
+	return r
+}
+
+func issueKinds(issues []Issue) []string {
+	var kinds []string
+	for _, issue := range issues {
+		kinds = append(kinds, string(issue.Kind)+" "+issue.Ref+" "+string(issue.Status))
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+func TestReconcileRepairs(t *testing.T) {
+	chain := fakeChainState{
+		proposals: []model.Proposal{
+			{ProposalID: "active", Category: "policies", DocumentName: "security", ContentHash: "hash-active", CurrentStatus: model.ProposalStatusActive},
+			// the content was never stored
+			{ProposalID: "missing", Category: "policies", DocumentName: "privacy", ContentHash: "hash-missing", CurrentStatus: model.ProposalStatusActive},
+			{ProposalID: "accepted", Category: "policies", DocumentName: "backup", ContentHash: "hash-accepted", CurrentStatus: model.ProposalStatusAccepted},
+		},
+		docs: []model.Document{
+			{ProposalID: "accepted", Category: "policies", DocumentName: "backup", Version: 1, ContentHash: "hash-accepted", Status: model.DocStatusActive},
+		},
+	}
+	content := memoryContent{
+		"hash-active": {repository.ProposalRef("active"): true},
+		// the doc version reference was never added
+		"hash-accepted": {repository.ProposalRef("accepted"): true},
+		// the proposal submission failed
+		"hash-orphan": {repository.ProposalRef("orphan"): true},
+	}
+	r := newTestReconciler(chain, content, 0)
+	ctx := context.Background()
+
+	issues, err := r.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"missing_content doc:policies;backup;1 repaired",
+		"missing_content proposal:missing repaired",
+		"orphaned_content proposal:orphan repaired",
+	}, issueKinds(issues))
+
+	// the proposal keeps its reference until the doc version has its own
+	issues, err = r.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"missing_content proposal:missing repaired",
+		"orphaned_content proposal:accepted repaired",
+	}, issueKinds(issues))
+
+	assert.Equal(t, memoryContent{
+		"hash-active":   {repository.ProposalRef("active"): true},
+		"hash-accepted": {repository.DocVersionRef("policies", "backup", 1): true},
+	}, content)
+	// removed again, the fake chain doesn't apply the removal
+	assert.Equal(t, []string{"missing", "missing"}, r.removed)
+}
+
+func TestReconcileAfterGrace(t *testing.T) {
+	content := memoryContent{"hash-orphan": {repository.ProposalRef("orphan"): true}}
+	r := newTestReconciler(fakeChainState{}, content, time.Hour)
+	ctx := context.Background()
+
+	issues, err := r.Reconcile(ctx)
+	require.NoError(t, err)
+	require.Len(t, issues, 1)
+	assert.Equal(t, IssueOpen, issues[0].Status)
+	// the submission may still be in progress
+	assert.Len(t, content, 1)
+
+	stored := r.issues.issues[issues[0].ID]
+	stored.FirstSeenAt = time.Now().Add(-2 * time.Hour)
+	r.issues.issues[issues[0].ID] = stored
+
+	issues, err = r.Reconcile(ctx)
+	require.NoError(t, err)
+	require.Len(t, issues, 1)
+	assert.Equal(t, IssueRepaired, issues[0].Status)
+	assert.Empty(t, content)
+}
+
+func TestReconcileSkipsInFlight(t *testing.T) {
+	content := memoryContent{"hash-1": {repository.ProposalRef("proposal-1"): true}}
+	r := newTestReconciler(fakeChainState{}, content, 0)
+	ctx := context.Background()
+
+	r.outbox.entries["batch-1"] = Entry{ID: "batch-1", State: StateSubmitted, ContentHash: "hash-1", ContentRef: repository.ProposalRef("proposal-1")}
+
+	issues, err := r.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, issues)
+	assert.Len(t, content, 1)
+}
+
+func TestReconcileFlagsMissingDocContent(t *testing.T) {
+	chain := fakeChainState{docs: []model.Document{
+		{ProposalID: "accepted", Category: "policies", DocumentName: "backup", Version: 1, ContentHash: "hash-lost", Status: model.DocStatusActive},
+	}}
+	r := newTestReconciler(chain, memoryContent{}, 0)
+	ctx := context.Background()
+
+	issues, err := r.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"missing_content doc:policies;backup;1 flagged"}, issueKinds(issues))
+
+	// restored from a backup
+	r.content["hash-lost"] = map[string]bool{repository.DocVersionRef("policies", "backup", 1): true}
+	issues, err = r.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, issues)
+
+	resolved, err := r.Issues(ctx, IssueResolved, 0)
+	require.NoError(t, err)
+	assert.Len(t, resolved, 1)
+}
@@ -0,0 +1,42 @@
+package paging
+
+import (
+	"errors"
+	"strconv"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// Page selects the items following the cursor; the cursor is opaque to the clients,
+// it's the value returned as the next cursor of the previous page
+type Page struct {
+	Cursor string
+	Limit  int
+}
+
+func NewPage(cursor, limit string) (Page, error) {
+	page := Page{Cursor: cursor}
+
+	if limit != "" {
+		var err error
+		if page.Limit, err = strconv.Atoi(limit); err != nil || page.Limit < 1 {
+			return Page{}, errors.New("invalid limit: " + limit)
+		}
+	}
+
+	page.Normalize()
+	return page, nil
+}
+
+// Normalize sets the limit to the default if not given and caps it
+func (p *Page) Normalize() {
+	if p.Limit <= 0 {
+		p.Limit = DefaultLimit
+	}
+	if p.Limit > MaxLimit {
+		p.Limit = MaxLimit
+	}
+}
@@ -0,0 +1,24 @@
+package paging_test
+
+import (
+	"doc-management/internal/paging"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPage(t *testing.T) {
+	page, err := paging.NewPage("", "")
+	require.NoError(t, err)
+	assert.Equal(t, paging.Page{Limit: paging.DefaultLimit}, page)
+
+	page, err = paging.NewPage("abc", "1000")
+	require.NoError(t, err)
+	assert.Equal(t, paging.Page{Cursor: "abc", Limit: paging.MaxLimit}, page)
+
+	_, err = paging.NewPage("", "-1")
+	assert.Error(t, err)
+	_, err = paging.NewPage("", "x")
+	assert.Error(t, err)
+}
@@ -0,0 +1,158 @@
+package http
+
+import (
+	"doc-management/internal/batches"
+	"doc-management/internal/blockchain"
+	"doc-management/internal/config"
+	"doc-management/internal/ports/http/middleware/auth"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	contentTypeEventStream = "text/event-stream"
+	// keeps the idle event stream open through the proxies
+	eventStreamKeepAlive = 15 * time.Second
+)
+
+type retrivedBatch struct {
+	BatchID             string                          `json:"batchID"`
+	Status              string                          `json:"status"`
+	Action              string                          `json:"action,omitempty"`
+	Target              string                          `json:"target,omitempty"`
+	InvalidTransactions []blockchain.InvalidTransaction `json:"invalidTransactions,omitempty"`
+	SubmittedAt         *time.Time                      `json:"submittedAt,omitempty"`
+	UpdatedAt           *time.Time                      `json:"updatedAt,omitempty"`
+}
+
+func newRetrivedBatch(batch batches.Batch) retrivedBatch {
+	retBatch := retrivedBatch{
+		BatchID:             batch.ID,
+		Status:              batch.Status,
+		Action:              string(batch.Action),
+		Target:              batch.Target,
+		InvalidTransactions: batch.InvalidTransactions,
+	}
+	if !batch.SubmittedAt.IsZero() {
+		retBatch.SubmittedAt = &batch.SubmittedAt
+	}
+	if !batch.UpdatedAt.IsZero() {
+		retBatch.UpdatedAt = &batch.UpdatedAt
+	}
+	return retBatch
+}
+
+// readWait reads the time to wait for the submitted batch to be committed, at most the request timeout
+func readWait(r *http.Request) (time.Duration, error) {
+	value := r.URL.Query().Get("wait")
+	if value == "" {
+		return 0, nil
+	}
+
+	wait, err := time.ParseDuration(value)
+	if err != nil || wait < 0 {
+		return 0, errors.New("invalid wait duration: " + value)
+	}
+	if max := config.GetRequestTimeout(); wait > max {
+		wait = max
+	}
+	return wait, nil
+}
+
+// submissionStatus returns the given status if the batch is committed, 202 Accepted while it's pending
+func submissionStatus(batch batches.Batch, committed int) int {
+	if batch.Status == blockchain.BatchCommitted {
+		return committed
+	}
+	return http.StatusAccepted
+}
+
+// batchLocation returns the path where the status of the batch is served
+func batchLocation(batchID string) string {
+	return "/api/batches/" + batchID
+}
+
+func (ser server) getBatch(w http.ResponseWriter, r *http.Request) {
+	if err := auth.ValidateScope(r, "docs.read"); err != nil {
+		ser.unauthorizedRequest(w, err.Error())
+		return
+	}
+
+	batchID := normalize(mux.Vars(r)["batchID"])
+	if strings.Contains(r.Header.Get("Accept"), contentTypeEventStream) {
+		ser.streamBatch(w, r, batchID)
+		return
+	}
+
+	batch, err := ser.app.GetBatch(r.Context(), auth.GetSubject(r), batchID)
+	if err != nil {
+		ser.appError(w, "getting the batch failed: ", err)
+		return
+	}
+
+	ser.respondJSON(w, http.StatusOK, newRetrivedBatch(batch))
+}
+
+// streamBatch sends the batch and then each of its changes as server-sent events, until the batch is final
+func (ser server) streamBatch(w http.ResponseWriter, r *http.Request, batchID string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		ser.serverError(w, "streaming is not supported")
+		return
+	}
+
+	batch, changes, stop, err := ser.app.WatchBatch(r.Context(), auth.GetSubject(r), batchID)
+	if err != nil {
+		ser.appError(w, "getting the batch failed: ", err)
+		return
+	}
+	defer stop()
+
+	w.Header().Set("Content-Type", contentTypeEventStream)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	if err := writeBatchEvent(w, batch); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+	for changes != nil {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+
+		case batch, ok := <-changes:
+			if !ok {
+				return
+			}
+			if err := writeBatchEvent(w, batch); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func writeBatchEvent(w http.ResponseWriter, batch batches.Batch) error {
+	data, err := json.Marshal(newRetrivedBatch(batch))
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: status\ndata: %s\n\n", data)
+	return err
+}
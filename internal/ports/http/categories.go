@@ -0,0 +1,131 @@
+package http
+
+import (
+	"doc-management/internal/paging"
+	"doc-management/internal/ports/http/middleware/auth"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	nextCursorHeader = "X-Next-Cursor"
+	totalCountHeader = "X-Total-Count"
+)
+
+type retrievedCategory struct {
+	Name          string    `json:"name"`
+	DocumentCount int       `json:"documentCount"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+type retrievedCatalogDoc struct {
+	Name          string    `json:"name"`
+	Category      string    `json:"category"`
+	LatestVersion int       `json:"latestVersion"`
+	Status        string    `json:"status"`
+	Author        string    `json:"author"`
+	FileName      string    `json:"fileName"`
+	ContentType   string    `json:"contentType"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+func (ser server) getCategories(w http.ResponseWriter, r *http.Request) {
+	if err := auth.ValidateScope(r, "docs.read"); err != nil {
+		ser.unauthorizedRequest(w, err.Error())
+		return
+	}
+
+	page, err := ser.readPageParams(r)
+	if err != nil {
+		ser.badRequest(w, err.Error())
+		return
+	}
+
+	categories, next, total, err := ser.app.GetCategories(r.Context(), auth.GetSubject(r), page)
+	if err != nil {
+		ser.appError(w, "", err)
+		return
+	}
+
+	retCategories := make([]retrievedCategory, len(categories))
+	for i, category := range categories {
+		retCategories[i] = retrievedCategory{
+			Name:          category.Name,
+			DocumentCount: category.DocumentCount,
+			UpdatedAt:     category.UpdatedAt,
+		}
+	}
+
+	ser.respondPage(w, retCategories, next, total)
+}
+
+func (ser server) getCategoryDocs(w http.ResponseWriter, r *http.Request) {
+	if err := auth.ValidateScope(r, "docs.read"); err != nil {
+		ser.unauthorizedRequest(w, err.Error())
+		return
+	}
+
+	category := normalize(mux.Vars(r)["category"])
+	if category == "" {
+		ser.badRequest(w, "category needs to be given")
+		return
+	}
+
+	page, err := ser.readPageParams(r)
+	if err != nil {
+		ser.badRequest(w, err.Error())
+		return
+	}
+	ser.logger.Debug("getting docs of category " + category)
+
+	docs, next, total, err := ser.app.GetCategoryDocuments(r.Context(), auth.GetSubject(r), category, page)
+	if err != nil {
+		ser.appError(w, "", err)
+		return
+	}
+
+	retDocs := make([]retrievedCatalogDoc, len(docs))
+	for i, doc := range docs {
+		retDocs[i] = retrievedCatalogDoc{
+			Name:          doc.DocumentName,
+			Category:      doc.Category,
+			LatestVersion: doc.LatestVersion,
+			Status:        doc.Status.String(),
+			Author:        doc.Author,
+			FileName:      doc.FileName,
+			ContentType:   doc.ContentType,
+			UpdatedAt:     doc.UpdatedAt,
+		}
+	}
+
+	ser.respondPage(w, retDocs, next, total)
+}
+
+func (ser server) readPageParams(r *http.Request) (paging.Page, error) {
+	queryParams := r.URL.Query()
+
+	return paging.NewPage(normalize(queryParams.Get("cursor")), normalize(queryParams.Get("limit")))
+}
+
+// respondPage writes the items as a JSON array, the paging information is passed in the headers
+func (ser server) respondPage(w http.ResponseWriter, items interface{}, next string, total int64) {
+	response, err := json.Marshal(items)
+	if err != nil {
+		ser.serverError(w, "marshalling the response failed: "+err.Error())
+		return
+	}
+
+	if next != "" {
+		w.Header().Set(nextCursorHeader, next)
+	}
+	w.Header().Set(totalCountHeader, fmt.Sprint(total))
+
+	if _, err := w.Write(response); err != nil {
+		ser.serverError(w, "failed to write the response: "+err.Error())
+		return
+	}
+}
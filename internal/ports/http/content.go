@@ -0,0 +1,154 @@
+package http
+
+import (
+	"doc-management/internal/app"
+	"doc-management/internal/blockchain"
+	"doc-management/internal/hashing"
+	"doc-management/internal/rbac"
+	"doc-management/internal/repository"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"time"
+)
+
+const (
+	contentTypeOctetStream = "application/octet-stream"
+
+	// the number of bytes http.DetectContentType considers
+	sniffLen = 512
+	// the uploaded files bigger than this are spooled to temporary files instead of memory
+	maxUploadMemory = 1 << 20
+	// allowance for the form fields and the multipart boundaries on top of the max content size
+	maxFormOverhead = 1 << 20
+)
+
+var errContentTooLarge = errors.New("the content is too large")
+
+// serveContent writes the whole verified content or its requested range;
+// the content hash is used as the ETag, so the conditional requests are handled as well
+func (ser server) serveContent(w http.ResponseWriter, r *http.Request, fileName, contentType, contentHash string, content io.ReadSeeker) {
+	if contentType == "" {
+		var err error
+		if contentType, err = sniffContentType(content); err != nil {
+			ser.serverError(w, "reading the content failed: "+err.Error())
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", `"`+contentHash+`"`)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+
+	http.ServeContent(w, r, fileName, time.Time{}, content)
+}
+
+// sniffContentType detects the content type from the beginning of the content, leaves the content rewound
+func sniffContentType(content io.ReadSeeker) (string, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(content, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	return http.DetectContentType(head[:n]), nil
+}
+
+// readUpload reads the uploaded file, spooled to disk by the multipart reader if it's big, to compute its hash;
+// returns the file rewound, to be read again when it's stored
+func readUpload(file multipart.File, header *multipart.FileHeader) (contentHash string, contentType string, err error) {
+	contentHash, size, err := hashing.CalculateSHA512From(file)
+	if err != nil {
+		return "", "", errors.New("failed to read the proposal file: " + err.Error())
+	}
+	if size != header.Size {
+		return "", "", fmt.Errorf("upload error: size of received file: %v, size declared in the header: %v", size, header.Size)
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", "", errors.New("failed to read the proposal file: " + err.Error())
+	}
+	sniffed, err := sniffContentType(file)
+	if err != nil {
+		return "", "", errors.New("failed to read the proposal file: " + err.Error())
+	}
+
+	return contentHash, declaredContentType(sniffed, header.Header.Get("Content-Type")), nil
+}
+
+// declaredContentType returns the sniffed content type of the uploaded file; office documents are recognized
+// only as zip archives, in such cases the content type declared by the client is more specific
+func declaredContentType(detected string, declared string) string {
+	if declared == "" || declared == contentTypeOctetStream {
+		return detected
+	}
+
+	if detected == contentTypeOctetStream || detected == "application/zip" {
+		return declared
+	}
+
+	return detected
+}
+
+// limitedBody fails the reads once more than the limit is read; unlike http.MaxBytesReader,
+// the exceeded limit can be told apart from the other read errors
+type limitedBody struct {
+	io.ReadCloser
+	left     int64
+	exceeded bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, errContentTooLarge
+	}
+
+	// one byte over the limit is enough to tell it's exceeded
+	if int64(len(p)) > b.left+1 {
+		p = p[:b.left+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.left {
+		b.exceeded = true
+		n = int(b.left)
+		b.left = 0
+		return n, errContentTooLarge
+	}
+	b.left -= int64(n)
+
+	return n, err
+}
+
+// uploadedContent returns nil for no file, not a nil file in a non-nil interface
+func uploadedContent(file multipart.File) io.Reader {
+	if file == nil {
+		return nil
+	}
+	return file
+}
+
+func fileNameOrDefault(fileName, defaultName string) string {
+	if fileName == "" {
+		return defaultName
+	}
+	return fileName
+}
+
+func (ser server) contentError(w http.ResponseWriter, err error) {
+	switch err {
+	case blockchain.ErrNotFound, app.ErrNoContent, repository.ErrContentNotFound:
+		ser.notFound(w, err.Error())
+	case rbac.ErrForbidden:
+		ser.forbidden(w, err.Error())
+	case app.ErrContentInvalid:
+		ser.serverError(w, err.Error())
+	default:
+		ser.appError(w, "getting the content failed: ", err)
+	}
+}
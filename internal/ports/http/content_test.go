@@ -0,0 +1,297 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"doc-management/internal/app"
+	"doc-management/internal/blockchain"
+	"doc-management/internal/catalog"
+	"doc-management/internal/hashing"
+	"doc-management/internal/keystore"
+	"doc-management/internal/model"
+	"doc-management/internal/projection"
+	"doc-management/internal/rbac"
+	"doc-management/internal/repository"
+	"doc-management/internal/repository/filesystem"
+	"doc-management/internal/search"
+	"doc-management/internal/usermanager"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/sawtooth-sdk-go/signing"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const testUserID = "some-user"
+
+// fakeProjection serves the proposals and the doc versions given in the test
+type fakeProjection struct {
+	projection.Repository
+	proposals map[string]model.Proposal
+	docs      map[string]model.Document
+}
+
+func (p *fakeProjection) GetProposal(ctx context.Context, proposalID string) (model.Proposal, error) {
+	proposal, ok := p.proposals[proposalID]
+	if !ok {
+		return model.Proposal{}, blockchain.ErrNotFound
+	}
+	return proposal, nil
+}
+
+func (p *fakeProjection) GetDocProposals(ctx context.Context, category, docName string) ([]model.Proposal, error) {
+	return nil, blockchain.ErrNotFound
+}
+
+func (p *fakeProjection) GetDocumentVersion(ctx context.Context, category, docName string, version int) (model.Document, error) {
+	doc, ok := p.docs[repository.DocVersionRef(category, docName, version)]
+	if !ok {
+		return model.Document{}, blockchain.ErrNotFound
+	}
+	return doc, nil
+}
+
+// fakeStatuses records the doc statuses set in the catalog and in the index
+type fakeStatuses struct {
+	catalog.Catalog
+	search.Index
+	statuses map[string]model.DocStatus
+}
+
+func (s *fakeStatuses) UpdateDocumentStatus(ctx context.Context, category, docName string, version int, status model.DocStatus) error {
+	s.statuses[repository.DocVersionRef(category, docName, version)] = status
+	return nil
+}
+
+func (s *fakeStatuses) SetStatus(ctx context.Context, category, docName string, version int, status model.DocStatus) error {
+	return nil
+}
+
+// noSigners fails to sign, the app can't submit anything
+type noSigners struct {
+	keystore.Signers
+}
+
+func (noSigners) Signer(ctx context.Context, subject rbac.Subject, onBehalfOf string, op keystore.Operation) (*signing.Signer, error) {
+	return nil, errors.New("no keys")
+}
+
+type contentServer struct {
+	router   *mux.Router
+	store    repository.RewritableStore
+	reader   *fakeProjection
+	statuses *fakeStatuses
+}
+
+func newContentServer(t *testing.T) contentServer {
+	hashing.Initialize(zap.NewNop())
+	// the content is stored without the blockchain in the client signing mode
+	viper.Set("CLIENT_SIGNING", true)
+	t.Cleanup(func() {
+		viper.Set("CLIENT_SIGNING", false)
+		viper.Set("CONTENT_MAX_SIZE", 0)
+	})
+
+	blobs, err := filesystem.NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	cs := contentServer{
+		store:    repository.NewRefCountedStore(blobs),
+		reader:   &fakeProjection{proposals: make(map[string]model.Proposal), docs: make(map[string]model.Document)},
+		statuses: &fakeStatuses{statuses: make(map[string]model.DocStatus)},
+		router:   mux.NewRouter(),
+	}
+
+	a := app.NewApp(zap.NewNop(), app.Deps{
+		Content:   cs.store,
+		Projected: cs.reader,
+		Catalog:   cs.statuses,
+		Index:     cs.statuses,
+		Policy:    rbac.Policy{DefaultRole: rbac.RoleProposer},
+		Signers:   noSigners{},
+	})
+	NewServer(zap.NewNop(), &a, "", usermanager.TokenParams{}).registerHandlers(cs.router)
+
+	return cs
+}
+
+func (cs contentServer) serve(r *http.Request, scope string) *httptest.ResponseRecorder {
+	ctx := context.WithValue(r.Context(), "userID", testUserID)
+	ctx = context.WithValue(ctx, "scopes", []string{scope})
+
+	response := httptest.NewRecorder()
+	cs.router.ServeHTTP(response, r.WithContext(ctx))
+	return response
+}
+
+// addDoc adds the active doc version of the content to the projection
+func (cs contentServer) addDoc(contentHash string) model.Document {
+	doc := model.Document{
+		DocumentName: "contract",
+		Category:     "legal",
+		Version:      1,
+		Status:       model.DocStatusActive,
+		ContentHash:  contentHash,
+		FileName:     "contract.pdf",
+		ContentType:  "application/pdf",
+	}
+	cs.reader.docs[repository.DocVersionRef(doc.Category, doc.DocumentName, doc.Version)] = doc
+	return doc
+}
+
+func newUpload(t *testing.T, fileName string, content []byte) *http.Request {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	require.NoError(t, form.WriteField("category", "legal"))
+
+	if content != nil {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="docFile"; filename="%s"`, fileName))
+		header.Set("Content-Type", "application/pdf")
+		part, err := form.CreatePart(header)
+		require.NoError(t, err)
+		_, err = part.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, form.Close())
+
+	r := httptest.NewRequest(http.MethodPut, "/api/proposals/contract", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	return r
+}
+
+func randomContent(t *testing.T, size int) []byte {
+	content := make([]byte, size)
+	_, err := rand.Read(content)
+	require.NoError(t, err)
+	return content
+}
+
+func TestUploadProposal(t *testing.T) {
+	cs := newContentServer(t)
+	// bigger than kept in memory while the form is parsed
+	content := randomContent(t, 2*maxUploadMemory)
+
+	response := cs.serve(newUpload(t, "contract.pdf", content), "docs.write")
+	require.Equal(t, http.StatusAccepted, response.Code, response.Body.String())
+
+	var unsigned unsignedTransaction
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &unsigned))
+	assert.Equal(t, hashing.CalculateSHA512(string(content)), unsigned.ContentHash)
+
+	stored, err := cs.store.GetContent(context.TODO(), unsigned.ContentHash)
+	require.NoError(t, err)
+	assert.Equal(t, content, stored)
+}
+
+func TestUploadProposalTooLarge(t *testing.T) {
+	cs := newContentServer(t)
+	viper.Set("CONTENT_MAX_SIZE", 1024)
+
+	response := cs.serve(newUpload(t, "contract.pdf", randomContent(t, 2048)), "docs.write")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, response.Code)
+
+	// the form exceeds the allowance for the fields as well, the size isn't known in advance
+	r := newUpload(t, "contract.pdf", randomContent(t, 1024+maxFormOverhead))
+	r.ContentLength = -1
+	response = cs.serve(r, "docs.write")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, response.Code)
+
+	stored, err := cs.store.ListContent(context.TODO())
+	require.NoError(t, err)
+	assert.Empty(t, stored)
+}
+
+func TestUploadProposalWithoutFile(t *testing.T) {
+	cs := newContentServer(t)
+
+	response := cs.serve(newUpload(t, "", nil), "docs.write")
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+
+	stored, err := cs.store.ListContent(context.TODO())
+	require.NoError(t, err)
+	assert.Empty(t, stored)
+}
+
+func TestDownloadDocContent(t *testing.T) {
+	cs := newContentServer(t)
+	content := randomContent(t, 4096)
+	hash := hashing.CalculateSHA512(string(content))
+	require.NoError(t, cs.store.PutContent(context.TODO(), hash, content, repository.DocVersionRef("legal", "contract", 1)))
+	cs.addDoc(hash)
+
+	response := cs.serve(httptest.NewRequest(http.MethodGet, "/api/docs/legal/contract/1/content", nil), "docs.read")
+	require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+	assert.Equal(t, content, response.Body.Bytes())
+	assert.Equal(t, "application/pdf", response.Header().Get("Content-Type"))
+	assert.Equal(t, `"`+hash+`"`, response.Header().Get("ETag"))
+	assert.Equal(t, `attachment; filename=contract.pdf`, response.Header().Get("Content-Disposition"))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/docs/legal/contract/1/content", nil)
+	r.Header.Set("Range", "bytes=100-199")
+	response = cs.serve(r, "docs.read")
+	require.Equal(t, http.StatusPartialContent, response.Code)
+	assert.Equal(t, content[100:200], response.Body.Bytes())
+
+	r = httptest.NewRequest(http.MethodGet, "/api/docs/legal/contract/1/content", nil)
+	r.Header.Set("If-None-Match", `"`+hash+`"`)
+	response = cs.serve(r, "docs.read")
+	assert.Equal(t, http.StatusNotModified, response.Code)
+	assert.Empty(t, response.Body.Bytes())
+}
+
+func TestDownloadProposalContent(t *testing.T) {
+	cs := newContentServer(t)
+	content := []byte("%PDF-1.4 mala agatka")
+	hash := hashing.CalculateSHA512(string(content))
+	require.NoError(t, cs.store.PutContent(context.TODO(), hash, content, repository.ProposalRef("some-proposal")))
+	cs.reader.proposals["some-proposal"] = model.Proposal{ProposalID: "some-proposal", DocumentName: "contract", Category: "legal", ContentHash: hash}
+
+	response := cs.serve(httptest.NewRequest(http.MethodGet, "/api/proposals/some-proposal/content", nil), "docs.read")
+	require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+	assert.Equal(t, content, response.Body.Bytes())
+	// detected if not stored with the proposal
+	assert.Equal(t, "application/pdf", response.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename=contract`, response.Header().Get("Content-Disposition"))
+
+	response = cs.serve(httptest.NewRequest(http.MethodGet, "/api/proposals/other-proposal/content", nil), "docs.read")
+	assert.Equal(t, http.StatusNotFound, response.Code)
+}
+
+func TestDownloadMissingContent(t *testing.T) {
+	cs := newContentServer(t)
+	cs.addDoc(hashing.CalculateSHA512("mala agatka"))
+
+	response := cs.serve(httptest.NewRequest(http.MethodGet, "/api/docs/legal/contract/1/content", nil), "docs.read")
+	assert.Equal(t, http.StatusNotFound, response.Code)
+
+	response = cs.serve(httptest.NewRequest(http.MethodGet, "/api/docs/legal/contract/2/content", nil), "docs.read")
+	assert.Equal(t, http.StatusNotFound, response.Code)
+}
+
+func TestDownloadContentHashMismatch(t *testing.T) {
+	cs := newContentServer(t)
+	hash := hashing.CalculateSHA512("mala agatka")
+	tampered := []byte("mniejsza agatka")
+	require.NoError(t, cs.store.PutContent(context.TODO(), hash, tampered, repository.DocVersionRef("legal", "contract", 1)))
+	doc := cs.addDoc(hash)
+
+	response := cs.serve(httptest.NewRequest(http.MethodGet, "/api/docs/legal/contract/1/content", nil), "docs.read")
+	assert.Equal(t, http.StatusInternalServerError, response.Code)
+	assert.NotContains(t, response.Body.String(), string(tampered))
+	assert.Empty(t, response.Header().Get("ETag"))
+
+	// the doc version is invalidated
+	assert.Equal(t, model.DocStatusInvalid, cs.statuses.statuses[repository.DocVersionRef(doc.Category, doc.DocumentName, doc.Version)])
+}
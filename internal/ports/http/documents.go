@@ -3,8 +3,11 @@ package http
 import (
 	"doc-management/internal/model"
 	"doc-management/internal/ports/http/middleware/auth"
+	"doc-management/internal/search"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/gorilla/mux"
 )
@@ -17,6 +20,10 @@ type retrivedDocVersion struct {
 	Content    string `json:"content"`
 	Author     string `json:"author"`
 	Status     string `json:"status"`
+
+	FileName    string `json:"fileName"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
 }
 
 func (r *retrivedDocVersion) assign(doc model.Document) {
@@ -26,6 +33,9 @@ func (r *retrivedDocVersion) assign(doc model.Document) {
 	r.Version = doc.Version
 	r.Author = doc.Author
 	r.Status = string(doc.Status)
+	r.FileName = doc.FileName
+	r.ContentType = doc.ContentType
+	r.Size = doc.ContentSize
 
 	// limit the content length to display
 	if len(doc.Content) > 80 {
@@ -49,15 +59,39 @@ func (ser server) getDocVersions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	docs, err := ser.app.GetDocumentVersions(r.Context(), docName, category)
+	docs, err := ser.app.GetDocumentVersions(r.Context(), auth.GetSubject(r), docName, category)
 	if err != nil {
-		ser.serverError(w, err.Error())
+		ser.appError(w, "", err)
 		return
 	}
 
 	ser.respondDocRequest(w, docs)
 }
 
+func (ser server) getDocContent(w http.ResponseWriter, r *http.Request) {
+	if err := auth.ValidateScope(r, "docs.read"); err != nil {
+		ser.unauthorizedRequest(w, err.Error())
+		return
+	}
+
+	docName, category := ser.readGetDocVersionParams(r)
+	version, err := strconv.Atoi(normalize(mux.Vars(r)["version"]))
+	if err != nil || version < 1 {
+		ser.badRequest(w, "invalid doc version")
+		return
+	}
+	ser.logger.Debug("getting content of doc " + docName + ", category " + category + ", version " + strconv.Itoa(version))
+
+	doc, content, err := ser.app.OpenDocumentVersion(r.Context(), auth.GetSubject(r), docName, category, version)
+	if err != nil {
+		ser.contentError(w, err)
+		return
+	}
+	defer content.Close()
+
+	ser.serveContent(w, r, fileNameOrDefault(doc.FileName, doc.DocumentName), doc.ContentType, doc.ContentHash, content)
+}
+
 func (ser server) getDocuments(w http.ResponseWriter, r *http.Request) {
 	if err := auth.ValidateScope(r, "docs.read"); err != nil {
 		ser.unauthorizedRequest(w, err.Error())
@@ -72,9 +106,9 @@ func (ser server) getDocuments(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	docs, err := ser.app.GetDocuments(r.Context(), author, signer)
+	docs, err := ser.app.GetDocuments(r.Context(), auth.GetSubject(r), author, signer)
 	if err != nil {
-		ser.serverError(w, err.Error())
+		ser.appError(w, "", err)
 		return
 	}
 
@@ -116,3 +150,92 @@ func (ser server) respondDocRequest(w http.ResponseWriter, docs []model.Document
 		return
 	}
 }
+
+type searchHit struct {
+	Name        string  `json:"name"`
+	Category    string  `json:"category"`
+	Version     int     `json:"version"`
+	Author      string  `json:"author"`
+	Status      string  `json:"status"`
+	FileName    string  `json:"fileName"`
+	ContentType string  `json:"contentType"`
+	Score       float64 `json:"score"`
+	Snippet     string  `json:"snippet"`
+}
+
+func (ser server) searchDocuments(w http.ResponseWriter, r *http.Request) {
+	if err := auth.ValidateScope(r, "docs.read"); err != nil {
+		ser.unauthorizedRequest(w, err.Error())
+		return
+	}
+
+	query, err := ser.readSearchDocsParams(r)
+	if err != nil {
+		ser.badRequest(w, err.Error())
+		return
+	}
+	ser.logger.Debug("searching docs, query {" + query.Text + "}, category {" + query.Category + "}, author {" + query.Author + "}, status {" + query.Status.String() + "}")
+
+	hits, err := ser.app.SearchDocuments(r.Context(), auth.GetSubject(r), query)
+	if err == search.ErrEmptyQuery {
+		ser.badRequest(w, err.Error())
+		return
+	}
+	if err != nil {
+		ser.appError(w, "", err)
+		return
+	}
+
+	retHits := make([]searchHit, len(hits))
+	for i, hit := range hits {
+		retHits[i] = searchHit{
+			Name:        hit.DocumentName,
+			Category:    hit.Category,
+			Version:     hit.Version,
+			Author:      hit.Author,
+			Status:      hit.Status.String(),
+			FileName:    hit.FileName,
+			ContentType: hit.ContentType,
+			Score:       hit.Score,
+			Snippet:     hit.Snippet,
+		}
+	}
+
+	response, err := json.Marshal(retHits)
+	if err != nil {
+		ser.serverError(w, "marshalling the response failed: "+err.Error())
+		return
+	}
+
+	if _, err := w.Write(response); err != nil {
+		ser.serverError(w, "failed to write the response: "+err.Error())
+		return
+	}
+}
+
+func (ser server) readSearchDocsParams(r *http.Request) (query search.Query, err error) {
+	queryParams := r.URL.Query()
+
+	query.Text = normalize(queryParams.Get("q"))
+	query.Category = normalize(queryParams.Get("category"))
+	query.Author = normalize(queryParams.Get("author"))
+	query.Status = model.DocStatus(normalize(queryParams.Get("status")))
+
+	if allVersions := normalize(queryParams.Get("allVersions")); allVersions != "" {
+		if query.AllVersions, err = strconv.ParseBool(allVersions); err != nil {
+			return query, errors.New("invalid allVersions param: " + allVersions)
+		}
+	}
+
+	if limit := normalize(queryParams.Get("limit")); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil {
+			return query, errors.New("invalid limit param: " + limit)
+		}
+	}
+
+	if err := query.Normalize(); err != nil {
+		return query, err
+	}
+
+	return query, nil
+}
@@ -0,0 +1,105 @@
+package http
+
+import (
+	"doc-management/internal/app"
+	"doc-management/internal/batches"
+	"doc-management/internal/blockchain"
+	"doc-management/internal/inbox"
+	"doc-management/internal/keystore"
+	"doc-management/internal/rbac"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// the codes of the errors in the response body
+const (
+	errorForbidden            = "forbidden"
+	errorInvalidBatch         = "invalid_batch"
+	errorNotFound             = "not_found"
+	errorConflict             = "conflict"
+	errorInvalidTransaction   = "invalid_transaction"
+	errorNotImplemented       = "not_implemented"
+	errorValidatorUnavailable = "validator_unavailable"
+	errorValidatorTimeout     = "validator_timeout"
+	errorInternal             = "internal_error"
+)
+
+// retryAfter is the number of seconds suggested to the client if the validator is unavailable
+const retryAfter = "5"
+
+type errorBody struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// set for the transactions rejected by the validator
+	BatchID       string `json:"batchID,omitempty"`
+	TransactionID string `json:"transactionID,omitempty"`
+}
+
+// appError responds with the status corresponding to the error returned by the app and the error in the JSON body
+func (ser server) appError(w http.ResponseWriter, message string, err error) {
+	var invalidTransaction *blockchain.InvalidTransactionError
+
+	switch {
+	case errors.Is(err, rbac.ErrForbidden):
+		ser.respondError(w, http.StatusForbidden, apiError{Code: errorForbidden, Message: err.Error()})
+
+	case errors.Is(err, blockchain.ErrInvalidBatch):
+		ser.respondError(w, http.StatusBadRequest, apiError{Code: errorInvalidBatch, Message: err.Error()})
+
+	case errors.Is(err, blockchain.ErrNotFound), errors.Is(err, app.ErrClientSigningDisabled),
+		errors.Is(err, keystore.ErrNotFound), errors.Is(err, batches.ErrNotFound), errors.Is(err, inbox.ErrNotFound):
+		ser.respondError(w, http.StatusNotFound, apiError{Code: errorNotFound, Message: err.Error()})
+
+	case errors.Is(err, keystore.ErrKeyChanged), errors.Is(err, keystore.ErrKeyRevoked),
+		errors.Is(err, app.ErrProposalExists), errors.Is(err, app.ErrAlreadySigned), errors.Is(err, app.ErrOwnProposal),
+		errors.Is(err, inbox.ErrNotDead):
+		ser.respondError(w, http.StatusConflict, apiError{Code: errorConflict, Message: err.Error()})
+
+	case errors.As(err, &invalidTransaction):
+		ser.respondError(w, http.StatusUnprocessableEntity, apiError{
+			Code:          errorInvalidTransaction,
+			Message:       invalidTransaction.Message,
+			BatchID:       invalidTransaction.BatchID,
+			TransactionID: invalidTransaction.TransactionID,
+		})
+
+	case errors.Is(err, keystore.ErrNotSupported), errors.Is(err, app.ErrReconcilerDisabled):
+		ser.respondError(w, http.StatusNotImplemented, apiError{Code: errorNotImplemented, Message: err.Error()})
+
+	case errors.Is(err, blockchain.ErrValidatorUnavailable):
+		w.Header().Set("Retry-After", retryAfter)
+		ser.respondError(w, http.StatusServiceUnavailable, apiError{Code: errorValidatorUnavailable, Message: message + err.Error()})
+
+	case errors.Is(err, blockchain.ErrValidatorTimeout):
+		ser.respondError(w, http.StatusGatewayTimeout, apiError{Code: errorValidatorTimeout, Message: message + err.Error()})
+
+	default:
+		ser.respondError(w, http.StatusInternalServerError, apiError{Code: errorInternal, Message: message + err.Error()})
+	}
+}
+
+func (ser server) respondError(w http.ResponseWriter, status int, apiErr apiError) {
+	if status >= http.StatusInternalServerError {
+		ser.logger.Error(apiErr.Message)
+	} else {
+		ser.logger.Warn(apiErr.Message)
+	}
+
+	response, err := json.Marshal(errorBody{Error: apiErr})
+	if err != nil {
+		ser.serverError(w, "marshalling the error failed: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if _, err := w.Write(response); err != nil {
+		ser.logger.Error("failed to write the response: " + err.Error())
+	}
+}
@@ -0,0 +1,91 @@
+package http
+
+import (
+	"doc-management/internal/inbox"
+	"doc-management/internal/ports/http/middleware/auth"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type receivedEvent struct {
+	ID            string     `json:"id"`
+	Type          string     `json:"type"`
+	Key           string     `json:"key"`
+	BlockID       string     `json:"blockID,omitempty"`
+	BlockNum      uint64     `json:"blockNum,omitempty"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty"`
+	LastError     string     `json:"lastError,omitempty"`
+	ReceivedAt    time.Time  `json:"receivedAt"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+}
+
+func newReceivedEvent(event inbox.Event) receivedEvent {
+	retEvent := receivedEvent{
+		ID:         event.ID,
+		Type:       event.Type,
+		Key:        event.Key,
+		BlockID:    event.BlockID,
+		BlockNum:   event.BlockNum,
+		Status:     string(event.Status),
+		Attempts:   event.Attempts,
+		LastError:  event.LastError,
+		ReceivedAt: event.ReceivedAt,
+		UpdatedAt:  event.UpdatedAt,
+	}
+	if event.Status == inbox.StatusPending {
+		retEvent.NextAttemptAt = &event.NextAttemptAt
+	}
+	return retEvent
+}
+
+func (ser server) getEvents(w http.ResponseWriter, r *http.Request) {
+	if err := auth.ValidateScope(r, "docs.read"); err != nil {
+		ser.unauthorizedRequest(w, err.Error())
+		return
+	}
+
+	status := inbox.StatusDead
+	if value := normalize(r.URL.Query().Get("status")); value != "" {
+		status = inbox.Status(value)
+	}
+	if !status.IsValid() {
+		ser.badRequest(w, "invalid status param: "+string(status))
+		return
+	}
+	limit, err := readLimit(r)
+	if err != nil {
+		ser.badRequest(w, err.Error())
+		return
+	}
+
+	events, err := ser.app.ListEvents(r.Context(), auth.GetSubject(r), status, limit)
+	if err != nil {
+		ser.appError(w, "listing the events failed: ", err)
+		return
+	}
+
+	retEvents := make([]receivedEvent, len(events))
+	for i, event := range events {
+		retEvents[i] = newReceivedEvent(event)
+	}
+	ser.respondJSON(w, http.StatusOK, retEvents)
+}
+
+func (ser server) replayEvent(w http.ResponseWriter, r *http.Request) {
+	if err := auth.ValidateScope(r, "docs.sign"); err != nil {
+		ser.unauthorizedRequest(w, err.Error())
+		return
+	}
+
+	eventID := normalize(mux.Vars(r)["eventID"])
+	event, err := ser.app.ReplayEvent(r.Context(), auth.GetSubject(r), eventID)
+	if err != nil {
+		ser.appError(w, "replaying the event failed: ", err)
+		return
+	}
+	ser.respondJSON(w, http.StatusOK, newReceivedEvent(event))
+}
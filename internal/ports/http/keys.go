@@ -0,0 +1,176 @@
+package http
+
+import (
+	"doc-management/internal/keystore"
+	"doc-management/internal/ports/http/middleware/auth"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type retrivedKey struct {
+	PublicKey  string     `json:"publicKey"`
+	Status     string     `json:"status"`
+	CreatedAt  *time.Time `json:"createdAt,omitempty"`
+	RetiredAt  *time.Time `json:"retiredAt,omitempty"`
+	RetiredBy  string     `json:"retiredBy,omitempty"`
+	ReplacedBy string     `json:"replacedBy,omitempty"`
+	Reason     string     `json:"reason,omitempty"`
+}
+
+func (k *retrivedKey) assign(info keystore.KeyInfo) {
+	k.PublicKey = info.PublicKey
+	k.Status = string(info.Status)
+	k.RetiredBy = info.RetiredBy
+	k.ReplacedBy = info.ReplacedBy
+	k.Reason = info.Reason
+	if !info.CreatedAt.IsZero() {
+		k.CreatedAt = &info.CreatedAt
+	}
+	if !info.RetiredAt.IsZero() {
+		k.RetiredAt = &info.RetiredAt
+	}
+}
+
+func (ser server) getKeys(w http.ResponseWriter, r *http.Request) {
+	if err := auth.ValidateScope(r, "docs.read"); err != nil {
+		ser.unauthorizedRequest(w, err.Error())
+		return
+	}
+
+	userID := normalize(mux.Vars(r)["userID"])
+	keys, err := ser.app.GetKeys(r.Context(), auth.GetSubject(r), userID)
+	if err != nil {
+		ser.appError(w, "getting the keys failed: ", err)
+		return
+	}
+
+	retKeys := make([]retrivedKey, len(keys))
+	for i, key := range keys {
+		retKeys[i].assign(key)
+	}
+	ser.respondJSON(w, http.StatusOK, retKeys)
+}
+
+func (ser server) rotateKey(w http.ResponseWriter, r *http.Request) {
+	if err := auth.ValidateScope(r, "docs.sign"); err != nil {
+		ser.unauthorizedRequest(w, err.Error())
+		return
+	}
+
+	userID := normalize(mux.Vars(r)["userID"])
+	body, err := readKeyChangeBody(r)
+	if err != nil {
+		ser.badRequest(w, err.Error())
+		return
+	}
+
+	key, err := ser.app.RotateKey(r.Context(), auth.GetSubject(r), userID, body.Reason)
+	if err != nil {
+		ser.appError(w, "rotating the key failed: ", err)
+		return
+	}
+
+	var retKey retrivedKey
+	retKey.assign(key)
+	ser.respondJSON(w, http.StatusOK, retKey)
+}
+
+func (ser server) revokeKey(w http.ResponseWriter, r *http.Request) {
+	if err := auth.ValidateScope(r, "docs.sign"); err != nil {
+		ser.unauthorizedRequest(w, err.Error())
+		return
+	}
+
+	userID := normalize(mux.Vars(r)["userID"])
+	body, err := readKeyChangeBody(r)
+	if err != nil {
+		ser.badRequest(w, err.Error())
+		return
+	}
+
+	key, err := ser.app.RevokeKey(r.Context(), auth.GetSubject(r), userID, normalize(body.PublicKey), body.Reason)
+	if err != nil {
+		ser.appError(w, "revoking the key failed: ", err)
+		return
+	}
+
+	var retKey retrivedKey
+	retKey.assign(key)
+	ser.respondJSON(w, http.StatusOK, retKey)
+}
+
+type keyCheck struct {
+	UserID          string `json:"userID"`
+	Source          string `json:"source"`
+	Status          string `json:"status"`
+	PublicKey       string `json:"publicKey,omitempty"`
+	StoredPublicKey string `json:"storedPublicKey,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+func (ser server) auditKeys(w http.ResponseWriter, r *http.Request) {
+	if err := auth.ValidateScope(r, "docs.read"); err != nil {
+		ser.unauthorizedRequest(w, err.Error())
+		return
+	}
+
+	ser.respondKeyChecks(w, r, false)
+}
+
+func (ser server) repairKeys(w http.ResponseWriter, r *http.Request) {
+	if err := auth.ValidateScope(r, "docs.sign"); err != nil {
+		ser.unauthorizedRequest(w, err.Error())
+		return
+	}
+
+	ser.respondKeyChecks(w, r, true)
+}
+
+func (ser server) respondKeyChecks(w http.ResponseWriter, r *http.Request, repair bool) {
+	checks, err := ser.app.AuditKeys(r.Context(), auth.GetSubject(r), repair)
+	if err != nil {
+		ser.appError(w, "auditing the keys failed: ", err)
+		return
+	}
+
+	retChecks := make([]keyCheck, len(checks))
+	for i, check := range checks {
+		retChecks[i] = keyCheck{
+			UserID:          check.UserID,
+			Source:          check.Source,
+			Status:          string(check.Status),
+			PublicKey:       check.PublicKey,
+			StoredPublicKey: check.StoredPublicKey,
+			Error:           check.Error,
+		}
+	}
+	ser.respondJSON(w, http.StatusOK, retChecks)
+}
+
+type keyChangeBody struct {
+	// the key to revoke, the current one if empty
+	PublicKey string `json:"publicKey"`
+	Reason    string `json:"reason"`
+}
+
+func readKeyChangeBody(r *http.Request) (keyChangeBody, error) {
+	var body keyChangeBody
+
+	bodyBytes, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return body, errors.New("can't read the request body: " + err.Error())
+	}
+	if len(bodyBytes) > 0 {
+		if err := json.Unmarshal(bodyBytes, &body); err != nil {
+			return body, errors.New("invalid body: " + err.Error())
+		}
+	}
+
+	return body, nil
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"context"
+	"doc-management/internal/rbac"
 	"errors"
 	"net/http"
 	"strings"
@@ -9,130 +10,225 @@ import (
 
 	"go.uber.org/multierr"
 	"go.uber.org/zap"
+	"gopkg.in/square/go-jose.v2"
 	"gopkg.in/square/go-jose.v2/jwt"
 )
 
-const policyName = "B2C_1_singin"
+const (
+	// the Azure AD object ID of the user
+	defaultUserIDClaim = "oid"
+	// the scopes in the Azure AD tokens, the OIDC providers usually use "scope"
+	defaultScopeClaim = "scp"
+)
+
+var ErrUnsupportedAlgorithm = errors.New("unsupported token signing algorithm")
+
+// the asymmetric algorithms only, the issuer's keys are public
+var allowedAlgorithms = map[string]bool{
+	string(jose.RS256): true, string(jose.RS384): true, string(jose.RS512): true,
+	string(jose.PS256): true, string(jose.PS384): true, string(jose.PS512): true,
+	string(jose.ES256): true, string(jose.ES384): true, string(jose.ES512): true,
+}
 
 type JwtTokenParams struct {
 	Issuer   string
 	Audience string
+	// B2C user flow expected in the tfp (or acr) claim, not checked if empty
+	PolicyName string
+	// tolerated clock difference when checking exp, nbf and iat
+	ClockSkew time.Duration
+	// claim holding the user ID, oid if empty
+	UserIDClaim string
+	// claim holding the granted scopes, scp if empty
+	ScopeClaim string
 }
 
 type TokenValidator struct {
 	JwtTokenParams
+	keys   *KeySet
 	logger *zap.Logger
 }
 
-func NewTokenValidator(logger *zap.Logger, params JwtTokenParams) TokenValidator {
-	return TokenValidator{logger: logger, JwtTokenParams: params}
+func NewTokenValidator(logger *zap.Logger, params JwtTokenParams, keys *KeySet) TokenValidator {
+	return TokenValidator{logger: logger, JwtTokenParams: params, keys: keys}
 }
 
 func (t TokenValidator) ValidateGetScopes(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		token := r.Header.Get("Authorization")
-		claims, err := parseToken(strings.TrimPrefix(token, "Bearer "))
+		claims, err := t.verifyToken(r.Context(), strings.TrimPrefix(token, "Bearer "))
 		if err != nil {
-			t.authError(w, errors.New("failed to parse the auth token: "+err.Error()))
+			t.authError(w, errors.New("auth token validation: "+err.Error()))
 			return
 		}
 
-		if err := t.validateClaims(claims); err != nil {
-			t.authError(w, errors.New("auth token validation: "+err.Error()))
+		// the identity of the user is always taken from the token
+		userIDClaim := t.UserIDClaim
+		if userIDClaim == "" {
+			userIDClaim = defaultUserIDClaim
+		}
+		user, ok := claims[userIDClaim].(string)
+		if !ok || user == "" {
+			t.authError(w, errors.New("auth token validation: user ID ("+userIDClaim+") is missing"))
 			return
 		}
 
-		// add user id and scopes to the request context
-		newCtx := r.Context()
-		if user, ok := claims["oid"]; ok {
-			newCtx = context.WithValue(newCtx, "userID", user)
+		scopeClaim := t.ScopeClaim
+		if scopeClaim == "" {
+			scopeClaim = defaultScopeClaim
+		}
+		scopes, err := parseScopes(claims[scopeClaim])
+		if err != nil {
+			t.authError(w, errors.New("auth token validation: invalid scopes ("+scopeClaim+"): "+err.Error()))
+			return
 		}
-		if scopes, ok := claims["scp"]; ok {
+
+		// add user id and scopes to the request context
+		newCtx := context.WithValue(r.Context(), "userID", user)
+		if scopes != nil {
 			newCtx = context.WithValue(newCtx, "scopes", scopes)
 		}
+		if groups, ok := claims["groups"].([]interface{}); ok {
+			newCtx = context.WithValue(newCtx, "groups", groups)
+		}
 
 		next.ServeHTTP(w, r.WithContext(newCtx))
 	})
 }
 
+// parseScopes reads the scopes given either as a space-separated string or as an array of strings,
+// nil if the claim is missing
+func parseScopes(claim interface{}) ([]string, error) {
+	switch value := claim.(type) {
+	case nil:
+		return nil, nil
+
+	case string:
+		return strings.Fields(value), nil
+
+	case []interface{}:
+		scopes := make([]string, 0, len(value))
+		for _, item := range value {
+			scope, ok := item.(string)
+			if !ok {
+				return nil, errors.New("the scope is not a string")
+			}
+			scopes = append(scopes, scope)
+		}
+		return scopes, nil
+
+	default:
+		return nil, errors.New("neither a string nor an array")
+	}
+}
+
 func (t TokenValidator) authError(w http.ResponseWriter, err error) {
 	t.logger.Warn(err.Error())
 	w.WriteHeader(http.StatusUnauthorized)
 	w.Write([]byte(err.Error()))
 }
 
-// dirty solution, should be done with an approperiate library;
-// validating azure tokens using their tools fails
-// and azure tokens are incompatible with the standard JWT validation libraries
-func (t TokenValidator) validateClaims(claims map[string]interface{}) (err error) {
-	if issuer, ok := claims["iss"]; ok {
-		issuerStr := issuer.(string)
-		if issuerStr != t.Issuer {
-			err = multierr.Append(err, errors.New("invalid issuer: "+issuerStr+", expected: "+t.Issuer))
-		}
-	} else {
-		err = multierr.Append(err, errors.New("issuer is missing"))
+// verifyToken checks the token signature with the issuer's key and validates the claims;
+// returns all the token claims
+func (t TokenValidator) verifyToken(ctx context.Context, tokenString string) (map[string]interface{}, error) {
+	token, err := jwt.ParseSigned(tokenString)
+	if err != nil {
+		return nil, errors.New("failed to parse the token: " + err.Error())
+	}
+	if len(token.Headers) != 1 {
+		return nil, errors.New("token needs to have exactly one signature")
 	}
 
-	if audience, ok := claims["aud"]; ok {
-		audienceStr := audience.(string)
-		if audienceStr != t.Audience {
-			err = multierr.Append(err, errors.New("invalid audience: "+audienceStr+", expected: "+t.Audience))
-		}
-	} else {
-		err = multierr.Append(err, errors.New("audience is missing"))
+	header := token.Headers[0]
+	if !allowedAlgorithms[header.Algorithm] {
+		return nil, ErrUnsupportedAlgorithm
 	}
 
-	if policy, ok := claims["tfp"]; ok {
-		policyStr := policy.(string)
-		if policyStr != policyName {
-			err = multierr.Append(err, errors.New("invalid policy name: "+policyStr))
-		}
-	} else {
-		err = multierr.Append(err, errors.New("policy is missing"))
+	key, err := t.keys.Key(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if key.Algorithm != "" && key.Algorithm != header.Algorithm {
+		return nil, errors.New("token algorithm " + header.Algorithm + " doesn't match the key algorithm " + key.Algorithm)
 	}
 
-	if exp, ok := claims["exp"]; ok {
+	var registered jwt.Claims
+	var claims map[string]interface{}
+	if err := token.Claims(key.Key, &registered, &claims); err != nil {
+		return nil, errors.New("invalid token signature: " + err.Error())
+	}
 
-		expFloat := exp.(float64)
+	if err := t.validateClaims(registered, claims); err != nil {
+		return nil, err
+	}
 
-		expiration := time.Unix(int64(expFloat), 0)
+	return claims, nil
+}
 
-		if expiration.Before(time.Now()) {
-			err = multierr.Append(err, errors.New("token expired, expiration time: "+expiration.String()))
-		}
-	} else {
+func (t TokenValidator) validateClaims(registered jwt.Claims, claims map[string]interface{}) (err error) {
+	issuer := t.Issuer
+	if issuer == "" {
+		issuer = t.keys.Issuer()
+	}
+	if issuer == "" {
+		err = multierr.Append(err, errors.New("expected issuer is not known"))
+	}
+	if registered.Expiry == nil {
 		err = multierr.Append(err, errors.New("expiration time is missing"))
 	}
 
+	expected := jwt.Expected{
+		Issuer:   issuer,
+		Audience: jwt.Audience{t.Audience},
+		Time:     time.Now(),
+	}
+	if validationErr := registered.ValidateWithLeeway(expected, t.ClockSkew); validationErr != nil {
+		err = multierr.Append(err, validationErr)
+	}
+
+	if t.PolicyName != "" {
+		policy, ok := claims["tfp"].(string)
+		if !ok {
+			policy, ok = claims["acr"].(string)
+		}
+		if !ok {
+			err = multierr.Append(err, errors.New("policy is missing"))
+		} else if !strings.EqualFold(policy, t.PolicyName) {
+			err = multierr.Append(err, errors.New("invalid policy name: "+policy))
+		}
+	}
+
 	return err
 }
 
-func parseToken(tokenString string) (map[string]interface{}, error) {
+// GetSubject returns the authenticated user with the groups listed in the token
+func GetSubject(r *http.Request) rbac.Subject {
+	subject := rbac.Subject{}
 
-	var claims map[string]interface{}
-
-	token, err := jwt.ParseSigned(tokenString)
-	if err != nil {
-		return nil, err
+	if userID, ok := r.Context().Value("userID").(string); ok {
+		subject.UserID = userID
 	}
-
-	if err := token.UnsafeClaimsWithoutVerification(&claims); err != nil {
-		return nil, err
+	if groups, ok := r.Context().Value("groups").([]interface{}); ok {
+		for _, group := range groups {
+			if groupStr, ok := group.(string); ok {
+				subject.Groups = append(subject.Groups, groupStr)
+			}
+		}
 	}
 
-	return claims, nil
+	return subject
 }
 
 func ValidateScope(r *http.Request, expectedScope string) error {
-	value := r.Context().Value("scopes")
-	if value == nil {
+	scopes, ok := r.Context().Value("scopes").([]string)
+	if !ok {
 		return errors.New("token scope is missing in the request context")
 	}
-	scopes := value.(string)
-	if !strings.Contains(scopes, expectedScope) {
-		return errors.New("expected scope " + expectedScope + " is missing: " + scopes)
+	for _, scope := range scopes {
+		if scope == expectedScope {
+			return nil
+		}
 	}
 
-	return nil
+	return errors.New("expected scope " + expectedScope + " is missing: " + strings.Join(scopes, " "))
 }
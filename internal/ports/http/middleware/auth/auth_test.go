@@ -0,0 +1,351 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	testIssuer   = "https://issuer.example.com/v2.0/"
+	testAudience = "client-id"
+	testPolicy   = "B2C_1_singin"
+)
+
+// jwksServer serves the discovery document and the public keys of the issuer
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    []jose.JSONWebKey
+	fetches int
+	failing bool
+	// the key set is served once the channel is closed, if set
+	release chan struct{}
+}
+
+func newJwksServer(t *testing.T) *jwksServer {
+	s := &jwksServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"issuer": testIssuer, "jwks_uri": s.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.fetches++
+		release := s.release
+		s.mu.Unlock()
+		if release != nil {
+			<-release
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: s.keys})
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) publish(key jose.JSONWebKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = append(s.keys, key.Public())
+}
+
+func newSigningKey(t *testing.T, keyID string) jose.JSONWebKey {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return jose.JSONWebKey{Key: private, KeyID: keyID, Algorithm: string(jose.RS256), Use: "sig"}
+}
+
+func sign(t *testing.T, key jose.JSONWebKey, claims map[string]interface{}) string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, (&jose.SignerOptions{}).WithType("JWT"))
+	require.NoError(t, err)
+	token, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	require.NoError(t, err)
+	return token
+}
+
+func validClaims() map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss": testIssuer,
+		"aud": testAudience,
+		"tfp": testPolicy,
+		"oid": "user-1",
+		"scp": "docs.read",
+		"exp": now.Add(time.Hour).Unix(),
+		"nbf": now.Add(-time.Minute).Unix(),
+		"iat": now.Add(-time.Minute).Unix(),
+	}
+}
+
+func newValidator(server *jwksServer) TokenValidator {
+	keys := NewKeySet(server.URL + "/.well-known/openid-configuration")
+	keys.minRefreshInterval = 0
+	return NewTokenValidator(zap.NewNop(), JwtTokenParams{
+		Audience:   testAudience,
+		PolicyName: testPolicy,
+		ClockSkew:  time.Minute,
+	}, keys)
+}
+
+// request sends the token through the middleware, returns the response status and the user ID passed to the handler
+func request(validator TokenValidator, token string) (int, string) {
+	var userID string
+	handler := validator.ValidateGetScopes(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID = GetSubject(r).UserID
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/docs", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	return w.Code, userID
+}
+
+func TestValidToken(t *testing.T) {
+	server := newJwksServer(t)
+	key := newSigningKey(t, "key-1")
+	server.publish(key)
+
+	status, userID := request(newValidator(server), sign(t, key, validClaims()))
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "user-1", userID)
+}
+
+func TestForgedToken(t *testing.T) {
+	server := newJwksServer(t)
+	server.publish(newSigningKey(t, "key-1"))
+
+	// the same key ID, but a different key
+	status, _ := request(newValidator(server), sign(t, newSigningKey(t, "key-1"), validClaims()))
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	status, _ = request(newValidator(server), sign(t, newSigningKey(t, "unknown"), validClaims()))
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
+
+func TestUnsignedToken(t *testing.T) {
+	server := newJwksServer(t)
+	server.publish(newSigningKey(t, "key-1"))
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: []byte("shared-secret-shared-secret-1234")}, nil)
+	require.NoError(t, err)
+	token, err := jwt.Signed(signer).Claims(validClaims()).CompactSerialize()
+	require.NoError(t, err)
+
+	status, _ := request(newValidator(server), token)
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
+
+func TestKeyRotation(t *testing.T) {
+	server := newJwksServer(t)
+	oldKey := newSigningKey(t, "key-1")
+	server.publish(oldKey)
+	validator := newValidator(server)
+
+	status, _ := request(validator, sign(t, oldKey, validClaims()))
+	require.Equal(t, http.StatusOK, status)
+
+	// the new key is fetched when a token signed by it arrives
+	newKey := newSigningKey(t, "key-2")
+	server.publish(newKey)
+	status, _ = request(validator, sign(t, newKey, validClaims()))
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestUnknownKeyRefreshLimited(t *testing.T) {
+	server := newJwksServer(t)
+	oldKey := newSigningKey(t, "key-1")
+	server.publish(oldKey)
+	validator := newValidator(server)
+	validator.keys.minRefreshInterval = time.Hour
+
+	status, _ := request(validator, sign(t, oldKey, validClaims()))
+	require.Equal(t, http.StatusOK, status)
+
+	newKey := newSigningKey(t, "key-2")
+	server.publish(newKey)
+	status, _ = request(validator, sign(t, newKey, validClaims()))
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
+
+func TestInvalidClaims(t *testing.T) {
+	server := newJwksServer(t)
+	key := newSigningKey(t, "key-1")
+	server.publish(key)
+	validator := newValidator(server)
+	now := time.Now()
+
+	cases := map[string]func(claims map[string]interface{}){
+		"expired":           func(c map[string]interface{}) { c["exp"] = now.Add(-2 * time.Minute).Unix() },
+		"no expiration":     func(c map[string]interface{}) { delete(c, "exp") },
+		"not valid yet":     func(c map[string]interface{}) { c["nbf"] = now.Add(2 * time.Minute).Unix() },
+		"issued in future":  func(c map[string]interface{}) { c["iat"] = now.Add(2 * time.Minute).Unix() },
+		"wrong issuer":      func(c map[string]interface{}) { c["iss"] = "https://other.example.com/" },
+		"wrong audience":    func(c map[string]interface{}) { c["aud"] = "other-client" },
+		"wrong policy":      func(c map[string]interface{}) { c["tfp"] = "B2C_1_other" },
+		"missing policy":    func(c map[string]interface{}) { delete(c, "tfp") },
+		"missing user ID":   func(c map[string]interface{}) { delete(c, "oid") },
+		"empty user ID":     func(c map[string]interface{}) { c["oid"] = "" },
+		"non-string userID": func(c map[string]interface{}) { c["oid"] = 12 },
+	}
+
+	for name, modify := range cases {
+		t.Run(name, func(t *testing.T) {
+			claims := validClaims()
+			modify(claims)
+			status, _ := request(validator, sign(t, key, claims))
+			assert.Equal(t, http.StatusUnauthorized, status)
+		})
+	}
+}
+
+func TestClockSkewTolerated(t *testing.T) {
+	server := newJwksServer(t)
+	key := newSigningKey(t, "key-1")
+	server.publish(key)
+	validator := newValidator(server)
+	now := time.Now()
+
+	claims := validClaims()
+	claims["exp"] = now.Add(-30 * time.Second).Unix()
+	claims["nbf"] = now.Add(30 * time.Second).Unix()
+	claims["iat"] = now.Add(30 * time.Second).Unix()
+
+	status, _ := request(validator, sign(t, key, claims))
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestScopeClaims(t *testing.T) {
+	server := newJwksServer(t)
+	key := newSigningKey(t, "key-1")
+	server.publish(key)
+
+	cases := map[string]struct {
+		scopeClaim string
+		claims     map[string]interface{}
+		status     int
+		scopeErr   bool
+	}{
+		"azure string":      {claims: map[string]interface{}{"scp": "docs.read docs.sign"}, status: http.StatusOK},
+		"oidc string":       {scopeClaim: "scope", claims: map[string]interface{}{"scope": "openid docs.read"}, status: http.StatusOK},
+		"array":             {claims: map[string]interface{}{"scp": []string{"docs.sign", "docs.read"}}, status: http.StatusOK},
+		"prefix only":       {claims: map[string]interface{}{"scp": "docs.readonly"}, status: http.StatusOK, scopeErr: true},
+		"other claim":       {claims: map[string]interface{}{"scope": "docs.read"}, status: http.StatusOK, scopeErr: true},
+		"non-string scope":  {claims: map[string]interface{}{"scp": []interface{}{"docs.read", 12}}, status: http.StatusUnauthorized},
+		"non-string claims": {claims: map[string]interface{}{"scp": 12}, status: http.StatusUnauthorized},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			validator := newValidator(server)
+			validator.ScopeClaim = c.scopeClaim
+			claims := validClaims()
+			delete(claims, "scp")
+			for claim, value := range c.claims {
+				claims[claim] = value
+			}
+
+			var scopeErr error
+			handler := validator.ValidateGetScopes(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				scopeErr = ValidateScope(r, "docs.read")
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/api/docs", nil)
+			r.Header.Set("Authorization", "Bearer "+sign(t, key, claims))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, c.status, w.Code)
+			if c.status == http.StatusOK {
+				assert.Equal(t, c.scopeErr, scopeErr != nil)
+			}
+		})
+	}
+}
+
+func (s *jwksServer) fetchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+func TestCachedKeysServedDuringRefresh(t *testing.T) {
+	server := newJwksServer(t)
+	key := newSigningKey(t, "key-1")
+	server.publish(key)
+	validator := newValidator(server)
+
+	status, _ := request(validator, sign(t, key, validClaims()))
+	require.Equal(t, http.StatusOK, status)
+
+	// a slow issuer, the token signed by an unknown key waits for the refresh
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+	defer releaseOnce.Do(func() { close(release) })
+	server.mu.Lock()
+	server.release = release
+	server.mu.Unlock()
+	unknown := make(chan int)
+	go func() {
+		status, _ := request(validator, sign(t, newSigningKey(t, "key-2"), validClaims()))
+		unknown <- status
+	}()
+	require.Eventually(t, func() bool { return server.fetchCount() == 2 }, time.Second, time.Millisecond)
+
+	// the cached keys are used meanwhile, with no other fetch
+	validator.keys.mu.Lock()
+	validator.keys.fetchedAt = time.Now().Add(-2 * defaultKeysTTL)
+	validator.keys.mu.Unlock()
+	status, _ = request(validator, sign(t, key, validClaims()))
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 2, server.fetchCount())
+
+	releaseOnce.Do(func() { close(release) })
+	assert.Equal(t, http.StatusUnauthorized, <-unknown)
+}
+
+func TestFailedRefreshRetriedAfterInterval(t *testing.T) {
+	server := newJwksServer(t)
+	key := newSigningKey(t, "key-1")
+	server.publish(key)
+	server.failing = true
+	validator := newValidator(server)
+	validator.keys.minRefreshInterval = time.Hour
+
+	status, _ := request(validator, sign(t, key, validClaims()))
+	require.Equal(t, http.StatusUnauthorized, status)
+	fetches := server.fetchCount()
+
+	// the issuer isn't asked again until the interval passes
+	status, _ = request(validator, sign(t, key, validClaims()))
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, fetches, server.fetchCount())
+
+	server.mu.Lock()
+	server.failing = false
+	server.mu.Unlock()
+	validator.keys.mu.Lock()
+	validator.keys.attemptedAt = time.Now().Add(-2 * time.Hour)
+	validator.keys.mu.Unlock()
+	status, _ = request(validator, sign(t, key, validClaims()))
+	assert.Equal(t, http.StatusOK, status)
+}
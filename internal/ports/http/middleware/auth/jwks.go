@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+const (
+	// the keys are refreshed at least this often, to drop the revoked ones
+	defaultKeysTTL = 24 * time.Hour
+	// an unknown key ID triggers a refresh at most this often, so invalid tokens can't flood the issuer
+	minKeysRefreshInterval = 1 * time.Minute
+	keysFetchTimeout       = 10 * time.Second
+)
+
+var ErrUnknownKey = errors.New("signing key not found in the issuer's key set")
+
+// KeySet holds the token signing keys published by the issuer. The keys are fetched from the jwks_uri
+// of the OpenID discovery document and refreshed when a token is signed by an unknown key or the cache expires.
+// A single fetch runs at a time, without blocking the requests served by the cached keys.
+type KeySet struct {
+	discoveryURL string
+	client       *http.Client
+	// minimal interval between the refreshes caused by unknown key IDs, also after a failed refresh
+	minRefreshInterval time.Duration
+
+	mu sync.Mutex
+	// read from the discovery document
+	issuer    string
+	jwksURI   string
+	keys      map[string]jose.JSONWebKey
+	fetchedAt time.Time
+	// the last refresh attempt and its error, if it failed
+	attemptedAt time.Time
+	lastErr     error
+	// the refresh in progress, nil if there is none
+	refreshing *refreshCall
+}
+
+type refreshCall struct {
+	done chan struct{}
+	err  error
+}
+
+func NewKeySet(discoveryURL string) *KeySet {
+	return &KeySet{
+		discoveryURL:       discoveryURL,
+		client:             &http.Client{Timeout: keysFetchTimeout},
+		minRefreshInterval: minKeysRefreshInterval,
+	}
+}
+
+type discoveryDocument struct {
+	Issuer  string `json:"issuer"`
+	JwksURI string `json:"jwks_uri"`
+}
+
+// Key returns the signing key with the given key ID
+func (k *KeySet) Key(ctx context.Context, keyID string) (jose.JSONWebKey, error) {
+	k.mu.Lock()
+	key, ok := k.keys[keyID]
+	expired := time.Since(k.fetchedAt) > defaultKeysTTL
+	canRefresh := time.Since(k.attemptedAt) >= k.minRefreshInterval
+	lastErr := k.lastErr
+	k.mu.Unlock()
+
+	if ok {
+		if expired && canRefresh {
+			// refreshed in the background, the cached keys are used meanwhile
+			k.startRefresh()
+		}
+		return key, nil
+	}
+
+	// the issuer may have rotated its keys
+	if !canRefresh {
+		if lastErr != nil {
+			return jose.JSONWebKey{}, lastErr
+		}
+		return jose.JSONWebKey{}, ErrUnknownKey
+	}
+
+	call := k.startRefresh()
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return jose.JSONWebKey{}, ctx.Err()
+	}
+	if call.err != nil {
+		return jose.JSONWebKey{}, call.err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if key, ok := k.keys[keyID]; ok {
+		return key, nil
+	}
+	return jose.JSONWebKey{}, ErrUnknownKey
+}
+
+// Issuer returns the issuer stated in the discovery document, empty if not fetched yet
+func (k *KeySet) Issuer() string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.issuer
+}
+
+// startRefresh starts fetching the keys unless a fetch is already in progress, returns the fetch to wait for
+func (k *KeySet) startRefresh() *refreshCall {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.refreshing != nil {
+		return k.refreshing
+	}
+	call := &refreshCall{done: make(chan struct{})}
+	k.refreshing = call
+	k.attemptedAt = time.Now()
+
+	go func() {
+		// not bound to the request, the other requests may be waiting for the same keys
+		ctx, cancel := context.WithTimeout(context.Background(), keysFetchTimeout)
+		defer cancel()
+		call.err = k.refresh(ctx)
+
+		k.mu.Lock()
+		k.refreshing = nil
+		k.lastErr = call.err
+		k.mu.Unlock()
+		close(call.done)
+	}()
+
+	return call
+}
+
+func (k *KeySet) refresh(ctx context.Context) error {
+	k.mu.Lock()
+	jwksURI := k.jwksURI
+	k.mu.Unlock()
+
+	// the discovery document is fetched only once, the jwks_uri doesn't change with the key rotation
+	if jwksURI == "" {
+		var discovery discoveryDocument
+		if err := k.getJSON(ctx, k.discoveryURL, &discovery); err != nil {
+			return errors.New("failed to get the OpenID discovery document: " + err.Error())
+		}
+		if discovery.JwksURI == "" {
+			return errors.New("jwks_uri is missing in the OpenID discovery document")
+		}
+		jwksURI = discovery.JwksURI
+
+		k.mu.Lock()
+		k.issuer = discovery.Issuer
+		k.jwksURI = discovery.JwksURI
+		k.mu.Unlock()
+	}
+
+	var keySet jose.JSONWebKeySet
+	if err := k.getJSON(ctx, jwksURI, &keySet); err != nil {
+		return errors.New("failed to get the issuer's key set: " + err.Error())
+	}
+
+	keys := make(map[string]jose.JSONWebKey)
+	for _, key := range keySet.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		if !key.IsPublic() {
+			continue
+		}
+		keys[key.KeyID] = key
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.fetchedAt = time.Now()
+	k.mu.Unlock()
+	return nil
+}
+
+func (k *KeySet) getJSON(ctx context.Context, url string, dest interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := k.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprint("unexpected status ", response.StatusCode, " from ", url))
+	}
+
+	return json.Unmarshal(body, dest)
+}
@@ -11,7 +11,8 @@ func AddCorsPolicy(handler http.Handler) http.Handler {
 		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodPut},
 		AllowCredentials: true,
 		Debug:            false,
-		AllowedHeaders:   []string{"Origin", "Content-Type", "Accept", "Authorization"},
+		AllowedHeaders:   []string{"Origin", "Content-Type", "Accept", "Authorization", "Range", "If-Range", "If-None-Match"},
+		ExposedHeaders:   []string{"Content-Disposition", "Content-Range", "Accept-Ranges", "ETag", "X-Next-Cursor", "X-Total-Count"},
 	})
 
 	return c.Handler(handler)
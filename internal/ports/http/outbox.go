@@ -0,0 +1,156 @@
+package http
+
+import (
+	"doc-management/internal/outbox"
+	"doc-management/internal/ports/http/middleware/auth"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const defaultAdminListLimit = 100
+
+type outboxEntry struct {
+	BatchID       string     `json:"batchID"`
+	State         string     `json:"state"`
+	UserID        string     `json:"userID"`
+	Action        string     `json:"action"`
+	Target        string     `json:"target"`
+	ContentHash   string     `json:"contentHash,omitempty"`
+	ContentRef    string     `json:"contentRef,omitempty"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty"`
+	LastError     string     `json:"lastError,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+}
+
+type reconciliationIssue struct {
+	ID          string    `json:"id"`
+	Kind        string    `json:"kind"`
+	Status      string    `json:"status"`
+	ContentHash string    `json:"contentHash"`
+	Ref         string    `json:"ref"`
+	Detail      string    `json:"detail"`
+	FirstSeenAt time.Time `json:"firstSeenAt"`
+	LastSeenAt  time.Time `json:"lastSeenAt"`
+}
+
+func (ser server) getOutbox(w http.ResponseWriter, r *http.Request) {
+	if err := auth.ValidateScope(r, "docs.read"); err != nil {
+		ser.unauthorizedRequest(w, err.Error())
+		return
+	}
+
+	state := outbox.StateFailed
+	if value := normalize(r.URL.Query().Get("state")); value != "" {
+		state = outbox.State(value)
+	}
+	if !state.IsValid() {
+		ser.badRequest(w, "invalid state param: "+string(state))
+		return
+	}
+	limit, err := readLimit(r)
+	if err != nil {
+		ser.badRequest(w, err.Error())
+		return
+	}
+
+	entries, err := ser.app.ListOutbox(r.Context(), auth.GetSubject(r), state, limit)
+	if err != nil {
+		ser.appError(w, "listing the outbox failed: ", err)
+		return
+	}
+
+	retEntries := make([]outboxEntry, len(entries))
+	for i, entry := range entries {
+		retEntries[i] = outboxEntry{
+			BatchID:     entry.ID,
+			State:       string(entry.State),
+			UserID:      entry.UserID,
+			Action:      string(entry.Action),
+			Target:      entry.Target,
+			ContentHash: entry.ContentHash,
+			ContentRef:  entry.ContentRef,
+			Attempts:    entry.Attempts,
+			LastError:   entry.LastError,
+			CreatedAt:   entry.CreatedAt,
+			UpdatedAt:   entry.UpdatedAt,
+		}
+		if entry.State == outbox.StatePending {
+			retEntries[i].NextAttemptAt = &entries[i].NextAttemptAt
+		}
+	}
+	ser.respondJSON(w, http.StatusOK, retEntries)
+}
+
+func (ser server) getReconciliationIssues(w http.ResponseWriter, r *http.Request) {
+	if err := auth.ValidateScope(r, "docs.read"); err != nil {
+		ser.unauthorizedRequest(w, err.Error())
+		return
+	}
+
+	status := outbox.IssueStatus(normalize(r.URL.Query().Get("status")))
+	if status != "" && !status.IsValid() {
+		ser.badRequest(w, "invalid status param: "+string(status))
+		return
+	}
+	limit, err := readLimit(r)
+	if err != nil {
+		ser.badRequest(w, err.Error())
+		return
+	}
+
+	issues, err := ser.app.ListReconciliationIssues(r.Context(), auth.GetSubject(r), status, limit)
+	if err != nil {
+		ser.appError(w, "listing the reconciliation issues failed: ", err)
+		return
+	}
+	ser.respondIssues(w, issues)
+}
+
+func (ser server) runReconciliation(w http.ResponseWriter, r *http.Request) {
+	if err := auth.ValidateScope(r, "docs.sign"); err != nil {
+		ser.unauthorizedRequest(w, err.Error())
+		return
+	}
+
+	issues, err := ser.app.Reconcile(r.Context(), auth.GetSubject(r))
+	if err != nil {
+		ser.appError(w, "the reconciliation failed: ", err)
+		return
+	}
+	ser.respondIssues(w, issues)
+}
+
+func (ser server) respondIssues(w http.ResponseWriter, issues []outbox.Issue) {
+	retIssues := make([]reconciliationIssue, len(issues))
+	for i, issue := range issues {
+		retIssues[i] = reconciliationIssue{
+			ID:          issue.ID,
+			Kind:        string(issue.Kind),
+			Status:      string(issue.Status),
+			ContentHash: issue.ContentHash,
+			Ref:         issue.Ref,
+			Detail:      issue.Detail,
+			FirstSeenAt: issue.FirstSeenAt,
+			LastSeenAt:  issue.LastSeenAt,
+		}
+	}
+	ser.respondJSON(w, http.StatusOK, retIssues)
+}
+
+// readLimit reads the max number of the listed admin records
+func readLimit(r *http.Request) (int, error) {
+	value := normalize(r.URL.Query().Get("limit"))
+	if value == "" {
+		return defaultAdminListLimit, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		return 0, errors.New("invalid limit param: " + value)
+	}
+	return limit, nil
+}
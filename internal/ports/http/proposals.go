@@ -2,13 +2,16 @@ package http
 
 import (
 	"context"
+	"doc-management/internal/blockchain"
 	"doc-management/internal/config"
 	"doc-management/internal/model"
 	"doc-management/internal/ports/http/middleware/auth"
+	"doc-management/internal/projection"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"strings"
 
@@ -29,6 +32,25 @@ type retrivedProposal struct {
 	Author         string   `json:"author"`
 	Signers        []string `json:"signers"`
 	ProposedStatus string   `json:"proposedStatus"`
+
+	FileName    string `json:"fileName"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+}
+
+type submittedProposal struct {
+	ProposalID  string `json:"proposalID"`
+	ContentHash string `json:"contentHash"`
+	// the submitted batch, pending until it's committed, see GET /api/batches/{batchID}
+	BatchID string `json:"batchID"`
+	Status  string `json:"status"`
+}
+
+// unsignedTransaction is returned in the client signing mode, the client signs the transaction and submits it in a batch
+type unsignedTransaction struct {
+	ProposalID  string                         `json:"proposalID"`
+	ContentHash string                         `json:"contentHash,omitempty"`
+	Transaction blockchain.TransactionTemplate `json:"transaction"`
 }
 
 func (ser server) signProposal(w http.ResponseWriter, r *http.Request) {
@@ -37,50 +59,90 @@ func (ser server) signProposal(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	proposalID, signer, err := ser.readSignProposalParams(r)
+	proposalID, onBehalfOf, err := ser.readSignProposalParams(r)
 	if err != nil {
 		ser.badRequest(w, err.Error())
 		return
 	}
+	wait, err := readWait(r)
+	if err != nil {
+		ser.badRequest(w, err.Error())
+		return
+	}
+
+	// the client signs the transaction itself and submits it to /api/transactions
+	if ser.app.ClientSigning() {
+		if onBehalfOf != "" && onBehalfOf != auth.GetSubject(r).UserID {
+			ser.badRequest(w, "onBehalfOf is not supported with the client signing")
+			return
+		}
 
-	if err := ser.app.SignProposal(r.Context(), proposalID, signer); err != nil {
-		ser.serverError(w, err.Error())
+		template, err := ser.app.SignProposalTemplate(r.Context(), auth.GetSubject(r), proposalID)
+		if err != nil {
+			ser.appError(w, "", err)
+			return
+		}
+
+		ser.respondJSON(w, http.StatusAccepted, unsignedTransaction{ProposalID: proposalID, Transaction: template})
+		return
+	}
+
+	batch, err := ser.app.SignProposal(r.Context(), auth.GetSubject(r), proposalID, onBehalfOf)
+	if err == nil {
+		batch, err = ser.app.AwaitBatch(r.Context(), batch, wait)
+	}
+	if err != nil {
+		ser.appError(w, "", err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Location", batchLocation(batch.ID))
+	ser.respondJSON(w, submissionStatus(batch, http.StatusOK), newRetrivedBatch(batch))
 
 }
 
-func (ser server) getAllProposals(w http.ResponseWriter, r *http.Request) {
+func (ser server) getProposalContent(w http.ResponseWriter, r *http.Request) {
 	if err := auth.ValidateScope(r, "docs.read"); err != nil {
 		ser.unauthorizedRequest(w, err.Error())
 		return
 	}
 
-	userID := normalize(r.URL.Query().Get("userID"))
-	category := normalize(r.URL.Query().Get("category"))
-
-	ser.logger.Info("getting all the proposals", zap.String("userID", userID), zap.String("category", category))
+	proposalID := normalize(mux.Vars(r)["proposalID"])
+	ser.logger.Debug("getting content of proposal " + proposalID)
 
-	if userID == "" && category == "" {
-		ser.badRequest(w, ErrSearchTooBroad.Error())
+	proposal, content, err := ser.app.OpenProposal(r.Context(), auth.GetSubject(r), proposalID)
+	if err != nil {
+		ser.contentError(w, err)
 		return
 	}
+	defer content.Close()
 
-	var proposals []model.Proposal
-	var err error
+	ser.serveContent(w, r, fileNameOrDefault(proposal.FileName, proposal.DocumentName), proposal.ContentType, proposal.ContentHash, content)
+}
 
-	if strings.HasPrefix(userID, notQueryPrefix) {
-		userID = strings.TrimPrefix(userID, notQueryPrefix)
-		proposals, err = ser.app.GetToSignProposals(r.Context(), userID)
+func (ser server) getAllProposals(w http.ResponseWriter, r *http.Request) {
+	if err := auth.ValidateScope(r, "docs.read"); err != nil {
+		ser.unauthorizedRequest(w, err.Error())
+		return
+	}
 
-	} else {
-		proposals, err = ser.app.GetUserProposals(r.Context(), userID)
+	filter, err := ser.readGetProposalsParams(r)
+	if err != nil {
+		ser.badRequest(w, err.Error())
+		return
 	}
 
+	page, err := ser.readPageParams(r)
 	if err != nil {
-		ser.serverError(w, "getting the proposals failed: "+err.Error())
+		ser.badRequest(w, err.Error())
+		return
+	}
+
+	ser.logger.Info("getting the proposals", zap.String("author", filter.Author), zap.String("notAuthor", filter.NotAuthor), zap.String("category", filter.Category), zap.String("status", string(filter.Status)))
+
+	proposals, next, total, err := ser.app.ListProposals(r.Context(), auth.GetSubject(r), filter, page)
+	if err != nil {
+		ser.appError(w, "getting the proposals failed: ", err)
 		return
 	}
 
@@ -93,6 +155,9 @@ func (ser server) getAllProposals(w http.ResponseWriter, r *http.Request) {
 			Author:         proposal.ModificationAuthor,
 			Signers:        proposal.Signers,
 			ProposedStatus: proposal.ProposedStatus.String(),
+			FileName:       proposal.FileName,
+			ContentType:    proposal.ContentType,
+			Size:           proposal.ContentSize,
 		}
 		// limit the content length to display
 		if len(proposal.Content) > 80 {
@@ -102,16 +167,36 @@ func (ser server) getAllProposals(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	response, err := json.Marshal(proposToReturn)
-	if err != nil {
-		ser.serverError(w, "marshalling the response failed: "+err.Error())
-		return
+	ser.respondPage(w, proposToReturn, next, total)
+}
+
+func (ser server) readGetProposalsParams(r *http.Request) (projection.ProposalFilter, error) {
+	queryParams := r.URL.Query()
+
+	filter := projection.ProposalFilter{
+		Category: normalize(queryParams.Get("category")),
+		Author:   normalize(queryParams.Get("author")),
+		Status:   model.ProposalStatus(normalize(queryParams.Get("status"))),
+	}
+	if filter.Status == "" {
+		filter.Status = model.ProposalStatusActive
+	}
+	if !filter.Status.IsValid() {
+		return filter, errors.New("invalid status: " + string(filter.Status))
 	}
 
-	if _, err := w.Write(response); err != nil {
-		ser.serverError(w, "failed to write the response: "+err.Error())
-		return
+	// userID=!user selects the proposals of other users, to be signed by the user
+	userID := normalize(queryParams.Get("userID"))
+	if strings.HasPrefix(userID, notQueryPrefix) {
+		filter.NotAuthor = strings.TrimPrefix(userID, notQueryPrefix)
+	} else if userID != "" {
+		if filter.Author != "" && filter.Author != userID {
+			return filter, errors.New("userID and author params don't match")
+		}
+		filter.Author = userID
 	}
+
+	return filter, nil
 }
 
 func (ser server) putProposal(w http.ResponseWriter, r *http.Request) {
@@ -121,7 +206,22 @@ func (ser server) putProposal(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	proposal, err := ser.readAddProposalParams(r)
+	proposal, file, err := ser.readAddProposalParams(r)
+	if r.MultipartForm != nil {
+		defer r.MultipartForm.RemoveAll()
+	}
+	if err == errContentTooLarge {
+		ser.tooLarge(w, fmt.Sprint("the file exceeds the max size of ", config.GetMaxContentSize(), " bytes"))
+		return
+	}
+	if err != nil {
+		ser.badRequest(w, err.Error())
+		return
+	}
+	if file != nil {
+		defer file.Close()
+	}
+	wait, err := readWait(r)
 	if err != nil {
 		ser.badRequest(w, err.Error())
 		return
@@ -131,18 +231,51 @@ func (ser server) putProposal(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), config.GetRequestTimeout())
 	defer cancel()
 
-	if err := ser.app.AddProposal(ctx, proposal); err != nil {
-		ser.serverError(w, "saving the proposal failed: "+err.Error())
+	// the client signs the transaction itself and submits it to /api/transactions
+	if ser.app.ClientSigning() {
+		proposal, template, err := ser.app.ProposalTemplate(ctx, auth.GetSubject(r), proposal, uploadedContent(file))
+		if err != nil {
+			ser.appError(w, "preparing the proposal failed: ", err)
+			return
+		}
+
+		ser.respondJSON(w, http.StatusAccepted, unsignedTransaction{ProposalID: proposal.ProposalID, ContentHash: proposal.ContentHash, Transaction: template})
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
+	proposal, batch, err := ser.app.AddProposal(ctx, auth.GetSubject(r), proposal, uploadedContent(file))
+	if err == nil {
+		batch, err = ser.app.AwaitBatch(ctx, batch, wait)
+	}
+	if err != nil {
+		ser.appError(w, "saving the proposal failed: ", err)
+		return
+	}
+
+	w.Header().Set("Location", batchLocation(batch.ID))
+	ser.respondJSON(w, submissionStatus(batch, http.StatusCreated), submittedProposal{
+		ProposalID:  proposal.ProposalID,
+		ContentHash: proposal.ContentHash,
+		BatchID:     batch.ID,
+		Status:      batch.Status,
+	})
 }
 
-func (ser server) readAddProposalParams(r *http.Request) (model.Proposal, error) {
-	// max file size is 10MB
-	if err := r.ParseMultipartForm(10e7); err != nil {
-		return model.Proposal{}, errors.New("failed to parse the form: " + err.Error())
+// readAddProposalParams returns the proposal with the hash and the size of the uploaded file filled in,
+// and the file itself, nil if the doc is to be removed; the form must be removed once the file is stored
+func (ser server) readAddProposalParams(r *http.Request) (model.Proposal, multipart.File, error) {
+	maxSize := config.GetMaxContentSize()
+	if r.ContentLength > maxSize+maxFormOverhead {
+		return model.Proposal{}, nil, errContentTooLarge
+	}
+
+	body := &limitedBody{ReadCloser: r.Body, left: maxSize + maxFormOverhead}
+	r.Body = body
+	if err := r.ParseMultipartForm(maxUploadMemory); err != nil {
+		if body.exceeded {
+			return model.Proposal{}, nil, errContentTooLarge
+		}
+		return model.Proposal{}, nil, errors.New("failed to parse the form: " + err.Error())
 	}
 
 	var err error
@@ -153,62 +286,66 @@ func (ser server) readAddProposalParams(r *http.Request) (model.Proposal, error)
 		err = multierr.Append(err, errors.New("docName is missing"))
 	}
 
-	userID := normalize(r.FormValue("userID"))
-	if userID == "" {
-		err = multierr.Append(err, errors.New("userID is missing"))
+	// the author is the authenticated user, unless an admin acts on behalf of another user
+	onBehalfOf, identityErr := readOnBehalfOf(r, normalize(r.FormValue("onBehalfOf")), normalize(r.FormValue("userID")), "userID")
+	if identityErr != nil {
+		err = multierr.Append(err, identityErr)
 	}
 
 	category := normalize(r.FormValue("category"))
 	docStatus := normalize(r.FormValue("docStatus"))
 
-	var content []byte
-	// if the doc is to be removed, there is no file content
-	if docStatus != model.DocStatusRemoved.String() {
-
-		file, handler, err := r.FormFile("docFile")
-		if err != nil {
-			err = multierr.Append(err, errors.New("failed to get the proposal file from form: "+err.Error()))
-		}
-		defer file.Close()
-
-		if err != nil {
-			return model.Proposal{}, err
-		}
+	proposal := model.Proposal{
+		DocumentName:       docName,
+		Category:           category,
+		ModificationAuthor: onBehalfOf,
+		ProposedStatus:     model.DocStatus(docStatus),
+	}
 
-		bytes, err := ioutil.ReadAll(file)
+	// if the doc is to be removed, there is no file content
+	if docStatus == model.DocStatusRemoved.String() {
 		if err != nil {
-			return model.Proposal{}, errors.New("failed to read the proposal file: " + err.Error())
+			return model.Proposal{}, nil, err
 		}
+		return proposal, nil, nil
+	}
 
-		if len(bytes) != int(handler.Size) {
-			return model.Proposal{}, errors.New(fmt.Sprintf("upload error: size of received file: %v, size declared in the header: %v", len(bytes), handler.Size))
+	file, header, fileErr := r.FormFile("docFile")
+	if fileErr != nil {
+		err = multierr.Append(err, errors.New("failed to get the proposal file from form: "+fileErr.Error()))
+	}
+	if err != nil {
+		if file != nil {
+			file.Close()
 		}
+		return model.Proposal{}, nil, err
+	}
 
-		ser.logger.Info(fmt.Sprintf("received file: %s, size %v", handler.Filename, handler.Size))
-		content = bytes
+	if header.Size > maxSize {
+		file.Close()
+		return model.Proposal{}, nil, errContentTooLarge
+	}
 
-	} else {
-		// just check previous validation errors
-		if err != nil {
-			return model.Proposal{}, err
-		}
+	if proposal.ContentHash, proposal.ContentType, err = readUpload(file, header); err != nil {
+		file.Close()
+		return model.Proposal{}, nil, err
 	}
+	proposal.FileName = header.Filename
+	proposal.ContentSize = header.Size
+	ser.logger.Info(fmt.Sprintf("received file: %s, size %v, content type %s", proposal.FileName, proposal.ContentSize, proposal.ContentType))
 
-	return model.Proposal{
-		DocumentName:       docName,
-		Category:           category,
-		ModificationAuthor: userID,
-		Content:            content,
-		ProposedStatus:     model.DocStatus(docStatus),
-	}, nil
+	return proposal, file, nil
 }
 
-func (ser server) readSignProposalParams(r *http.Request) (proposalID, signer string, err error) {
+// readSignProposalParams returns the proposal ID and the user on behalf of whom the proposal is signed,
+// empty if signed by the authenticated user
+func (ser server) readSignProposalParams(r *http.Request) (proposalID, onBehalfOf string, err error) {
 	params := mux.Vars(r)
 
 	proposalID = normalize(params["proposalID"])
 	if proposalID == "" {
-		err = multierr.Append(err, errors.New("proposalID is missing"))
+		err = errors.New("proposalID is missing")
+		return
 	}
 
 	bodyBytes, err := ioutil.ReadAll(r.Body)
@@ -219,13 +356,28 @@ func (ser server) readSignProposalParams(r *http.Request) (proposalID, signer st
 	}
 
 	var body struct {
-		Signer string `json:"signer"`
+		// deprecated, the signer is the authenticated user
+		Signer     string `json:"signer"`
+		OnBehalfOf string `json:"onBehalfOf"`
 	}
 
-	if err = json.Unmarshal(bodyBytes, &body); err != nil {
-		err = errors.New("invalid body: " + err.Error())
-		return
+	if len(bodyBytes) > 0 {
+		if err = json.Unmarshal(bodyBytes, &body); err != nil {
+			err = errors.New("invalid body: " + err.Error())
+			return
+		}
+	}
+
+	onBehalfOf, err = readOnBehalfOf(r, normalize(body.OnBehalfOf), normalize(body.Signer), "signer")
+	return proposalID, onBehalfOf, err
+}
+
+// readOnBehalfOf returns the user on behalf of whom the request is made, empty for the authenticated user;
+// the deprecated identity param is accepted only if it refers to the authenticated user
+func readOnBehalfOf(r *http.Request, onBehalfOf, deprecated, deprecatedName string) (string, error) {
+	if deprecated != "" && deprecated != auth.GetSubject(r).UserID {
+		return "", errors.New(deprecatedName + " doesn't match the authenticated user, use onBehalfOf to act on behalf of another user")
 	}
 
-	return proposalID, body.Signer, nil
+	return onBehalfOf, nil
 }
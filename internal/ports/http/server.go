@@ -2,10 +2,14 @@ package http
 
 import (
 	"doc-management/internal/app"
+	"doc-management/internal/blockchain/events"
 	"doc-management/internal/config"
+	"doc-management/internal/usermanager"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"doc-management/internal/ports/http/middleware/auth"
 	"doc-management/internal/ports/http/middleware/cors"
@@ -17,10 +21,11 @@ import (
 var ErrSearchTooBroad = errors.New("query is too broad, include filters")
 
 type server struct {
-	app        *app.App
-	httpServer *http.Server
-	addr       string
-	logger     *zap.Logger
+	app         *app.App
+	httpServer  *http.Server
+	addr        string
+	tokenParams usermanager.TokenParams
+	logger      *zap.Logger
 }
 
 func (ser server) badRequest(w http.ResponseWriter, message string) {
@@ -33,6 +38,36 @@ func (ser server) unauthorizedRequest(w http.ResponseWriter, message string) {
 	ser.logger.Warn(message)
 }
 
+func (ser server) notFound(w http.ResponseWriter, message string) {
+	http.Error(w, message, http.StatusNotFound)
+	ser.logger.Warn(message)
+}
+
+func (ser server) tooLarge(w http.ResponseWriter, message string) {
+	http.Error(w, message, http.StatusRequestEntityTooLarge)
+	ser.logger.Warn(message)
+}
+
+func (ser server) forbidden(w http.ResponseWriter, message string) {
+	http.Error(w, message, http.StatusForbidden)
+	ser.logger.Warn(message)
+}
+
+// respondJSON writes the value as the JSON body with the given status
+func (ser server) respondJSON(w http.ResponseWriter, status int, value interface{}) {
+	response, err := json.Marshal(value)
+	if err != nil {
+		ser.serverError(w, "marshalling the response failed: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(response); err != nil {
+		ser.logger.Error("failed to write the response: " + err.Error())
+	}
+}
+
 func (ser server) serverError(w http.ResponseWriter, message string) {
 	http.Error(w, message, http.StatusInternalServerError)
 	ser.logger.Error(message)
@@ -40,32 +75,93 @@ func (ser server) serverError(w http.ResponseWriter, message string) {
 
 func (ser server) registerHandlers(router *mux.Router) {
 
-	router.HandleFunc("/health", healthcheck)
+	router.HandleFunc("/health", ser.healthcheck)
 
 	// to create a new proposal
 	router.HandleFunc("/api/proposals/{docName}", ser.putProposal).Methods(http.MethodPut)
 	// to sign a certain proposal
 	router.HandleFunc("/api/proposals/{proposalID}", ser.signProposal).Methods(http.MethodPost)
 
+	// to submit the transactions signed by the client, in the client signing mode
+	router.HandleFunc("/api/transactions", ser.postTransactions).Methods(http.MethodPost)
+	// the status of a submitted batch, also as a stream of server-sent events
+	router.HandleFunc("/api/batches/{batchID}", ser.getBatch).Methods(http.MethodGet)
+
+	// the public keys of a user, to rotate and revoke the user's signing key
+	router.HandleFunc("/api/users/{userID}/keys", ser.getKeys).Methods(http.MethodGet)
+	router.HandleFunc("/api/users/{userID}/keys/rotate", ser.rotateKey).Methods(http.MethodPost)
+	router.HandleFunc("/api/users/{userID}/keys/revoke", ser.revokeKey).Methods(http.MethodPost)
+	// verify the key records of all the users, replace the mismatched public keys
+	router.HandleFunc("/api/admin/keys", ser.auditKeys).Methods(http.MethodGet)
+	router.HandleFunc("/api/admin/keys/repair", ser.repairKeys).Methods(http.MethodPost)
+	// the batches recorded in the outbox and the content mismatches found by the reconciler
+	router.HandleFunc("/api/admin/outbox", ser.getOutbox).Methods(http.MethodGet)
+	router.HandleFunc("/api/admin/reconciliation", ser.getReconciliationIssues).Methods(http.MethodGet)
+	router.HandleFunc("/api/admin/reconciliation/run", ser.runReconciliation).Methods(http.MethodPost)
+	// the received blockchain events, to replay the dead-lettered ones
+	router.HandleFunc("/api/admin/events", ser.getEvents).Methods(http.MethodGet)
+	router.HandleFunc("/api/admin/events/{eventID}/replay", ser.replayEvent).Methods(http.MethodPost)
+
+	// to download the full content of a proposal
+	router.HandleFunc("/api/proposals/{proposalID}/content", ser.getProposalContent).Methods(http.MethodGet)
+
 	// for getting all proposals filtered by a certain category or author
 	router.HandleFunc("/api/proposals", ser.getAllProposals).Methods(http.MethodGet)
 
+	// to browse the categories and the docs in them
+	router.HandleFunc("/api/categories", ser.getCategories).Methods(http.MethodGet)
+	router.HandleFunc("/api/categories/{category}/docs", ser.getCategoryDocs).Methods(http.MethodGet)
+
 	// for getting all docs by certain author, signed by a certain user, ...
 	router.HandleFunc("/api/docs", ser.getDocuments).Methods(http.MethodGet)
+	// full-text search in the accepted docs
+	router.HandleFunc("/api/docs/search", ser.searchDocuments).Methods(http.MethodGet)
 	// for getting all versions of a certain doc
 	router.HandleFunc("/api/docs/{category}/{docName}", ser.getDocVersions).Methods(http.MethodGet)
+	// to download the full content of a certain doc version
+	router.HandleFunc("/api/docs/{category}/{docName}/{version:[0-9]+}/content", ser.getDocContent).Methods(http.MethodGet)
+
+}
 
+type health struct {
+	Status string       `json:"status"`
+	Events eventsHealth `json:"events"`
 }
 
-func healthcheck(w http.ResponseWriter, r *http.Request) {
-	_, _ = w.Write([]byte("all good here"))
+type eventsHealth struct {
+	State       string    `json:"state"`
+	Since       time.Time `json:"since"`
+	LastBlockID string    `json:"lastBlockID,omitempty"`
+	LastError   string    `json:"lastError,omitempty"`
 }
 
-func NewServer(logger *zap.Logger, a *app.App, address string) server {
+// healthcheck fails while the app isn't connected to the validator's events, as the accepted proposals
+// aren't submitted as doc versions then
+func (ser server) healthcheck(w http.ResponseWriter, r *http.Request) {
+	listener := ser.app.EventsStatus()
+
+	status, code := "ok", http.StatusOK
+	if listener.State != events.StateConnected {
+		status, code = "degraded", http.StatusServiceUnavailable
+	}
+	ser.respondJSON(w, code, health{
+		Status: status,
+		Events: eventsHealth{
+			State:       string(listener.State),
+			Since:       listener.Since,
+			LastBlockID: listener.LastBlockID,
+			LastError:   listener.LastError,
+		},
+	})
+}
+
+// NewServer creates the HTTP server accepting the tokens described by the token params
+func NewServer(logger *zap.Logger, a *app.App, address string, tokenParams usermanager.TokenParams) server {
 	return server{
-		app:    a,
-		addr:   address,
-		logger: logger,
+		app:         a,
+		addr:        address,
+		tokenParams: tokenParams,
+		logger:      logger,
 	}
 }
 
@@ -74,9 +170,13 @@ func (ser server) Run() error {
 	ser.registerHandlers(router)
 
 	tokenValidator := auth.NewTokenValidator(ser.logger, auth.JwtTokenParams{
-		Issuer:   config.GetTokenIssuer(),
-		Audience: config.GetClientID(),
-	})
+		Issuer:      ser.tokenParams.Issuer,
+		Audience:    ser.tokenParams.Audience,
+		PolicyName:  ser.tokenParams.PolicyName,
+		ClockSkew:   config.GetAuthClockSkew(),
+		UserIDClaim: ser.tokenParams.UserIDClaim,
+		ScopeClaim:  ser.tokenParams.ScopeClaim,
+	}, auth.NewKeySet(ser.tokenParams.DiscoveryURL))
 
 	handler := cors.AddCorsPolicy(tokenValidator.ValidateGetScopes(router))
 
@@ -0,0 +1,61 @@
+package http
+
+import (
+	"context"
+	"doc-management/internal/ports/http/middleware/auth"
+	"io/ioutil"
+	"net/http"
+)
+
+// the max size of a batch list signed by the client
+const maxBatchListSize = 1 << 20
+
+type relayedBatches struct {
+	Batches []retrivedBatch `json:"batches"`
+}
+
+// postTransactions relays the serialized BatchList signed by the client to the validator
+func (ser server) postTransactions(w http.ResponseWriter, r *http.Request) {
+	if err := auth.ValidateScope(r, "docs.write"); err != nil {
+		ser.unauthorizedRequest(w, err.Error())
+		return
+	}
+
+	if contentType := r.Header.Get("Content-Type"); contentType != contentTypeOctetStream {
+		ser.badRequest(w, "unsupported content type "+contentType+", expecting "+contentTypeOctetStream)
+		return
+	}
+
+	wait, err := readWait(r)
+	if err != nil {
+		ser.badRequest(w, err.Error())
+		return
+	}
+
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchListSize))
+	r.Body.Close()
+	if err != nil {
+		ser.badRequest(w, "can't read the batch list: "+err.Error())
+		return
+	}
+
+	relayed, err := ser.app.RelayTransactions(r.Context(), auth.GetSubject(r), data)
+	if err != nil {
+		ser.appError(w, "relaying the transactions failed: ", err)
+		return
+	}
+
+	// the batches are processed in parallel, so the wait is shared
+	ctx, cancel := context.WithTimeout(r.Context(), wait)
+	defer cancel()
+
+	retBatches := make([]retrivedBatch, len(relayed))
+	for i, batch := range relayed {
+		if batch, err = ser.app.AwaitBatch(ctx, batch, wait); err != nil {
+			ser.appError(w, "", err)
+			return
+		}
+		retBatches[i] = newRetrivedBatch(batch)
+	}
+	ser.respondJSON(w, http.StatusAccepted, relayedBatches{Batches: retBatches})
+}
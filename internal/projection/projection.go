@@ -0,0 +1,58 @@
+package projection
+
+import (
+	"context"
+	"doc-management/internal/model"
+	"doc-management/internal/paging"
+)
+
+// Change is a decoded state change of a proposal or a doc version
+type Change struct {
+	Address string
+	// set if the proposal data changed
+	Proposal *model.Proposal
+	// set if the doc version data changed
+	Document *model.Document
+	Deleted  bool
+}
+
+// ProposalFilter selects the proposals, empty fields match all
+type ProposalFilter struct {
+	Category string
+	// categories to select from, nil means all
+	Categories []string
+	Author     string
+	// proposals created by other users than this one, e.g. to be signed by the user
+	NotAuthor string
+	Status    model.ProposalStatus
+}
+
+// Store keeps the projection of the blockchain state
+type Store interface {
+	// ApplyChanges applies the state changes made in the block and marks the block as the last one processed;
+	// applying the same block again changes nothing
+	ApplyChanges(ctx context.Context, blockID string, blockNum uint64, changes []Change) error
+	// LastBlock returns the ID and the number of the last processed block, empty if no block was processed yet;
+	// the number is 0 if the state snapshot was loaded and no block was processed since
+	LastBlock(ctx context.Context) (blockID string, blockNum uint64, err error)
+}
+
+// Reader serves the reads of the blockchain state, the same as the blockchain client does
+type Reader interface {
+	GetProposal(ctx context.Context, proposalID string) (model.Proposal, error)
+	// ListProposals returns the proposals matching the filter ordered by the proposal ID
+	ListProposals(ctx context.Context, filter ProposalFilter, page paging.Page) (proposals []model.Proposal, next string, total int64, err error)
+	// GetDocProposals returns the active proposals of the document
+	GetDocProposals(ctx context.Context, category, docName string) ([]model.Proposal, error)
+
+	GetDocumentVersions(ctx context.Context, category, docName string) ([]model.Document, error)
+	GetDocumentVersion(ctx context.Context, category, docName string, version int) (model.Document, error)
+	GetDocumentsOfAuthor(ctx context.Context, author string) ([]model.Document, error)
+	GetDocumentsSignedBy(ctx context.Context, signer string) ([]model.Document, error)
+}
+
+// Repository keeps the projection and serves the reads from it
+type Repository interface {
+	Store
+	Reader
+}
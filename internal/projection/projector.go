@@ -0,0 +1,144 @@
+package projection
+
+import (
+	"context"
+	"doc-management/internal/blockchain"
+	"doc-management/internal/blockchain/doctrackerfamily"
+	"doc-management/internal/blockchain/proposalfamily"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const applyTimeout = 30 * time.Second
+
+// Projector keeps the projection store in sync with the blockchain state;
+// it's fed with the state changes of each committed block
+type Projector struct {
+	logger *zap.Logger
+	client *blockchain.Client
+	store  Store
+}
+
+func NewProjector(logger *zap.Logger, client *blockchain.Client, store Store) *Projector {
+	return &Projector{
+		logger: logger,
+		client: client,
+		store:  store,
+	}
+}
+
+// Prefixes returns the prefixes of the projected addresses
+func (p *Projector) Prefixes() []string {
+	return []string{proposalfamily.GetProposalAddressFromID(""), doctrackerfamily.GetDocsPrefix()}
+}
+
+// Init returns the last processed block, the events are to be replayed from it.
+// If no block was processed yet, the current state is loaded from the REST API;
+// the number of the snapshot block is not known, it's returned as 0.
+func (p *Projector) Init(ctx context.Context) (lastBlockID string, lastBlockNum uint64, err error) {
+	lastBlockID, lastBlockNum, err = p.store.LastBlock(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	if lastBlockID != "" {
+		p.logger.Info("resuming the projection from block " + lastBlockID)
+		return lastBlockID, lastBlockNum, nil
+	}
+
+	head, err := p.loadSnapshot(ctx)
+	return head, 0, err
+}
+
+// Resync loads the current state again, e.g. when the blocks committed since the last processed one
+// can't be replayed; returns the block the state was read at
+func (p *Projector) Resync(ctx context.Context) (head string, err error) {
+	p.logger.Info("resyncing the projection with the current state")
+	return p.loadSnapshot(ctx)
+}
+
+func (p *Projector) loadSnapshot(ctx context.Context) (head string, err error) {
+	var entries []blockchain.StateEntry
+	for _, prefix := range p.Prefixes() {
+		prefixEntries, stateHead, err := p.client.ListState(ctx, prefix, head)
+		if err != nil {
+			return "", errors.New("failed to load the state snapshot: " + err.Error())
+		}
+		// all the prefixes are read at the same block
+		head = stateHead
+		entries = append(entries, prefixEntries...)
+	}
+
+	if head == "" {
+		// nothing committed yet, the events are sent from the genesis block
+		return "", nil
+	}
+
+	changes := make([]blockchain.StateChange, len(entries))
+	for i, entry := range entries {
+		changes[i] = blockchain.StateChange{Address: entry.Address, Value: entry.Data}
+	}
+
+	// the block number is not known, it's updated with the next block
+	if err := p.store.ApplyChanges(ctx, head, 0, p.decode(changes)); err != nil {
+		return "", err
+	}
+
+	p.logger.Info(fmt.Sprint("projection loaded from the state snapshot, ", len(entries), " entries"), zap.String("head", head))
+	return head, nil
+}
+
+// HandleStateDelta applies the changes made in the block
+func (p *Projector) HandleStateDelta(delta blockchain.StateDelta) error {
+	ctx, cancel := context.WithTimeout(context.Background(), applyTimeout)
+	defer cancel()
+
+	if err := p.store.ApplyChanges(ctx, delta.BlockID, delta.BlockNum, p.decode(delta.Changes)); err != nil {
+		return errors.New("failed to apply the state changes: " + err.Error())
+	}
+
+	return nil
+}
+
+func (p *Projector) decode(stateChanges []blockchain.StateChange) []Change {
+	proposalsPrefix := proposalfamily.GetProposalAddressFromID("")
+	docsPrefix := doctrackerfamily.GetDocsPrefix()
+
+	var changes []Change
+	for _, stateChange := range stateChanges {
+		change := Change{Address: stateChange.Address, Deleted: stateChange.Deleted}
+
+		switch {
+		case strings.HasPrefix(stateChange.Address, proposalsPrefix):
+			if !stateChange.Deleted {
+				proposal, err := blockchain.DecodeProposal(stateChange.Value)
+				if err != nil {
+					p.logger.Error("skipping the proposal change: "+err.Error(), zap.String("address", stateChange.Address))
+					continue
+				}
+				change.Proposal = &proposal
+			}
+
+		case strings.HasPrefix(stateChange.Address, docsPrefix):
+			if !stateChange.Deleted {
+				doc, err := blockchain.DecodeDocument(stateChange.Value)
+				if err != nil {
+					p.logger.Error("skipping the doc version change: "+err.Error(), zap.String("address", stateChange.Address))
+					continue
+				}
+				change.Document = &doc
+			}
+
+		default:
+			// user and doc entries of the families are derived from the proposals and doc versions
+			continue
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes
+}
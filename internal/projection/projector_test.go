@@ -0,0 +1,83 @@
+package projection_test
+
+import (
+	"context"
+	"doc-management/internal/blockchain"
+	"doc-management/internal/blockchain/doctrackerfamily"
+	"doc-management/internal/blockchain/proposalfamily"
+	"doc-management/internal/hashing"
+	"doc-management/internal/model"
+	"doc-management/internal/projection"
+	"testing"
+
+	"github.com/fxamacker/cbor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type storeMock struct {
+	blockID string
+	changes []projection.Change
+}
+
+func (s *storeMock) ApplyChanges(ctx context.Context, blockID string, blockNum uint64, changes []projection.Change) error {
+	s.blockID = blockID
+	s.changes = append(s.changes, changes...)
+	return nil
+}
+
+func (s *storeMock) LastBlock(ctx context.Context) (string, uint64, error) {
+	return s.blockID, 0, nil
+}
+
+func TestHandleStateDelta(t *testing.T) {
+	hashing.Initialize(zap.NewNop())
+	store := &storeMock{}
+	projector := projection.NewProjector(zap.NewNop(), blockchain.NewClient(zap.NewNop(), "localhost"), store)
+
+	proposalData, err := cbor.Marshal(proposalfamily.ProposalData{
+		ProposalID:        "some-proposal",
+		DocName:           "name",
+		Category:          "general",
+		Author:            "agatka",
+		ProposedDocStatus: string(model.DocStatusActive),
+		CurrentStatus:     string(model.ProposalStatusActive),
+	}, cbor.EncOptions{})
+	require.NoError(t, err)
+
+	doc := model.Document{DocumentName: "name", Category: "general", Author: "agatka", Version: 2, Status: model.DocStatusActive}
+	docData, err := cbor.Marshal(doc, cbor.EncOptions{})
+	require.NoError(t, err)
+
+	proposalAddr := proposalfamily.GetProposalAddressFromID("some-proposal")
+	docAddr := doctrackerfamily.GetDocVersionAddress(doc)
+
+	require.NoError(t, projector.HandleStateDelta(blockchain.StateDelta{
+		BlockID:  "abc",
+		BlockNum: 3,
+		Changes: []blockchain.StateChange{
+			{Address: proposalAddr, Value: proposalData},
+			{Address: docAddr, Value: docData},
+			// derived from the proposals, not projected
+			{Address: proposalfamily.GetUserAddress("agatka"), Value: []byte{}},
+			{Address: proposalAddr, Deleted: true},
+			{Address: proposalfamily.GetProposalAddressFromID("broken"), Value: []byte("not cbor")},
+		},
+	}))
+
+	lastBlock, _, err := store.LastBlock(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, "abc", lastBlock)
+
+	require.Len(t, store.changes, 3)
+	assert.Equal(t, proposalAddr, store.changes[0].Address)
+	require.NotNil(t, store.changes[0].Proposal)
+	assert.Equal(t, "some-proposal", store.changes[0].Proposal.ProposalID)
+	assert.Equal(t, "agatka", store.changes[0].Proposal.ModificationAuthor)
+
+	require.NotNil(t, store.changes[1].Document)
+	assert.Equal(t, doc, *store.changes[1].Document)
+
+	assert.Equal(t, projection.Change{Address: proposalAddr, Deleted: true}, store.changes[2])
+}
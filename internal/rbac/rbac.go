@@ -0,0 +1,176 @@
+package rbac
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AllCategories is the wildcard category of the role bindings
+const AllCategories = "*"
+
+var ErrForbidden = errors.New("permission denied")
+
+// Role is a set of permissions in a category, each role includes the permissions of the lower ones
+type Role string
+
+const (
+	RoleNone Role = ""
+	// can read the documents and proposals
+	RoleViewer Role = "viewer"
+	// can submit proposals
+	RoleProposer Role = "proposer"
+	// can sign proposals
+	RoleSigner Role = "signer"
+	// can act on behalf of other users
+	RoleAdmin Role = "admin"
+)
+
+var roleLevels = map[Role]int{
+	RoleNone:     0,
+	RoleViewer:   1,
+	RoleProposer: 2,
+	RoleSigner:   3,
+	RoleAdmin:    4,
+}
+
+func (r Role) IsValid() bool {
+	_, ok := roleLevels[r]
+	return ok && r != RoleNone
+}
+
+// Includes returns true if the role has at least the permissions of the other role
+func (r Role) Includes(other Role) bool {
+	return roleLevels[r] >= roleLevels[other]
+}
+
+// Subject is the authenticated user with the groups they belong to
+type Subject struct {
+	UserID string
+	Groups []string
+}
+
+// Binding assigns roles per category to a user or a group
+type Binding struct {
+	User  string `yaml:"user"`
+	Group string `yaml:"group"`
+	// category -> role, the category can be * for all categories
+	Roles map[string]Role `yaml:"roles"`
+}
+
+type Policy struct {
+	// role of all the authenticated users in all categories
+	DefaultRole Role      `yaml:"defaultRole"`
+	Bindings    []Binding `yaml:"bindings"`
+}
+
+func ReadPolicyFile(path string) (Policy, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return Policy{}, errors.New("failed to read the policy file: " + err.Error())
+	}
+
+	return ParsePolicy(content)
+}
+
+func ParsePolicy(content []byte) (Policy, error) {
+	var policy Policy
+	if err := yaml.Unmarshal(content, &policy); err != nil {
+		return Policy{}, errors.New("failed to parse the policy: " + err.Error())
+	}
+
+	if err := policy.Validate(); err != nil {
+		return Policy{}, err
+	}
+
+	return policy, nil
+}
+
+func (p Policy) Validate() error {
+	if p.DefaultRole != RoleNone && !p.DefaultRole.IsValid() {
+		return errors.New("invalid default role: " + string(p.DefaultRole))
+	}
+
+	for i, binding := range p.Bindings {
+		if (binding.User == "") == (binding.Group == "") {
+			return errors.New(fmt.Sprint("binding ", i, ": exactly one of user and group needs to be given"))
+		}
+		for category, role := range binding.Roles {
+			if !role.IsValid() {
+				return errors.New("invalid role " + string(role) + " in category " + category)
+			}
+		}
+	}
+
+	return nil
+}
+
+// RoleOf returns the highest role of the subject in the category
+func (p Policy) RoleOf(subject Subject, category string) Role {
+	role := p.DefaultRole
+
+	for _, binding := range p.Bindings {
+		if !binding.matches(subject) {
+			continue
+		}
+		for _, bound := range []Role{binding.Roles[AllCategories], binding.Roles[category]} {
+			if bound.Includes(role) {
+				role = bound
+			}
+		}
+	}
+
+	return role
+}
+
+// Authorize returns ErrForbidden if the subject doesn't have the role in the category
+func (p Policy) Authorize(subject Subject, category string, role Role) error {
+	if !p.RoleOf(subject, category).Includes(role) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// Categories returns the categories in which the subject has at least the given role;
+// all is true if the subject has the role in all the categories
+func (p Policy) Categories(subject Subject, role Role) (categories []string, all bool) {
+	if p.DefaultRole.Includes(role) {
+		return nil, true
+	}
+
+	seen := make(map[string]bool)
+	for _, binding := range p.Bindings {
+		if !binding.matches(subject) {
+			continue
+		}
+		for category, bound := range binding.Roles {
+			if !bound.Includes(role) {
+				continue
+			}
+			if category == AllCategories {
+				return nil, true
+			}
+			if !seen[category] {
+				seen[category] = true
+				categories = append(categories, category)
+			}
+		}
+	}
+
+	return categories, false
+}
+
+func (b Binding) matches(subject Subject) bool {
+	if b.User != "" {
+		return b.User == subject.UserID
+	}
+
+	for _, group := range subject.Groups {
+		if group == b.Group {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package rbac_test
+
+import (
+	"doc-management/internal/rbac"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPolicy = `
+defaultRole: viewer
+bindings:
+  - user: admin-id
+    roles:
+      "*": admin
+  - group: finance-group
+    roles:
+      finance: signer
+      general: proposer
+  - group: engineering-group
+    roles:
+      engineering: signer
+  - user: agatka
+    roles:
+      finance: proposer
+`
+
+func TestRoleOf(t *testing.T) {
+	policy, err := rbac.ParsePolicy([]byte(testPolicy))
+	require.NoError(t, err)
+
+	finance := rbac.Subject{UserID: "someone", Groups: []string{"finance-group"}}
+	engineer := rbac.Subject{UserID: "agatka", Groups: []string{"engineering-group"}}
+	admin := rbac.Subject{UserID: "admin-id"}
+
+	assert.Equal(t, rbac.RoleSigner, policy.RoleOf(finance, "finance"))
+	assert.Equal(t, rbac.RoleProposer, policy.RoleOf(finance, "general"))
+	assert.Equal(t, rbac.RoleViewer, policy.RoleOf(finance, "engineering"))
+
+	// the highest of the bound roles
+	assert.Equal(t, rbac.RoleProposer, policy.RoleOf(engineer, "finance"))
+	assert.ErrorIs(t, policy.Authorize(engineer, "finance", rbac.RoleSigner), rbac.ErrForbidden)
+	assert.NoError(t, policy.Authorize(engineer, "engineering", rbac.RoleSigner))
+
+	assert.Equal(t, rbac.RoleAdmin, policy.RoleOf(admin, "anything"))
+	assert.NoError(t, policy.Authorize(admin, "finance", rbac.RoleSigner))
+}
+
+func TestCategories(t *testing.T) {
+	policy, err := rbac.ParsePolicy([]byte(testPolicy))
+	require.NoError(t, err)
+
+	categories, all := policy.Categories(rbac.Subject{UserID: "agatka", Groups: []string{"engineering-group"}}, rbac.RoleSigner)
+	assert.False(t, all)
+	assert.Equal(t, []string{"engineering"}, categories)
+
+	_, all = policy.Categories(rbac.Subject{UserID: "nobody"}, rbac.RoleViewer)
+	assert.True(t, all)
+
+	_, all = policy.Categories(rbac.Subject{UserID: "admin-id"}, rbac.RoleSigner)
+	assert.True(t, all)
+
+	categories, all = policy.Categories(rbac.Subject{UserID: "nobody"}, rbac.RoleProposer)
+	assert.False(t, all)
+	assert.Empty(t, categories)
+}
+
+func TestInvalidPolicy(t *testing.T) {
+	_, err := rbac.ParsePolicy([]byte("bindings:\n  - user: a\n    roles:\n      general: owner\n"))
+	assert.Error(t, err)
+
+	_, err = rbac.ParsePolicy([]byte("bindings:\n  - user: a\n    group: b\n"))
+	assert.Error(t, err)
+
+	_, err = rbac.ParsePolicy([]byte("defaultRole: superuser\n"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+const (
+	proposalRefPrefix   = "proposal:"
+	docVersionRefPrefix = "doc:"
+)
+
+var (
+	ErrContentNotFound = errors.New("content not found")
+	// ErrNoContent is returned if the content to be stored is nil or has no hash
+	ErrNoContent = errors.New("no content given")
+)
+
+// ContentStore keeps the off-chain content of proposals and documents.
+// The content is addressed by its SHA-512 hash, the same one that is stored on the blockchain,
+// so the same content is stored only once. Proposals and document versions hold references to it.
+type ContentStore interface {
+	// PutContent stores the content under the given hash if it's not stored yet and adds the reference to it
+	PutContent(ctx context.Context, contentHash string, content []byte, ref string) error
+	// GetContent returns ErrContentNotFound if there is no content stored under the given hash
+	GetContent(ctx context.Context, contentHash string) ([]byte, error)
+	// HasContent tells if the content is stored under the given hash, without reading it
+	HasContent(ctx context.Context, contentHash string) (bool, error)
+	// AddRef adds the reference to the stored content without storing it again,
+	// returns ErrContentNotFound if there is no content stored under the given hash
+	AddRef(ctx context.Context, contentHash string, ref string) error
+	// ReleaseContent removes the reference, the content is deleted once it's not referenced anymore
+	ReleaseContent(ctx context.Context, contentHash string, ref string) error
+}
+
+// RewritableStore is a content store that allows listing the stored content and rewriting it in place,
+// keeping its references; used to transform the stored content, e.g. to re-encrypt it
+type RewritableStore interface {
+	ContentStore
+	// ListContent returns hashes of all the stored content
+	ListContent(ctx context.Context) ([]string, error)
+	RewriteContent(ctx context.Context, contentHash string, content []byte) error
+}
+
+// StreamingStore is a content store that writes and reads the content as a stream,
+// so that big content isn't held in memory as a whole
+type StreamingStore interface {
+	ContentStore
+	// PutContentFrom is PutContent with the content of the given size read from the reader;
+	// the reader isn't read if the content is already stored, ErrNoContent is returned if it is nil
+	PutContentFrom(ctx context.Context, contentHash string, content io.Reader, size int64, ref string) error
+	// OpenContent returns ErrContentNotFound if there is no content stored under the given hash
+	OpenContent(ctx context.Context, contentHash string) (io.ReadCloser, error)
+}
+
+// PutContentFrom stores the content read from the reader, streamed if the store supports it
+func PutContentFrom(ctx context.Context, store ContentStore, contentHash string, content io.Reader, size int64, ref string) error {
+	if content == nil || contentHash == "" {
+		return ErrNoContent
+	}
+
+	if streaming, ok := store.(StreamingStore); ok {
+		return streaming.PutContentFrom(ctx, contentHash, content, size, ref)
+	}
+
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		return errors.New("failed to read the content: " + err.Error())
+	}
+	return store.PutContent(ctx, contentHash, data, ref)
+}
+
+// OpenContent returns the reader of the stored content, streamed if the store supports it
+func OpenContent(ctx context.Context, store ContentStore, contentHash string) (io.ReadCloser, error) {
+	if streaming, ok := store.(StreamingStore); ok {
+		return streaming.OpenContent(ctx, contentHash)
+	}
+
+	data, err := store.GetContent(ctx, contentHash)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// RefListingStore is a content store that lists the references of all the stored content,
+// used to find the content not referenced by any proposal or doc version on the blockchain
+type RefListingStore interface {
+	ContentStore
+	// ListRefs returns the references of all the stored content by the content hash
+	ListRefs(ctx context.Context) (map[string][]string, error)
+}
+
+func ProposalRef(proposalID string) string {
+	return proposalRefPrefix + proposalID
+}
+
+func DocVersionRef(category, docName string, version int) string {
+	return docVersionRefPrefix + category + ";" + docName + ";" + fmt.Sprint(version)
+}
+
+// ParseProposalRef returns the proposal ID of the reference created by ProposalRef
+func ParseProposalRef(ref string) (proposalID string, ok bool) {
+	if !strings.HasPrefix(ref, proposalRefPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(ref, proposalRefPrefix), true
+}
+
+// ParseDocVersionRef returns the doc version of the reference created by DocVersionRef
+func ParseDocVersionRef(ref string) (category, docName string, version int, ok bool) {
+	if !strings.HasPrefix(ref, docVersionRefPrefix) {
+		return "", "", 0, false
+	}
+
+	// the doc name may contain the separator, the category and the version can't
+	parts := strings.Split(strings.TrimPrefix(ref, docVersionRefPrefix), ";")
+	if len(parts) < 3 {
+		return "", "", 0, false
+	}
+	version, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil {
+		return "", "", 0, false
+	}
+
+	return parts[0], strings.Join(parts[1:len(parts)-1], ";"), version, true
+}
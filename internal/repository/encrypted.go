@@ -0,0 +1,166 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"doc-management/internal/encryption"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// EncryptedStore encrypts the content before passing it to the underlying store.
+// The content hash is the hash of the plaintext, it's authenticated together with the content.
+type EncryptedStore struct {
+	store   ContentStore
+	keyring *encryption.Keyring
+}
+
+func NewEncryptedStore(store ContentStore, keyring *encryption.Keyring) (EncryptedStore, error) {
+	if keyring.Empty() {
+		return EncryptedStore{}, errors.New("no master key given")
+	}
+
+	return EncryptedStore{store: store, keyring: keyring}, nil
+}
+
+func (s EncryptedStore) PutContent(ctx context.Context, contentHash string, content []byte, ref string) error {
+	return s.PutContentFrom(ctx, contentHash, bytes.NewReader(content), int64(len(content)), ref)
+}
+
+// PutContentFrom encrypts the content chunk by chunk while it's streamed to the underlying store
+func (s EncryptedStore) PutContentFrom(ctx context.Context, contentHash string, content io.Reader, size int64, ref string) error {
+	if content == nil || contentHash == "" {
+		return ErrNoContent
+	}
+
+	sealed, sealedSize, err := encryption.SealStream(s.keyring, content, size, []byte(contentHash))
+	if err != nil {
+		return errors.New("failed to encrypt the content: " + err.Error())
+	}
+
+	return PutContentFrom(ctx, s.store, contentHash, sealed, sealedSize, ref)
+}
+
+func (s EncryptedStore) GetContent(ctx context.Context, contentHash string) ([]byte, error) {
+	reader, err := s.OpenContent(ctx, contentHash)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, errors.New("failed to decrypt the content: " + err.Error())
+	}
+
+	return content, nil
+}
+
+// OpenContent decrypts the content chunk by chunk while it's read
+func (s EncryptedStore) OpenContent(ctx context.Context, contentHash string) (io.ReadCloser, error) {
+	stored, err := OpenContent(ctx, s.store, contentHash)
+	if err != nil {
+		return nil, err
+	}
+
+	buffered := bufio.NewReader(stored)
+	// the error is returned when reading, the content can be shorter than the magic
+	prefix, _ := buffered.Peek(encryption.MagicSize)
+
+	// stored before enabling the encryption
+	if !encryption.IsEnvelope(prefix) {
+		return decryptedContent{buffered, stored}, nil
+	}
+
+	return decryptedContent{encryption.OpenStream(s.keyring, buffered, []byte(contentHash)), stored}, nil
+}
+
+// decryptedContent closes the stored content read through the decrypting reader
+type decryptedContent struct {
+	io.Reader
+	stored io.Closer
+}
+
+func (c decryptedContent) Close() error {
+	return c.stored.Close()
+}
+
+func (s EncryptedStore) HasContent(ctx context.Context, contentHash string) (bool, error) {
+	return s.store.HasContent(ctx, contentHash)
+}
+
+func (s EncryptedStore) AddRef(ctx context.Context, contentHash string, ref string) error {
+	return s.store.AddRef(ctx, contentHash, ref)
+}
+
+func (s EncryptedStore) ReleaseContent(ctx context.Context, contentHash string, ref string) error {
+	return s.store.ReleaseContent(ctx, contentHash, ref)
+}
+
+// ListRefs returns the references of the content kept by the underlying store, if it lists them
+func (s EncryptedStore) ListRefs(ctx context.Context) (map[string][]string, error) {
+	store, ok := s.store.(RefListingStore)
+	if !ok {
+		return nil, errors.New("the content store doesn't support listing the content references")
+	}
+
+	return store.ListRefs(ctx)
+}
+
+// RotateKeys wraps the data keys of all the stored content with the active master key;
+// the content stored before enabling the encryption gets encrypted.
+// Returns the number of the rewritten entries.
+func (s EncryptedStore) RotateKeys(ctx context.Context) (rewritten int, err error) {
+	store, ok := s.store.(RewritableStore)
+	if !ok {
+		return 0, errors.New("the content store doesn't support rewriting the content")
+	}
+
+	hashes, err := store.ListContent(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, contentHash := range hashes {
+		stored, err := store.GetContent(ctx, contentHash)
+		if err == ErrContentNotFound {
+			// released in the meantime
+			continue
+		}
+		if err != nil {
+			return rewritten, err
+		}
+
+		var updated []byte
+		changed := true
+		if encryption.IsEnvelope(stored) {
+			updated, changed, err = encryption.Rewrap(s.keyring, stored)
+		} else {
+			updated, err = sealContent(s.keyring, stored, contentHash)
+		}
+		if err != nil {
+			return rewritten, errors.New("failed to re-encrypt the content " + contentHash + ": " + err.Error())
+		}
+
+		if !changed {
+			continue
+		}
+
+		if err := store.RewriteContent(ctx, contentHash, updated); err != nil {
+			return rewritten, err
+		}
+		rewritten++
+	}
+
+	return rewritten, nil
+}
+
+func sealContent(keyring *encryption.Keyring, content []byte, contentHash string) ([]byte, error) {
+	sealed, _, err := encryption.SealStream(keyring, bytes.NewReader(content), int64(len(content)), []byte(contentHash))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(sealed)
+}
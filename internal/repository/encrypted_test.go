@@ -0,0 +1,123 @@
+package repository_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"doc-management/internal/encryption"
+	"doc-management/internal/hashing"
+	"doc-management/internal/repository"
+	"doc-management/internal/repository/filesystem"
+	"encoding/base64"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newMasterKey(t *testing.T) string {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func TestEncryptedStore(t *testing.T) {
+	hashing.Initialize(zap.NewNop())
+	blobs, err := filesystem.NewStore(t.TempDir())
+	require.NoError(t, err)
+	plain := repository.NewRefCountedStore(blobs)
+
+	// content stored before enabling the encryption
+	legacy := []byte("mala agatka")
+	legacyHash := hashing.CalculateSHA512(string(legacy))
+	require.NoError(t, plain.PutContent(context.TODO(), legacyHash, legacy, repository.ProposalRef("legacy")))
+
+	keyring := encryption.NewKeyring()
+	require.NoError(t, keyring.AddKey("first", newMasterKey(t)))
+	store, err := repository.NewEncryptedStore(plain, keyring)
+	require.NoError(t, err)
+
+	content := []byte("mniejsza agatka")
+	hash := hashing.CalculateSHA512(string(content))
+	require.NoError(t, store.PutContent(context.TODO(), hash, content, repository.ProposalRef("new")))
+
+	stored, err := plain.GetContent(context.TODO(), hash)
+	require.NoError(t, err)
+	assert.True(t, encryption.IsEnvelope(stored))
+	assert.NotContains(t, string(stored), string(content))
+
+	decrypted, err := store.GetContent(context.TODO(), hash)
+	require.NoError(t, err)
+	assert.Equal(t, content, decrypted)
+
+	decrypted, err = store.GetContent(context.TODO(), legacyHash)
+	require.NoError(t, err)
+	assert.Equal(t, legacy, decrypted)
+
+	// the new key wraps the data keys, the legacy content gets encrypted
+	secondKey := newMasterKey(t)
+	require.NoError(t, keyring.AddKey("second", secondKey))
+	rewritten, err := store.RotateKeys(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, 2, rewritten)
+
+	rewritten, err = store.RotateKeys(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, 0, rewritten)
+
+	stored, err = plain.GetContent(context.TODO(), legacyHash)
+	require.NoError(t, err)
+	assert.True(t, encryption.IsEnvelope(stored))
+
+	// the first key is no longer needed
+	onlySecond := encryption.NewKeyring()
+	require.NoError(t, onlySecond.AddKey("second", secondKey))
+	store, err = repository.NewEncryptedStore(plain, onlySecond)
+	require.NoError(t, err)
+
+	for hash, expected := range map[string][]byte{hash: content, legacyHash: legacy} {
+		decrypted, err := store.GetContent(context.TODO(), hash)
+		require.NoError(t, err)
+		assert.Equal(t, expected, decrypted)
+	}
+}
+
+func TestEncryptedStoreStreaming(t *testing.T) {
+	hashing.Initialize(zap.NewNop())
+	blobs, err := filesystem.NewStore(t.TempDir())
+	require.NoError(t, err)
+	plain := repository.NewRefCountedStore(blobs)
+
+	keyring := encryption.NewKeyring()
+	require.NoError(t, keyring.AddKey("first", newMasterKey(t)))
+	store, err := repository.NewEncryptedStore(plain, keyring)
+	require.NoError(t, err)
+
+	// stored as a whole before streaming
+	legacy := []byte("mala agatka")
+	legacyHash := hashing.CalculateSHA512(string(legacy))
+	sealed, err := encryption.Seal(keyring, legacy, []byte(legacyHash))
+	require.NoError(t, err)
+	require.NoError(t, plain.PutContent(context.TODO(), legacyHash, sealed, repository.ProposalRef("legacy")))
+
+	content := make([]byte, 1<<20+100)
+	_, err = rand.Read(content)
+	require.NoError(t, err)
+	hash := hashing.CalculateSHA512(string(content))
+	require.NoError(t, store.PutContentFrom(context.TODO(), hash, bytes.NewReader(content), int64(len(content)), repository.ProposalRef("new")))
+
+	for hash, expected := range map[string][]byte{hash: content, legacyHash: legacy} {
+		reader, err := store.OpenContent(context.TODO(), hash)
+		require.NoError(t, err)
+		decrypted, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		assert.Equal(t, expected, decrypted)
+	}
+
+	_, err = store.OpenContent(context.TODO(), hashing.CalculateSHA512("missing"))
+	assert.ErrorIs(t, err, repository.ErrContentNotFound)
+}
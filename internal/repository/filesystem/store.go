@@ -0,0 +1,158 @@
+package filesystem
+
+import (
+	"bytes"
+	"context"
+	"doc-management/internal/repository"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// objects are named after the content hash, optionally with a suffix
+var validName = regexp.MustCompile(`^[0-9a-f]{2,}(\.[a-z]+)?$`)
+
+// Store keeps the objects in files grouped in subdirectories by the first two name characters
+type Store struct {
+	rootDir string
+}
+
+func NewStore(rootDir string) (Store, error) {
+	if err := os.MkdirAll(rootDir, 0700); err != nil {
+		return Store{}, errors.New("failed to create the content directory: " + err.Error())
+	}
+
+	return Store{rootDir: rootDir}, nil
+}
+
+func (s Store) objectPath(name string) (string, error) {
+	// the name is used as a file name, it must not be able to point outside of the root dir
+	if !validName.MatchString(name) {
+		return "", errors.New("invalid object name: " + name)
+	}
+
+	return filepath.Join(s.rootDir, name[:2], name), nil
+}
+
+func (s Store) PutBlob(ctx context.Context, name string, data []byte) error {
+	return s.PutBlobFrom(ctx, name, bytes.NewReader(data))
+}
+
+func (s Store) PutBlobFrom(ctx context.Context, name string, data io.Reader) error {
+	path, err := s.objectPath(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.New("failed to create the content directory: " + err.Error())
+	}
+
+	// write to a temporary file first, so that a partially written file is never read
+	tmp, err := os.CreateTemp(filepath.Dir(path), name+".*.tmp")
+	if err != nil {
+		return errors.New("failed to create the content file: " + err.Error())
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, data); err != nil {
+		tmp.Close()
+		return errors.New("failed to write the content: " + err.Error())
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.New("failed to write the content: " + err.Error())
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.New("failed to store the content: " + err.Error())
+	}
+
+	return nil
+}
+
+func (s Store) GetBlob(ctx context.Context, name string) ([]byte, error) {
+	path, err := s.objectPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, repository.ErrContentNotFound
+	}
+	if err != nil {
+		return nil, errors.New("failed to read the content: " + err.Error())
+	}
+
+	return data, nil
+}
+
+func (s Store) HasBlob(ctx context.Context, name string) (bool, error) {
+	path, err := s.objectPath(name)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.New("failed to check the content: " + err.Error())
+	}
+
+	return true, nil
+}
+
+func (s Store) OpenBlob(ctx context.Context, name string) (io.ReadCloser, error) {
+	path, err := s.objectPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, repository.ErrContentNotFound
+	}
+	if err != nil {
+		return nil, errors.New("failed to read the content: " + err.Error())
+	}
+
+	return file, nil
+}
+
+func (s Store) DeleteBlob(ctx context.Context, name string) error {
+	path, err := s.objectPath(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return errors.New("failed to remove the content: " + err.Error())
+	}
+
+	return nil
+}
+
+func (s Store) ListBlobs(ctx context.Context) ([]string, error) {
+	var names []string
+
+	err := filepath.WalkDir(s.rootDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// skip the directories and the temporary files
+		if !entry.IsDir() && validName.MatchString(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.New("failed to list the content directory: " + err.Error())
+	}
+
+	return names, nil
+}
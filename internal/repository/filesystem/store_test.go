@@ -0,0 +1,54 @@
+package filesystem_test
+
+import (
+	"context"
+	"doc-management/internal/repository"
+	"doc-management/internal/repository/filesystem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPutGetDelete(t *testing.T) {
+	store, err := filesystem.NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	name := "3768b1bbee7097f5"
+
+	_, err = store.GetBlob(context.TODO(), name)
+	assert.ErrorIs(t, err, repository.ErrContentNotFound)
+	exists, err := store.HasBlob(context.TODO(), name)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, store.PutBlob(context.TODO(), name, []byte("mala agatka")))
+	require.NoError(t, store.PutBlob(context.TODO(), name, []byte("mniejsza agatka")))
+
+	stored, err := store.GetBlob(context.TODO(), name)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("mniejsza agatka"), stored)
+	exists, err = store.HasBlob(context.TODO(), name)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	names, err := store.ListBlobs(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, []string{name}, names)
+
+	require.NoError(t, store.DeleteBlob(context.TODO(), name))
+	require.NoError(t, store.DeleteBlob(context.TODO(), name))
+
+	_, err = store.GetBlob(context.TODO(), name)
+	assert.ErrorIs(t, err, repository.ErrContentNotFound)
+}
+
+func TestInvalidName(t *testing.T) {
+	store, err := filesystem.NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	assert.Error(t, store.PutBlob(context.TODO(), "../../etc/passwd", []byte("x")))
+	_, err = store.GetBlob(context.TODO(), "../secret")
+	assert.Error(t, err)
+	assert.NoError(t, store.PutBlob(context.TODO(), "3768b1bb.refs", []byte("[]")))
+}
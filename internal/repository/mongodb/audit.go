@@ -0,0 +1,20 @@
+package mongodb
+
+import (
+	"context"
+	"doc-management/internal/audit"
+	"doc-management/internal/config"
+	"errors"
+)
+
+const auditCollection = "audit"
+
+func (b Repository) Record(ctx context.Context, entry audit.Entry) error {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(auditCollection)
+
+	if _, err := coll.InsertOne(ctx, entry); err != nil {
+		return errors.New("failed to insert the audit entry: " + err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package mongodb
+
+import (
+	"context"
+	"doc-management/internal/audit"
+	"doc-management/internal/batches"
+	"doc-management/internal/blockchain"
+	"doc-management/internal/config"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const batchesCollection = "batches"
+
+type storedBatch struct {
+	ID                  string `bson:"_id"`
+	UserID              string
+	Action              audit.Action
+	Target              string
+	Status              string
+	InvalidTransactions []blockchain.InvalidTransaction
+	SubmittedAt         time.Time
+	UpdatedAt           time.Time
+}
+
+func (b Repository) EnsureBatchesIndex(ctx context.Context) error {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(batchesCollection)
+
+	_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "submittedat", Value: 1}},
+	})
+	if err != nil {
+		return errors.New("failed to create the batches index: " + err.Error())
+	}
+
+	return nil
+}
+
+func (b Repository) InsertBatch(ctx context.Context, batch batches.Batch) error {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(batchesCollection)
+
+	if _, err := coll.InsertOne(ctx, storedBatch(batch)); err != nil {
+		return errors.New("failed to store the batch: " + err.Error())
+	}
+
+	return nil
+}
+
+func (b Repository) GetBatch(ctx context.Context, batchID string) (batches.Batch, error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(batchesCollection)
+
+	var batch storedBatch
+	err := coll.FindOne(ctx, bson.M{"_id": batchID}).Decode(&batch)
+	if err == mongo.ErrNoDocuments {
+		return batches.Batch{}, batches.ErrNotFound
+	}
+	if err != nil {
+		return batches.Batch{}, errors.New("failed to get the batch: " + err.Error())
+	}
+
+	return batches.Batch(batch), nil
+}
+
+func (b Repository) UpdateBatch(ctx context.Context, batch batches.Batch) error {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(batchesCollection)
+
+	result, err := coll.ReplaceOne(ctx, bson.M{"_id": batch.ID}, storedBatch(batch))
+	if err != nil {
+		return errors.New("failed to update the batch: " + err.Error())
+	}
+	if result.MatchedCount == 0 {
+		return batches.ErrNotFound
+	}
+
+	return nil
+}
+
+func (b Repository) PendingBatches(ctx context.Context, limit int) ([]batches.Batch, error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(batchesCollection)
+
+	opts := options.Find().SetSort(bson.D{{Key: "submittedat", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := coll.Find(ctx, bson.M{"status": blockchain.BatchPending}, opts)
+	if err != nil {
+		return nil, errors.New("failed to get the pending batches: " + err.Error())
+	}
+
+	var stored []storedBatch
+	if err := cursor.All(ctx, &stored); err != nil {
+		return nil, errors.New("failed to decode the pending batches: " + err.Error())
+	}
+
+	pending := make([]batches.Batch, len(stored))
+	for i, batch := range stored {
+		pending[i] = batches.Batch(batch)
+	}
+	return pending, nil
+}
@@ -0,0 +1,192 @@
+package mongodb
+
+import (
+	"context"
+	"doc-management/internal/catalog"
+	"doc-management/internal/config"
+	"doc-management/internal/model"
+	"doc-management/internal/paging"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const catalogCollection = "catalog"
+
+type catalogDoc struct {
+	ID            string `bson:"_id"`
+	Category      string
+	DocumentName  string
+	LatestVersion int
+	Status        model.DocStatus
+	Author        string
+	FileName      string
+	ContentType   string
+	UpdatedAt     time.Time
+}
+
+func catalogID(category, docName string) string {
+	return category + ";" + docName
+}
+
+func (b Repository) EnsureCatalogIndex(ctx context.Context) error {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(catalogCollection)
+
+	_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "category", Value: 1}, {Key: "documentname", Value: 1}},
+	})
+	if err != nil {
+		return errors.New("failed to create the catalog index: " + err.Error())
+	}
+
+	return nil
+}
+
+func (b Repository) UpdateDocument(ctx context.Context, doc model.Document) error {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(catalogCollection)
+
+	entry := catalog.NewDocument(doc)
+	toSet := catalogDoc{
+		ID:            catalogID(entry.Category, entry.DocumentName),
+		Category:      entry.Category,
+		DocumentName:  entry.DocumentName,
+		LatestVersion: entry.LatestVersion,
+		Status:        entry.Status,
+		Author:        entry.Author,
+		FileName:      entry.FileName,
+		ContentType:   entry.ContentType,
+		UpdatedAt:     entry.UpdatedAt,
+	}
+
+	// if the same or a newer version is already in the catalog, the filter doesn't match and the upsert fails
+	_, err := coll.ReplaceOne(ctx,
+		bson.M{"_id": toSet.ID, "latestversion": bson.M{"$lt": toSet.LatestVersion}},
+		toSet,
+		options.Replace().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		b.logger.Debug("version already in the catalog, skipping: " + toSet.ID)
+		return nil
+	}
+	if err != nil {
+		return errors.New("failed to update the catalog: " + err.Error())
+	}
+
+	return nil
+}
+
+func (b Repository) UpdateDocumentStatus(ctx context.Context, category, docName string, version int, status model.DocStatus) error {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(catalogCollection)
+
+	_, err := coll.UpdateOne(ctx,
+		bson.M{"_id": catalogID(category, docName), "latestversion": version},
+		bson.M{"$set": bson.M{"status": status, "updatedat": time.Now().UTC()}})
+	if err != nil {
+		return errors.New("failed to update the catalog status: " + err.Error())
+	}
+
+	return nil
+}
+
+func (b Repository) ListCategories(ctx context.Context, only []string, page paging.Page) (categories []catalog.Category, next string, total int64, err error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(catalogCollection)
+
+	filter := bson.M{}
+	if only != nil {
+		filter["category"] = bson.M{"$in": only}
+	}
+
+	group := bson.M{
+		"_id":           "$category",
+		"documentcount": bson.M{"$sum": 1},
+		"updatedat":     bson.M{"$max": "$updatedat"},
+	}
+
+	cursor, err := coll.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$and": bson.A{filter, bson.M{"category": bson.M{"$gt": page.Cursor}}}}}},
+		{{Key: "$group", Value: group}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		// one more to know if there is a next page
+		{{Key: "$limit", Value: page.Limit + 1}},
+	})
+	if err != nil {
+		return nil, "", 0, errors.New("failed to list the categories: " + err.Error())
+	}
+
+	var found []struct {
+		Name          string `bson:"_id"`
+		DocumentCount int
+		UpdatedAt     time.Time
+	}
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, "", 0, errors.New("failed to get the categories from the cursor: " + err.Error())
+	}
+
+	if len(found) > page.Limit {
+		found = found[:page.Limit]
+		next = found[len(found)-1].Name
+	}
+
+	categories = make([]catalog.Category, len(found))
+	for i, category := range found {
+		categories[i] = catalog.Category{
+			Name:          category.Name,
+			DocumentCount: category.DocumentCount,
+			UpdatedAt:     category.UpdatedAt,
+		}
+	}
+
+	distinct, err := coll.Distinct(ctx, "category", filter)
+	if err != nil {
+		return nil, "", 0, errors.New("failed to count the categories: " + err.Error())
+	}
+
+	return categories, next, int64(len(distinct)), nil
+}
+
+func (b Repository) ListDocuments(ctx context.Context, category string, page paging.Page) (docs []catalog.Document, next string, total int64, err error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(catalogCollection)
+
+	cursor, err := coll.Find(ctx,
+		bson.M{"category": category, "documentname": bson.M{"$gt": page.Cursor}},
+		options.Find().
+			SetSort(bson.D{{Key: "documentname", Value: 1}}).
+			// one more to know if there is a next page
+			SetLimit(int64(page.Limit+1)))
+	if err != nil {
+		return nil, "", 0, errors.New("failed to list the documents: " + err.Error())
+	}
+
+	var found []catalogDoc
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, "", 0, errors.New("failed to get the documents from the cursor: " + err.Error())
+	}
+
+	if len(found) > page.Limit {
+		found = found[:page.Limit]
+		next = found[len(found)-1].DocumentName
+	}
+
+	docs = make([]catalog.Document, len(found))
+	for i, doc := range found {
+		docs[i] = catalog.Document{
+			Category:      doc.Category,
+			DocumentName:  doc.DocumentName,
+			LatestVersion: doc.LatestVersion,
+			Status:        doc.Status,
+			Author:        doc.Author,
+			FileName:      doc.FileName,
+			ContentType:   doc.ContentType,
+			UpdatedAt:     doc.UpdatedAt,
+		}
+	}
+
+	total, err = coll.CountDocuments(ctx, bson.M{"category": category})
+	if err != nil {
+		return nil, "", 0, errors.New("failed to count the documents: " + err.Error())
+	}
+
+	return docs, next, total, nil
+}
@@ -0,0 +1,370 @@
+package mongodb
+
+import (
+	"bytes"
+	"context"
+	"doc-management/internal/config"
+	"doc-management/internal/repository"
+	"errors"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	contentCollection = "contents"
+
+	// content bigger than this is stored in GridFS chunks instead of inline,
+	// as a single BSON document can't exceed 16MB
+	maxInlineContentSize = 8 * 1024 * 1024
+
+	// fallback deadline of GridFS operations if the context has none
+	gridFSTimeout = time.Minute
+)
+
+type storedContent struct {
+	ContentHash string `bson:"_id"`
+	// inline content, empty if chunked
+	Content []byte
+	// if true, the content is stored in the GridFS bucket under the chunks ID
+	Chunked bool `bson:",omitempty"`
+	// GridFS file ID of the chunks, the content hash if empty;
+	// rewritten content gets new chunks so that the old ones stay readable until swapped
+	ChunksID string `bson:",omitempty"`
+	// proposals and doc versions referencing the content
+	Refs []string
+}
+
+func (c storedContent) chunksID() string {
+	if c.ChunksID != "" {
+		return c.ChunksID
+	}
+	return c.ContentHash
+}
+
+func (b Repository) PutContent(ctx context.Context, contentHash string, content []byte, ref string) error {
+	return b.PutContentFrom(ctx, contentHash, bytes.NewReader(content), int64(len(content)), ref)
+}
+
+func (b Repository) PutContentFrom(ctx context.Context, contentHash string, content io.Reader, size int64, ref string) error {
+	if content == nil || contentHash == "" {
+		return repository.ErrNoContent
+	}
+
+	coll := b.client.Database(config.GetDatabaseName()).Collection(contentCollection)
+
+	// if the content is already stored, only add the reference
+	added, err := b.addContentRef(ctx, contentHash, ref)
+	if err != nil || added {
+		return err
+	}
+
+	toInsert := storedContent{
+		ContentHash: contentHash,
+		Refs:        []string{ref},
+	}
+
+	if size > maxInlineContentSize {
+		if err := b.uploadChunks(ctx, contentHash, content); err != nil {
+			return err
+		}
+		toInsert.Chunked = true
+	} else if toInsert.Content, err = ioutil.ReadAll(content); err != nil {
+		return errors.New("failed to read the content: " + err.Error())
+	}
+
+	if _, err := coll.InsertOne(ctx, toInsert); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			// inserted in the meantime
+			if added, err := b.addContentRef(ctx, contentHash, ref); err != nil || added {
+				return err
+			}
+			return errors.New("failed to insert the content: removed in the meantime")
+		}
+		if toInsert.Chunked {
+			_ = b.removeChunks(context.Background(), contentHash)
+		}
+		return errors.New("failed to insert the content: " + err.Error())
+	}
+
+	return nil
+}
+
+// addContentRef returns false if the content isn't stored
+func (b Repository) addContentRef(ctx context.Context, contentHash string, ref string) (bool, error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(contentCollection)
+
+	result, err := coll.UpdateByID(ctx, contentHash, bson.M{"$addToSet": bson.M{"refs": ref}})
+	if err != nil {
+		return false, errors.New("failed to add the content reference: " + err.Error())
+	}
+
+	return result.MatchedCount > 0, nil
+}
+
+func (b Repository) AddRef(ctx context.Context, contentHash string, ref string) error {
+	stored, err := b.addContentRef(ctx, contentHash, ref)
+	if err != nil {
+		return err
+	}
+	if !stored {
+		return repository.ErrContentNotFound
+	}
+
+	return nil
+}
+
+func (b Repository) GetContent(ctx context.Context, contentHash string) ([]byte, error) {
+	reader, err := b.OpenContent(ctx, contentHash)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, errors.New("failed to download the content from GridFS: " + err.Error())
+	}
+
+	return content, nil
+}
+
+func (b Repository) HasContent(ctx context.Context, contentHash string) (bool, error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(contentCollection)
+
+	count, err := coll.CountDocuments(ctx, bson.M{"_id": contentHash}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, errors.New("failed to check the content: " + err.Error())
+	}
+
+	return count > 0, nil
+}
+
+func (b Repository) OpenContent(ctx context.Context, contentHash string) (io.ReadCloser, error) {
+	fromDB, err := b.findContent(ctx, contentHash)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := b.openStoredContent(ctx, fromDB)
+	if err == gridfs.ErrFileNotFound {
+		// the content got rewritten and the old chunks removed after the content was read
+		if fromDB, err = b.findContent(ctx, contentHash); err != nil {
+			return nil, err
+		}
+		content, err = b.openStoredContent(ctx, fromDB)
+	}
+	if err != nil {
+		return nil, errors.New("failed to download the content from GridFS: " + err.Error())
+	}
+
+	return content, nil
+}
+
+func (b Repository) findContent(ctx context.Context, contentHash string) (storedContent, error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(contentCollection)
+
+	var fromDB storedContent
+	if err := coll.FindOne(ctx, bson.M{"_id": contentHash}).Decode(&fromDB); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return storedContent{}, repository.ErrContentNotFound
+		}
+		return storedContent{}, errors.New("failed to find the content: " + err.Error())
+	}
+
+	return fromDB, nil
+}
+
+func (b Repository) openStoredContent(ctx context.Context, fromDB storedContent) (io.ReadCloser, error) {
+	if !fromDB.Chunked {
+		return ioutil.NopCloser(bytes.NewReader(fromDB.Content)), nil
+	}
+
+	return b.openChunks(ctx, fromDB.chunksID())
+}
+
+func (b Repository) ReleaseContent(ctx context.Context, contentHash string, ref string) error {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(contentCollection)
+
+	result, err := coll.UpdateByID(ctx, contentHash, bson.M{"$pull": bson.M{"refs": ref}})
+	if err != nil {
+		return errors.New("failed to remove the content reference: " + err.Error())
+	}
+	if result.MatchedCount == 0 {
+		b.logger.Debug("content not found, can't be released: " + contentHash)
+		return nil
+	}
+
+	// remove only if no reference was added in the meantime
+	var deleted storedContent
+	err = coll.FindOneAndDelete(ctx, bson.M{"_id": contentHash, "refs": bson.M{"$size": 0}},
+		options.FindOneAndDelete().SetProjection(bson.M{"chunked": 1, "chunksid": 1})).Decode(&deleted)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return errors.New("failed to remove the content: " + err.Error())
+	}
+	if !deleted.Chunked {
+		return nil
+	}
+
+	deleted.ContentHash = contentHash
+	return b.removeChunks(ctx, deleted.chunksID())
+}
+
+func (b Repository) ListContent(ctx context.Context) ([]string, error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(contentCollection)
+
+	cursor, err := coll.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, errors.New("failed to find the content: " + err.Error())
+	}
+
+	var ids []struct {
+		ContentHash string `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &ids); err != nil {
+		return nil, errors.New("failed to get the content hashes from the cursor: " + err.Error())
+	}
+
+	hashes := make([]string, len(ids))
+	for i, id := range ids {
+		hashes[i] = id.ContentHash
+	}
+
+	return hashes, nil
+}
+
+func (b Repository) ListRefs(ctx context.Context) (map[string][]string, error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(contentCollection)
+
+	cursor, err := coll.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"_id": 1, "refs": 1}))
+	if err != nil {
+		return nil, errors.New("failed to find the content: " + err.Error())
+	}
+
+	var stored []struct {
+		ContentHash string `bson:"_id"`
+		Refs        []string
+	}
+	if err := cursor.All(ctx, &stored); err != nil {
+		return nil, errors.New("failed to get the content references from the cursor: " + err.Error())
+	}
+
+	refs := make(map[string][]string, len(stored))
+	for _, content := range stored {
+		refs[content.ContentHash] = content.Refs
+	}
+
+	return refs, nil
+}
+
+// RewriteContent replaces the content keeping its references. Chunked content is uploaded
+// under a new chunks ID and swapped in the content record, the previous chunks are removed
+// only afterwards, so the content stays readable during the rewrite.
+func (b Repository) RewriteContent(ctx context.Context, contentHash string, content []byte) error {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(contentCollection)
+
+	var previous storedContent
+	err := coll.FindOne(ctx, bson.M{"_id": contentHash}, options.FindOne().SetProjection(bson.M{"chunked": 1, "chunksid": 1})).Decode(&previous)
+	if err == mongo.ErrNoDocuments {
+		// released in the meantime
+		return nil
+	}
+	if err != nil {
+		return errors.New("failed to find the content: " + err.Error())
+	}
+	previous.ContentHash = contentHash
+
+	update := bson.M{"$set": bson.M{"content": content, "chunked": false}, "$unset": bson.M{"chunksid": ""}}
+	chunksID := ""
+	if len(content) > maxInlineContentSize {
+		chunksID = contentHash + ":" + primitive.NewObjectID().Hex()
+		if err := b.uploadChunks(ctx, chunksID, bytes.NewReader(content)); err != nil {
+			return err
+		}
+		update = bson.M{"$set": bson.M{"content": nil, "chunked": true, "chunksid": chunksID}}
+	}
+
+	result, err := coll.UpdateByID(ctx, contentHash, update)
+	if err != nil || result.MatchedCount == 0 {
+		if chunksID != "" {
+			_ = b.removeChunks(context.Background(), chunksID)
+		}
+		if err != nil {
+			return errors.New("failed to rewrite the content: " + err.Error())
+		}
+		return nil
+	}
+
+	if previous.Chunked {
+		if err := b.removeChunks(ctx, previous.chunksID()); err != nil {
+			b.logger.Warn("failed to remove the rewritten chunks of " + contentHash + ": " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+func (b Repository) getBucket(ctx context.Context) (*gridfs.Bucket, error) {
+	bucket, err := gridfs.NewBucket(b.client.Database(config.GetDatabaseName()), options.GridFSBucket().SetName(contentCollection))
+	if err != nil {
+		return nil, errors.New("failed to open the GridFS bucket: " + err.Error())
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(gridFSTimeout)
+	}
+	if err := bucket.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	if err := bucket.SetWriteDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	return bucket, nil
+}
+
+func (b Repository) uploadChunks(ctx context.Context, chunksID string, content io.Reader) error {
+	bucket, err := b.getBucket(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := bucket.UploadFromStreamWithID(chunksID, chunksID, content); err != nil {
+		return errors.New("failed to upload the content to GridFS: " + err.Error())
+	}
+
+	return nil
+}
+
+// openChunks returns gridfs.ErrFileNotFound unwrapped if the chunks don't exist
+func (b Repository) openChunks(ctx context.Context, chunksID string) (io.ReadCloser, error) {
+	bucket, err := b.getBucket(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return bucket.OpenDownloadStream(chunksID)
+}
+
+func (b Repository) removeChunks(ctx context.Context, chunksID string) error {
+	bucket, err := b.getBucket(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := bucket.Delete(chunksID); err != nil && err != gridfs.ErrFileNotFound {
+		return errors.New("failed to remove the content from GridFS: " + err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package mongodb
+
+import (
+	"context"
+	"doc-management/internal/config"
+	"doc-management/internal/inbox"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	inboxCollection      = "inbox"
+	eventStateCollection = "event_state"
+)
+
+type storedEvent struct {
+	ID            string `bson:"_id"`
+	Type          string
+	Key           string
+	Data          []byte
+	BlockID       string
+	BlockNum      uint64
+	Status        inbox.Status
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	ReceivedAt    time.Time
+	UpdatedAt     time.Time
+}
+
+func (b Repository) EnsureInboxIndex(ctx context.Context) error {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(inboxCollection)
+
+	_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "nextattemptat", Value: 1}},
+	})
+	if err != nil {
+		return errors.New("failed to create the inbox index: " + err.Error())
+	}
+
+	return nil
+}
+
+func (b Repository) InsertEvent(ctx context.Context, event inbox.Event) (bool, error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(inboxCollection)
+
+	_, err := coll.InsertOne(ctx, storedEvent(event))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.New("failed to store the event: " + err.Error())
+	}
+
+	return true, nil
+}
+
+func (b Repository) GetEvent(ctx context.Context, id string) (inbox.Event, error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(inboxCollection)
+
+	var event storedEvent
+	err := coll.FindOne(ctx, bson.M{"_id": id}).Decode(&event)
+	if err == mongo.ErrNoDocuments {
+		return inbox.Event{}, inbox.ErrNotFound
+	}
+	if err != nil {
+		return inbox.Event{}, errors.New("failed to get the event: " + err.Error())
+	}
+
+	return inbox.Event(event), nil
+}
+
+func (b Repository) UpdateEvent(ctx context.Context, event inbox.Event) error {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(inboxCollection)
+
+	result, err := coll.ReplaceOne(ctx, bson.M{"_id": event.ID}, storedEvent(event))
+	if err != nil {
+		return errors.New("failed to update the event: " + err.Error())
+	}
+	if result.MatchedCount == 0 {
+		return inbox.ErrNotFound
+	}
+
+	return nil
+}
+
+func (b Repository) DueEvents(ctx context.Context, until time.Time, limit int) ([]inbox.Event, error) {
+	filter := bson.M{"status": inbox.StatusPending, "nextattemptat": bson.M{"$lte": until}}
+	return b.findEvents(ctx, filter, bson.D{{Key: "receivedat", Value: 1}}, limit)
+}
+
+func (b Repository) ListEvents(ctx context.Context, status inbox.Status, limit int) ([]inbox.Event, error) {
+	return b.findEvents(ctx, bson.M{"status": status}, bson.D{{Key: "receivedat", Value: -1}}, limit)
+}
+
+func (b Repository) findEvents(ctx context.Context, filter bson.M, sort bson.D, limit int) ([]inbox.Event, error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(inboxCollection)
+
+	opts := options.Find().SetSort(sort)
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.New("failed to get the events: " + err.Error())
+	}
+
+	var stored []storedEvent
+	if err := cursor.All(ctx, &stored); err != nil {
+		return nil, errors.New("failed to decode the events: " + err.Error())
+	}
+
+	events := make([]inbox.Event, len(stored))
+	for i, event := range stored {
+		events[i] = inbox.Event(event)
+	}
+	return events, nil
+}
+
+// LastEventBlock returns the last block whose events were handled by the listener
+func (b Repository) LastEventBlock(ctx context.Context) (string, uint64, error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(eventStateCollection)
+
+	var state projectionState
+	err := coll.FindOne(ctx, bson.M{"_id": lastBlockID}).Decode(&state)
+	if err == mongo.ErrNoDocuments {
+		return "", 0, nil
+	}
+	if err != nil {
+		return "", 0, errors.New("failed to get the last handled block: " + err.Error())
+	}
+
+	return state.BlockID, state.BlockNum, nil
+}
+
+func (b Repository) SaveEventBlock(ctx context.Context, blockID string, blockNum uint64) error {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(eventStateCollection)
+
+	state := projectionState{ID: lastBlockID, BlockID: blockID, BlockNum: blockNum, UpdatedAt: time.Now().UTC()}
+	if _, err := coll.ReplaceOne(ctx, bson.M{"_id": lastBlockID}, state, options.Replace().SetUpsert(true)); err != nil {
+		return errors.New("failed to store the last handled block: " + err.Error())
+	}
+
+	return nil
+}
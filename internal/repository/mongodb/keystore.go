@@ -0,0 +1,159 @@
+package mongodb
+
+import (
+	"context"
+	"doc-management/internal/config"
+	"doc-management/internal/keystore"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	keystoreCollection   = "keystore"
+	keyHistoryCollection = "keyhistory"
+)
+
+type retiredKey struct {
+	UserID     string
+	PublicKey  string `bson:"_id"`
+	Status     keystore.KeyStatus
+	CreatedAt  time.Time
+	RetiredAt  time.Time
+	RetiredBy  string
+	ReplacedBy string
+	Reason     string
+}
+
+type keyRecord struct {
+	UserID     string `bson:"_id"`
+	PublicKey  string
+	PrivateKey []byte
+	CreatedAt  time.Time
+}
+
+func (b Repository) GetKey(ctx context.Context, userID string) (keystore.Record, error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(keystoreCollection)
+
+	var record keyRecord
+	err := coll.FindOne(ctx, bson.M{"_id": userID}).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return keystore.Record{}, keystore.ErrNotFound
+	}
+	if err != nil {
+		return keystore.Record{}, errors.New("failed to get the signing key: " + err.Error())
+	}
+
+	return keystore.Record(record), nil
+}
+
+func (b Repository) InsertKey(ctx context.Context, record keystore.Record) (keystore.Record, error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(keystoreCollection)
+
+	_, err := coll.InsertOne(ctx, keyRecord(record))
+	if mongo.IsDuplicateKeyError(err) {
+		// created meanwhile by another request, keep the first one
+		return b.GetKey(ctx, record.UserID)
+	}
+	if err != nil {
+		return keystore.Record{}, errors.New("failed to store the signing key: " + err.Error())
+	}
+
+	return record, nil
+}
+
+func (b Repository) ReplaceKey(ctx context.Context, publicKey string, record keystore.Record) error {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(keystoreCollection)
+
+	result, err := coll.ReplaceOne(ctx, bson.M{"_id": record.UserID, "publickey": publicKey}, keyRecord(record))
+	if err != nil {
+		return errors.New("failed to replace the signing key: " + err.Error())
+	}
+	if result.MatchedCount == 0 {
+		return keystore.ErrKeyChanged
+	}
+
+	return nil
+}
+
+func (b Repository) ListKeys(ctx context.Context) ([]keystore.Record, error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(keystoreCollection)
+
+	cursor, err := coll.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, errors.New("failed to list the signing keys: " + err.Error())
+	}
+
+	var records []keyRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, errors.New("failed to decode the signing keys: " + err.Error())
+	}
+
+	result := make([]keystore.Record, len(records))
+	for i, record := range records {
+		result[i] = keystore.Record(record)
+	}
+	return result, nil
+}
+
+func (b Repository) EnsureKeyHistoryIndex(ctx context.Context) error {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(keyHistoryCollection)
+
+	_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "userid", Value: 1}, {Key: "createdat", Value: 1}},
+	})
+	if err != nil {
+		return errors.New("failed to create the key history index: " + err.Error())
+	}
+
+	return nil
+}
+
+func (b Repository) PutRetiredKey(ctx context.Context, info keystore.KeyInfo) error {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(keyHistoryCollection)
+
+	_, err := coll.ReplaceOne(ctx, bson.M{"_id": info.PublicKey}, retiredKey(info), options.Replace().SetUpsert(true))
+	if err != nil {
+		return errors.New("failed to store the retired key: " + err.Error())
+	}
+
+	return nil
+}
+
+func (b Repository) GetRetiredKey(ctx context.Context, publicKey string) (keystore.KeyInfo, error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(keyHistoryCollection)
+
+	var key retiredKey
+	err := coll.FindOne(ctx, bson.M{"_id": publicKey}).Decode(&key)
+	if err == mongo.ErrNoDocuments {
+		return keystore.KeyInfo{}, keystore.ErrNotFound
+	}
+	if err != nil {
+		return keystore.KeyInfo{}, errors.New("failed to get the retired key: " + err.Error())
+	}
+
+	return keystore.KeyInfo(key), nil
+}
+
+func (b Repository) GetRetiredKeys(ctx context.Context, userID string) ([]keystore.KeyInfo, error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(keyHistoryCollection)
+
+	cursor, err := coll.Find(ctx, bson.M{"userid": userID}, options.Find().SetSort(bson.D{{Key: "createdat", Value: 1}}))
+	if err != nil {
+		return nil, errors.New("failed to get the retired keys: " + err.Error())
+	}
+
+	var keys []retiredKey
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, errors.New("failed to decode the retired keys: " + err.Error())
+	}
+
+	infos := make([]keystore.KeyInfo, len(keys))
+	for i, key := range keys {
+		infos[i] = keystore.KeyInfo(key)
+	}
+	return infos, nil
+}
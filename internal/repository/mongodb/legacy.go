@@ -0,0 +1,125 @@
+package mongodb
+
+import (
+	"bytes"
+	"context"
+	"doc-management/internal/config"
+	"doc-management/internal/hashing"
+	"doc-management/internal/repository"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/multierr"
+)
+
+const (
+	// the content used to be stored per proposal ID and per doc version
+	legacyProposalsCollection = "proposals"
+	legacyDocsCollection      = "documents"
+)
+
+type legacyStoredContent struct {
+	ID      string `bson:"_id"`
+	Content []byte
+	Chunked bool `bson:",omitempty"`
+}
+
+// MigrateLegacyContent copies the content stored per proposal and per doc version
+// to the content collection addressed by the content hash, batchSize entries at a time.
+// The legacy collections are kept, the migrated entries are only marked, so an interrupted
+// migration continues with the entries not migrated yet.
+func (b Repository) MigrateLegacyContent(ctx context.Context, batchSize int) (err error) {
+	legacyRefs := map[string]func(id string) string{
+		legacyProposalsCollection: repository.ProposalRef,
+		// doc ID used to consist of category;name;version
+		legacyDocsCollection: func(id string) string { return "doc:" + id },
+	}
+
+	for _, collName := range []string{legacyProposalsCollection, legacyDocsCollection} {
+		migrated, collErr := b.migrateLegacyCollection(ctx, collName, legacyRefs[collName], batchSize)
+		if collErr != nil {
+			err = multierr.Append(err, errors.New("migrating "+collName+": "+collErr.Error()))
+		}
+		if migrated > 0 {
+			b.logger.Info(fmt.Sprint("migrated ", migrated, " entries of the legacy collection ", collName))
+		}
+	}
+
+	return err
+}
+
+func (b Repository) migrateLegacyCollection(ctx context.Context, collName string, getRef func(id string) string, batchSize int) (migrated int, err error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(collName)
+
+	for {
+		batch, err := b.findLegacyBatch(ctx, coll, batchSize)
+		if err != nil {
+			return migrated, err
+		}
+
+		for _, legacy := range batch {
+			if err := b.migrateLegacyEntry(ctx, coll, legacy, getRef); err != nil {
+				return migrated, errors.New("entry " + legacy.ID + ": " + err.Error())
+			}
+			migrated++
+		}
+
+		if len(batch) < batchSize {
+			return migrated, nil
+		}
+		b.logger.Info(fmt.Sprint("migrated ", migrated, " entries of the legacy collection ", collName, " so far"))
+	}
+}
+
+// findLegacyBatch returns the next entries not migrated yet; a new query per batch
+// keeps the cursor from timing out while the content is copied
+func (b Repository) findLegacyBatch(ctx context.Context, coll *mongo.Collection, batchSize int) ([]legacyStoredContent, error) {
+	opts := options.Find().SetSort(bson.M{"_id": 1}).SetLimit(int64(batchSize))
+	cursor, err := coll.Find(ctx, bson.M{"migrated": bson.M{"$exists": false}}, opts)
+	if err != nil {
+		return nil, errors.New("failed to find the entries: " + err.Error())
+	}
+
+	var batch []legacyStoredContent
+	if err := cursor.All(ctx, &batch); err != nil {
+		return nil, errors.New("failed to decode the entries: " + err.Error())
+	}
+	return batch, nil
+}
+
+func (b Repository) migrateLegacyEntry(ctx context.Context, coll *mongo.Collection, legacy legacyStoredContent, getRef func(id string) string) (err error) {
+	content := legacy.Content
+	if legacy.Chunked {
+		content, err = b.downloadLegacyChunks(ctx, coll.Name(), legacy.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := b.PutContent(ctx, hashing.CalculateSHA512(string(content)), content, getRef(legacy.ID)); err != nil {
+		return err
+	}
+
+	if _, err := coll.UpdateByID(ctx, legacy.ID, bson.M{"$set": bson.M{"migrated": true}}); err != nil {
+		return errors.New("failed to mark the entry as migrated: " + err.Error())
+	}
+	return nil
+}
+
+func (b Repository) downloadLegacyChunks(ctx context.Context, bucketName string, id string) ([]byte, error) {
+	bucket, err := gridfs.NewBucket(b.client.Database(config.GetDatabaseName()), options.GridFSBucket().SetName(bucketName))
+	if err != nil {
+		return nil, errors.New("failed to open the GridFS bucket: " + err.Error())
+	}
+
+	var content bytes.Buffer
+	if _, err := bucket.DownloadToStream(id, &content); err != nil {
+		return nil, errors.New("failed to download the content from GridFS: " + err.Error())
+	}
+
+	return content.Bytes(), nil
+}
@@ -0,0 +1,233 @@
+package mongodb
+
+import (
+	"context"
+	"doc-management/internal/audit"
+	"doc-management/internal/config"
+	"doc-management/internal/outbox"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	outboxCollection = "outbox"
+	issuesCollection = "reconciliation"
+)
+
+type storedOutboxEntry struct {
+	ID            string `bson:"_id"`
+	UserID        string
+	Action        audit.Action
+	Target        string
+	BatchList     []byte
+	ContentHash   string
+	ContentRef    string
+	ReplacedRef   string
+	State         outbox.State
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+type storedIssue struct {
+	ID          string `bson:"_id"`
+	Kind        outbox.IssueKind
+	ContentHash string
+	Ref         string
+	Detail      string
+	Status      outbox.IssueStatus
+	FirstSeenAt time.Time
+	LastSeenAt  time.Time
+	UpdatedAt   time.Time
+}
+
+func (b Repository) EnsureOutboxIndex(ctx context.Context) error {
+	db := b.client.Database(config.GetDatabaseName())
+
+	_, err := db.Collection(outboxCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "state", Value: 1}, {Key: "nextattemptat", Value: 1}},
+	})
+	if err != nil {
+		return errors.New("failed to create the outbox index: " + err.Error())
+	}
+
+	_, err = db.Collection(outboxCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "action", Value: 1}, {Key: "target", Value: 1}, {Key: "state", Value: 1}},
+	})
+	if err != nil {
+		return errors.New("failed to create the outbox target index: " + err.Error())
+	}
+
+	_, err = db.Collection(issuesCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "lastseenat", Value: -1}},
+	})
+	if err != nil {
+		return errors.New("failed to create the reconciliation index: " + err.Error())
+	}
+
+	return nil
+}
+
+func (b Repository) InsertOutboxEntry(ctx context.Context, entry outbox.Entry) error {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(outboxCollection)
+
+	if _, err := coll.InsertOne(ctx, storedOutboxEntry(entry)); err != nil {
+		return errors.New("failed to store the outbox entry: " + err.Error())
+	}
+
+	return nil
+}
+
+func (b Repository) GetOutboxEntry(ctx context.Context, id string) (outbox.Entry, error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(outboxCollection)
+
+	var entry storedOutboxEntry
+	err := coll.FindOne(ctx, bson.M{"_id": id}).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		return outbox.Entry{}, outbox.ErrNotFound
+	}
+	if err != nil {
+		return outbox.Entry{}, errors.New("failed to get the outbox entry: " + err.Error())
+	}
+
+	return outbox.Entry(entry), nil
+}
+
+func (b Repository) UpdateOutboxEntry(ctx context.Context, entry outbox.Entry) error {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(outboxCollection)
+
+	result, err := coll.ReplaceOne(ctx, bson.M{"_id": entry.ID}, storedOutboxEntry(entry))
+	if err != nil {
+		return errors.New("failed to update the outbox entry: " + err.Error())
+	}
+	if result.MatchedCount == 0 {
+		return outbox.ErrNotFound
+	}
+
+	return nil
+}
+
+func (b Repository) DueOutboxEntries(ctx context.Context, until time.Time, limit int) ([]outbox.Entry, error) {
+	pendingFilter := bson.M{"state": outbox.StatePending, "nextattemptat": bson.M{"$lte": until}}
+	pendingOpts := options.Find().SetSort(bson.D{{Key: "nextattemptat", Value: 1}})
+	pending, err := b.findOutboxEntries(ctx, pendingFilter, pendingOpts, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	submittedOpts := options.Find().SetSort(bson.D{{Key: "createdat", Value: 1}})
+	submitted, err := b.findOutboxEntries(ctx, bson.M{"state": outbox.StateSubmitted}, submittedOpts, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(pending, submitted...), nil
+}
+
+func (b Repository) ListOutboxEntries(ctx context.Context, state outbox.State, limit int) ([]outbox.Entry, error) {
+	// the batch lists are only needed to submit the batches
+	opts := options.Find().SetSort(bson.D{{Key: "createdat", Value: -1}}).SetProjection(bson.M{"batchlist": 0})
+	return b.findOutboxEntries(ctx, bson.M{"state": state}, opts, limit)
+}
+
+func (b Repository) OpenOutboxEntryExists(ctx context.Context, action audit.Action, target string) (bool, error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(outboxCollection)
+
+	filter := bson.M{
+		"action": action,
+		"target": target,
+		"state":  bson.M{"$in": bson.A{outbox.StatePending, outbox.StateSubmitted}},
+	}
+	err := coll.FindOne(ctx, filter, options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.New("failed to get the outbox entry: " + err.Error())
+	}
+
+	return true, nil
+}
+
+func (b Repository) findOutboxEntries(ctx context.Context, filter bson.M, opts *options.FindOptions, limit int) ([]outbox.Entry, error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(outboxCollection)
+
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.New("failed to get the outbox entries: " + err.Error())
+	}
+
+	var stored []storedOutboxEntry
+	if err := cursor.All(ctx, &stored); err != nil {
+		return nil, errors.New("failed to decode the outbox entries: " + err.Error())
+	}
+
+	entries := make([]outbox.Entry, len(stored))
+	for i, entry := range stored {
+		entries[i] = outbox.Entry(entry)
+	}
+	return entries, nil
+}
+
+func (b Repository) GetIssue(ctx context.Context, id string) (outbox.Issue, error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(issuesCollection)
+
+	var issue storedIssue
+	err := coll.FindOne(ctx, bson.M{"_id": id}).Decode(&issue)
+	if err == mongo.ErrNoDocuments {
+		return outbox.Issue{}, outbox.ErrIssueNotFound
+	}
+	if err != nil {
+		return outbox.Issue{}, errors.New("failed to get the reconciliation issue: " + err.Error())
+	}
+
+	return outbox.Issue(issue), nil
+}
+
+func (b Repository) SaveIssue(ctx context.Context, issue outbox.Issue) error {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(issuesCollection)
+
+	opts := options.Replace().SetUpsert(true)
+	if _, err := coll.ReplaceOne(ctx, bson.M{"_id": issue.ID}, storedIssue(issue), opts); err != nil {
+		return errors.New("failed to store the reconciliation issue: " + err.Error())
+	}
+
+	return nil
+}
+
+func (b Repository) ListIssues(ctx context.Context, status outbox.IssueStatus, limit int) ([]outbox.Issue, error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(issuesCollection)
+
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "lastseenat", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.New("failed to get the reconciliation issues: " + err.Error())
+	}
+
+	var stored []storedIssue
+	if err := cursor.All(ctx, &stored); err != nil {
+		return nil, errors.New("failed to decode the reconciliation issues: " + err.Error())
+	}
+
+	issues := make([]outbox.Issue, len(stored))
+	for i, issue := range stored {
+		issues[i] = outbox.Issue(issue)
+	}
+	return issues, nil
+}
@@ -0,0 +1,335 @@
+package mongodb
+
+import (
+	"context"
+	"doc-management/internal/blockchain"
+	"doc-management/internal/config"
+	"doc-management/internal/model"
+	"doc-management/internal/paging"
+	"doc-management/internal/projection"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	projectedProposalsCollection = "proj_proposals"
+	projectedDocsCollection      = "proj_documents"
+	projectionStateCollection    = "proj_state"
+
+	lastBlockID = "lastBlock"
+)
+
+type projectedProposal struct {
+	Address            string `bson:"_id"`
+	ProposalID         string
+	DocumentName       string
+	Category           string
+	ModificationAuthor string
+	ContentHash        string
+	FileName           string
+	ContentType        string
+	ContentSize        int64
+	ProposedStatus     model.DocStatus
+	CurrentStatus      model.ProposalStatus
+	Signers            []string
+}
+
+type projectedDoc struct {
+	Address      string `bson:"_id"`
+	DocumentName string
+	Category     string
+	Author       string
+	ContentHash  string
+	FileName     string
+	ContentType  string
+	ContentSize  int64
+	Version      int
+	Status       model.DocStatus
+	ProposalID   string
+	Signers      []string
+}
+
+type projectionState struct {
+	ID        string `bson:"_id"`
+	BlockID   string
+	BlockNum  uint64
+	UpdatedAt time.Time
+}
+
+func (b Repository) EnsureProjectionIndex(ctx context.Context) error {
+	db := b.client.Database(config.GetDatabaseName())
+
+	_, err := db.Collection(projectedProposalsCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "proposalid", Value: 1}}},
+		{Keys: bson.D{{Key: "currentstatus", Value: 1}, {Key: "modificationauthor", Value: 1}, {Key: "proposalid", Value: 1}}},
+		{Keys: bson.D{{Key: "category", Value: 1}, {Key: "documentname", Value: 1}}},
+	})
+	if err != nil {
+		return errors.New("failed to create the projected proposals index: " + err.Error())
+	}
+
+	_, err = db.Collection(projectedDocsCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "category", Value: 1}, {Key: "documentname", Value: 1}, {Key: "version", Value: 1}}},
+		{Keys: bson.D{{Key: "author", Value: 1}}},
+		{Keys: bson.D{{Key: "signers", Value: 1}}},
+	})
+	if err != nil {
+		return errors.New("failed to create the projected docs index: " + err.Error())
+	}
+
+	return nil
+}
+
+func (b Repository) ApplyChanges(ctx context.Context, blockID string, blockNum uint64, changes []projection.Change) error {
+	db := b.client.Database(config.GetDatabaseName())
+
+	for _, change := range changes {
+		var err error
+		switch {
+		case change.Deleted:
+			// the address is unique across the collections
+			_, err = db.Collection(projectedProposalsCollection).DeleteOne(ctx, bson.M{"_id": change.Address})
+			if err == nil {
+				_, err = db.Collection(projectedDocsCollection).DeleteOne(ctx, bson.M{"_id": change.Address})
+			}
+
+		case change.Proposal != nil:
+			_, err = db.Collection(projectedProposalsCollection).ReplaceOne(ctx,
+				bson.M{"_id": change.Address}, newProjectedProposal(change.Address, *change.Proposal), options.Replace().SetUpsert(true))
+
+		case change.Document != nil:
+			_, err = db.Collection(projectedDocsCollection).ReplaceOne(ctx,
+				bson.M{"_id": change.Address}, newProjectedDoc(change.Address, *change.Document), options.Replace().SetUpsert(true))
+		}
+
+		if err != nil {
+			return errors.New("failed to apply the change of " + change.Address + ": " + err.Error())
+		}
+	}
+
+	// the block is marked as processed only when all its changes are applied
+	state := projectionState{ID: lastBlockID, BlockID: blockID, BlockNum: blockNum, UpdatedAt: time.Now().UTC()}
+	if _, err := db.Collection(projectionStateCollection).ReplaceOne(ctx, bson.M{"_id": lastBlockID}, state, options.Replace().SetUpsert(true)); err != nil {
+		return errors.New("failed to store the last processed block: " + err.Error())
+	}
+
+	return nil
+}
+
+func (b Repository) LastBlock(ctx context.Context) (string, uint64, error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(projectionStateCollection)
+
+	var state projectionState
+	err := coll.FindOne(ctx, bson.M{"_id": lastBlockID}).Decode(&state)
+	if err == mongo.ErrNoDocuments {
+		return "", 0, nil
+	}
+	if err != nil {
+		return "", 0, errors.New("failed to get the last processed block: " + err.Error())
+	}
+
+	return state.BlockID, state.BlockNum, nil
+}
+
+func (b Repository) GetProposal(ctx context.Context, proposalID string) (model.Proposal, error) {
+	proposals, err := b.findProposals(ctx, bson.M{"proposalid": proposalID})
+	if err != nil {
+		return model.Proposal{}, err
+	}
+	if len(proposals) == 0 {
+		return model.Proposal{}, blockchain.ErrNotFound
+	}
+
+	return proposals[0], nil
+}
+
+func (b Repository) ListProposals(ctx context.Context, filter projection.ProposalFilter, page paging.Page) (proposals []model.Proposal, next string, total int64, err error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(projectedProposalsCollection)
+
+	query := bson.M{}
+	category := bson.M{}
+	if filter.Category != "" {
+		category["$eq"] = filter.Category
+	}
+	if filter.Categories != nil {
+		category["$in"] = filter.Categories
+	}
+	if len(category) > 0 {
+		query["category"] = category
+	}
+	if filter.Status != "" {
+		query["currentstatus"] = filter.Status
+	}
+	author := bson.M{}
+	if filter.Author != "" {
+		author["$eq"] = filter.Author
+	}
+	if filter.NotAuthor != "" {
+		author["$ne"] = filter.NotAuthor
+	}
+	if len(author) > 0 {
+		query["modificationauthor"] = author
+	}
+
+	total, err = coll.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, "", 0, errors.New("failed to count the proposals: " + err.Error())
+	}
+
+	query["proposalid"] = bson.M{"$gt": page.Cursor}
+	proposals, err = b.findProposals(ctx, query, options.Find().
+		SetSort(bson.D{{Key: "proposalid", Value: 1}}).
+		// one more to know if there is a next page
+		SetLimit(int64(page.Limit+1)))
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	if len(proposals) > page.Limit {
+		proposals = proposals[:page.Limit]
+		next = proposals[len(proposals)-1].ProposalID
+	}
+
+	return proposals, next, total, nil
+}
+
+func (b Repository) GetDocProposals(ctx context.Context, category, docName string) ([]model.Proposal, error) {
+	return b.findProposals(ctx, bson.M{"currentstatus": model.ProposalStatusActive, "category": category, "documentname": docName})
+}
+
+func (b Repository) GetDocumentVersions(ctx context.Context, category, docName string) ([]model.Document, error) {
+	return b.findDocs(ctx, bson.M{"category": category, "documentname": docName})
+}
+
+func (b Repository) GetDocumentVersion(ctx context.Context, category, docName string, version int) (model.Document, error) {
+	docs, err := b.findDocs(ctx, bson.M{"category": category, "documentname": docName, "version": version})
+	if err != nil {
+		return model.Document{}, err
+	}
+	if len(docs) == 0 {
+		return model.Document{}, blockchain.ErrNotFound
+	}
+
+	return docs[0], nil
+}
+
+func (b Repository) GetDocumentsOfAuthor(ctx context.Context, author string) ([]model.Document, error) {
+	return b.findDocs(ctx, bson.M{"author": author})
+}
+
+func (b Repository) GetDocumentsSignedBy(ctx context.Context, signer string) ([]model.Document, error) {
+	return b.findDocs(ctx, bson.M{"signers": signer})
+}
+
+func (b Repository) findProposals(ctx context.Context, filter bson.M, opts ...*options.FindOptions) ([]model.Proposal, error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(projectedProposalsCollection)
+
+	cursor, err := coll.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, errors.New("failed to find the proposals: " + err.Error())
+	}
+
+	var found []projectedProposal
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, errors.New("failed to get the proposals from the cursor: " + err.Error())
+	}
+
+	proposals := make([]model.Proposal, len(found))
+	for i, p := range found {
+		proposals[i] = p.toModel()
+	}
+
+	return proposals, nil
+}
+
+func (b Repository) findDocs(ctx context.Context, filter bson.M) ([]model.Document, error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(projectedDocsCollection)
+
+	cursor, err := coll.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "version", Value: 1}}))
+	if err != nil {
+		return nil, errors.New("failed to find the docs: " + err.Error())
+	}
+
+	var found []projectedDoc
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, errors.New("failed to get the docs from the cursor: " + err.Error())
+	}
+
+	docs := make([]model.Document, len(found))
+	for i, doc := range found {
+		docs[i] = doc.toModel()
+	}
+
+	return docs, nil
+}
+
+func newProjectedProposal(address string, p model.Proposal) projectedProposal {
+	return projectedProposal{
+		Address:            address,
+		ProposalID:         p.ProposalID,
+		DocumentName:       p.DocumentName,
+		Category:           p.Category,
+		ModificationAuthor: p.ModificationAuthor,
+		ContentHash:        p.ContentHash,
+		FileName:           p.FileName,
+		ContentType:        p.ContentType,
+		ContentSize:        p.ContentSize,
+		ProposedStatus:     p.ProposedStatus,
+		CurrentStatus:      p.CurrentStatus,
+		Signers:            p.Signers,
+	}
+}
+
+func (p projectedProposal) toModel() model.Proposal {
+	return model.Proposal{
+		ProposalID:         p.ProposalID,
+		DocumentName:       p.DocumentName,
+		Category:           p.Category,
+		ModificationAuthor: p.ModificationAuthor,
+		Content:            []byte{},
+		ContentHash:        p.ContentHash,
+		FileName:           p.FileName,
+		ContentType:        p.ContentType,
+		ContentSize:        p.ContentSize,
+		ProposedStatus:     p.ProposedStatus,
+		CurrentStatus:      p.CurrentStatus,
+		Signers:            p.Signers,
+	}
+}
+
+func newProjectedDoc(address string, doc model.Document) projectedDoc {
+	return projectedDoc{
+		Address:      address,
+		DocumentName: doc.DocumentName,
+		Category:     doc.Category,
+		Author:       doc.Author,
+		ContentHash:  doc.ContentHash,
+		FileName:     doc.FileName,
+		ContentType:  doc.ContentType,
+		ContentSize:  doc.ContentSize,
+		Version:      doc.Version,
+		Status:       doc.Status,
+		ProposalID:   doc.ProposalID,
+		Signers:      doc.Signers,
+	}
+}
+
+func (doc projectedDoc) toModel() model.Document {
+	return model.Document{
+		DocumentName: doc.DocumentName,
+		Category:     doc.Category,
+		Author:       doc.Author,
+		ContentHash:  doc.ContentHash,
+		FileName:     doc.FileName,
+		ContentType:  doc.ContentType,
+		ContentSize:  doc.ContentSize,
+		Version:      doc.Version,
+		Status:       doc.Status,
+		ProposalID:   doc.ProposalID,
+		Signers:      doc.Signers,
+	}
+}
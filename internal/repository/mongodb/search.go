@@ -0,0 +1,155 @@
+package mongodb
+
+import (
+	"context"
+	"doc-management/internal/config"
+	"doc-management/internal/model"
+	"doc-management/internal/repository"
+	"doc-management/internal/search"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const searchCollection = "search"
+
+type indexedDoc struct {
+	// the same as the doc version content reference
+	ID           string `bson:"_id"`
+	DocumentName string
+	Category     string
+	Version      int
+	Author       string
+	Status       model.DocStatus
+	FileName     string
+	ContentType  string
+	Text         string
+	Latest       bool
+	Score        float64 `bson:",omitempty"`
+}
+
+// EnsureSearchIndex creates the text index used for searching the documents;
+// the matches in the document name and the file name weight more than in the text
+func (b Repository) EnsureSearchIndex(ctx context.Context) error {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(searchCollection)
+
+	_, err := coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "documentname", Value: "text"}, {Key: "filename", Value: "text"}, {Key: "text", Value: "text"}},
+			Options: options.Index().SetName("search_text").
+				SetWeights(bson.M{"documentname": 10, "filename": 5, "text": 1}),
+		},
+		{
+			Keys: bson.D{{Key: "category", Value: 1}, {Key: "documentname", Value: 1}},
+		},
+	})
+	if err != nil {
+		return errors.New("failed to create the search index: " + err.Error())
+	}
+
+	return nil
+}
+
+func (b Repository) IndexDocument(ctx context.Context, entry search.Entry) error {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(searchCollection)
+
+	doc := indexedDoc{
+		ID:           repository.DocVersionRef(entry.Category, entry.DocumentName, entry.Version),
+		DocumentName: entry.DocumentName,
+		Category:     entry.Category,
+		Version:      entry.Version,
+		Author:       entry.Author,
+		Status:       entry.Status,
+		FileName:     entry.FileName,
+		ContentType:  entry.ContentType,
+		Text:         entry.Text,
+		Latest:       true,
+	}
+
+	if _, err := coll.ReplaceOne(ctx, bson.M{"_id": doc.ID}, doc, options.Replace().SetUpsert(true)); err != nil {
+		return errors.New("failed to index the document: " + err.Error())
+	}
+
+	_, err := coll.UpdateMany(ctx, bson.M{
+		"category":     entry.Category,
+		"documentname": entry.DocumentName,
+		"version":      bson.M{"$lt": entry.Version},
+	}, bson.M{"$set": bson.M{"latest": false}})
+	if err != nil {
+		return errors.New("failed to update the previous document versions: " + err.Error())
+	}
+
+	return nil
+}
+
+func (b Repository) SetStatus(ctx context.Context, category, docName string, version int, status model.DocStatus) error {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(searchCollection)
+
+	_, err := coll.UpdateByID(ctx, repository.DocVersionRef(category, docName, version), bson.M{"$set": bson.M{"status": status}})
+	if err != nil {
+		return errors.New("failed to update the indexed document status: " + err.Error())
+	}
+
+	return nil
+}
+
+func (b Repository) Search(ctx context.Context, query search.Query) ([]search.Hit, error) {
+	coll := b.client.Database(config.GetDatabaseName()).Collection(searchCollection)
+
+	filter := bson.M{"$text": bson.M{"$search": query.Text}}
+	category := bson.M{}
+	if query.Category != "" {
+		category["$eq"] = query.Category
+	}
+	if query.Categories != nil {
+		category["$in"] = query.Categories
+	}
+	if len(category) > 0 {
+		filter["category"] = category
+	}
+	if query.Author != "" {
+		filter["author"] = query.Author
+	}
+	if query.Status != "" {
+		filter["status"] = query.Status
+	}
+	if !query.AllVersions {
+		filter["latest"] = true
+	}
+
+	score := bson.M{"$meta": "textScore"}
+	cursor, err := coll.Find(ctx, filter, options.Find().
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.D{{Key: "score", Value: score}}).
+		SetLimit(int64(query.Limit)))
+	if err != nil {
+		return nil, errors.New("failed to search the documents: " + err.Error())
+	}
+
+	var found []indexedDoc
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, errors.New("failed to get the found documents from the cursor: " + err.Error())
+	}
+
+	terms := search.Terms(query.Text)
+	hits := make([]search.Hit, len(found))
+	for i, doc := range found {
+		hits[i] = search.Hit{
+			Entry: search.Entry{
+				DocumentName: doc.DocumentName,
+				Category:     doc.Category,
+				Version:      doc.Version,
+				Author:       doc.Author,
+				Status:       doc.Status,
+				FileName:     doc.FileName,
+				ContentType:  doc.ContentType,
+			},
+			Score:   doc.Score,
+			Snippet: search.Snippet(doc.Text, terms),
+		}
+	}
+
+	return hits, nil
+}
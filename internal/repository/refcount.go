@@ -0,0 +1,284 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+const refsSuffix = ".refs"
+
+// BlobStore keeps plain named objects, storing an object under an existing name overwrites it
+type BlobStore interface {
+	PutBlob(ctx context.Context, name string, data []byte) error
+	// GetBlob returns ErrContentNotFound if the object doesn't exist
+	GetBlob(ctx context.Context, name string) ([]byte, error)
+	HasBlob(ctx context.Context, name string) (bool, error)
+	// DeleteBlob doesn't fail if the object doesn't exist
+	DeleteBlob(ctx context.Context, name string) error
+	ListBlobs(ctx context.Context) ([]string, error)
+}
+
+// StreamingBlobStore is a blob store that writes and reads the objects as a stream
+type StreamingBlobStore interface {
+	BlobStore
+	PutBlobFrom(ctx context.Context, name string, data io.Reader) error
+	// OpenBlob returns ErrContentNotFound if the object doesn't exist
+	OpenBlob(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+// refCountedStore is a ContentStore for the stores that can't track the references themselves;
+// the references to the content are kept in an object next to it.
+// The references are guarded only within this process.
+type refCountedStore struct {
+	blobs BlobStore
+	mutex *sync.Mutex
+	// the number of the content uploads in progress by hash, the content isn't deleted while uploaded
+	uploads map[string]int
+}
+
+func NewRefCountedStore(blobs BlobStore) RewritableStore {
+	return refCountedStore{
+		blobs:   blobs,
+		mutex:   &sync.Mutex{},
+		uploads: make(map[string]int),
+	}
+}
+
+func (s refCountedStore) PutContent(ctx context.Context, contentHash string, content []byte, ref string) error {
+	return s.PutContentFrom(ctx, contentHash, bytes.NewReader(content), int64(len(content)), ref)
+}
+
+func (s refCountedStore) PutContentFrom(ctx context.Context, contentHash string, content io.Reader, size int64, ref string) error {
+	if content == nil || contentHash == "" {
+		return ErrNoContent
+	}
+
+	uploading, err := s.refOrStartUpload(ctx, contentHash, ref)
+	if err != nil || !uploading {
+		return err
+	}
+
+	// the blob is keyed by the hash, the concurrent uploads of the same content store the same blob,
+	// so it's uploaded without holding the lock
+	uploadErr := s.putBlob(ctx, contentHash, content)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.uploads[contentHash]--
+	if s.uploads[contentHash] == 0 {
+		delete(s.uploads, contentHash)
+	}
+	if uploadErr != nil {
+		return uploadErr
+	}
+
+	// the content could be referenced or released in the meantime
+	refs, err := s.getRefs(ctx, contentHash)
+	if err != nil {
+		return err
+	}
+
+	return s.addRef(ctx, contentHash, refs, ref)
+}
+
+// refOrStartUpload adds the reference if the content is already stored,
+// otherwise records the upload, so that the content isn't deleted until it's finished
+func (s refCountedStore) refOrStartUpload(ctx context.Context, contentHash string, ref string) (uploading bool, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	refs, err := s.getRefs(ctx, contentHash)
+	if err != nil {
+		return false, err
+	}
+
+	// the content is stored as long as it's referenced
+	if len(refs) > 0 {
+		return false, s.addRef(ctx, contentHash, refs, ref)
+	}
+
+	s.uploads[contentHash]++
+	return true, nil
+}
+
+func (s refCountedStore) AddRef(ctx context.Context, contentHash string, ref string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	refs, err := s.getRefs(ctx, contentHash)
+	if err != nil {
+		return err
+	}
+
+	// the content is stored as long as it's referenced
+	if len(refs) == 0 {
+		return ErrContentNotFound
+	}
+
+	return s.addRef(ctx, contentHash, refs, ref)
+}
+
+// addRef stores the references with the given one, unless it's already there
+func (s refCountedStore) addRef(ctx context.Context, contentHash string, refs []string, ref string) error {
+	for _, existing := range refs {
+		if existing == ref {
+			return nil
+		}
+	}
+
+	return s.putRefs(ctx, contentHash, append(refs, ref))
+}
+
+func (s refCountedStore) GetContent(ctx context.Context, contentHash string) ([]byte, error) {
+	return s.blobs.GetBlob(ctx, contentHash)
+}
+
+func (s refCountedStore) HasContent(ctx context.Context, contentHash string) (bool, error) {
+	return s.blobs.HasBlob(ctx, contentHash)
+}
+
+func (s refCountedStore) OpenContent(ctx context.Context, contentHash string) (io.ReadCloser, error) {
+	if streaming, ok := s.blobs.(StreamingBlobStore); ok {
+		return streaming.OpenBlob(ctx, contentHash)
+	}
+
+	data, err := s.blobs.GetBlob(ctx, contentHash)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s refCountedStore) putBlob(ctx context.Context, name string, data io.Reader) error {
+	if streaming, ok := s.blobs.(StreamingBlobStore); ok {
+		return streaming.PutBlobFrom(ctx, name, data)
+	}
+
+	content, err := ioutil.ReadAll(data)
+	if err != nil {
+		return errors.New("failed to read the content: " + err.Error())
+	}
+	return s.blobs.PutBlob(ctx, name, content)
+}
+
+func (s refCountedStore) ReleaseContent(ctx context.Context, contentHash string, ref string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	refs, err := s.getRefs(ctx, contentHash)
+	if err != nil {
+		return err
+	}
+
+	var left []string
+	for _, existing := range refs {
+		if existing != ref {
+			left = append(left, existing)
+		}
+	}
+
+	if len(left) > 0 {
+		return s.putRefs(ctx, contentHash, left)
+	}
+
+	// stored again, the upload adds its reference once finished
+	if s.uploads[contentHash] > 0 {
+		return s.blobs.DeleteBlob(ctx, contentHash+refsSuffix)
+	}
+
+	if err := s.blobs.DeleteBlob(ctx, contentHash); err != nil {
+		return err
+	}
+
+	return s.blobs.DeleteBlob(ctx, contentHash+refsSuffix)
+}
+
+func (s refCountedStore) ListContent(ctx context.Context) ([]string, error) {
+	names, err := s.blobs.ListBlobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes []string
+	for _, name := range names {
+		if !strings.HasSuffix(name, refsSuffix) {
+			hashes = append(hashes, name)
+		}
+	}
+
+	return hashes, nil
+}
+
+func (s refCountedStore) ListRefs(ctx context.Context) (map[string][]string, error) {
+	hashes, err := s.ListContent(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	refs := make(map[string][]string, len(hashes))
+	for _, contentHash := range hashes {
+		contentRefs, err := s.getRefs(ctx, contentHash)
+		if err != nil {
+			return nil, err
+		}
+		refs[contentHash] = contentRefs
+	}
+
+	return refs, nil
+}
+
+func (s refCountedStore) RewriteContent(ctx context.Context, contentHash string, content []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	refs, err := s.getRefs(ctx, contentHash)
+	if err != nil {
+		return err
+	}
+	if len(refs) == 0 {
+		// released in the meantime
+		return nil
+	}
+
+	return s.blobs.PutBlob(ctx, contentHash, content)
+}
+
+func (s refCountedStore) getRefs(ctx context.Context, contentHash string) ([]string, error) {
+	data, err := s.blobs.GetBlob(ctx, contentHash+refsSuffix)
+	if err == ErrContentNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.New("failed to get the content references: " + err.Error())
+	}
+
+	var refs []string
+	if err := json.Unmarshal(data, &refs); err != nil {
+		return nil, errors.New("failed to unmarshal the content references: " + err.Error())
+	}
+
+	return refs, nil
+}
+
+func (s refCountedStore) putRefs(ctx context.Context, contentHash string, refs []string) error {
+	data, err := json.Marshal(refs)
+	if err != nil {
+		return errors.New("failed to marshal the content references: " + err.Error())
+	}
+
+	if err := s.blobs.PutBlob(ctx, contentHash+refsSuffix, data); err != nil {
+		return errors.New("failed to store the content references: " + err.Error())
+	}
+
+	return nil
+}
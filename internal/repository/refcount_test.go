@@ -0,0 +1,160 @@
+package repository_test
+
+import (
+	"context"
+	"doc-management/internal/hashing"
+	"doc-management/internal/repository"
+	"doc-management/internal/repository/filesystem"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRefCountedStore(t *testing.T) {
+	hashing.Initialize(zap.NewNop())
+	blobs, err := filesystem.NewStore(t.TempDir())
+	require.NoError(t, err)
+	store := repository.NewRefCountedStore(blobs)
+
+	content := []byte("mala agatka")
+	hash := hashing.CalculateSHA512(string(content))
+	proposalRef := repository.ProposalRef("some-proposal")
+	docRef := repository.DocVersionRef("general", "name", 1)
+
+	require.NoError(t, store.PutContent(context.TODO(), hash, content, proposalRef))
+	require.NoError(t, store.PutContent(context.TODO(), hash, content, docRef))
+	// adding the same reference again changes nothing
+	require.NoError(t, store.PutContent(context.TODO(), hash, content, docRef))
+
+	require.NoError(t, store.ReleaseContent(context.TODO(), hash, proposalRef))
+	stored, err := store.GetContent(context.TODO(), hash)
+	require.NoError(t, err)
+	assert.Equal(t, content, stored)
+	exists, err := store.HasContent(context.TODO(), hash)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	require.NoError(t, store.ReleaseContent(context.TODO(), hash, docRef))
+	_, err = store.GetContent(context.TODO(), hash)
+	assert.ErrorIs(t, err, repository.ErrContentNotFound)
+	exists, err = store.HasContent(context.TODO(), hash)
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestListRefs(t *testing.T) {
+	hashing.Initialize(zap.NewNop())
+	blobs, err := filesystem.NewStore(t.TempDir())
+	require.NoError(t, err)
+	store := repository.NewRefCountedStore(blobs).(repository.RefListingStore)
+
+	content := []byte("mala agatka")
+	hash := hashing.CalculateSHA512(string(content))
+	require.NoError(t, store.PutContent(context.TODO(), hash, content, repository.ProposalRef("some-proposal")))
+	require.NoError(t, store.PutContent(context.TODO(), hash, content, repository.DocVersionRef("general", "name;with;separators", 2)))
+
+	refs, err := store.ListRefs(context.TODO())
+	require.NoError(t, err)
+	require.Len(t, refs[hash], 2)
+
+	proposalID, ok := repository.ParseProposalRef(refs[hash][0])
+	require.True(t, ok)
+	assert.Equal(t, "some-proposal", proposalID)
+
+	category, docName, version, ok := repository.ParseDocVersionRef(refs[hash][1])
+	require.True(t, ok)
+	assert.Equal(t, "general", category)
+	assert.Equal(t, "name;with;separators", docName)
+	assert.Equal(t, 2, version)
+
+	_, _, _, ok = repository.ParseDocVersionRef("doc:general;name")
+	assert.False(t, ok)
+}
+
+func TestPutNoContent(t *testing.T) {
+	hashing.Initialize(zap.NewNop())
+	blobs, err := filesystem.NewStore(t.TempDir())
+	require.NoError(t, err)
+	store := repository.NewRefCountedStore(blobs)
+
+	hash := hashing.CalculateSHA512("")
+	err = repository.PutContentFrom(context.TODO(), store, hash, nil, 0, repository.ProposalRef("some-proposal"))
+	assert.ErrorIs(t, err, repository.ErrNoContent)
+	err = store.(repository.StreamingStore).PutContentFrom(context.TODO(), "", strings.NewReader(""), 0, repository.ProposalRef("some-proposal"))
+	assert.ErrorIs(t, err, repository.ErrNoContent)
+
+	stored, err := store.ListContent(context.TODO())
+	require.NoError(t, err)
+	assert.Empty(t, stored)
+}
+
+func TestPutContentWhileUploading(t *testing.T) {
+	hashing.Initialize(zap.NewNop())
+	blobs, err := filesystem.NewStore(t.TempDir())
+	require.NoError(t, err)
+	store := repository.NewRefCountedStore(blobs)
+
+	content := []byte("mala agatka")
+	hash := hashing.CalculateSHA512(string(content))
+	reader, writer := io.Pipe()
+	uploaded := make(chan error)
+	go func() {
+		uploaded <- store.(repository.StreamingStore).PutContentFrom(context.TODO(), hash, reader, int64(len(content)), repository.ProposalRef("uploading"))
+	}()
+	// the upload is in progress once the first byte is read
+	_, err = writer.Write(content[:1])
+	require.NoError(t, err)
+
+	// the other content is stored meanwhile
+	other := []byte("mniejsza agatka")
+	require.NoError(t, store.PutContent(context.TODO(), hashing.CalculateSHA512(string(other)), other, repository.ProposalRef("other")))
+
+	// stored and released meanwhile, kept for the upload
+	require.NoError(t, store.PutContent(context.TODO(), hash, content, repository.ProposalRef("released")))
+	require.NoError(t, store.ReleaseContent(context.TODO(), hash, repository.ProposalRef("released")))
+
+	_, err = writer.Write(content[1:])
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	require.NoError(t, <-uploaded)
+
+	stored, err := store.GetContent(context.TODO(), hash)
+	require.NoError(t, err)
+	assert.Equal(t, content, stored)
+
+	refs, err := store.(repository.RefListingStore).ListRefs(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, []string{repository.ProposalRef("uploading")}, refs[hash])
+}
+
+func TestAddRef(t *testing.T) {
+	hashing.Initialize(zap.NewNop())
+	blobs, err := filesystem.NewStore(t.TempDir())
+	require.NoError(t, err)
+	store := repository.NewRefCountedStore(blobs)
+
+	content := []byte("mala agatka")
+	hash := hashing.CalculateSHA512(string(content))
+	proposalRef := repository.ProposalRef("some-proposal")
+	docRef := repository.DocVersionRef("general", "name", 1)
+
+	assert.ErrorIs(t, store.AddRef(context.TODO(), hash, docRef), repository.ErrContentNotFound)
+
+	require.NoError(t, store.PutContent(context.TODO(), hash, content, proposalRef))
+	require.NoError(t, store.AddRef(context.TODO(), hash, docRef))
+	require.NoError(t, store.AddRef(context.TODO(), hash, docRef))
+
+	refs, err := store.(repository.RefListingStore).ListRefs(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, []string{proposalRef, docRef}, refs[hash])
+
+	// kept for the added reference
+	require.NoError(t, store.ReleaseContent(context.TODO(), hash, proposalRef))
+	stored, err := store.GetContent(context.TODO(), hash)
+	require.NoError(t, err)
+	assert.Equal(t, content, stored)
+}
@@ -0,0 +1,195 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"doc-management/internal/repository"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	// e.g. http://localhost:9000 for a local MinIO instance, https is used if the scheme is missing
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+}
+
+// Store keeps the objects in a bucket of an S3 compatible object store,
+// the objects are addressed in the path style
+type Store struct {
+	Config
+	client *http.Client
+}
+
+func NewStore(config Config) (Store, error) {
+	if config.Endpoint == "" || config.Bucket == "" {
+		return Store{}, errors.New("S3 endpoint and bucket need to be given")
+	}
+
+	if !strings.HasPrefix(config.Endpoint, "http://") && !strings.HasPrefix(config.Endpoint, "https://") {
+		config.Endpoint = "https://" + config.Endpoint
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+
+	return Store{
+		Config: config,
+		client: &http.Client{},
+	}, nil
+}
+
+func (s Store) PutBlob(ctx context.Context, name string, data []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, name, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError("failed to put the object", resp)
+	}
+
+	return nil
+}
+
+func (s Store) GetBlob(ctx context.Context, name string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, repository.ErrContentNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError("failed to get the object", resp)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.New("failed to read the object: " + err.Error())
+	}
+
+	return data, nil
+}
+
+func (s Store) HasBlob(ctx context.Context, name string) (bool, error) {
+	resp, err := s.do(ctx, http.MethodHead, name, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, responseError("failed to check the object", resp)
+	}
+
+	return true, nil
+}
+
+func (s Store) DeleteBlob(ctx context.Context, name string) error {
+	resp, err := s.do(ctx, http.MethodDelete, name, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return responseError("failed to delete the object", resp)
+	}
+
+	return nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (s Store) ListBlobs(ctx context.Context) ([]string, error) {
+	var names []string
+	continuationToken := ""
+
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+
+		resp, err := s.do(ctx, http.MethodGet, "?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			err := responseError("failed to list the objects", resp)
+			resp.Body.Close()
+			return nil, err
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.New("failed to decode the object list: " + err.Error())
+		}
+
+		for _, object := range result.Contents {
+			names = append(names, object.Key)
+		}
+
+		if !result.IsTruncated {
+			return names, nil
+		}
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+// do sends a request to the bucket, the object name might be replaced with a query
+func (s Store) do(ctx context.Context, method string, objectName string, body []byte) (*http.Response, error) {
+	url := s.Endpoint + "/" + s.Bucket + "/" + objectName
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, errors.New("failed to create a new request: " + err.Error())
+	}
+	signRequest(req, hashSHA256(body), s.AccessKey, s.SecretKey, s.Region, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.New("object store request failed: " + err.Error())
+	}
+
+	return resp, nil
+}
+
+func responseError(message string, resp *http.Response) error {
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		body = []byte("failed to read the response body: " + err.Error())
+	}
+
+	return errors.New(fmt.Sprint(message, ", status: ", resp.Status, "; ", string(body)))
+}
@@ -0,0 +1,23 @@
+package retry
+
+import "time"
+
+// Policy tells how the failed operations are retried, with a backoff doubled on each attempt
+type Policy struct {
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// given up after this number of attempts, zero if retried until it succeeds
+	MaxAttempts int
+}
+
+// Backoff returns the time to wait after the given number of the failed attempts, doubled on each attempt
+func (p Policy) Backoff(attempts int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < attempts && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,88 @@
+package search
+
+import (
+	"context"
+	"doc-management/internal/model"
+	"errors"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+var ErrEmptyQuery = errors.New("search query is empty")
+
+// Entry is an indexed document version
+type Entry struct {
+	DocumentName string
+	Category     string
+	Version      int
+	Author       string
+	Status       model.DocStatus
+	FileName     string
+	ContentType  string
+	// text extracted from the content, empty if the content is not textual
+	Text string
+}
+
+type Query struct {
+	Text     string
+	Category string
+	// categories to search in, nil means all
+	Categories []string
+	Author     string
+	Status     model.DocStatus
+	// by default only the latest version of each document is searched
+	AllVersions bool
+	Limit       int
+}
+
+type Hit struct {
+	Entry
+	Score float64
+	// fragment of the text with the matched terms wrapped in <mark> tags, HTML escaped
+	Snippet string
+}
+
+// Index keeps the searchable text of accepted document versions
+type Index interface {
+	// IndexDocument adds the document version to the index, it becomes the latest version of the document
+	IndexDocument(ctx context.Context, entry Entry) error
+	SetStatus(ctx context.Context, category, docName string, version int, status model.DocStatus) error
+	// Search returns the hits ordered by relevance
+	Search(ctx context.Context, query Query) ([]Hit, error)
+}
+
+func NewEntry(doc model.Document) Entry {
+	return Entry{
+		DocumentName: doc.DocumentName,
+		Category:     doc.Category,
+		Version:      doc.Version,
+		Author:       doc.Author,
+		Status:       doc.Status,
+		FileName:     doc.FileName,
+		ContentType:  doc.ContentType,
+		Text:         ExtractText(doc.ContentType, doc.Content),
+	}
+}
+
+// Normalize validates the query and fills in the defaults
+func (q *Query) Normalize() error {
+	if len(Terms(q.Text)) == 0 {
+		return ErrEmptyQuery
+	}
+
+	if q.Status != "" && q.Status != model.DocStatusActive && q.Status != model.DocStatusRemoved && q.Status != model.DocStatusInvalid {
+		return errors.New("invalid document status: " + q.Status.String())
+	}
+
+	if q.Limit <= 0 {
+		q.Limit = DefaultLimit
+	}
+	if q.Limit > MaxLimit {
+		q.Limit = MaxLimit
+	}
+
+	return nil
+}
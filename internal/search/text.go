@@ -0,0 +1,148 @@
+package search
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// longer text is truncated before indexing
+	maxIndexedText = 1024 * 1024
+
+	snippetLength = 200
+)
+
+var textContentTypes = []string{
+	"text/",
+	"application/json",
+	"application/xml",
+	"application/x-yaml",
+	"application/yaml",
+}
+
+// ExtractText returns the searchable text of the content,
+// or an empty string if the content is binary
+func ExtractText(contentType string, content []byte) string {
+	if !isTextContentType(contentType) {
+		return ""
+	}
+
+	if len(content) > maxIndexedText {
+		content = content[:maxIndexedText]
+		// don't cut a multibyte character
+		for len(content) > 0 && !utf8.Valid(content) {
+			content = content[:len(content)-1]
+		}
+	}
+
+	if !utf8.Valid(content) || bytes.IndexByte(content, 0) >= 0 {
+		return ""
+	}
+
+	return string(content)
+}
+
+func isTextContentType(contentType string) bool {
+	// the content uploaded before storing the content type was plain text
+	if contentType == "" {
+		return true
+	}
+
+	contentType = strings.ToLower(contentType)
+	for _, prefix := range textContentTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Terms splits the query into lower case words
+func Terms(query string) []string {
+	words := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	var terms []string
+	seen := make(map[string]bool)
+	for _, word := range words {
+		if !seen[word] {
+			seen[word] = true
+			terms = append(terms, word)
+		}
+	}
+
+	return terms
+}
+
+// Snippet returns a fragment of the text around the first matched term,
+// the words starting with any of the terms are wrapped in <mark> tags.
+// The text is HTML escaped.
+func Snippet(text string, terms []string) string {
+	if text == "" {
+		return ""
+	}
+
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = regexp.QuoteMeta(term)
+	}
+	matcher := regexp.MustCompile(`(?i)(` + strings.Join(quoted, "|") + `)[\p{L}\p{N}]*`)
+
+	start := 0
+	if first := matcher.FindStringIndex(text); first != nil && first[0] > snippetLength/4 {
+		start = wordStart(text, first[0]-snippetLength/4)
+	}
+	end := start + snippetLength
+	if end >= len(text) {
+		end = len(text)
+	} else {
+		end = wordEnd(text, end)
+	}
+
+	fragment := strings.Join(strings.Fields(text[start:end]), " ")
+
+	var snippet strings.Builder
+	if start > 0 {
+		snippet.WriteString("…")
+	}
+	last := 0
+	for _, match := range matcher.FindAllStringIndex(fragment, -1) {
+		snippet.WriteString(html.EscapeString(fragment[last:match[0]]))
+		snippet.WriteString("<mark>" + html.EscapeString(fragment[match[0]:match[1]]) + "</mark>")
+		last = match[1]
+	}
+	snippet.WriteString(html.EscapeString(fragment[last:]))
+	if end < len(text) {
+		snippet.WriteString("…")
+	}
+
+	return snippet.String()
+}
+
+// wordStart moves the position forward to the beginning of the next word
+func wordStart(text string, pos int) int {
+	for pos < len(text) && !utf8.RuneStart(text[pos]) {
+		pos++
+	}
+	if space := strings.IndexFunc(text[pos:], unicode.IsSpace); space >= 0 && space < snippetLength/4 {
+		return pos + space + 1
+	}
+	return pos
+}
+
+// wordEnd moves the position forward to the end of the current word
+func wordEnd(text string, pos int) int {
+	for pos < len(text) && !utf8.RuneStart(text[pos]) {
+		pos++
+	}
+	if space := strings.IndexFunc(text[pos:], unicode.IsSpace); space >= 0 && space < snippetLength/4 {
+		return pos + space
+	}
+	return pos
+}
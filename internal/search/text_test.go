@@ -0,0 +1,36 @@
+package search_test
+
+import (
+	"doc-management/internal/search"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractText(t *testing.T) {
+	assert.Equal(t, "mala agatka", search.ExtractText("text/plain; charset=utf-8", []byte("mala agatka")))
+	assert.Equal(t, "mala agatka", search.ExtractText("", []byte("mala agatka")))
+	assert.Equal(t, "", search.ExtractText("application/pdf", []byte("%PDF-1.4")))
+	assert.Equal(t, "", search.ExtractText("text/plain", []byte{'a', 0, 'b'}))
+}
+
+func TestTerms(t *testing.T) {
+	assert.Equal(t, []string{"remote", "work"}, search.Terms("  Remote-work, remote WORK"))
+	assert.Empty(t, search.Terms(" ,. "))
+}
+
+func TestSnippet(t *testing.T) {
+	snippet := search.Snippet("Employees may <b>work</b> remotely up to 3 days a week.", []string{"remote", "work"})
+	assert.Equal(t, "Employees may &lt;b&gt;<mark>work</mark>&lt;/b&gt; <mark>remotely</mark> up to 3 days a week.", snippet)
+
+	long := strings.Repeat("lorem ipsum ", 50) + "the remote work policy " + strings.Repeat("dolor sit ", 50)
+	snippet = search.Snippet(long, []string{"remote"})
+	assert.True(t, strings.HasPrefix(snippet, "…"))
+	assert.True(t, strings.HasSuffix(snippet, "…"))
+	assert.Contains(t, snippet, "the <mark>remote</mark> work policy")
+	assert.NotContains(t, snippet, "  ")
+
+	// no match in the text, e.g. matched by the document name
+	assert.Equal(t, "short text", search.Snippet("short text", []string{"policy"}))
+}
@@ -0,0 +1,105 @@
+package signkeys
+
+import (
+	"encoding/hex"
+	"errors"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/hyperledger/sawtooth-sdk-go/signing"
+)
+
+var (
+	ErrKeyMismatch = errors.New("the public key doesn't match the private key")
+	ErrInvalidKey  = errors.New("invalid secp256k1 private key")
+)
+
+type CheckStatus string
+
+const (
+	CheckOK CheckStatus = "ok"
+	// the user has no keys yet, they are generated on the first use
+	CheckMissing CheckStatus = "missing"
+	// the stored public key doesn't match the private key
+	CheckMismatch CheckStatus = "mismatch"
+	// the mismatched public key was replaced by the derived one,
+	// or the private key moved to the keystore was cleared from the directory
+	CheckRepaired CheckStatus = "repaired"
+	// the private key was moved to the keystore, but it's still stored in the directory
+	CheckImported CheckStatus = "imported"
+	// the private key can't be read, the record can't be repaired
+	CheckInvalid CheckStatus = "invalid"
+)
+
+// KeyCheck is the result of verifying a stored key record
+type KeyCheck struct {
+	UserID string
+	// where the key is stored, e.g. keystore or directory
+	Source string
+	Status CheckStatus
+	// derived from the private key
+	PublicKey       string
+	StoredPublicKey string
+	Error           string
+}
+
+// FromPrivateKey returns the keys with the public key derived from the private key
+func FromPrivateKey(privateKeyHex string) (UserKeys, error) {
+	priv, err := hex.DecodeString(privateKeyHex)
+	if err != nil || len(priv) != 32 {
+		return UserKeys{}, ErrInvalidKey
+	}
+
+	d := new(big.Int).SetBytes(priv)
+	if d.Sign() == 0 || d.Cmp(btcec.S256().N) >= 0 {
+		return UserKeys{}, ErrInvalidKey
+	}
+
+	_, pub := btcec.PrivKeyFromBytes(btcec.S256(), priv)
+	return UserKeys{
+		PublicKey:  signing.NewSecp256k1PublicKey(pub.SerializeUncompressed()),
+		PrivateKey: signing.NewSecp256k1PrivateKey(priv),
+	}, nil
+}
+
+// Matches tells if the public key, compressed or not, belongs to the private key
+func (u UserKeys) Matches(publicKeyHex string) bool {
+	if !u.Valid() {
+		return false
+	}
+
+	given, err := hex.DecodeString(publicKeyHex)
+	if err != nil {
+		return false
+	}
+	parsed, err := btcec.ParsePubKey(given, btcec.S256())
+	if err != nil {
+		return false
+	}
+
+	_, derived := btcec.PrivKeyFromBytes(btcec.S256(), u.PrivateKey.AsBytes())
+	return parsed.IsEqual(derived)
+}
+
+// Check verifies the stored key record; the keys are returned if the private key is valid
+func Check(userID, source, privateKeyHex, publicKeyHex string) (UserKeys, KeyCheck) {
+	check := KeyCheck{UserID: userID, Source: source, StoredPublicKey: publicKeyHex, Status: CheckOK}
+	if privateKeyHex == "" {
+		check.Status = CheckMissing
+		return UserKeys{}, check
+	}
+
+	keys, err := NewUserKeys(privateKeyHex, publicKeyHex)
+	switch err {
+	case nil:
+	case ErrKeyMismatch:
+		check.Status = CheckMismatch
+	default:
+		check.Status = CheckInvalid
+		check.Error = err.Error()
+		return UserKeys{}, check
+	}
+
+	check.PublicKey = keys.PublicKey.AsHex()
+	return keys, check
+}
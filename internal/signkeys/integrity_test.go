@@ -0,0 +1,52 @@
+package signkeys_test
+
+import (
+	"doc-management/internal/signkeys"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewUserKeysDerivesPublicKey(t *testing.T) {
+	keys, err := signkeys.GenerateKeys()
+	require.NoError(t, err)
+
+	loaded, err := signkeys.NewUserKeys(keys.PrivateKey.AsHex(), keys.PublicKey.AsHex())
+	require.NoError(t, err)
+	assert.Equal(t, keys.PublicKey.AsHex(), loaded.PublicKey.AsHex())
+
+	// the compressed key as used by the signer matches as well
+	_, err = signkeys.NewUserKeys(keys.PrivateKey.AsHex(), keys.GetSigner().GetPublicKey().AsHex())
+	assert.NoError(t, err)
+}
+
+func TestNewUserKeysMismatch(t *testing.T) {
+	keys, err := signkeys.GenerateKeys()
+	require.NoError(t, err)
+
+	// the private key stored in place of the public key
+	loaded, err := signkeys.NewUserKeys(keys.PrivateKey.AsHex(), keys.PrivateKey.AsHex())
+	assert.Equal(t, signkeys.ErrKeyMismatch, err)
+	assert.Equal(t, keys.PublicKey.AsHex(), loaded.PublicKey.AsHex())
+}
+
+func TestCheck(t *testing.T) {
+	keys, err := signkeys.GenerateKeys()
+	require.NoError(t, err)
+
+	_, check := signkeys.Check("user-1", "directory", keys.PrivateKey.AsHex(), keys.PublicKey.AsHex())
+	assert.Equal(t, signkeys.CheckOK, check.Status)
+
+	_, check = signkeys.Check("user-1", "directory", keys.PrivateKey.AsHex(), "")
+	assert.Equal(t, signkeys.CheckMismatch, check.Status)
+	assert.Equal(t, keys.PublicKey.AsHex(), check.PublicKey)
+
+	_, check = signkeys.Check("user-1", "directory", "", "")
+	assert.Equal(t, signkeys.CheckMissing, check.Status)
+
+	for _, invalid := range []string{"zz", "0000000000000000000000000000000000000000000000000000000000000000", "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"} {
+		_, check = signkeys.Check("user-1", "directory", invalid, "")
+		assert.Equal(t, signkeys.CheckInvalid, check.Status, invalid)
+	}
+}
@@ -4,12 +4,10 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
-	"encoding/hex"
 	"errors"
 
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/hyperledger/sawtooth-sdk-go/signing"
-	"go.uber.org/multierr"
 )
 
 type UserKeys struct {
@@ -18,6 +16,9 @@ type UserKeys struct {
 }
 
 func (u UserKeys) Valid() bool {
+	if u.PrivateKey == nil || u.PublicKey == nil {
+		return false
+	}
 	return u.PrivateKey.AsHex() != "" && u.PublicKey.AsHex() != ""
 }
 
@@ -26,17 +27,18 @@ func (u UserKeys) GetSigner() *signing.Signer {
 	return cryptoFactory.NewSigner(u.PrivateKey)
 }
 
+// NewUserKeys parses the stored keys, the public key is derived from the private key; if the stored
+// public key doesn't match, the keys are returned with the derived public key together with ErrKeyMismatch
 func NewUserKeys(privateKeyHex, publicKeyHex string) (UserKeys, error) {
-	priv, errPriv := hex.DecodeString(privateKeyHex)
-	pub, errPub := hex.DecodeString(publicKeyHex)
-	if err := multierr.Combine(errPriv, errPub); err != nil {
+	keys, err := FromPrivateKey(privateKeyHex)
+	if err != nil {
 		return UserKeys{}, err
 	}
 
-	return UserKeys{
-		PublicKey:  signing.NewSecp256k1PublicKey(pub),
-		PrivateKey: signing.NewSecp256k1PrivateKey(priv),
-	}, nil
+	if !keys.Matches(publicKeyHex) {
+		return keys, ErrKeyMismatch
+	}
+	return keys, nil
 }
 
 // source: https://github.com/ethereum/go-ethereum/blob/86d547707965685cef732aa28c15e6811ea98408/crypto/secp256k1/secp256_test.go#L19
@@ -13,7 +13,7 @@ const (
 	loginDomain = "https://login.microsoftonline.com/"
 )
 
-func (m UserManager) getAppToken() (string, error) {
+func (m GraphProvider) getAppToken() (string, error) {
 
 	path := loginDomain + m.tenantID + "/oauth2/v2.0/token"
 	data := url.Values{}
@@ -53,7 +53,7 @@ func (m UserManager) getAppToken() (string, error) {
 	return unmarshalled.Token, nil
 }
 
-func (m UserManager) setNewAppToken() error {
+func (m GraphProvider) setNewAppToken() error {
 	token, err := m.getAppToken()
 	if err != nil {
 		return err
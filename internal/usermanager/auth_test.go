@@ -14,11 +14,12 @@ func TestSetAppToken(t *testing.T) {
 	err := viper.ReadInConfig()
 	require.NoError(t, err)
 
-	manager, err := NewUserManager(
+	manager, err := NewGraphProvider(
 		config.GetTenantID(),
 		config.GetClientID(),
 		config.GetMsExtensionID(),
 		config.GetAppSecret(),
+		TokenParams{},
 	)
 	require.NoError(t, err)
 
@@ -0,0 +1,179 @@
+package usermanager
+
+import (
+	"context"
+	"doc-management/internal/model"
+	"doc-management/internal/signkeys"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// FileProvider keeps the users in memory, loaded from and saved to a JSON file if the path is given;
+// meant for the development and the integration tests
+type FileProvider struct {
+	path        string
+	tokenParams TokenParams
+
+	mu    *sync.Mutex
+	users map[string]fileUser
+}
+
+type fileUser struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	PrivateKey string `json:"privateKey,omitempty"`
+	PublicKey  string `json:"publicKey,omitempty"`
+}
+
+func (u fileUser) record() UserRecord {
+	return UserRecord{ID: u.ID, Name: u.Name, PrivateKey: u.PrivateKey, PublicKey: u.PublicKey}
+}
+
+type usersFile struct {
+	Users []fileUser `json:"users"`
+}
+
+func NewFileProvider(path string, tokenParams TokenParams) (FileProvider, error) {
+	provider := FileProvider{
+		path:        path,
+		tokenParams: tokenParams,
+		mu:          &sync.Mutex{},
+		users:       make(map[string]fileUser),
+	}
+	if path == "" {
+		return provider, nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return provider, nil
+	}
+	if err != nil {
+		return FileProvider{}, errors.New("failed to read the users file: " + err.Error())
+	}
+
+	var file usersFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return FileProvider{}, errors.New("failed to parse the users file: " + err.Error())
+	}
+	for _, user := range file.Users {
+		provider.users[user.ID] = user
+	}
+
+	return provider, nil
+}
+
+// AddUser adds a user without keys, an existing user is left untouched
+func (p FileProvider) AddUser(userID, name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.users[userID]; ok {
+		return nil
+	}
+	p.users[userID] = fileUser{ID: userID, Name: name}
+
+	return p.save()
+}
+
+func (p FileProvider) GetUser(ctx context.Context, userID string) (model.User, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	user, ok := p.users[userID]
+	if !ok {
+		return model.User{}, ErrUserNotFound
+	}
+
+	return user.record().User()
+}
+
+func (p FileProvider) ListUsers(ctx context.Context) ([]UserRecord, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	records := make([]UserRecord, 0, len(p.users))
+	for _, user := range p.users {
+		records = append(records, user.record())
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+
+	return records, nil
+}
+
+func (p FileProvider) StoreKeys(ctx context.Context, user model.User, keys signkeys.UserKeys) (model.User, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stored, ok := p.users[user.ID]
+	if !ok {
+		return model.User{}, ErrUserNotFound
+	}
+	stored.PrivateKey = keys.PrivateKey.AsHex()
+	stored.PublicKey = keys.PublicKey.AsHex()
+	p.users[user.ID] = stored
+
+	if err := p.save(); err != nil {
+		return model.User{}, err
+	}
+	return user.SetKeys(keys), nil
+}
+
+func (p FileProvider) ClearPrivateKey(ctx context.Context, userID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stored, ok := p.users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	stored.PrivateKey = ""
+	p.users[userID] = stored
+
+	return p.save()
+}
+
+func (p FileProvider) TokenParams() TokenParams {
+	return p.tokenParams
+}
+
+// save writes the users to the file, replacing it at once; the file holds the private keys, so it's readable only by the owner
+func (p FileProvider) save() error {
+	if p.path == "" {
+		return nil
+	}
+
+	var file usersFile
+	for _, user := range p.users {
+		file.Users = append(file.Users, user)
+	}
+	sort.Slice(file.Users, func(i, j int) bool { return file.Users[i].ID < file.Users[j].ID })
+	content, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(p.path), filepath.Base(p.path)+".*")
+	if err != nil {
+		return errors.New("failed to save the users: " + err.Error())
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return errors.New("failed to save the users: " + err.Error())
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.New("failed to save the users: " + err.Error())
+	}
+
+	if err := os.Rename(tmp.Name(), p.path); err != nil {
+		return errors.New("failed to save the users: " + err.Error())
+	}
+	return nil
+}
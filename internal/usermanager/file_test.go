@@ -0,0 +1,111 @@
+package usermanager
+
+import (
+	"context"
+	"doc-management/internal/signkeys"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileProviderAssignsKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	provider, err := NewFileProvider(path, TokenParams{})
+	require.NoError(t, err)
+	require.NoError(t, provider.AddUser("user-1", "user1@example.com"))
+
+	manager := NewUserManager(provider)
+	user, err := manager.GetUserByID(context.TODO(), "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, "user1@example.com", user.Name)
+	require.True(t, user.HasValidKeys())
+
+	// the keys are kept in the file
+	reloaded, err := NewFileProvider(path, TokenParams{})
+	require.NoError(t, err)
+	again, err := NewUserManager(reloaded).GetUserByID(context.TODO(), "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, user.Keys.PrivateKey.AsHex(), again.Keys.PrivateKey.AsHex())
+	assert.Equal(t, user.Keys.PublicKey.AsHex(), again.Keys.PublicKey.AsHex())
+}
+
+func TestFileProviderRepairsMismatchedKeys(t *testing.T) {
+	keys, err := signkeys.GenerateKeys()
+	require.NoError(t, err)
+
+	// the private key stored in place of the public key, as the Graph provider used to
+	path := filepath.Join(t.TempDir(), "users.json")
+	content := `{"users":[{"id":"user-1","name":"user1@example.com","privateKey":"` + keys.PrivateKey.AsHex() +
+		`","publicKey":"` + keys.PrivateKey.AsHex() + `"},{"id":"user-2","name":"user2@example.com"}]}`
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+
+	provider, err := NewFileProvider(path, TokenParams{})
+	require.NoError(t, err)
+	manager := NewUserManager(provider)
+
+	checks, err := manager.AuditKeys(context.TODO(), false)
+	require.NoError(t, err)
+	require.Len(t, checks, 2)
+	assert.Equal(t, signkeys.CheckMismatch, checks[0].Status)
+	assert.Equal(t, keys.PublicKey.AsHex(), checks[0].PublicKey)
+	assert.Equal(t, signkeys.CheckMissing, checks[1].Status)
+
+	checks, err = manager.AuditKeys(context.TODO(), true)
+	require.NoError(t, err)
+	assert.Equal(t, signkeys.CheckRepaired, checks[0].Status)
+
+	// the repaired record is saved
+	reloaded, err := NewFileProvider(path, TokenParams{})
+	require.NoError(t, err)
+	user, err := reloaded.GetUser(context.TODO(), "user-1")
+	require.NoError(t, err)
+	assert.False(t, user.KeysMismatch)
+	assert.Equal(t, keys.PublicKey.AsHex(), user.Keys.PublicKey.AsHex())
+}
+
+func TestFileProviderUnknownUser(t *testing.T) {
+	provider, err := NewFileProvider("", TokenParams{})
+	require.NoError(t, err)
+
+	_, err = NewUserManager(provider).GetUserByID(context.TODO(), "unknown")
+	assert.Equal(t, ErrUserNotFound, err)
+}
+
+func TestKeystoreUserManagerNeverStoresKeys(t *testing.T) {
+	keys, err := signkeys.GenerateKeys()
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "users.json")
+	content := `{"users":[{"id":"user-1","name":"user1@example.com","privateKey":"` + keys.PrivateKey.AsHex() +
+		`","publicKey":"` + keys.PrivateKey.AsHex() + `"},{"id":"user-2","name":"user2@example.com"}]}`
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+
+	provider, err := NewFileProvider(path, TokenParams{})
+	require.NoError(t, err)
+	manager := NewKeystoreUserManager(provider)
+	ctx := context.TODO()
+
+	// the mismatched record is read with the derived public key, but not repaired
+	stored, err := manager.GetStoredKeys(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, keys.PublicKey.AsHex(), stored.PublicKey.AsHex())
+	checks, err := manager.AuditKeys(ctx, true)
+	require.NoError(t, err)
+	assert.Equal(t, signkeys.CheckMismatch, checks[0].Status)
+
+	// no keys are generated into the directory
+	_, err = manager.GetUserByID(ctx, "user-2")
+	assert.Equal(t, ErrKeysInKeystore, err)
+
+	require.NoError(t, manager.ClearStoredKeys(ctx, "user-1"))
+	reloaded, err := NewFileProvider(path, TokenParams{})
+	require.NoError(t, err)
+	records, err := reloaded.ListUsers(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, records[0].PrivateKey)
+	assert.Equal(t, keys.PrivateKey.AsHex(), records[0].PublicKey)
+	assert.Empty(t, records[1].PrivateKey)
+}
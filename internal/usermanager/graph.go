@@ -0,0 +1,282 @@
+package usermanager
+
+import (
+	"context"
+	"doc-management/internal/model"
+	"doc-management/internal/signkeys"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type TokenGuard struct {
+	token string
+}
+
+// GraphProvider manages the users of Azure AD B2C via Microsoft Graph,
+// the keys are stored in the B2C extension attributes
+type GraphProvider struct {
+	tenantID    string
+	clientID    string
+	extensionID string
+
+	secret      string
+	tokenGuard  *TokenGuard
+	tokenParams TokenParams
+}
+
+const (
+	graphURL      = "https://graph.microsoft.com/v1.0/"
+	graphPageSize = 100
+)
+
+// graphUser is a user as returned by Graph, with the extension prefix removed from the attribute names
+type graphUser struct {
+	ID         string `json:"id"`
+	Name       string `json:"userPrincipalName"`
+	PrivateKey string `json:"PrivateKey"`
+	PublicKey  string `json:"PublicKey"`
+}
+
+func (u graphUser) record() UserRecord {
+	return UserRecord{ID: u.ID, Name: u.Name, PrivateKey: u.PrivateKey, PublicKey: u.PublicKey}
+}
+
+func NewGraphProvider(tenantID, clientID, extensionID, secret string, tokenParams TokenParams) (GraphProvider, error) {
+
+	manager := GraphProvider{
+		tenantID:    tenantID,
+		clientID:    clientID,
+		extensionID: extensionID,
+		secret:      secret,
+
+		tokenGuard:  &TokenGuard{token: ""},
+		tokenParams: tokenParams,
+	}
+
+	err := manager.setNewAppToken()
+	if err != nil {
+		return GraphProvider{}, err
+	}
+
+	return manager, nil
+}
+
+func (m GraphProvider) updateUserKeys(ctx context.Context, user model.User, userKeys signkeys.UserKeys) (model.User, error) {
+	path := graphURL + m.tenantID + "/users/" + user.ID
+
+	var updateBody struct {
+		PrivateKey string `json:"extension_EXTENSION_ID_PrivateKey"`
+		PublicKey  string `json:"extension_EXTENSION_ID_PublicKey"`
+	}
+
+	updatedUser := user.SetKeys(userKeys)
+	updateBody.PrivateKey = updatedUser.Keys.PrivateKey.AsHex()
+	updateBody.PublicKey = updatedUser.Keys.PublicKey.AsHex()
+
+	marshalledReqBody, err := json.Marshal(updateBody)
+	if err != nil {
+		return user, err
+	}
+
+	modifiedBody := strings.ReplaceAll(string(marshalledReqBody), "EXTENSION_ID", m.extensionID)
+	r, err := http.NewRequestWithContext(ctx, http.MethodPatch, path, strings.NewReader(modifiedBody))
+	if err != nil {
+		return user, err
+	}
+	r.Header.Add("Authorization", "Bearer "+m.tokenGuard.token)
+	r.Header.Add("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return user, err
+	}
+
+	if isResponseSuccess(resp.StatusCode) {
+		// on successful response, nothing more to do, return
+		return updatedUser, nil
+	}
+
+	// if unauthorized, set the new token and try again
+	if resp.StatusCode == http.StatusUnauthorized {
+		if err := m.setNewAppToken(); err != nil {
+			return user, errors.New("token not valid, failed to set a new one: " + err.Error())
+		}
+		return m.updateUserKeys(ctx, user, userKeys)
+	}
+
+	// on any other not successfull status code read the response body to get the error
+	defer resp.Body.Close()
+	reponseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		reponseBody = []byte("failed to read the response body: " + err.Error())
+	}
+
+	return user, errors.New("update user's keys, status code: " + resp.Status + "; " + string(reponseBody))
+
+}
+
+func (m GraphProvider) getUserByID(ctx context.Context, userID string) (model.User, error) {
+	path := graphURL + m.tenantID + "/users/" + userID +
+		"?$select=userPrincipalName," +
+		"extension_" + m.extensionID + "_PrivateKey," +
+		"extension_" + m.extensionID + "_PublicKey"
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return model.User{}, err
+	}
+	r.Header.Add("Authorization", "Bearer "+m.tokenGuard.token)
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	defer resp.Body.Close()
+	reponseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return model.User{}, errors.New("reading response error: " + err.Error())
+	}
+
+	if !isResponseSuccess(resp.StatusCode) {
+		// if unauthorized, set the new token and try again
+		if resp.StatusCode == http.StatusUnauthorized {
+			if err := m.setNewAppToken(); err != nil {
+				return model.User{}, errors.New("token not valid, failed to set a new one: " + err.Error())
+			}
+			return m.getUserByID(ctx, userID)
+		}
+
+		return model.User{}, errors.New("status code: " + resp.Status + "; body: " + string(reponseBody))
+	}
+
+	modifiedBody := strings.ReplaceAll(string(reponseBody), "extension_"+m.extensionID+"_", "")
+
+	var unmarshalled graphUser
+	if err := json.Unmarshal([]byte(modifiedBody), &unmarshalled); err != nil {
+		return model.User{}, errors.New("failed to unmarshal the response: " + err.Error())
+	}
+
+	unmarshalled.ID = userID
+	return unmarshalled.record().User()
+}
+
+func (m GraphProvider) listUsers(ctx context.Context) ([]UserRecord, error) {
+	path := graphURL + m.tenantID + "/users" +
+		"?$top=" + strconv.Itoa(graphPageSize) +
+		"&$select=id,userPrincipalName," +
+		"extension_" + m.extensionID + "_PrivateKey," +
+		"extension_" + m.extensionID + "_PublicKey"
+
+	var records []UserRecord
+	for path != "" {
+		r, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+		if err != nil {
+			return nil, err
+		}
+		r.Header.Add("Authorization", "Bearer "+m.tokenGuard.token)
+
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			return nil, err
+		}
+		reponseBody, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.New("reading response error: " + err.Error())
+		}
+
+		if !isResponseSuccess(resp.StatusCode) {
+			// if unauthorized, set the new token and try the page again
+			if resp.StatusCode == http.StatusUnauthorized {
+				if err := m.setNewAppToken(); err != nil {
+					return nil, errors.New("token not valid, failed to set a new one: " + err.Error())
+				}
+				continue
+			}
+
+			return nil, errors.New("status code: " + resp.Status + "; body: " + string(reponseBody))
+		}
+
+		modifiedBody := strings.ReplaceAll(string(reponseBody), "extension_"+m.extensionID+"_", "")
+
+		var page struct {
+			Value    []graphUser `json:"value"`
+			NextLink string      `json:"@odata.nextLink"`
+		}
+		if err := json.Unmarshal([]byte(modifiedBody), &page); err != nil {
+			return nil, errors.New("failed to unmarshal the response: " + err.Error())
+		}
+
+		for _, user := range page.Value {
+			records = append(records, user.record())
+		}
+		path = page.NextLink
+	}
+
+	return records, nil
+}
+
+func (m GraphProvider) GetUser(ctx context.Context, userID string) (model.User, error) {
+	return m.getUserByID(ctx, userID)
+}
+
+func (m GraphProvider) ListUsers(ctx context.Context) ([]UserRecord, error) {
+	return m.listUsers(ctx)
+}
+
+func (m GraphProvider) StoreKeys(ctx context.Context, user model.User, keys signkeys.UserKeys) (model.User, error) {
+	return m.updateUserKeys(ctx, user, keys)
+}
+
+func (m GraphProvider) ClearPrivateKey(ctx context.Context, userID string) error {
+	path := graphURL + m.tenantID + "/users/" + userID
+	// null removes the extension attribute
+	body := `{"extension_` + m.extensionID + `_PrivateKey": null}`
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPatch, path, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Add("Authorization", "Bearer "+m.tokenGuard.token)
+	r.Header.Add("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if isResponseSuccess(resp.StatusCode) {
+		return nil
+	}
+
+	// if unauthorized, set the new token and try again
+	if resp.StatusCode == http.StatusUnauthorized {
+		if err := m.setNewAppToken(); err != nil {
+			return errors.New("token not valid, failed to set a new one: " + err.Error())
+		}
+		return m.ClearPrivateKey(ctx, userID)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrUserNotFound
+	}
+
+	reponseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		reponseBody = []byte("failed to read the response body: " + err.Error())
+	}
+	return errors.New("clear user's private key, status code: " + resp.Status + "; " + string(reponseBody))
+}
+
+func (m GraphProvider) TokenParams() TokenParams {
+	return m.tokenParams
+}
+
+func isResponseSuccess(responseCode int) bool {
+	return responseCode >= 200 && responseCode < 300
+}
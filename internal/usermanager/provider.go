@@ -0,0 +1,64 @@
+package usermanager
+
+import (
+	"context"
+	"doc-management/internal/model"
+	"doc-management/internal/signkeys"
+	"errors"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+
+// IdentityProvider manages the organization users and stores their signing keys
+type IdentityProvider interface {
+	// GetUser returns the user with the stored keys, the keys are empty if not assigned yet
+	GetUser(ctx context.Context, userID string) (model.User, error)
+	// ListUsers returns all the users with their keys as stored
+	ListUsers(ctx context.Context) ([]UserRecord, error)
+	// StoreKeys saves the signing keys of the user, returns the user with the keys set
+	StoreKeys(ctx context.Context, user model.User, keys signkeys.UserKeys) (model.User, error)
+	// ClearPrivateKey removes the stored private key of the user, e.g. once it's moved to the keystore;
+	// the public key is kept
+	ClearPrivateKey(ctx context.Context, userID string) error
+	// TokenParams describes the tokens the provider issues to the users
+	TokenParams() TokenParams
+}
+
+// UserRecord is a user with the hex encoded keys as stored by the identity provider, not verified
+type UserRecord struct {
+	ID         string
+	Name       string
+	PrivateKey string
+	PublicKey  string
+}
+
+// User returns the user with the public key derived from the stored private key
+func (r UserRecord) User() (model.User, error) {
+	user := model.User{ID: r.ID, Name: r.Name}
+	if r.PrivateKey == "" {
+		return user, nil
+	}
+
+	keys, err := signkeys.NewUserKeys(r.PrivateKey, r.PublicKey)
+	if err == signkeys.ErrKeyMismatch {
+		user.KeysMismatch = true
+	} else if err != nil {
+		return model.User{}, errors.New("failed to parse the keys: " + err.Error())
+	}
+
+	return user.SetKeys(keys), nil
+}
+
+// TokenParams are the parameters needed to validate the users' access tokens
+type TokenParams struct {
+	Issuer   string
+	Audience string
+	// URL of the OpenID discovery document pointing to the signing keys
+	DiscoveryURL string
+	// B2C user flow expected in the tokens, empty if not checked
+	PolicyName string
+	// claim holding the user ID
+	UserIDClaim string
+	// claim holding the granted scopes
+	ScopeClaim string
+}
@@ -0,0 +1,285 @@
+package usermanager
+
+import (
+	"bytes"
+	"context"
+	"doc-management/internal/model"
+	"doc-management/internal/signkeys"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SCIM extension of the user resource holding the signing keys
+	KeysSchema = "urn:doc-management:params:scim:schemas:extension:keys:2.0:User"
+
+	scimPatchSchema    = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	scimContentType    = "application/scim+json"
+	scimRequestTimeout = 20 * time.Second
+	scimPageSize       = 100
+)
+
+// SCIMProvider manages the users of an OpenID Connect identity provider, e.g. Keycloak, via its SCIM 2.0 API;
+// the application authenticates with the client credentials, the keys are stored in the KeysSchema extension
+type SCIMProvider struct {
+	scimURL       string
+	tokenEndpoint string
+	clientID      string
+	secret        string
+
+	client      *http.Client
+	tokenGuard  *TokenGuard
+	tokenParams TokenParams
+}
+
+type scimUser struct {
+	ID       string `json:"id"`
+	UserName string `json:"userName"`
+	Keys     struct {
+		PrivateKey string `json:"privateKey"`
+		PublicKey  string `json:"publicKey"`
+	} `json:"urn:doc-management:params:scim:schemas:extension:keys:2.0:User"`
+}
+
+func (u scimUser) record() UserRecord {
+	return UserRecord{ID: u.ID, Name: u.UserName, PrivateKey: u.Keys.PrivateKey, PublicKey: u.Keys.PublicKey}
+}
+
+// NewSCIMProvider reads the token endpoint from the issuer's discovery document and gets the app token
+func NewSCIMProvider(ctx context.Context, issuer, scimURL, clientID, secret string) (SCIMProvider, error) {
+	discoveryURL := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	provider := SCIMProvider{
+		scimURL:    strings.TrimSuffix(scimURL, "/"),
+		clientID:   clientID,
+		secret:     secret,
+		client:     &http.Client{Timeout: scimRequestTimeout},
+		tokenGuard: &TokenGuard{token: ""},
+		tokenParams: TokenParams{
+			Issuer:       issuer,
+			Audience:     clientID,
+			DiscoveryURL: discoveryURL,
+			UserIDClaim:  "sub",
+			ScopeClaim:   "scope",
+		},
+	}
+
+	status, body, err := provider.send(ctx, http.MethodGet, discoveryURL, "", nil)
+	if err != nil {
+		return SCIMProvider{}, errors.New("failed to get the OpenID discovery document: " + err.Error())
+	}
+	if !isResponseSuccess(status) {
+		return SCIMProvider{}, errors.New("failed to get the OpenID discovery document, status: " + http.StatusText(status))
+	}
+
+	var discovery struct {
+		TokenEndpoint string `json:"token_endpoint"`
+	}
+	if err := json.Unmarshal(body, &discovery); err != nil || discovery.TokenEndpoint == "" {
+		return SCIMProvider{}, errors.New("token_endpoint is missing in the OpenID discovery document")
+	}
+	provider.tokenEndpoint = discovery.TokenEndpoint
+
+	if err := provider.setNewAppToken(ctx); err != nil {
+		return SCIMProvider{}, err
+	}
+
+	return provider, nil
+}
+
+func (p SCIMProvider) GetUser(ctx context.Context, userID string) (model.User, error) {
+	status, body, err := p.authorizedRequest(ctx, http.MethodGet, p.scimURL+"/Users/"+url.PathEscape(userID), nil)
+	if err != nil {
+		return model.User{}, err
+	}
+	if status == http.StatusNotFound {
+		return model.User{}, ErrUserNotFound
+	}
+	if !isResponseSuccess(status) {
+		return model.User{}, errors.New("get user, status code: " + http.StatusText(status) + "; body: " + string(body))
+	}
+
+	var user scimUser
+	if err := json.Unmarshal(body, &user); err != nil {
+		return model.User{}, errors.New("failed to unmarshal the user: " + err.Error())
+	}
+
+	user.ID = userID
+	return user.record().User()
+}
+
+// ListUsers pages through all the users of the SCIM endpoint
+func (p SCIMProvider) ListUsers(ctx context.Context) ([]UserRecord, error) {
+	var records []UserRecord
+	for startIndex := 1; ; {
+		query := url.Values{}
+		query.Set("startIndex", strconv.Itoa(startIndex))
+		query.Set("count", strconv.Itoa(scimPageSize))
+
+		status, body, err := p.authorizedRequest(ctx, http.MethodGet, p.scimURL+"/Users?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		if !isResponseSuccess(status) {
+			return nil, errors.New("list users, status code: " + http.StatusText(status) + "; body: " + string(body))
+		}
+
+		var page struct {
+			TotalResults int        `json:"totalResults"`
+			Resources    []scimUser `json:"Resources"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, errors.New("failed to unmarshal the users: " + err.Error())
+		}
+
+		for _, user := range page.Resources {
+			records = append(records, user.record())
+		}
+
+		startIndex += len(page.Resources)
+		if len(page.Resources) == 0 || startIndex > page.TotalResults {
+			return records, nil
+		}
+	}
+}
+
+func (p SCIMProvider) StoreKeys(ctx context.Context, user model.User, keys signkeys.UserKeys) (model.User, error) {
+	type operation struct {
+		Op    string `json:"op"`
+		Path  string `json:"path"`
+		Value string `json:"value"`
+	}
+	patch := struct {
+		Schemas    []string    `json:"schemas"`
+		Operations []operation `json:"Operations"`
+	}{
+		Schemas: []string{scimPatchSchema},
+		Operations: []operation{
+			{Op: "add", Path: KeysSchema + ":privateKey", Value: keys.PrivateKey.AsHex()},
+			{Op: "add", Path: KeysSchema + ":publicKey", Value: keys.PublicKey.AsHex()},
+		},
+	}
+
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	status, response, err := p.authorizedRequest(ctx, http.MethodPatch, p.scimURL+"/Users/"+url.PathEscape(user.ID), body)
+	if err != nil {
+		return model.User{}, err
+	}
+	if !isResponseSuccess(status) {
+		return model.User{}, errors.New("update user's keys, status code: " + http.StatusText(status) + "; body: " + string(response))
+	}
+
+	return user.SetKeys(keys), nil
+}
+
+func (p SCIMProvider) ClearPrivateKey(ctx context.Context, userID string) error {
+	patch := map[string]interface{}{
+		"schemas":    []string{scimPatchSchema},
+		"Operations": []map[string]string{{"op": "remove", "path": KeysSchema + ":privateKey"}},
+	}
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+
+	status, response, err := p.authorizedRequest(ctx, http.MethodPatch, p.scimURL+"/Users/"+url.PathEscape(userID), body)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusNotFound {
+		return ErrUserNotFound
+	}
+	if !isResponseSuccess(status) {
+		return errors.New("clear user's private key, status code: " + http.StatusText(status) + "; body: " + string(response))
+	}
+
+	return nil
+}
+
+func (p SCIMProvider) TokenParams() TokenParams {
+	return p.tokenParams
+}
+
+// authorizedRequest sends the request with the app token, the token is renewed once if rejected
+func (p SCIMProvider) authorizedRequest(ctx context.Context, method, url string, body []byte) (int, []byte, error) {
+	status, response, err := p.send(ctx, method, url, p.tokenGuard.token, body)
+	if err != nil || status != http.StatusUnauthorized {
+		return status, response, err
+	}
+
+	if err := p.setNewAppToken(ctx); err != nil {
+		return 0, nil, errors.New("token not valid, failed to set a new one: " + err.Error())
+	}
+	return p.send(ctx, method, url, p.tokenGuard.token, body)
+}
+
+func (p SCIMProvider) send(ctx context.Context, method, url, token string, body []byte) (int, []byte, error) {
+	r, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	if token != "" {
+		r.Header.Add("Authorization", "Bearer "+token)
+	}
+	if body != nil {
+		r.Header.Add("Content-Type", scimContentType)
+	}
+
+	resp, err := p.client.Do(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	response, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, errors.New("reading response error: " + err.Error())
+	}
+	return resp.StatusCode, response, nil
+}
+
+func (p SCIMProvider) setNewAppToken(ctx context.Context) error {
+	data := url.Values{}
+	data.Set("client_id", p.clientID)
+	data.Set("client_secret", p.secret)
+	data.Set("grant_type", "client_credentials")
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenEndpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		return err
+	}
+	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.New("reading response error: " + err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("get app token, status code: " + resp.Status + "; body: " + string(responseBody))
+	}
+
+	var unmarshalled struct {
+		Token string `json:"access_token"`
+	}
+	if err := json.Unmarshal(responseBody, &unmarshalled); err != nil {
+		return errors.New("failed to unmarshal the token response: " + err.Error())
+	}
+
+	p.tokenGuard.token = unmarshalled.Token
+	return nil
+}
@@ -0,0 +1,119 @@
+package usermanager
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scimServer imitates an OIDC identity provider with the SCIM API
+type scimServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	token  string
+	issued int
+	users  map[string]map[string]interface{}
+}
+
+func newSCIMServer(t *testing.T) *scimServer {
+	s := &scimServer{users: map[string]map[string]interface{}{
+		"user-1": {"id": "user-1", "userName": "user1"},
+	}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"issuer": s.URL, "token_endpoint": s.URL + "/token"})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		if r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("client_secret") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.issued++
+		s.token = "token-" + string(rune('0'+s.issued))
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": s.token})
+	})
+	mux.HandleFunc("/scim/v2/Users/", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer "+s.token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		user, ok := s.users[strings.TrimPrefix(r.URL.Path, "/scim/v2/Users/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if r.Method == http.MethodPatch {
+			body, _ := ioutil.ReadAll(r.Body)
+			var patch struct {
+				Operations []struct {
+					Path  string
+					Value string
+				}
+			}
+			require.NoError(t, json.Unmarshal(body, &patch))
+			keys := map[string]interface{}{}
+			for _, op := range patch.Operations {
+				keys[strings.TrimPrefix(op.Path, KeysSchema+":")] = op.Value
+			}
+			user[KeysSchema] = keys
+		}
+		_ = json.NewEncoder(w).Encode(user)
+	})
+
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestSCIMProviderAssignsKeys(t *testing.T) {
+	server := newSCIMServer(t)
+	provider, err := NewSCIMProvider(context.TODO(), server.URL, server.URL+"/scim/v2", "client", "secret")
+	require.NoError(t, err)
+
+	assert.Equal(t, server.URL, provider.TokenParams().Issuer)
+	assert.Equal(t, "sub", provider.TokenParams().UserIDClaim)
+
+	manager := NewUserManager(provider)
+	user, err := manager.GetUserByID(context.TODO(), "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, "user1", user.Name)
+	require.True(t, user.HasValidKeys())
+
+	again, err := manager.GetUserByID(context.TODO(), "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, user.Keys.PrivateKey.AsHex(), again.Keys.PrivateKey.AsHex())
+	assert.Equal(t, user.Keys.PublicKey.AsHex(), again.Keys.PublicKey.AsHex())
+}
+
+func TestSCIMProviderRenewsToken(t *testing.T) {
+	server := newSCIMServer(t)
+	provider, err := NewSCIMProvider(context.TODO(), server.URL, server.URL+"/scim/v2", "client", "secret")
+	require.NoError(t, err)
+
+	server.mu.Lock()
+	server.token = "expired"
+	server.mu.Unlock()
+
+	_, err = provider.GetUser(context.TODO(), "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, server.issued)
+
+	_, err = provider.GetUser(context.TODO(), "unknown")
+	assert.Equal(t, ErrUserNotFound, err)
+}
@@ -4,50 +4,32 @@ import (
 	"context"
 	"doc-management/internal/model"
 	"doc-management/internal/signkeys"
-	"encoding/json"
 	"errors"
-	"io/ioutil"
-	"net/http"
-	"strings"
 )
 
-type TokenGuard struct {
-	token string
-}
+// KeySource is the source of the key checks of the identity provider records
+const KeySource = "directory"
 
-type UserManager struct {
-	tenantID    string
-	clientID    string
-	extensionID string
+var ErrKeysInKeystore = errors.New("the signing keys are kept in the keystore, they are not stored in the directory")
 
-	secret     string
-	tokenGuard *TokenGuard
+// UserManager gets the users from the identity provider and assigns them the signing keys
+type UserManager struct {
+	provider IdentityProvider
+	// the keys are kept in the keystore, the stored ones are only read to be imported
+	keystore bool
 }
 
-const (
-	graphURL = "https://graph.microsoft.com/v1.0/"
-)
-
-func NewUserManager(tenantID, clientID, extensionID, secret string) (UserManager, error) {
-
-	manager := UserManager{
-		tenantID:    tenantID,
-		clientID:    clientID,
-		extensionID: extensionID,
-		secret:      secret,
-
-		tokenGuard: &TokenGuard{token: ""},
-	}
-
-	err := manager.setNewAppToken()
-	if err != nil {
-		return UserManager{}, err
-	}
+func NewUserManager(provider IdentityProvider) UserManager {
+	return UserManager{provider: provider}
+}
 
-	return manager, nil
+// NewKeystoreUserManager creates the user manager of the deployments keeping the signing keys in the keystore;
+// it never writes a private key to the identity provider, it only reads and clears the legacy ones
+func NewKeystoreUserManager(provider IdentityProvider) UserManager {
+	return UserManager{provider: provider, keystore: true}
 }
 
-// InitAndReadAppKeys reads the app keys from the AD, sets them if they don't exist yet
+// InitAndReadAppKeys reads the app keys from the identity provider, sets them if they don't exist yet
 func (m UserManager) InitAndReadAppKeys(ctx context.Context, appUserID string) (keys signkeys.UserKeys, err error) {
 	user, err := m.GetUserByID(ctx, appUserID)
 	if err != nil {
@@ -57,13 +39,16 @@ func (m UserManager) InitAndReadAppKeys(ctx context.Context, appUserID string) (
 	return user.Keys, nil
 }
 
-// GetUserByID gets the user info from the AD and updates the user's keys if not assigned yet
+// GetUserByID gets the user info from the identity provider and updates the user's keys if not assigned yet
 func (m UserManager) GetUserByID(ctx context.Context, userID string) (model.User, error) {
-	user, err := m.getUserByID(ctx, userID)
+	user, err := m.provider.GetUser(ctx, userID)
 	if err != nil {
 		return model.User{}, err
 	}
 
+	if user.KeysMismatch {
+		return m.repairKeys(ctx, user)
+	}
 	if user.HasValidKeys() {
 		return user, nil
 	}
@@ -74,120 +59,79 @@ func (m UserManager) GetUserByID(ctx context.Context, userID string) (model.User
 		return model.User{}, errors.New("failed to generate user's keys: " + err.Error())
 	}
 
-	return m.updateUserKeys(ctx, user, keys)
+	return m.storeKeys(ctx, user, keys)
 }
 
-func (m UserManager) updateUserKeys(ctx context.Context, user model.User, userKeys signkeys.UserKeys) (model.User, error) {
-	path := graphURL + m.tenantID + "/users/" + user.ID
-
-	var updateBody struct {
-		PrivateKey string `json:"extension_EXTENSION_ID_PrivateKey"`
-		PublicKey  string `json:"extension_EXTENSION_ID_PublicKey"`
-	}
-
-	updatedUser := user.SetKeys(userKeys)
-	updateBody.PrivateKey = updatedUser.Keys.PrivateKey.AsHex()
-	updateBody.PublicKey = updatedUser.Keys.PrivateKey.AsHex()
-
-	marshalledReqBody, err := json.Marshal(updateBody)
-	if err != nil {
-		return user, err
-	}
-
-	modifiedBody := strings.ReplaceAll(string(marshalledReqBody), "EXTENSION_ID", m.extensionID)
-	r, err := http.NewRequestWithContext(ctx, http.MethodPatch, path, strings.NewReader(modifiedBody))
-	if err != nil {
-		return user, err
-	}
-	r.Header.Add("Authorization", "Bearer "+m.tokenGuard.token)
-	r.Header.Add("Content-Type", "application/json")
+func (m UserManager) TokenParams() TokenParams {
+	return m.provider.TokenParams()
+}
 
-	resp, err := http.DefaultClient.Do(r)
+// GetStoredKeys returns the keys stored by the identity provider, empty if the user has none; the public key
+// is derived from the private key, the mismatched record is repaired unless the keys are kept in the keystore
+func (m UserManager) GetStoredKeys(ctx context.Context, userID string) (signkeys.UserKeys, error) {
+	user, err := m.provider.GetUser(ctx, userID)
 	if err != nil {
-		return user, err
+		return signkeys.UserKeys{}, err
 	}
 
-	if isResponseSuccess(resp.StatusCode) {
-		// on successful response, nothing more to do, return
-		return updatedUser, nil
-	}
-
-	// if unauthorized, set the new token and try again
-	if resp.StatusCode == http.StatusUnauthorized {
-		if err := m.setNewAppToken(); err != nil {
-			return user, errors.New("token not valid, failed to set a new one: " + err.Error())
+	if user.KeysMismatch && !m.keystore {
+		if _, err := m.repairKeys(ctx, user); err != nil {
+			return signkeys.UserKeys{}, err
 		}
-		return m.updateUserKeys(ctx, user, userKeys)
-	}
-
-	// on any other not successfull status code read the response body to get the error
-	defer resp.Body.Close()
-	reponseBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		reponseBody = []byte("failed to read the response body: " + err.Error())
 	}
 
-	return user, errors.New("update user's keys, status code: " + resp.Status + "; " + string(reponseBody))
-
+	return user.Keys, nil
 }
 
-func (m UserManager) getUserByID(ctx context.Context, userID string) (model.User, error) {
-	path := graphURL + m.tenantID + "/users/" + userID +
-		"?$select=userPrincipalName," +
-		"extension_" + m.extensionID + "_PrivateKey," +
-		"extension_" + m.extensionID + "_PublicKey"
-
-	r, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
-	if err != nil {
-		return model.User{}, err
-	}
-	r.Header.Add("Authorization", "Bearer "+m.tokenGuard.token)
-
-	resp, err := http.DefaultClient.Do(r)
-	if err != nil {
-		return model.User{}, err
+// ClearStoredKeys removes the private key stored by the identity provider, once it's moved to the keystore
+func (m UserManager) ClearStoredKeys(ctx context.Context, userID string) error {
+	if err := m.provider.ClearPrivateKey(ctx, userID); err != nil {
+		return errors.New("failed to clear the stored private key: " + err.Error())
 	}
+	return nil
+}
 
-	defer resp.Body.Close()
-	reponseBody, err := ioutil.ReadAll(resp.Body)
+// AuditKeys verifies the key records of all the users, the mismatched public keys are replaced
+// by the ones derived from the private keys if repair is set
+func (m UserManager) AuditKeys(ctx context.Context, repair bool) ([]signkeys.KeyCheck, error) {
+	records, err := m.provider.ListUsers(ctx)
 	if err != nil {
-		return model.User{}, errors.New("reading response error: " + err.Error())
-	}
-
-	if !isResponseSuccess(resp.StatusCode) {
-		// if unauthorized, set the new token and try again
-		if resp.StatusCode == http.StatusUnauthorized {
-			if err := m.setNewAppToken(); err != nil {
-				return model.User{}, errors.New("token not valid, failed to set a new one: " + err.Error())
+		return nil, errors.New("failed to list the users: " + err.Error())
+	}
+
+	checks := make([]signkeys.KeyCheck, 0, len(records))
+	for _, record := range records {
+		keys, check := signkeys.Check(record.ID, KeySource, record.PrivateKey, record.PublicKey)
+		// the keys kept in the keystore are imported with the derived public key, the record stays as it is
+		if check.Status == signkeys.CheckMismatch && repair && !m.keystore {
+			user := model.User{ID: record.ID, Name: record.Name, KeysMismatch: true}.SetKeys(keys)
+			if _, err := m.repairKeys(ctx, user); err != nil {
+				check.Error = err.Error()
+			} else {
+				check.Status = signkeys.CheckRepaired
 			}
-			return m.GetUserByID(ctx, userID)
 		}
-
-		return model.User{}, errors.New("status code: " + resp.Status + "; body: " + string(reponseBody))
+		checks = append(checks, check)
 	}
 
-	modifiedBody := strings.ReplaceAll(string(reponseBody), "extension_"+m.extensionID+"_", "")
-
-	var unmarshalled struct {
-		Name       string `json:"userPrincipalName"`
-		PrivateKey string `json:"PrivateKey"`
-		PublicKey  string `json:"PublicKey"`
-	}
-	if err := json.Unmarshal([]byte(modifiedBody), &unmarshalled); err != nil {
-		return model.User{}, errors.New("failed to unmarshal the response: " + err.Error())
-	}
+	return checks, nil
+}
 
-	keys, err := signkeys.NewUserKeys(unmarshalled.PrivateKey, unmarshalled.PublicKey)
+// repairKeys stores the public key derived from the private key in place of the mismatched one
+func (m UserManager) repairKeys(ctx context.Context, user model.User) (model.User, error) {
+	repaired, err := m.storeKeys(ctx, user, user.Keys)
 	if err != nil {
-		return model.User{}, errors.New("failed to parse the keys: " + err.Error())
+		return model.User{}, errors.New("failed to repair the user's keys: " + err.Error())
 	}
-	return model.User{
-		ID:   userID,
-		Name: unmarshalled.Name,
-		Keys: keys,
-	}, nil
+
+	repaired.KeysMismatch = false
+	return repaired, nil
 }
 
-func isResponseSuccess(responseCode int) bool {
-	return responseCode >= 200 && responseCode < 300
+// storeKeys writes the keys to the identity provider, never if the keys are kept in the keystore
+func (m UserManager) storeKeys(ctx context.Context, user model.User, keys signkeys.UserKeys) (model.User, error) {
+	if m.keystore {
+		return model.User{}, ErrKeysInKeystore
+	}
+	return m.provider.StoreKeys(ctx, user, keys)
 }
@@ -12,16 +12,17 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-func newTestUserManager(t *testing.T) UserManager {
+func newTestUserManager(t *testing.T) GraphProvider {
 	viper.SetConfigFile("./../../.env")
 	err := viper.ReadInConfig()
 	require.NoError(t, err)
 
-	manager, err := NewUserManager(
+	manager, err := NewGraphProvider(
 		config.GetTenantID(),
 		config.GetClientID(),
 		config.GetMsExtensionID(),
 		config.GetAppSecret(),
+		TokenParams{},
 	)
 	require.NoError(t, err)
 
@@ -31,7 +32,7 @@ func newTestUserManager(t *testing.T) UserManager {
 
 func TestReadAppKeys(t *testing.T) {
 
-	manager := newTestUserManager(t)
+	manager := NewUserManager(newTestUserManager(t))
 	appUserID := "1ce7cffe-bf4a-4a14-8535-413013cae16f"
 	keys, err := manager.InitAndReadAppKeys(context.TODO(), appUserID)
 	require.NoError(t, err)